@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -12,12 +13,22 @@ import (
 
 	"smart-home/config"
 	"smart-home/internal/application"
+	"smart-home/internal/domain"
 	"smart-home/internal/infra/anthropic"
 	"smart-home/internal/infra/audio"
+	"smart-home/internal/infra/audiofile"
+	"smart-home/internal/infra/composite"
 	"smart-home/internal/infra/gemini"
 	"smart-home/internal/infra/homeassistant"
+	"smart-home/internal/infra/llm"
 	"smart-home/internal/infra/openai"
 	"smart-home/internal/infra/pushover"
+	"smart-home/internal/infra/rules"
+	"smart-home/internal/infra/schedule"
+	"smart-home/internal/infra/storage"
+	"smart-home/internal/infra/tuya"
+	"smart-home/internal/infra/vosk"
+	"smart-home/internal/infra/whispercpp"
 )
 
 func main() {
@@ -43,23 +54,53 @@ func main() {
 		cancel()
 	}()
 
-	audioSource := createAudioSource(cfg.Audio, logger)
+	audioSource := createAudioSource(cfg.Audio, compressedFormats(cfg.STT), logger)
 
 	// Create STT client only if needed (not needed for text-only sources like Alexa)
-	sttClient := createSTTClient(cfg, logger)
+	sttClient, err := createSTTClient(cfg, logger)
+	if err != nil {
+		logger.Error("creating speech-to-text client", "error", err)
+		os.Exit(1)
+	}
+	// The microphone listens for the wake word through the same recognizer; a
+	// streaming one transcribes while the user talks
+	if mic, ok := audioSource.(*audio.MicrophoneSource); ok {
+		// Everything the microphone hears is transcribed to look for the wake
+		// word: it mustn't leave the house
+		if hasWakeWord(cfg.Audio) && !localSTT(cfg.STT) {
+			logger.Error("listening for the wake word needs a local stt provider (openai_compatible, whispercpp or vosk), not the hosted OpenAI API")
+			os.Exit(1)
+		}
+		if _, noop := sttClient.(*application.NoopSTT); !noop {
+			mic.SetSpeechToText(sttClient)
+		}
+		if streaming, ok := sttClient.(application.StreamingSpeechToText); ok {
+			mic.SetStreamingSTT(streaming)
+		}
+	}
 
-	// Create intent parser (Anthropic or Gemini - whichever has an API key)
-	intentParser, err := createIntentParser(cfg, logger)
+	// Create intent parser (local rules, Anthropic or Gemini, or rules first
+	// with the LLM as fallback)
+	intentParser, llmChain, err := createIntentParser(cfg, logger)
 	if err != nil {
 		logger.Error("creating intent parser", "error", err)
 		os.Exit(1)
 	}
 
-	// Create IoT controller and registry (Home Assistant or Tuya)
-	iotController, registry, syncInterval := createIoTBackend(cfg, logger)
+	// Create IoT controller and registry (Home Assistant, Tuya or both)
+	iotController, registry, syncInterval, err := createIoTBackend(ctx, cfg, logger)
+	if err != nil {
+		logger.Error("creating IoT backend", "error", err)
+		os.Exit(1)
+	}
 	if syncInterval > 0 {
 		registry.StartPeriodicSync(ctx, syncInterval)
 	}
+	// Partial transcripts that already are a whole command end the utterance
+	// without waiting for silence
+	if mic, ok := audioSource.(*audio.MicrophoneSource); ok {
+		mic.SetEarlyEnd(func(command string) bool { return rules.Complete(command, registry) })
+	}
 
 	var notifier application.Notifier
 	if cfg.Pushover.Enabled {
@@ -77,9 +118,37 @@ func main() {
 		notifier,
 		logger,
 	)
+	assistant.SetSessionTimeout(parseDuration("session timeout", cfg.Assistant.SessionTimeout, application.DefaultSessionTimeout, logger))
+	assistant.SetMinConfidence(cfg.Assistant.MinConfidence)
+
+	timers := application.NewScheduler(storage.NewTimerStore(cfg.Timers.File), logger)
+	if err := timers.Load(); err != nil {
+		logger.Error("loading timers", "error", err)
+		os.Exit(1)
+	}
+	assistant.SetScheduler(timers)
+	if httpSource, ok := audioSource.(*audio.HTTPSource); ok {
+		httpSource.SetTimers(timers)
+		if llmChain != nil {
+			httpSource.SetIntentStats(llmChain)
+		}
+	}
+
+	routines, err := createRoutines(cfg, logger)
+	if err != nil {
+		logger.Error("creating routines", "error", err)
+		os.Exit(1)
+	}
+	if routines != nil {
+		assistant.SetRoutines(routines)
+		if httpSource, ok := audioSource.(*audio.HTTPSource); ok {
+			httpSource.SetRoutines(routines)
+		}
+	}
 
 	logger.Info("starting smart home assistant",
 		"audio_source", cfg.Audio.Source,
+		"backend", cfg.Backend,
 	)
 
 	if err := assistant.Run(ctx); err != nil && err != context.Canceled {
@@ -88,54 +157,377 @@ func main() {
 	}
 }
 
-func createAudioSource(cfg config.AudioConfig, logger *slog.Logger) application.AudioSource {
+func hasWakeWord(cfg config.AudioConfig) bool {
+	return cfg.WakeWord != "" || len(cfg.WakeWords) > 0
+}
+
+// localSTT tells whether the speech-to-text provider runs on a server of
+// our own rather than on OpenAI's.
+func localSTT(cfg config.STTConfig) bool {
+	switch cfg.Provider {
+	case "openai_compatible", "whispercpp", "vosk":
+		return true
+	default:
+		return false
+	}
+}
+
+// compressedFormats lists the compressed formats the speech-to-text backend
+// reads itself: whisper.cpp and Vosk only take WAV.
+func compressedFormats(cfg config.STTConfig) []audiofile.Format {
+	switch cfg.Provider {
+	case "whispercpp", "vosk":
+		return nil
+	default:
+		return audiofile.Compressed
+	}
+}
+
+func createAudioSource(cfg config.AudioConfig, compressed []audiofile.Format, logger *slog.Logger) application.AudioSource {
 	switch cfg.Source {
 	case "http":
-		return audio.NewHTTPSource(cfg.HTTPAddr, cfg.AuthToken, logger)
+		source := audio.NewHTTPSource(cfg.HTTPAddr, cfg.AuthToken, logger)
+		source.SetMaxDuration(parseDuration("audio max_duration", cfg.MaxDuration, audiofile.DefaultMaxDuration, logger))
+		source.SetCompressedFormats(compressed)
+		return source
 	case "file":
-		return audio.NewFileSource(cfg.FileDir)
+		source := audio.NewFileSource(cfg.FileDir)
+		source.SetMaxDuration(parseDuration("audio max_duration", cfg.MaxDuration, audiofile.DefaultMaxDuration, logger))
+		source.SetCompressedFormats(compressed)
+		return source
 	case "microphone":
-		return audio.NewMicrophoneSource(cfg.WakeWord, cfg.SampleRate, logger)
+		words := cfg.WakeWords
+		if cfg.WakeWord != "" {
+			words = append([]string{cfg.WakeWord}, words...)
+		}
+		var wakeWord *audio.WakeWordDetector
+		if len(words) > 0 {
+			timeout := parseDuration("wake word timeout", cfg.WakeWordTimeout, audio.DefaultWakeWordTimeout, logger)
+			wakeWord = audio.NewWakeWordDetector(words, timeout)
+		}
+		mic := audio.NewMicrophoneSource(wakeWord, cfg.SampleRate, logger)
+		vad := audio.DefaultVADConfig(cfg.SampleRate)
+		vad.PreRoll = parseDuration("vad pre_roll", cfg.VAD.PreRoll, vad.PreRoll, logger)
+		vad.Hangover = parseDuration("vad hangover", cfg.VAD.Hangover, vad.Hangover, logger)
+		vad.MaxUtterance = parseDuration("vad max_utterance", cfg.VAD.MaxUtterance, vad.MaxUtterance, logger)
+		vad.MinSpeech = parseDuration("vad min_speech", cfg.VAD.MinSpeech, vad.MinSpeech, logger)
+		if cfg.VAD.MarginDB > 0 {
+			vad.Margin = cfg.VAD.MarginDB
+		}
+		mic.SetVAD(vad)
+		return mic
 	default:
 		logger.Warn("unknown audio source, using http", "source", cfg.Source)
-		return audio.NewHTTPSource(cfg.HTTPAddr, cfg.AuthToken, logger)
+		source := audio.NewHTTPSource(cfg.HTTPAddr, cfg.AuthToken, logger)
+		source.SetCompressedFormats(compressed)
+		return source
 	}
 }
 
-func createSTTClient(cfg *config.Config, logger *slog.Logger) application.SpeechToText {
-	if cfg.OpenAI.APIKey == "" {
-		logger.Info("no OpenAI API key configured, using noop STT (text commands only)")
-		return &application.NoopSTT{}
+func createSTTClient(cfg *config.Config, logger *slog.Logger) (application.SpeechToText, error) {
+	timeout := parseDuration("stt timeout", cfg.STT.Timeout, 60*time.Second, logger)
+
+	switch cfg.STT.Provider {
+	case "":
+		if cfg.OpenAI.APIKey == "" {
+			logger.Info("no speech-to-text configured, using noop STT (text commands only)")
+			return &application.NoopSTT{}, nil
+		}
+		logger.Info("using OpenAI Whisper for speech-to-text")
+		return openai.NewWhisperClient(cfg.OpenAI.APIKey, cfg.STT.Language), nil
+	case "openai":
+		if cfg.OpenAI.APIKey == "" {
+			return nil, fmt.Errorf("stt provider openai needs openai.api_key")
+		}
+		logger.Info("using OpenAI Whisper for speech-to-text")
+		return openai.NewWhisperClient(cfg.OpenAI.APIKey, cfg.STT.Language), nil
+	case "openai_compatible":
+		if cfg.STT.BaseURL == "" {
+			return nil, fmt.Errorf("stt provider openai_compatible needs stt.base_url")
+		}
+		logger.Info("using OpenAI-compatible server for speech-to-text", "url", cfg.STT.BaseURL, "model", cfg.STT.Model)
+		client := openai.NewCompatibleWhisperClient(cfg.STT.BaseURL, cfg.STT.APIKey, cfg.STT.Model, cfg.STT.Language)
+		client.SetTimeout(timeout)
+		return client, nil
+	case "whispercpp":
+		if cfg.STT.BaseURL == "" {
+			return nil, fmt.Errorf("stt provider whispercpp needs stt.base_url")
+		}
+		logger.Info("using whisper.cpp server for speech-to-text", "url", cfg.STT.BaseURL)
+		client := whispercpp.NewClient(cfg.STT.BaseURL, cfg.STT.Language)
+		client.SetTimeout(timeout)
+		return client, nil
+	case "vosk":
+		if cfg.STT.BaseURL == "" {
+			return nil, fmt.Errorf("stt provider vosk needs stt.base_url")
+		}
+		logger.Info("using Vosk server for speech-to-text", "url", cfg.STT.BaseURL)
+		return vosk.NewClient(cfg.STT.BaseURL, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown stt provider %q: use openai, openai_compatible, whispercpp or vosk", cfg.STT.Provider)
 	}
-	logger.Info("using OpenAI Whisper for speech-to-text")
-	return openai.NewWhisperClient(cfg.OpenAI.APIKey, cfg.OpenAI.Language)
 }
 
-func createIntentParser(cfg *config.Config, logger *slog.Logger) (application.IntentParser, error) {
-	// Prefer Anthropic if configured, otherwise use Gemini
-	if cfg.Anthropic.APIKey != "" {
-		logger.Info("using Anthropic Claude for intent parsing", "model", cfg.Anthropic.Model)
-		return anthropic.NewClaudeClient(cfg.Anthropic.APIKey, cfg.Anthropic.Model), nil
+// createIntentParser also returns the chain of LLMs it asks, if any, to
+// report how each is doing.
+func createIntentParser(cfg *config.Config, logger *slog.Logger) (application.IntentParser, *composite.Parser, error) {
+	switch cfg.Intent.Parser {
+	case "rules":
+		logger.Info("using local rules for intent parsing")
+		return rules.NewParser(nil, logger), nil, nil
+	case "llm":
+		chain, err := createLLMParser(cfg, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+		return chain, chain, nil
+	case "hybrid":
+		chain, err := createLLMParser(cfg, logger)
+		switch {
+		case errors.Is(err, errNoLLM):
+			logger.Warn("no LLM configured, using local rules only")
+			return rules.NewParser(nil, logger), nil, nil
+		case err != nil:
+			return nil, nil, err
+		}
+		logger.Info("parsing simple commands locally before asking the LLM")
+		return rules.NewParser(chain, logger), chain, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown intent parser %q: use hybrid, llm or rules", cfg.Intent.Parser)
+	}
+}
+
+var errNoLLM = errors.New("no LLM configured: set anthropic.api_key, gemini.api_key or openai_compatible.base_url")
+
+// createLLMParser chains the configured LLMs in order, so an outage of one
+// falls over to the next. Providers without an API key (or URL) are left
+// out.
+func createLLMParser(cfg *config.Config, logger *slog.Logger) (*composite.Parser, error) {
+	prompt, err := llm.LoadPrompt(cfg.Intent.PromptFile)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Intent.PromptFile != "" {
+		logger.Info("using custom intent prompt", "file", cfg.Intent.PromptFile)
+	}
+
+	var providers []composite.Provider
+	for _, name := range cfg.Intent.Providers {
+		switch name {
+		case "anthropic":
+			if cfg.Anthropic.APIKey == "" {
+				continue
+			}
+			logger.Info("using Anthropic Claude for intent parsing", "model", cfg.Anthropic.Model)
+			client := anthropic.NewClaudeClient(cfg.Anthropic.APIKey, cfg.Anthropic.Model)
+			client.SetPrompt(prompt)
+			providers = append(providers, composite.Provider{
+				Name:    name,
+				Parser:  client,
+				Timeout: parseDuration("anthropic timeout", cfg.Anthropic.Timeout, 10*time.Second, logger),
+			})
+		case "gemini":
+			if cfg.Gemini.APIKey == "" {
+				continue
+			}
+			logger.Info("using Google Gemini for intent parsing", "model", cfg.Gemini.Model)
+			client := gemini.NewClient(cfg.Gemini.APIKey, cfg.Gemini.Model)
+			client.SetPrompt(prompt)
+			providers = append(providers, composite.Provider{
+				Name:    name,
+				Parser:  client,
+				Timeout: parseDuration("gemini timeout", cfg.Gemini.Timeout, 10*time.Second, logger),
+			})
+		case "openai_compatible":
+			if cfg.OpenAIChat.BaseURL == "" {
+				continue
+			}
+			logger.Info("using OpenAI-compatible chat API for intent parsing", "url", cfg.OpenAIChat.BaseURL, "model", cfg.OpenAIChat.Model)
+			client := openai.NewChatClient(cfg.OpenAIChat.BaseURL, cfg.OpenAIChat.APIKey, cfg.OpenAIChat.Model)
+			client.SetPrompt(prompt)
+			providers = append(providers, composite.Provider{
+				Name:    name,
+				Parser:  client,
+				Timeout: parseDuration("openai_compatible timeout", cfg.OpenAIChat.Timeout, 30*time.Second, logger),
+			})
+		default:
+			return nil, fmt.Errorf("unknown intent provider %q: use anthropic, gemini or openai_compatible", name)
+		}
 	}
-	if cfg.Gemini.APIKey != "" {
-		logger.Info("using Google Gemini for intent parsing", "model", cfg.Gemini.Model)
-		return gemini.NewClient(cfg.Gemini.APIKey, cfg.Gemini.Model), nil
+	if len(providers) == 0 {
+		return nil, errNoLLM
+	}
+
+	parser := composite.NewParser(logger, providers...)
+	parser.SetBreaker(cfg.Intent.FailureThreshold, parseDuration("intent cooldown", cfg.Intent.Cooldown, composite.DefaultCooldown, logger))
+	return parser, nil
+}
+
+func createIoTBackend(ctx context.Context, cfg *config.Config, logger *slog.Logger) (application.DeviceController, application.DeviceRegistry, time.Duration, error) {
+	switch cfg.Backend {
+	case "homeassistant":
+		b := newHomeAssistantBackend(ctx, cfg, logger)
+		return b.Controller, b.Registry, b.SyncInterval, nil
+	case "tuya":
+		b := newTuyaBackend(cfg, logger)
+		return b.Controller, b.Registry, b.SyncInterval, nil
+	case "both":
+		ha := newHomeAssistantBackend(ctx, cfg, logger)
+		ty := newTuyaBackend(cfg, logger)
+		// Each backend keeps its own sync interval inside the composite
+		return composite.NewController(ha, ty), composite.NewRegistry(logger, ha, ty), ha.SyncInterval, nil
+	default:
+		return nil, nil, 0, fmt.Errorf("unknown backend %q: use homeassistant, tuya or both", cfg.Backend)
 	}
-	return nil, fmt.Errorf("no LLM API key configured: set either anthropic.api_key or gemini.api_key")
 }
 
-func createIoTBackend(cfg *config.Config, logger *slog.Logger) (application.DeviceController, application.DeviceRegistry, time.Duration) {
+func newHomeAssistantBackend(ctx context.Context, cfg *config.Config, logger *slog.Logger) composite.Backend {
 	logger.Info("using Home Assistant for device control", "url", cfg.HomeAssistant.URL)
 	haClient := homeassistant.NewClient(cfg.HomeAssistant.URL, cfg.HomeAssistant.Token)
-	registry := homeassistant.NewRegistry(haClient, logger)
+	registry := homeassistant.NewRegistry(haClient, cfg.HomeAssistant.WebSocket, logger)
+
+	if cfg.HomeAssistant.WebSocket {
+		logger.Info("subscribing to Home Assistant state changes")
+		registry.StartLiveUpdates(ctx)
+	}
+
+	return composite.Backend{
+		Name:         "Home Assistant",
+		Controller:   haClient,
+		Registry:     registry,
+		SyncInterval: parseSyncInterval(cfg.HomeAssistant.SyncInterval, logger),
+	}
+}
+
+func newTuyaBackend(cfg *config.Config, logger *slog.Logger) composite.Backend {
+	logger.Info("using Tuya cloud for device control", "region", cfg.Tuya.Region)
+	tuyaClient := tuya.NewClient(cfg.Tuya.ClientID, cfg.Tuya.Secret, cfg.Tuya.Region, logger)
+
+	var controller application.DeviceController = tuyaClient
+	if cfg.Tuya.Local.Enabled {
+		devices := make([]tuya.LocalDevice, 0, len(cfg.Tuya.Local.Devices))
+		for _, d := range cfg.Tuya.Local.Devices {
+			devices = append(devices, tuya.LocalDevice{
+				ID:       d.ID,
+				IP:       d.IP,
+				Version:  d.Version,
+				LocalKey: d.LocalKey,
+			})
+		}
+		logger.Info("using Tuya LAN protocol for local devices", "devices", len(devices))
+		controller = tuya.NewLocalClient(tuyaClient, devices, cfg.Tuya.Local.KeyFile, logger)
+	}
+
+	return composite.Backend{
+		Name:         "Tuya",
+		Controller:   controller,
+		Registry:     tuya.NewRegistry(tuyaClient, logger),
+		SyncInterval: parseSyncInterval(cfg.Tuya.SyncInterval, logger),
+	}
+}
+
+// createRoutines builds the configured routines, or returns nil if there
+// are none.
+func createRoutines(cfg *config.Config, logger *slog.Logger) (*application.Routines, error) {
+	if len(cfg.Routines.List) == 0 {
+		return nil, nil
+	}
+
+	loc := schedule.Location{
+		Latitude:  cfg.Location.Latitude,
+		Longitude: cfg.Location.Longitude,
+		Time:      time.Local,
+	}
+	if cfg.Location.Timezone != "" {
+		tz, err := time.LoadLocation(cfg.Location.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("location timezone: %w", err)
+		}
+		loc.Time = tz
+	}
+
+	defs := make([]application.Routine, 0, len(cfg.Routines.List))
+	for _, rc := range cfg.Routines.List {
+		if rc.Name == "" {
+			return nil, fmt.Errorf("routine with schedule %q has no name", rc.Schedule)
+		}
+		sched, err := schedule.Parse(rc.Schedule, loc)
+		if err != nil {
+			return nil, fmt.Errorf("routine %q: %w", rc.Name, err)
+		}
+
+		routine := application.Routine{Name: rc.Name, Schedule: sched}
+		for _, action := range rc.Actions {
+			if action.Phrase != "" {
+				routine.Phrases = append(routine.Phrases, action.Phrase)
+				continue
+			}
+			cmd, err := routineCommand(action)
+			if err != nil {
+				return nil, fmt.Errorf("routine %q: %w", rc.Name, err)
+			}
+			routine.Commands = append(routine.Commands, cmd)
+		}
+		defs = append(defs, routine)
+	}
 
-	syncInterval, err := time.ParseDuration(cfg.HomeAssistant.SyncInterval)
+	routines := application.NewRoutines(defs, storage.NewRoutineStore(cfg.Routines.File), logger)
+	if err := routines.Load(); err != nil {
+		return nil, err
+	}
+	logger.Info("routines configured", "count", len(defs))
+	return routines, nil
+}
+
+// routineCommand turns an explicit action of a routine into a command.
+// Numbers come out of YAML as ints but the rest of the assistant, like the
+// LLM parsers, expects float64.
+func routineCommand(action config.RoutineAction) (domain.Command, error) {
+	cmd := domain.Command{
+		Action:     domain.Action(action.Action),
+		TargetName: action.Target,
+		TargetType: domain.TargetType(action.TargetType),
+		DeviceType: domain.DeviceType(action.DeviceType),
+		Parameters: make(map[string]any, len(action.Parameters)),
+	}
+	if !cmd.Action.Valid() || cmd.Action == domain.ActionUnknown {
+		return domain.Command{}, fmt.Errorf("invalid action %q", action.Action)
+	}
+	if cmd.TargetName == "" && cmd.Action != domain.ActionListTimers && cmd.Action != domain.ActionCancelTimer {
+		return domain.Command{}, fmt.Errorf("action %q has no target", action.Action)
+	}
+	switch cmd.TargetType {
+	case "":
+		cmd.TargetType = domain.TargetTypeDevice
+	case domain.TargetTypeDevice, domain.TargetTypeScene, domain.TargetTypeArea:
+	default:
+		return domain.Command{}, fmt.Errorf("invalid target type %q", action.TargetType)
+	}
+	for k, v := range action.Parameters {
+		if n, ok := v.(int); ok {
+			v = float64(n)
+		}
+		cmd.Parameters[k] = v
+	}
+	return cmd, nil
+}
+
+func parseSyncInterval(value string, logger *slog.Logger) time.Duration {
+	syncInterval, err := time.ParseDuration(value)
 	if err != nil {
 		logger.Warn("invalid sync interval, using default", "error", err)
-		syncInterval = 5 * time.Minute
+		return 5 * time.Minute
 	}
+	return syncInterval
+}
 
-	return haClient, registry, syncInterval
+func parseDuration(name, value string, fallback time.Duration, logger *slog.Logger) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Warn("invalid "+name+", using default", "error", err, "default", fallback)
+		return fallback
+	}
+	return d
 }
 
 func setupLogger(cfg config.LogConfig) *slog.Logger {
@@ -8,13 +8,21 @@ import (
 )
 
 type Config struct {
+	Backend       string              `yaml:"backend"`
 	Audio         AudioConfig         `yaml:"audio"`
 	OpenAI        OpenAIConfig        `yaml:"openai"`
+	STT           STTConfig           `yaml:"stt"`
 	Anthropic     AnthropicConfig     `yaml:"anthropic"`
 	Gemini        GeminiConfig        `yaml:"gemini"`
+	OpenAIChat    OpenAIChatConfig    `yaml:"openai_compatible"`
 	Tuya          TuyaConfig          `yaml:"tuya"`
 	HomeAssistant HomeAssistantConfig `yaml:"homeassistant"`
 	Pushover      PushoverConfig      `yaml:"pushover"`
+	Assistant     AssistantConfig     `yaml:"assistant"`
+	Intent        IntentConfig        `yaml:"intent"`
+	Timers        TimersConfig        `yaml:"timers"`
+	Location      LocationConfig      `yaml:"location"`
+	Routines      RoutinesConfig      `yaml:"routines"`
 	Log           LogConfig           `yaml:"log"`
 }
 
@@ -25,6 +33,26 @@ type AudioConfig struct {
 	WakeWord   string `yaml:"wake_word"`
 	SampleRate int    `yaml:"sample_rate"`
 	AuthToken  string `yaml:"auth_token"`
+	// WakeWords are more phrases that wake the microphone up, besides
+	// WakeWord; after one of them said alone, the command may follow within
+	// WakeWordTimeout
+	WakeWords       []string  `yaml:"wake_words"`
+	WakeWordTimeout string    `yaml:"wake_word_timeout"`
+	VAD             VADConfig `yaml:"vad"`
+	// MaxDuration is the longest clip the http and file sources accept
+	MaxDuration string `yaml:"max_duration"`
+}
+
+// VADConfig tunes how the microphone tells speech from the noise of the
+// room: an utterance starts after min_speech of sound margin_db above the
+// noise, includes the pre_roll before it, and ends after hangover of quiet
+// or at max_utterance.
+type VADConfig struct {
+	PreRoll      string  `yaml:"pre_roll"`
+	Hangover     string  `yaml:"hangover"`
+	MaxUtterance string  `yaml:"max_utterance"`
+	MinSpeech    string  `yaml:"min_speech"`
+	MarginDB     float64 `yaml:"margin_db"`
 }
 
 type OpenAIConfig struct {
@@ -32,27 +60,68 @@ type OpenAIConfig struct {
 	Language string `yaml:"language"`
 }
 
+// STTConfig picks the speech-to-text backend: "openai" (the hosted API,
+// with openai.api_key), "openai_compatible" (a self-hosted server with the
+// same /audio/transcriptions endpoint, e.g. faster-whisper-server) or
+// "whispercpp" (the whisper.cpp server) or "vosk" (a Vosk server over
+// WebSocket, which transcribes the microphone while the user talks). Empty
+// uses OpenAI if it has a key.
+type STTConfig struct {
+	Provider string `yaml:"provider"`
+	BaseURL  string `yaml:"base_url"`
+	APIKey   string `yaml:"api_key"`
+	Model    string `yaml:"model"`
+	Language string `yaml:"language"`
+	Timeout  string `yaml:"timeout"`
+}
+
 type AnthropicConfig struct {
-	APIKey string `yaml:"api_key"`
-	Model  string `yaml:"model"`
+	APIKey  string `yaml:"api_key"`
+	Model   string `yaml:"model"`
+	Timeout string `yaml:"timeout"`
 }
 
 type GeminiConfig struct {
-	APIKey string `yaml:"api_key"`
-	Model  string `yaml:"model"`
+	APIKey  string `yaml:"api_key"`
+	Model   string `yaml:"model"`
+	Timeout string `yaml:"timeout"`
+}
+
+// OpenAIChatConfig points the intent parser at an OpenAI Chat Completions
+// compatible server: OpenAI itself, Ollama, llama.cpp...
+type OpenAIChatConfig struct {
+	BaseURL string `yaml:"base_url"`
+	APIKey  string `yaml:"api_key"`
+	Model   string `yaml:"model"`
+	Timeout string `yaml:"timeout"`
 }
 
 type TuyaConfig struct {
-	ClientID     string `yaml:"client_id"`
-	Secret       string `yaml:"secret"`
-	Region       string `yaml:"region"`
-	SyncInterval string `yaml:"sync_interval"`
+	ClientID     string          `yaml:"client_id"`
+	Secret       string          `yaml:"secret"`
+	Region       string          `yaml:"region"`
+	SyncInterval string          `yaml:"sync_interval"`
+	Local        TuyaLocalConfig `yaml:"local"`
+}
+
+type TuyaLocalConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	KeyFile string            `yaml:"key_file"`
+	Devices []TuyaLocalDevice `yaml:"devices"`
+}
+
+type TuyaLocalDevice struct {
+	ID       string `yaml:"id"`
+	IP       string `yaml:"ip"`
+	Version  string `yaml:"version"`
+	LocalKey string `yaml:"local_key"`
 }
 
 type HomeAssistantConfig struct {
 	URL          string `yaml:"url"`
 	Token        string `yaml:"token"`
 	SyncInterval string `yaml:"sync_interval"`
+	WebSocket    bool   `yaml:"websocket"`
 }
 
 type PushoverConfig struct {
@@ -61,6 +130,68 @@ type PushoverConfig struct {
 	Enabled bool   `yaml:"enabled"`
 }
 
+type AssistantConfig struct {
+	SessionTimeout string  `yaml:"session_timeout"`
+	MinConfidence  float64 `yaml:"min_confidence"`
+}
+
+type IntentConfig struct {
+	Parser           string   `yaml:"parser"`
+	Providers        []string `yaml:"providers"`
+	FailureThreshold int      `yaml:"failure_threshold"`
+	Cooldown         string   `yaml:"cooldown"`
+	// PromptFile is a text/template replacing the built-in LLM prompt
+	PromptFile string `yaml:"prompt_file"`
+}
+
+// TimersConfig sets where pending timers ("apagá la estufa en 30 minutos")
+// are saved so they survive a restart.
+type TimersConfig struct {
+	File string `yaml:"file"`
+}
+
+// LocationConfig is where the house is, for sunrise and sunset, and its
+// time zone (an IANA name, the system's if empty).
+type LocationConfig struct {
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	Timezone  string  `yaml:"timezone"`
+}
+
+// RoutinesConfig lists the routines and sets where their state (enabled,
+// last run) is saved.
+type RoutinesConfig struct {
+	File string          `yaml:"file"`
+	List []RoutineConfig `yaml:"list"`
+}
+
+// RoutineConfig is a routine: a cron expression or "sunrise"/"sunset" with
+// an optional offset ("sunset-30m"), and what to do then.
+type RoutineConfig struct {
+	Name     string          `yaml:"name"`
+	Schedule string          `yaml:"schedule"`
+	Actions  []RoutineAction `yaml:"actions"`
+}
+
+// RoutineAction is either a phrase, given as a plain string ("apagá todas
+// las luces"), or an explicit command.
+type RoutineAction struct {
+	Phrase     string         `yaml:"phrase"`
+	Action     string         `yaml:"action"`
+	Target     string         `yaml:"target"`
+	TargetType string         `yaml:"target_type"`
+	DeviceType string         `yaml:"device_type"`
+	Parameters map[string]any `yaml:"parameters"`
+}
+
+func (a *RoutineAction) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&a.Phrase)
+	}
+	type plain RoutineAction
+	return node.Decode((*plain)(a))
+}
+
 type LogConfig struct {
 	Level  string `yaml:"level"`
 	Format string `yaml:"format"`
@@ -85,6 +216,9 @@ func Load(path string) (*Config, error) {
 }
 
 func (c *Config) setDefaults() {
+	if c.Backend == "" {
+		c.Backend = "homeassistant"
+	}
 	if c.Audio.Source == "" {
 		c.Audio.Source = "http"
 	}
@@ -94,27 +228,96 @@ func (c *Config) setDefaults() {
 	if c.Audio.FileDir == "" {
 		c.Audio.FileDir = "./audio"
 	}
+	if c.Audio.WakeWordTimeout == "" {
+		c.Audio.WakeWordTimeout = "8s"
+	}
+	if c.Audio.MaxDuration == "" {
+		c.Audio.MaxDuration = "30s"
+	}
+	if c.Audio.VAD.PreRoll == "" {
+		c.Audio.VAD.PreRoll = "300ms"
+	}
+	if c.Audio.VAD.Hangover == "" {
+		c.Audio.VAD.Hangover = "800ms"
+	}
+	if c.Audio.VAD.MaxUtterance == "" {
+		c.Audio.VAD.MaxUtterance = "10s"
+	}
+	if c.Audio.VAD.MinSpeech == "" {
+		c.Audio.VAD.MinSpeech = "60ms"
+	}
+	if c.Audio.VAD.MarginDB == 0 {
+		c.Audio.VAD.MarginDB = 10
+	}
 	if c.Audio.SampleRate == 0 {
 		c.Audio.SampleRate = 16000
 	}
 	if c.OpenAI.Language == "" {
 		c.OpenAI.Language = "es"
 	}
+	if c.STT.Language == "" {
+		c.STT.Language = c.OpenAI.Language
+	}
+	if c.STT.Model == "" {
+		c.STT.Model = "whisper-1"
+	}
+	if c.STT.Timeout == "" {
+		c.STT.Timeout = "60s"
+	}
 	if c.Anthropic.Model == "" {
 		c.Anthropic.Model = "claude-sonnet-4-20250514"
 	}
+	if c.Anthropic.Timeout == "" {
+		c.Anthropic.Timeout = "10s"
+	}
 	if c.Gemini.Model == "" {
 		c.Gemini.Model = "gemini-2.0-flash"
 	}
+	if c.Gemini.Timeout == "" {
+		c.Gemini.Timeout = "10s"
+	}
+	if c.OpenAIChat.Model == "" {
+		c.OpenAIChat.Model = "llama3.2"
+	}
+	if c.OpenAIChat.Timeout == "" {
+		c.OpenAIChat.Timeout = "30s"
+	}
 	if c.Tuya.Region == "" {
 		c.Tuya.Region = "us"
 	}
 	if c.Tuya.SyncInterval == "" {
 		c.Tuya.SyncInterval = "5m"
 	}
+	if c.Tuya.Local.KeyFile == "" {
+		c.Tuya.Local.KeyFile = "./tuya-local-keys.json"
+	}
 	if c.HomeAssistant.SyncInterval == "" {
 		c.HomeAssistant.SyncInterval = "5m"
 	}
+	if c.Assistant.SessionTimeout == "" {
+		c.Assistant.SessionTimeout = "5m"
+	}
+	if c.Assistant.MinConfidence == 0 {
+		c.Assistant.MinConfidence = 0.5
+	}
+	if c.Intent.Parser == "" {
+		c.Intent.Parser = "hybrid"
+	}
+	if len(c.Intent.Providers) == 0 {
+		c.Intent.Providers = []string{"anthropic", "gemini", "openai_compatible"}
+	}
+	if c.Intent.FailureThreshold == 0 {
+		c.Intent.FailureThreshold = 3
+	}
+	if c.Intent.Cooldown == "" {
+		c.Intent.Cooldown = "1m"
+	}
+	if c.Timers.File == "" {
+		c.Timers.File = "./data/timers.json"
+	}
+	if c.Routines.File == "" {
+		c.Routines.File = "./data/routines.json"
+	}
 	if c.Log.Level == "" {
 		c.Log.Level = "info"
 	}
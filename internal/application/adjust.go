@@ -0,0 +1,86 @@
+package application
+
+import (
+	"context"
+	"fmt"
+
+	"smart-home/internal/domain"
+)
+
+// adjustedParams are the values relative adjustments change, by action.
+var adjustedParams = map[domain.Action]string{
+	domain.ActionSetLevel:       "level",
+	domain.ActionSetPosition:    "position",
+	domain.ActionSetVolume:      "volume",
+	domain.ActionSetTemperature: "temperature",
+}
+
+// resolveDelta turns a relative adjustment ("a bit brighter", "two degrees
+// warmer") into the absolute value the controllers expect, from the
+// device's current state and clamped to its range. The delta stays in the
+// command for backends that can adjust natively, which are also left to
+// deal with it alone when the current value is unknown.
+func (a *Assistant) resolveDelta(ctx context.Context, device *domain.Device, cmd *domain.Command) error {
+	delta, ok := cmd.Delta()
+	if !ok {
+		return nil
+	}
+	param, ok := adjustedParams[cmd.Action]
+	if !ok {
+		return fmt.Errorf("%w: %s can't be adjusted by an amount", ErrUnsupportedAction, cmd.Action)
+	}
+	if _, ok := cmd.Parameters[param].(float64); ok {
+		return nil
+	}
+
+	current, ok := currentValue(device.State, param)
+	if !ok {
+		// The registry may not track state; ask the device itself
+		state, err := a.iot.GetState(ctx, device.ID)
+		if err != nil {
+			a.logger.Warn("can't read state to adjust device", "device", device.Name, "error", err)
+			return nil
+		}
+		if current, ok = currentValue(state, param); !ok {
+			return nil
+		}
+	}
+
+	value := current + delta
+	if param != "temperature" {
+		value = min(max(value, 0), 100)
+	}
+	if clamped, changed := device.Capabilities.Clamp(param, value); changed {
+		value = clamped
+	}
+
+	a.logger.Debug("resolved relative adjustment", "device", device.Name, "param", param, "from", current, "delta", delta, "to", value)
+	cmd.Parameters[param] = value
+	return nil
+}
+
+// currentValue reads the value a parameter sets from a device state. A
+// device that is off is at level 0.
+func currentValue(state *domain.DeviceState, param string) (float64, bool) {
+	if state == nil {
+		return 0, false
+	}
+
+	if param == "temperature" {
+		switch {
+		case state.TargetTemperature != nil:
+			return *state.TargetTemperature, true
+		case state.Temperature != nil:
+			return *state.Temperature, true
+		}
+		return 0, false
+	}
+
+	switch {
+	case state.Level != nil:
+		return float64(*state.Level), true
+	case state.Status == "off":
+		return 0, true
+	}
+	return 0, false
+}
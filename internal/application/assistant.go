@@ -2,12 +2,25 @@ package application
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 
 	"smart-home/internal/domain"
 )
 
+// ErrUnknownCommand is reported when an utterance contains nothing the
+// intent parser could turn into a command.
+var ErrUnknownCommand = errors.New("command not understood")
+
+// ErrUnsupportedAction is reported when the target device can't perform the
+// requested action and there is no equivalent one it can.
+var ErrUnsupportedAction = errors.New("action not supported")
+
 type Assistant struct {
 	audio    AudioSource
 	stt      SpeechToText
@@ -15,7 +28,12 @@ type Assistant struct {
 	iot      DeviceController
 	registry DeviceRegistry
 	notifier Notifier
+	sessions *Sessions
+	timers   *Scheduler
+	routines *Routines
 	logger   *slog.Logger
+
+	minConfidence float64
 }
 
 func NewAssistant(
@@ -34,10 +52,38 @@ func NewAssistant(
 		iot:      iot,
 		registry: registry,
 		notifier: notifier,
+		sessions: NewSessions(DefaultSessionTimeout),
+		timers:   NewScheduler(nil, logger),
 		logger:   logger,
+
+		minConfidence: DefaultMinConfidence,
 	}
 }
 
+// SetSessionTimeout changes how long a conversation is remembered after
+// its last turn. It must be called before Run.
+func (a *Assistant) SetSessionTimeout(d time.Duration) {
+	a.sessions = NewSessions(d)
+}
+
+// SetMinConfidence changes the parser confidence below which the assistant
+// asks for confirmation before acting. It must be called before Run.
+func (a *Assistant) SetMinConfidence(v float64) {
+	a.minConfidence = v
+}
+
+// SetScheduler replaces the in-memory scheduler of timed commands, e.g.
+// with one that keeps them across restarts. It must be called before Run.
+func (a *Assistant) SetScheduler(s *Scheduler) {
+	a.timers = s
+}
+
+// SetRoutines sets the routines to run on their schedules. It must be
+// called before Run.
+func (a *Assistant) SetRoutines(r *Routines) {
+	a.routines = r
+}
+
 func (a *Assistant) Run(ctx context.Context) error {
 	a.logger.Info("syncing device registry")
 	if err := a.registry.Sync(ctx); err != nil {
@@ -50,6 +96,11 @@ func (a *Assistant) Run(ctx context.Context) error {
 	}
 	defer a.audio.Stop()
 
+	a.timers.Start(ctx, a.runTimer)
+	if a.routines != nil {
+		a.routines.Start(ctx, a.runRoutine)
+	}
+
 	a.logger.Info("assistant ready, listening for commands")
 
 	for {
@@ -65,14 +116,55 @@ func (a *Assistant) Run(ctx context.Context) error {
 }
 
 func (a *Assistant) processOneCommand(ctx context.Context) error {
-	audioData, err := a.audio.NextCommand(ctx)
+	req, err := a.nextRequest(ctx)
 	if err != nil {
 		return fmt.Errorf("getting audio: %w", err)
 	}
 
-	if len(audioData) == 0 {
+	resp := a.handle(ctx, req)
+	if req.Reply != nil {
+		req.Reply(resp)
+	}
+
+	if errors.Is(resp.Err, ErrUnknownCommand) {
 		return nil
 	}
+	return resp.Err
+}
+
+// nextRequest waits for the next command, keeping the reply channel when the
+// source supports one. Requests without a session share the one of their
+// audio source.
+func (a *Assistant) nextRequest(ctx context.Context) (*Request, error) {
+	var req *Request
+	if src, ok := a.audio.(RequestSource); ok {
+		r, err := src.NextRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req = r
+	} else {
+		audioData, err := a.audio.NextCommand(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req = &Request{Audio: audioData}
+	}
+
+	if req.SessionID == "" {
+		req.SessionID = a.audio.Name()
+	}
+	return req, nil
+}
+
+// handle runs a single utterance through the pipeline and returns its
+// outcome: the text describing what was done, or a question when the
+// assistant needs more from the user before acting.
+func (a *Assistant) handle(ctx context.Context, req *Request) Response {
+	audioData := req.Audio
+	if len(audioData) == 0 {
+		return Response{Err: ErrUnknownCommand}
+	}
 
 	var text string
 
@@ -85,42 +177,100 @@ func (a *Assistant) processOneCommand(ctx context.Context) error {
 		var err error
 		text, err = a.stt.Transcribe(ctx, audioData)
 		if err != nil {
-			return fmt.Errorf("transcribing: %w", err)
+			return Response{Err: fmt.Errorf("transcribing: %w", err)}
 		}
 
 		a.logger.Info("transcribed", "text", text)
 	}
 
-	cmd, err := a.intent.Parse(ctx, text, a.registry)
-	if err != nil {
-		return fmt.Errorf("parsing intent: %w", err)
+	var cmds []*domain.Command
+	if pending := a.sessions.takePending(req.SessionID); pending != nil {
+		answered, ok := pending.answer(text)
+		if ok && len(answered) == 0 {
+			a.logger.Info("clarification declined", "text", text)
+			a.sessions.Record(req.SessionID, Turn{Text: text, Result: "Cancelado"})
+			return Response{Text: "Cancelado"}
+		}
+		if ok {
+			a.logger.Info("clarification answered", "text", text)
+			cmds = answered
+		}
 	}
 
-	a.logger.Info("parsed intent",
-		"action", cmd.Action,
-		"target", cmd.TargetName,
-		"confidence", cmd.Confidence,
-	)
+	if cmds == nil {
+		parsed, err := a.intent.Parse(ctx, text, a.registry, a.sessions.Get(req.SessionID))
+		if err != nil {
+			return Response{Err: fmt.Errorf("parsing intent: %w", err)}
+		}
 
-	if cmd.Action == domain.ActionUnknown {
-		a.logger.Warn("unknown command, skipping", "text", text)
-		return nil
+		for _, cmd := range parsed {
+			a.logger.Info("parsed intent",
+				"action", cmd.Action,
+				"target", cmd.TargetName,
+				"confidence", cmd.Confidence,
+			)
+		}
+
+		cmds = knownCommands(parsed)
+		if len(cmds) == 0 {
+			a.logger.Warn("unknown command, skipping", "text", text)
+			return Response{Err: ErrUnknownCommand}
+		}
+	}
+
+	if c := a.clarify(cmds); c != nil {
+		a.logger.Info("asking for clarification", "question", c.question)
+		a.sessions.ask(req.SessionID, c)
+		a.sessions.Record(req.SessionID, Turn{Text: text, Result: c.question})
+		if err := a.notifier.Notify(ctx, c.question); err != nil {
+			a.logger.Error("notifying question", "error", err)
+		}
+		return Response{Text: c.question, Question: true}
 	}
 
-	result, err := a.executeCommand(ctx, cmd)
+	result, err := a.executeAll(ctx, cmds)
+	a.sessions.Record(req.SessionID, Turn{Text: text, Result: result, Commands: cmds})
+	if notifyErr := a.notifier.Notify(ctx, result); notifyErr != nil {
+		a.logger.Error("notifying result", "error", notifyErr)
+	}
 	if err != nil {
-		notifyErr := a.notifier.Notify(ctx, fmt.Sprintf("Error: %s", err.Error()))
-		if notifyErr != nil {
-			a.logger.Error("notifying error", "error", notifyErr)
+		return Response{Text: result, Err: fmt.Errorf("executing: %w", err)}
+	}
+
+	return Response{Text: result}
+}
+
+// knownCommands drops the parts of an utterance the parser could not
+// understand, keeping the order of the rest.
+func knownCommands(cmds []*domain.Command) []*domain.Command {
+	known := make([]*domain.Command, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd != nil && cmd.Action != domain.ActionUnknown {
+			known = append(known, cmd)
 		}
-		return fmt.Errorf("executing: %w", err)
 	}
+	return known
+}
 
-	if err := a.notifier.Notify(ctx, result); err != nil {
-		a.logger.Error("notifying result", "error", err)
+// executeAll runs the commands in sequence. A failing command does not stop
+// the ones after it; every outcome is reported in the returned summary, one
+// line per command, and the failures are joined into the returned error.
+func (a *Assistant) executeAll(ctx context.Context, cmds []*domain.Command) (string, error) {
+	lines := make([]string, 0, len(cmds))
+	var errs []error
+
+	for _, cmd := range cmds {
+		result, err := a.executeCommand(ctx, cmd)
+		if err != nil {
+			a.logger.Error("executing command", "action", cmd.Action, "target", cmd.TargetName, "error", err)
+			lines = append(lines, fmt.Sprintf("Error: %s", err.Error()))
+			errs = append(errs, err)
+			continue
+		}
+		lines = append(lines, result)
 	}
 
-	return nil
+	return strings.Join(lines, "\n"), errors.Join(errs...)
 }
 
 func isTextCommand(data []byte) (string, bool) {
@@ -131,6 +281,15 @@ func isTextCommand(data []byte) (string, bool) {
 }
 
 func (a *Assistant) executeCommand(ctx context.Context, cmd *domain.Command) (string, error) {
+	switch {
+	case cmd.Action == domain.ActionListTimers:
+		return a.listTimers(), nil
+	case cmd.Action == domain.ActionCancelTimer:
+		return a.cancelTimers(cmd)
+	case cmd.Timed():
+		return a.schedule(ctx, cmd)
+	}
+
 	switch cmd.TargetType {
 	case domain.TargetTypeScene:
 		scene, ok := a.registry.FindSceneByName(cmd.TargetName)
@@ -148,13 +307,191 @@ func (a *Assistant) executeCommand(ctx context.Context, cmd *domain.Command) (st
 			return "", fmt.Errorf("device not found: %s", cmd.TargetName)
 		}
 		cmd.TargetID = device.ID
+		if err := a.adaptToDevice(device, cmd); err != nil {
+			return "", err
+		}
+		if err := a.resolveDelta(ctx, device, cmd); err != nil {
+			return "", err
+		}
+		if cmd.Action == domain.ActionGetStatus {
+			state, err := a.iot.GetState(ctx, device.ID)
+			if err != nil {
+				return "", err
+			}
+			return state.Describe(device.Name), nil
+		}
 		if err := a.iot.ExecuteCommand(ctx, cmd); err != nil {
 			return "", err
 		}
 		return fmt.Sprintf("Command '%s' executed on '%s'", cmd.Action, cmd.TargetName), nil
 
+	case domain.TargetTypeArea:
+		return a.executeOnArea(ctx, cmd)
+
 	default:
 		return "", fmt.Errorf("unknown target type: %s", cmd.TargetType)
 	}
 }
 
+// executeOnArea runs a command on every online device of an area the action
+// applies to, or only on those of cmd.DeviceType when set.
+func (a *Assistant) executeOnArea(ctx context.Context, cmd *domain.Command) (string, error) {
+	devices := devicesInArea(a.registry.GetDevices(), cmd)
+	if len(devices) == 0 {
+		return "", fmt.Errorf("no devices found in area: %s", cmd.TargetName)
+	}
+
+	var (
+		states []string
+		done   int
+		errs   []error
+	)
+	for _, device := range devices {
+		if cmd.Action == domain.ActionGetStatus {
+			state, err := a.iot.GetState(ctx, device.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", device.Name, err))
+				continue
+			}
+			states = append(states, state.Describe(device.Name))
+			continue
+		}
+
+		deviceCmd := *cmd
+		deviceCmd.TargetID = device.ID
+		deviceCmd.TargetName = device.Name
+		deviceCmd.TargetType = domain.TargetTypeDevice
+		deviceCmd.Parameters = maps.Clone(cmd.Parameters)
+		if err := a.adaptToDevice(&device, &deviceCmd); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := a.resolveDelta(ctx, &device, &deviceCmd); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := a.iot.ExecuteCommand(ctx, &deviceCmd); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", device.Name, err))
+			continue
+		}
+		done++
+	}
+
+	if cmd.Action == domain.ActionGetStatus {
+		return strings.Join(states, "; "), errors.Join(errs...)
+	}
+	return fmt.Sprintf("Command '%s' executed on %d of %d devices in '%s'", cmd.Action, done, len(devices), cmd.TargetName), errors.Join(errs...)
+}
+
+// devicesInArea matches the area name case-insensitively, falling back to a
+// substring match like the registries do for device names.
+func devicesInArea(devices []domain.Device, cmd *domain.Command) []domain.Device {
+	key := strings.ToLower(strings.TrimSpace(cmd.TargetName))
+	if key == "" {
+		return nil
+	}
+
+	matches := func(exact bool) []domain.Device {
+		var result []domain.Device
+		for _, d := range devices {
+			name := strings.ToLower(d.Area)
+			if name == "" || (exact && name != key) || (!exact && !strings.Contains(name, key)) {
+				continue
+			}
+			if !d.Online || (cmd.DeviceType != "" && d.Type != cmd.DeviceType) {
+				continue
+			}
+			if !d.Supports(cmd.Action) {
+				continue
+			}
+			result = append(result, d)
+		}
+		return result
+	}
+
+	if result := matches(true); len(result) > 0 {
+		return result
+	}
+	return matches(false)
+}
+
+// equivalentActions lists actions that mean the same on a different kind of
+// device, e.g. "set_level" on blinds is "set_position".
+var equivalentActions = map[domain.Action][]domain.Action{
+	domain.ActionSetLevel:    {domain.ActionSetPosition, domain.ActionSetVolume},
+	domain.ActionSetPosition: {domain.ActionSetLevel},
+	domain.ActionSetVolume:   {domain.ActionSetLevel},
+	domain.ActionTurnOn:      {domain.ActionOpen, domain.ActionStart},
+	domain.ActionTurnOff:     {domain.ActionClose, domain.ActionDock},
+	domain.ActionOpen:        {domain.ActionTurnOn},
+	domain.ActionClose:       {domain.ActionTurnOff},
+	domain.ActionStart:       {domain.ActionTurnOn},
+	domain.ActionDock:        {domain.ActionTurnOff},
+}
+
+// levelParams are the names the percentage of each level-like action goes by.
+var levelParams = map[domain.Action]string{
+	domain.ActionSetLevel:    "level",
+	domain.ActionSetPosition: "position",
+	domain.ActionSetVolume:   "volume",
+}
+
+// adaptToDevice checks the command against the device's capabilities before
+// it reaches the controller. Actions the device lacks are swapped for an
+// equivalent one when possible, and numeric parameters are clamped to the
+// device's range.
+func (a *Assistant) adaptToDevice(device *domain.Device, cmd *domain.Command) error {
+	caps := device.Capabilities
+	if caps == nil {
+		return nil
+	}
+
+	if !caps.Supports(cmd.Action) {
+		replaced := false
+		for _, alt := range equivalentActions[cmd.Action] {
+			if caps.Supports(alt) {
+				a.logger.Info("correcting action for device", "device", device.Name, "from", cmd.Action, "to", alt)
+				renameLevelParam(cmd, alt)
+				cmd.Action = alt
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			return fmt.Errorf("%w: %s can't %s", ErrUnsupportedAction, device.Name, cmd.Action)
+		}
+	}
+
+	for param, value := range cmd.Parameters {
+		if v, ok := value.(float64); ok {
+			if clamped, changed := caps.Clamp(param, v); changed {
+				a.logger.Info("clamping parameter to device range", "device", device.Name, "param", param, "from", v, "to", clamped)
+				cmd.Parameters[param] = clamped
+			}
+		}
+		if options, ok := caps.Options[param]; ok {
+			if v, ok := value.(string); ok && !slices.Contains(options, v) {
+				return fmt.Errorf("%w: %s has no %s %q (options: %s)", ErrUnsupportedAction, device.Name, param, v, strings.Join(options, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// renameLevelParam moves the percentage of a level-like command to the name
+// the new action expects.
+func renameLevelParam(cmd *domain.Command, to domain.Action) {
+	from, ok := levelParams[cmd.Action]
+	if !ok {
+		return
+	}
+	name, ok := levelParams[to]
+	if !ok {
+		return
+	}
+	if v, ok := cmd.Parameters[from]; ok {
+		cmd.Parameters[name] = v
+		delete(cmd.Parameters, from)
+	}
+}
@@ -2,8 +2,13 @@ package application_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,13 +48,20 @@ func (m *mockSTT) Transcribe(_ context.Context, audio []byte) (string, error) {
 
 type mockIntentParser struct {
 	intents map[string]*domain.Command
+	multi   map[string][]*domain.Command
+	// conversations records the context passed along with each utterance
+	conversations []*application.Conversation
 }
 
-func (m *mockIntentParser) Parse(_ context.Context, text string, _ application.DeviceRegistry) (*domain.Command, error) {
+func (m *mockIntentParser) Parse(_ context.Context, text string, _ application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	m.conversations = append(m.conversations, conv)
+	if cmds, ok := m.multi[text]; ok {
+		return cmds, nil
+	}
 	if cmd, ok := m.intents[text]; ok {
-		return cmd, nil
+		return []*domain.Command{cmd}, nil
 	}
-	return &domain.Command{Action: domain.ActionUnknown}, nil
+	return []*domain.Command{{Action: domain.ActionUnknown}}, nil
 }
 
 type mockDeviceController struct {
@@ -57,6 +69,7 @@ type mockDeviceController struct {
 	triggeredScenes  []string
 	doneChan         chan struct{}
 	expectedCommands int
+	states           map[string]*domain.DeviceState
 }
 
 func (m *mockDeviceController) ExecuteCommand(_ context.Context, cmd *domain.Command) error {
@@ -75,6 +88,13 @@ func (m *mockDeviceController) TriggerScene(_ context.Context, sceneID string) e
 	return nil
 }
 
+func (m *mockDeviceController) GetState(_ context.Context, deviceID string) (*domain.DeviceState, error) {
+	if state, ok := m.states[deviceID]; ok {
+		return state, nil
+	}
+	return nil, fmt.Errorf("no state for %s", deviceID)
+}
+
 type mockRegistry struct {
 	devices []domain.Device
 	scenes  []domain.Scene
@@ -83,7 +103,6 @@ type mockRegistry struct {
 func (m *mockRegistry) Sync(_ context.Context) error { return nil }
 func (m *mockRegistry) GetDevices() []domain.Device  { return m.devices }
 func (m *mockRegistry) GetScenes() []domain.Scene    { return m.scenes }
-func (m *mockRegistry) Summary() string              { return "mock devices" }
 
 func (m *mockRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
 	for i, d := range m.devices {
@@ -237,3 +256,896 @@ func TestAssistant_ProcessScene(t *testing.T) {
 	cancel()
 }
 
+
+type recordingNotifier struct {
+	messages chan string
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, message string) error {
+	n.messages <- message
+	return nil
+}
+
+func TestAssistant_ProcessMultipleCommands(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	audioSource := &mockAudioSource{
+		commands: [][]byte{[]byte(domain.TextCommandPrefix + "apaga la cocina, prende el garage y el living")},
+	}
+
+	intentParser := &mockIntentParser{
+		multi: map[string][]*domain.Command{
+			"apaga la cocina, prende el garage y el living": {
+				{Action: domain.ActionTurnOff, TargetName: "Luz Cocina", TargetType: domain.TargetTypeDevice},
+				{Action: domain.ActionTurnOn, TargetName: "Luz Garage", TargetType: domain.TargetTypeDevice},
+				{Action: domain.ActionUnknown},
+				{Action: domain.ActionTurnOn, TargetName: "Luz Living", TargetType: domain.TargetTypeDevice},
+			},
+		},
+	}
+
+	controller := &mockDeviceController{}
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "dev1", Name: "Luz Cocina", Type: domain.DeviceTypeLight, Online: true},
+			{ID: "dev2", Name: "Luz Living", Type: domain.DeviceTypeLight, Online: true},
+		},
+	}
+
+	notifier := &recordingNotifier{messages: make(chan string, 1)}
+
+	assistant := application.NewAssistant(
+		audioSource,
+		&mockSTT{},
+		intentParser,
+		controller,
+		registry,
+		notifier,
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	var message string
+	select {
+	case message = <-notifier.messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for notification")
+	}
+
+	cancel()
+
+	if len(controller.executedCommands) != 2 {
+		t.Fatalf("expected 2 executed commands, got %d", len(controller.executedCommands))
+	}
+
+	if controller.executedCommands[0].TargetID != "dev1" || controller.executedCommands[1].TargetID != "dev2" {
+		t.Errorf("commands executed out of order: %s, %s",
+			controller.executedCommands[0].TargetID, controller.executedCommands[1].TargetID)
+	}
+
+	want := "Command 'turn_off' executed on 'Luz Cocina'\n" +
+		"Error: device not found: Luz Garage\n" +
+		"Command 'turn_on' executed on 'Luz Living'"
+	if message != want {
+		t.Errorf("notification:\ngot  %q\nwant %q", message, want)
+	}
+}
+
+type mockRequestSource struct {
+	mockAudioSource
+	replies chan application.Response
+}
+
+func (m *mockRequestSource) NextRequest(ctx context.Context) (*application.Request, error) {
+	audio, err := m.NextCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &application.Request{
+		Audio: audio,
+		Reply: func(resp application.Response) { m.replies <- resp },
+	}, nil
+}
+
+func TestAssistant_RepliesWithOutcome(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := &mockRequestSource{
+		mockAudioSource: mockAudioSource{
+			commands: [][]byte{
+				[]byte(domain.TextCommandPrefix + "prende el garage"),
+				[]byte(domain.TextCommandPrefix + "qué hora es"),
+			},
+		},
+		replies: make(chan application.Response, 2),
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"prende el garage": {Action: domain.ActionTurnOn, TargetName: "Luz Garage", TargetType: domain.TargetTypeDevice},
+		},
+	}
+
+	assistant := application.NewAssistant(
+		source,
+		&mockSTT{},
+		intentParser,
+		&mockDeviceController{},
+		&mockRegistry{},
+		&application.NoopNotifier{},
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	resp := waitForReply(t, source.replies)
+	if resp.Err == nil || resp.Text != "Error: device not found: Luz Garage" {
+		t.Errorf("reply: got %+v, want device not found error", resp)
+	}
+
+	resp = waitForReply(t, source.replies)
+	if !errors.Is(resp.Err, application.ErrUnknownCommand) {
+		t.Errorf("reply error: got %v, want %v", resp.Err, application.ErrUnknownCommand)
+	}
+}
+
+func waitForReply(t *testing.T, replies <-chan application.Response) application.Response {
+	t.Helper()
+	select {
+	case resp := <-replies:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for reply")
+		return application.Response{}
+	}
+}
+
+func TestAssistant_GetStatus(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := &mockRequestSource{
+		mockAudioSource: mockAudioSource{
+			commands: [][]byte{[]byte(domain.TextCommandPrefix + "está prendida la luz del living?")},
+		},
+		replies: make(chan application.Response, 1),
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"está prendida la luz del living?": {
+				Action:     domain.ActionGetStatus,
+				TargetName: "Luz Living",
+				TargetType: domain.TargetTypeDevice,
+			},
+		},
+	}
+
+	level := 40
+	controller := &mockDeviceController{
+		states: map[string]*domain.DeviceState{
+			"dev123": {Status: "on", Level: &level},
+		},
+	}
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "dev123", Name: "Luz Living", Type: domain.DeviceTypeLight, Online: true},
+		},
+	}
+
+	assistant := application.NewAssistant(
+		source,
+		&mockSTT{},
+		intentParser,
+		controller,
+		registry,
+		&application.NoopNotifier{},
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	resp := waitForReply(t, source.replies)
+	if resp.Err != nil {
+		t.Fatalf("reply error: %v", resp.Err)
+	}
+	if resp.Text != "Luz Living is on at 40%" {
+		t.Errorf("reply: got %q, want %q", resp.Text, "Luz Living is on at 40%")
+	}
+
+	if len(controller.executedCommands) != 0 {
+		t.Errorf("status queries should not execute commands, got %d", len(controller.executedCommands))
+	}
+}
+
+func TestAssistant_AreaCommand(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := &mockRequestSource{
+		mockAudioSource: mockAudioSource{
+			commands: [][]byte{
+				[]byte(domain.TextCommandPrefix + "apagá todo en el dormitorio"),
+				[]byte(domain.TextCommandPrefix + "prendé las luces del living"),
+			},
+		},
+		replies: make(chan application.Response, 2),
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"apagá todo en el dormitorio": {
+				Action:     domain.ActionTurnOff,
+				TargetName: "dormitorio",
+				TargetType: domain.TargetTypeArea,
+			},
+			"prendé las luces del living": {
+				Action:     domain.ActionTurnOn,
+				TargetName: "Living",
+				TargetType: domain.TargetTypeArea,
+				DeviceType: domain.DeviceTypeLight,
+			},
+		},
+	}
+
+	controller := &mockDeviceController{}
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "light.bedroom", Name: "Luz Dormitorio", Type: domain.DeviceTypeLight, Area: "Dormitorio", Online: true},
+			{ID: "switch.fan", Name: "Ventilador", Type: domain.DeviceTypeSwitch, Area: "Dormitorio", Online: true},
+			{ID: "sensor.temp", Name: "Temperatura", Type: domain.DeviceTypeSensor, Area: "Dormitorio", Online: true},
+			{ID: "light.lamp", Name: "Velador", Type: domain.DeviceTypeLight, Area: "Dormitorio", Online: false},
+			{ID: "light.living", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living", Online: true},
+			{ID: "switch.tv", Name: "Tele", Type: domain.DeviceTypeSwitch, Area: "Living", Online: true},
+		},
+	}
+
+	assistant := application.NewAssistant(
+		source,
+		&mockSTT{},
+		intentParser,
+		controller,
+		registry,
+		&application.NoopNotifier{},
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	resp := waitForReply(t, source.replies)
+	if resp.Err != nil {
+		t.Fatalf("reply error: %v", resp.Err)
+	}
+	if want := "Command 'turn_off' executed on 2 of 2 devices in 'dormitorio'"; resp.Text != want {
+		t.Errorf("reply: got %q, want %q", resp.Text, want)
+	}
+
+	resp = waitForReply(t, source.replies)
+	if resp.Err != nil {
+		t.Fatalf("reply error: %v", resp.Err)
+	}
+
+	var targets []string
+	for _, cmd := range controller.executedCommands {
+		targets = append(targets, cmd.TargetID)
+	}
+	want := []string{"light.bedroom", "switch.fan", "light.living"}
+	if strings.Join(targets, ",") != strings.Join(want, ",") {
+		t.Errorf("executed on %v, want %v", targets, want)
+	}
+}
+
+func TestAssistant_AdaptsToCapabilities(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := &mockRequestSource{
+		mockAudioSource: mockAudioSource{
+			commands: [][]byte{
+				[]byte(domain.TextCommandPrefix + "poné el enchufe en rojo"),
+				[]byte(domain.TextCommandPrefix + "bajá la persiana al 30"),
+				[]byte(domain.TextCommandPrefix + "poné el aire en 40 grados"),
+			},
+		},
+		replies: make(chan application.Response, 3),
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"poné el enchufe en rojo": {
+				Action: domain.ActionSetColor, TargetName: "Enchufe", TargetType: domain.TargetTypeDevice,
+				Parameters: map[string]any{"color": "red"},
+			},
+			"bajá la persiana al 30": {
+				Action: domain.ActionSetLevel, TargetName: "Persiana", TargetType: domain.TargetTypeDevice,
+				Parameters: map[string]any{"level": 30.0},
+			},
+			"poné el aire en 40 grados": {
+				Action: domain.ActionSetTemperature, TargetName: "Aire", TargetType: domain.TargetTypeDevice,
+				Parameters: map[string]any{"temperature": 40.0},
+			},
+		},
+	}
+
+	climate := &domain.Capabilities{Actions: []domain.Action{domain.ActionSetTemperature}}
+	climate.SetRange("temperature", 16, 30)
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "plug", Name: "Enchufe", Type: domain.DeviceTypePlug, Online: true,
+				Capabilities: &domain.Capabilities{Actions: []domain.Action{domain.ActionTurnOn, domain.ActionTurnOff}}},
+			{ID: "cover", Name: "Persiana", Type: domain.DeviceTypeCover, Online: true,
+				Capabilities: &domain.Capabilities{Actions: []domain.Action{domain.ActionOpen, domain.ActionClose, domain.ActionSetPosition}}},
+			{ID: "ac", Name: "Aire", Type: domain.DeviceTypeThermostat, Online: true, Capabilities: climate},
+		},
+	}
+
+	controller := &mockDeviceController{}
+
+	assistant := application.NewAssistant(
+		source,
+		&mockSTT{},
+		intentParser,
+		controller,
+		registry,
+		&application.NoopNotifier{},
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	resp := waitForReply(t, source.replies)
+	if !errors.Is(resp.Err, application.ErrUnsupportedAction) {
+		t.Errorf("set_color on a plug: got %v, want %v", resp.Err, application.ErrUnsupportedAction)
+	}
+
+	for range 2 {
+		if resp := waitForReply(t, source.replies); resp.Err != nil {
+			t.Fatalf("reply error: %v", resp.Err)
+		}
+	}
+
+	if len(controller.executedCommands) != 2 {
+		t.Fatalf("expected 2 executed commands, got %d", len(controller.executedCommands))
+	}
+
+	cover := controller.executedCommands[0]
+	if cover.Action != domain.ActionSetPosition || cover.Parameters["position"] != 30.0 {
+		t.Errorf("cover command: got %s %v, want set_position to 30", cover.Action, cover.Parameters)
+	}
+
+	ac := controller.executedCommands[1]
+	if ac.Parameters["temperature"] != 30.0 {
+		t.Errorf("temperature should be clamped to 30, got %v", ac.Parameters["temperature"])
+	}
+}
+
+func TestAssistant_ResolvesRelativeAdjustments(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	texts := []string{"bajá la luz", "subí el aire tres grados", "subí el ventilador", "subí la persiana"}
+	source := &mockRequestSource{replies: make(chan application.Response, len(texts))}
+	for _, text := range texts {
+		source.commands = append(source.commands, []byte(domain.TextCommandPrefix+text))
+	}
+
+	adjust := func(action domain.Action, target string, delta float64) *domain.Command {
+		return &domain.Command{Action: action, TargetName: target, TargetType: domain.TargetTypeDevice,
+			Parameters: map[string]any{domain.ParamDelta: delta}}
+	}
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"bajá la luz":              adjust(domain.ActionSetLevel, "Luz", -10),
+			"subí el aire tres grados": adjust(domain.ActionSetTemperature, "Aire", 3),
+			"subí el ventilador":       adjust(domain.ActionSetLevel, "Ventilador", 10),
+			"subí la persiana":         adjust(domain.ActionSetPosition, "Persiana", 10),
+		},
+	}
+
+	climate := &domain.Capabilities{Actions: []domain.Action{domain.ActionSetTemperature}}
+	climate.SetRange("temperature", 16, 30)
+	level := 40
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "light", Name: "Luz", Type: domain.DeviceTypeLight, Online: true,
+				State: &domain.DeviceState{Status: "on", Level: &level}},
+			// The registry doesn't know the setpoint; the controller does
+			{ID: "ac", Name: "Aire", Type: domain.DeviceTypeThermostat, Online: true, Capabilities: climate},
+			{ID: "fan", Name: "Ventilador", Type: domain.DeviceTypeFan, Online: true,
+				State: &domain.DeviceState{Status: "off"}},
+			{ID: "cover", Name: "Persiana", Type: domain.DeviceTypeCover, Online: true},
+		},
+	}
+
+	setpoint := 29.0
+	controller := &mockDeviceController{
+		states: map[string]*domain.DeviceState{"ac": {Status: "cool", TargetTemperature: &setpoint}},
+	}
+
+	assistant := application.NewAssistant(source, &mockSTT{}, intentParser, controller, registry, &application.NoopNotifier{}, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	for range texts {
+		if resp := waitForReply(t, source.replies); resp.Err != nil {
+			t.Fatalf("reply error: %v", resp.Err)
+		}
+	}
+
+	if len(controller.executedCommands) != len(texts) {
+		t.Fatalf("expected %d executed commands, got %d", len(texts), len(controller.executedCommands))
+	}
+
+	want := []struct {
+		param string
+		value any
+	}{
+		{"level", 30.0},
+		{"temperature", 30.0}, // 29 + 3, clamped to the range
+		{"level", 10.0},       // off counts as 0
+		{"position", nil},     // unknown: left to the backend
+	}
+	for i, w := range want {
+		cmd := controller.executedCommands[i]
+		if got := cmd.Parameters[w.param]; got != w.value {
+			t.Errorf("%s: %s got %v, want %v", cmd.TargetName, w.param, got, w.value)
+		}
+		if _, ok := cmd.Delta(); !ok {
+			t.Errorf("%s: the delta should be kept for the backend", cmd.TargetName)
+		}
+	}
+}
+
+func TestAssistant_RemembersConversation(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := &mockRequestSource{
+		mockAudioSource: mockAudioSource{
+			commands: [][]byte{
+				[]byte(domain.TextCommandPrefix + "prende la luz del living"),
+				[]byte(domain.TextCommandPrefix + "bajala al 30"),
+			},
+		},
+		replies: make(chan application.Response, 2),
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"prende la luz del living": {Action: domain.ActionTurnOn, TargetName: "Luz Living", TargetType: domain.TargetTypeDevice},
+			"bajala al 30": {
+				Action:     domain.ActionSetLevel,
+				TargetName: "Luz Living",
+				TargetType: domain.TargetTypeDevice,
+				Parameters: map[string]any{"level": 30.0},
+			},
+		},
+	}
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "dev123", Name: "Luz Living", Type: domain.DeviceTypeLight, Online: true},
+		},
+	}
+
+	assistant := application.NewAssistant(
+		source,
+		&mockSTT{},
+		intentParser,
+		&mockDeviceController{},
+		registry,
+		&application.NoopNotifier{},
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	waitForReply(t, source.replies)
+	waitForReply(t, source.replies)
+
+	if len(intentParser.conversations) != 2 {
+		t.Fatalf("expected 2 parses, got %d", len(intentParser.conversations))
+	}
+	if conv := intentParser.conversations[0]; conv != nil {
+		t.Errorf("first utterance should have no history, got %+v", conv)
+	}
+
+	conv := intentParser.conversations[1]
+	if conv == nil || len(conv.Turns) != 1 {
+		t.Fatalf("second utterance should see the first turn, got %+v", conv)
+	}
+	if conv.Turns[0].Text != "prende la luz del living" {
+		t.Errorf("turn text: got %q", conv.Turns[0].Text)
+	}
+	if !slices.Equal(conv.LastTargets, []string{"Luz Living"}) {
+		t.Errorf("last targets: got %v", conv.LastTargets)
+	}
+}
+
+func TestSessions_Expire(t *testing.T) {
+	sessions := application.NewSessions(20 * time.Millisecond)
+	sessions.Record("alexa:1", application.Turn{Text: "prende la luz"})
+
+	if conv := sessions.Get("alexa:1"); conv == nil || len(conv.Turns) != 1 {
+		t.Fatalf("expected one turn, got %+v", conv)
+	}
+	if conv := sessions.Get("alexa:2"); conv != nil {
+		t.Errorf("other sessions should be empty, got %+v", conv)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if conv := sessions.Get("alexa:1"); conv != nil {
+		t.Errorf("session should have expired, got %+v", conv)
+	}
+}
+
+func TestAssistant_AsksWhichDevice(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	source := &mockRequestSource{
+		mockAudioSource: mockAudioSource{
+			commands: [][]byte{
+				[]byte(domain.TextCommandPrefix + "prende la lámpara"),
+				[]byte(domain.TextCommandPrefix + "la del living"),
+			},
+		},
+		replies: make(chan application.Response, 2),
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"prende la lámpara": {Action: domain.ActionTurnOn, TargetName: "Lámpara", TargetType: domain.TargetTypeDevice, Confidence: 0.9},
+		},
+	}
+
+	controller := &mockDeviceController{}
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "lamp1", Name: "Lámpara Dormitorio", Type: domain.DeviceTypeLight, Online: true},
+			{ID: "lamp2", Name: "Lámpara Living", Type: domain.DeviceTypeLight, Online: true},
+			{ID: "lamp3", Name: "Lámpara Escritorio", Type: domain.DeviceTypeLight, Online: true},
+		},
+	}
+
+	assistant := application.NewAssistant(
+		source,
+		&mockSTT{},
+		intentParser,
+		controller,
+		registry,
+		&application.NoopNotifier{},
+		logger,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	question := waitForReply(t, source.replies)
+	if !question.Question {
+		t.Fatalf("expected a question, got %+v", question)
+	}
+	want := "Hay 3 dispositivos que coinciden con 'Lámpara': Lámpara Dormitorio, Lámpara Living o Lámpara Escritorio. ¿Cuál?"
+	if question.Text != want {
+		t.Errorf("question: got %q, want %q", question.Text, want)
+	}
+
+	resp := waitForReply(t, source.replies)
+	if resp.Err != nil || resp.Question {
+		t.Fatalf("answer should complete the command, got %+v", resp)
+	}
+	if len(controller.executedCommands) != 1 || controller.executedCommands[0].TargetID != "lamp2" {
+		t.Fatalf("expected the living lamp to be turned on, got %+v", controller.executedCommands)
+	}
+	if len(intentParser.conversations) != 1 {
+		t.Errorf("the answer should not go through the parser, got %d parses", len(intentParser.conversations))
+	}
+}
+
+func TestAssistant_ConfirmsLowConfidence(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		answer       string
+		wantText     string
+		wantExecuted int
+	}{
+		{name: "confirmed", answer: "sí, dale", wantText: "Command 'turn_off' executed on 'Luz Garage'", wantExecuted: 1},
+		{name: "declined", answer: "no", wantText: "Cancelado", wantExecuted: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &mockRequestSource{
+				mockAudioSource: mockAudioSource{
+					commands: [][]byte{
+						[]byte(domain.TextCommandPrefix + "apagá eso"),
+						[]byte(domain.TextCommandPrefix + tt.answer),
+					},
+				},
+				replies: make(chan application.Response, 2),
+			}
+
+			intentParser := &mockIntentParser{
+				intents: map[string]*domain.Command{
+					"apagá eso": {Action: domain.ActionTurnOff, TargetName: "Luz Garage", TargetType: domain.TargetTypeDevice, Confidence: 0.3},
+				},
+			}
+
+			controller := &mockDeviceController{}
+			registry := &mockRegistry{
+				devices: []domain.Device{
+					{ID: "garage", Name: "Luz Garage", Type: domain.DeviceTypeLight, Online: true},
+				},
+			}
+
+			assistant := application.NewAssistant(
+				source,
+				&mockSTT{},
+				intentParser,
+				controller,
+				registry,
+				&application.NoopNotifier{},
+				logger,
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				_ = assistant.Run(ctx)
+			}()
+
+			question := waitForReply(t, source.replies)
+			if !question.Question || !strings.Contains(question.Text, "turn_off en 'Luz Garage'") {
+				t.Fatalf("expected a confirmation question, got %+v", question)
+			}
+
+			resp := waitForReply(t, source.replies)
+			if resp.Text != tt.wantText {
+				t.Errorf("reply: got %q, want %q", resp.Text, tt.wantText)
+			}
+			if len(controller.executedCommands) != tt.wantExecuted {
+				t.Errorf("executed: got %d, want %d", len(controller.executedCommands), tt.wantExecuted)
+			}
+		})
+	}
+}
+
+// memoryTimerStore records every save; the scheduler calls it from the
+// assistant's goroutine and its own.
+type memoryTimerStore struct {
+	mu    sync.Mutex
+	saves [][]application.Timer
+}
+
+func (m *memoryTimerStore) Load() ([]application.Timer, error) { return nil, nil }
+func (m *memoryTimerStore) Save(timers []application.Timer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.saves = append(m.saves, slices.Clone(timers))
+	return nil
+}
+
+func TestAssistant_Timers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	texts := []string{
+		"apagá el ventilador en una hora",
+		"qué timers hay",
+		"cancelá el timer del ventilador",
+		"prendé la luz un ratito",
+	}
+	source := &mockRequestSource{replies: make(chan application.Response, len(texts))}
+	for _, text := range texts {
+		source.commands = append(source.commands, []byte(domain.TextCommandPrefix+text))
+	}
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"apagá el ventilador en una hora": {Action: domain.ActionTurnOff, TargetName: "Ventilador", TargetType: domain.TargetTypeDevice, Delay: time.Hour},
+			"qué timers hay":                  {Action: domain.ActionListTimers},
+			"cancelá el timer del ventilador": {Action: domain.ActionCancelTimer, TargetName: "Ventilador"},
+			"prendé la luz un ratito":         {Action: domain.ActionTurnOn, TargetName: "Luz", TargetType: domain.TargetTypeDevice, Duration: 50 * time.Millisecond},
+		},
+	}
+
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "fan", Name: "Ventilador", Type: domain.DeviceTypeFan, Online: true},
+			{ID: "light", Name: "Luz", Type: domain.DeviceTypeLight, Online: true},
+		},
+	}
+
+	controller := &mockDeviceController{}
+	notifier := &recordingNotifier{messages: make(chan string, 10)}
+	store := &memoryTimerStore{}
+
+	assistant := application.NewAssistant(source, &mockSTT{}, intentParser, controller, registry, notifier, logger)
+	assistant.SetScheduler(application.NewScheduler(store, logger))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	if resp := waitForReply(t, source.replies); !strings.HasPrefix(resp.Text, "Programado: turn_off en 'Ventilador'") {
+		t.Errorf("scheduling reply: got %q", resp.Text)
+	}
+	if resp := waitForReply(t, source.replies); !strings.Contains(resp.Text, "turn_off en 'Ventilador'") {
+		t.Errorf("listing reply: got %q", resp.Text)
+	}
+	if resp := waitForReply(t, source.replies); !strings.HasPrefix(resp.Text, "Cancelado: turn_off en 'Ventilador'") {
+		t.Errorf("cancelling reply: got %q", resp.Text)
+	}
+
+	// Said with a duration, the command runs now and is undone later
+	if resp := waitForReply(t, source.replies); resp.Err != nil {
+		t.Fatalf("reply error: %v", resp.Err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		var msg string
+		select {
+		case msg = <-notifier.messages:
+		case <-deadline:
+			t.Fatal("timeout waiting for the timer to run")
+		}
+		if strings.HasPrefix(msg, "Timer: ") {
+			break
+		}
+	}
+
+	var actions []domain.Action
+	for _, cmd := range controller.executedCommands {
+		actions = append(actions, cmd.Action)
+	}
+	if !slices.Equal(actions, []domain.Action{domain.ActionTurnOn, domain.ActionTurnOff}) {
+		t.Errorf("executed %v, want turn_on then turn_off", actions)
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	// Set, cancelled, set, run
+	if len(store.saves) != 4 {
+		t.Fatalf("expected 4 saves, got %v", store.saves)
+	}
+	if first := store.saves[0]; len(first) != 1 || time.Until(first[0].At) < 59*time.Minute {
+		t.Errorf("first save: got %v, want the fan timer an hour from now", first)
+	}
+	if last := store.saves[3]; len(last) != 0 {
+		t.Errorf("no timers should be left, got %v", last)
+	}
+}
+
+// onceSchedule is due once, at the given time.
+type onceSchedule time.Time
+
+func (s onceSchedule) Next(after time.Time) time.Time {
+	if at := time.Time(s); after.Before(at) {
+		return at
+	}
+	return time.Time{}
+}
+
+func (s onceSchedule) String() string { return "once" }
+
+func TestAssistant_Routines(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	intentParser := &mockIntentParser{
+		intents: map[string]*domain.Command{
+			"abrí la persiana": {Action: domain.ActionOpen, TargetName: "Persiana", TargetType: domain.TargetTypeDevice, Confidence: 1},
+		},
+	}
+	registry := &mockRegistry{
+		devices: []domain.Device{
+			{ID: "cover", Name: "Persiana", Type: domain.DeviceTypeCover, Online: true},
+			{ID: "light", Name: "Luz", Type: domain.DeviceTypeLight, Online: true},
+		},
+	}
+	controller := &mockDeviceController{}
+	notifier := &recordingNotifier{messages: make(chan string, 10)}
+
+	soon := onceSchedule(time.Now().Add(50 * time.Millisecond))
+	routines := application.NewRoutines([]application.Routine{
+		{
+			Name:     "Mañana",
+			Schedule: soon,
+			Phrases:  []string{"abrí la persiana", "hacé magia"},
+			Commands: []domain.Command{{Action: domain.ActionTurnOn, TargetName: "Luz", TargetType: domain.TargetTypeDevice}},
+		},
+		{
+			Name:     "Noche",
+			Schedule: soon,
+			Commands: []domain.Command{{Action: domain.ActionTurnOff, TargetName: "Luz", TargetType: domain.TargetTypeDevice}},
+		},
+	}, nil, logger)
+	if _, err := routines.SetEnabled("noche", false); err != nil {
+		t.Fatalf("SetEnabled error: %v", err)
+	}
+
+	assistant := application.NewAssistant(&mockAudioSource{}, &mockSTT{}, intentParser, controller, registry, notifier, logger)
+	assistant.SetRoutines(routines)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = assistant.Run(ctx)
+	}()
+
+	var status application.RoutineStatus
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status = routines.List()[0]
+		if !status.LastRun.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the routine to run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The phrase went through the parser and the explicit command was run;
+	// the disabled routine wasn't
+	var actions []domain.Action
+	for _, cmd := range controller.executedCommands {
+		actions = append(actions, cmd.Action)
+	}
+	if !slices.Equal(actions, []domain.Action{domain.ActionOpen, domain.ActionTurnOn}) {
+		t.Errorf("executed %v, want open then turn_on", actions)
+	}
+	if !strings.Contains(status.LastResult, "Persiana") || !strings.Contains(status.LastResult, "Luz") {
+		t.Errorf("last result: got %q", status.LastResult)
+	}
+	if !strings.Contains(status.LastError, "hacé magia") {
+		t.Errorf("last error should name the phrase that failed, got %q", status.LastError)
+	}
+	if noche := routines.List()[1]; !noche.LastRun.IsZero() || noche.Enabled {
+		t.Errorf("the disabled routine shouldn't run: %+v", noche)
+	}
+}
@@ -9,6 +9,34 @@ type AudioSource interface {
 	Name() string
 }
 
+// Request is a single command received from an audio source together with
+// an optional way to report its outcome back to whoever issued it.
+type Request struct {
+	Audio []byte
+	// SessionID groups requests into a conversation, e.g. an Alexa session
+	// or an HTTP client. Empty means the audio source's own session.
+	SessionID string
+	// Reply, when set, is called exactly once with the outcome of the
+	// request. It must not block.
+	Reply func(Response)
+}
+
+// Response is the outcome of a Request: the text to speak or display, or
+// the error that prevented the command from completing.
+type Response struct {
+	Text string
+	Err  error
+	// Question reports that Text asks the user something; the answer is
+	// expected as the next request of the same session.
+	Question bool
+}
+
+// RequestSource is implemented by audio sources whose callers wait for the
+// result of their command, such as the HTTP source answering Alexa.
+type RequestSource interface {
+	NextRequest(ctx context.Context) (*Request, error)
+}
+
 type AudioFormat struct {
 	SampleRate int
 	Channels   int
@@ -22,4 +50,3 @@ func DefaultAudioFormat() AudioFormat {
 		BitDepth:   16,
 	}
 }
-
@@ -0,0 +1,218 @@
+package application
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"smart-home/internal/domain"
+)
+
+// DefaultMinConfidence is the parser confidence below which the assistant
+// asks before acting. Commands that report no confidence are trusted.
+const DefaultMinConfidence = 0.5
+
+// clarification is a question waiting for the user's answer, together with
+// the commands it holds back.
+type clarification struct {
+	question string
+	cmds     []*domain.Command
+	// index is the command whose target is ambiguous, or -1 when the whole
+	// utterance needs confirming
+	index   int
+	options []domain.Device
+}
+
+var (
+	yesWords = []string{"si", "sí", "dale", "ok", "okay", "claro", "yes", "yeah", "sure", "hacelo", "confirmo"}
+	noWords  = []string{"no", "nada", "cancelar", "cancelá", "cancela", "dejá", "deja", "cancel", "nope"}
+	allWords = []string{"todas", "todos", "ambas", "ambos", "all", "both"}
+	ordinals = map[string]int{
+		"1": 0, "uno": 0, "una": 0, "primera": 0, "primero": 0, "first": 0,
+		"2": 1, "dos": 1, "segunda": 1, "segundo": 1, "second": 1,
+		"3": 2, "tres": 2, "tercera": 2, "tercero": 2, "third": 2,
+		"4": 3, "cuatro": 3, "cuarta": 3, "cuarto": 3, "fourth": 3,
+	}
+)
+
+// clarify looks for the first thing in the commands worth asking about: a
+// device name several devices answer to, or an interpretation the parser
+// wasn't confident about. It returns nil when the commands can run as they
+// are. Ambiguous names that only one of the candidates can act on are
+// resolved to that device without asking.
+func (a *Assistant) clarify(cmds []*domain.Command) *clarification {
+	devices := a.registry.GetDevices()
+
+	for i, cmd := range cmds {
+		if cmd.TargetType != domain.TargetTypeDevice {
+			continue
+		}
+		candidates := ambiguousDevices(devices, cmd)
+		switch len(candidates) {
+		case 0:
+			continue
+		case 1:
+			a.logger.Info("resolved ambiguous device by capability", "name", cmd.TargetName, "device", candidates[0].Name)
+			cmd.TargetName = candidates[0].Name
+			continue
+		}
+
+		names := make([]string, len(candidates))
+		for j, d := range candidates {
+			names[j] = d.Name
+		}
+		return &clarification{
+			question: fmt.Sprintf("Hay %d dispositivos que coinciden con '%s': %s. ¿Cuál?", len(candidates), cmd.TargetName, joinOr(names)),
+			cmds:     cmds,
+			index:    i,
+			options:  candidates,
+		}
+	}
+
+	var doubtful []string
+	for _, cmd := range cmds {
+		if cmd.Confidence > 0 && cmd.Confidence < a.minConfidence {
+			doubtful = append(doubtful, fmt.Sprintf("%s en '%s'", cmd.Action, cmd.TargetName))
+		}
+	}
+	if len(doubtful) > 0 {
+		return &clarification{
+			question: fmt.Sprintf("No estoy seguro de haber entendido: %s. ¿Lo hago?", strings.Join(doubtful, ", ")),
+			cmds:     cmds,
+			index:    -1,
+		}
+	}
+
+	return nil
+}
+
+// ambiguousDevices returns the devices a name could refer to when there is
+// no exact match and more than one contains it. Candidates that can't
+// perform the action are left out unless that leaves none.
+func ambiguousDevices(devices []domain.Device, cmd *domain.Command) []domain.Device {
+	key := strings.ToLower(strings.TrimSpace(cmd.TargetName))
+	if key == "" {
+		return nil
+	}
+
+	var matches []domain.Device
+	for _, d := range devices {
+		name := strings.ToLower(d.Name)
+		if name == key {
+			return nil
+		}
+		if strings.Contains(name, key) {
+			matches = append(matches, d)
+		}
+	}
+	if len(matches) < 2 {
+		return nil
+	}
+
+	var capable []domain.Device
+	for _, d := range matches {
+		if d.Supports(cmd.Action) {
+			capable = append(capable, d)
+		}
+	}
+	if len(capable) == 0 {
+		return matches
+	}
+	return capable
+}
+
+// answer applies the user's reply to a pending question. It returns the
+// commands to run now, or ok=false when the reply doesn't answer the
+// question and should be handled as a new utterance. A refusal returns no
+// commands and ok=true.
+func (c *clarification) answer(text string) (cmds []*domain.Command, ok bool) {
+	words := answerWords(text)
+
+	if c.index < 0 {
+		switch {
+		case containsAny(words, yesWords):
+			for _, cmd := range c.cmds {
+				cmd.Confidence = 1
+			}
+			return c.cmds, true
+		case containsAny(words, noWords):
+			return nil, true
+		}
+		return nil, false
+	}
+
+	if containsAny(words, noWords) && len(words) == 1 {
+		return nil, true
+	}
+
+	target := c.cmds[c.index]
+	if containsAny(words, allWords) {
+		expanded := make([]*domain.Command, 0, len(c.cmds)+len(c.options)-1)
+		expanded = append(expanded, c.cmds[:c.index]...)
+		for _, d := range c.options {
+			cmd := *target
+			cmd.TargetName = d.Name
+			cmd.Confidence = 1
+			expanded = append(expanded, &cmd)
+		}
+		return append(expanded, c.cmds[c.index+1:]...), true
+	}
+
+	choice, found := c.choose(words)
+	if !found {
+		return nil, false
+	}
+	target.TargetName = choice.Name
+	target.Confidence = 1
+	return c.cmds, true
+}
+
+// choose picks the option the reply names: by position ("la segunda") or by
+// the option sharing the most words with the reply ("la del living").
+func (c *clarification) choose(words []string) (domain.Device, bool) {
+	best, bestScore, tied := -1, 0, false
+	for i, d := range c.options {
+		name := strings.ToLower(d.Name)
+		score := 0
+		for _, w := range words {
+			if len([]rune(w)) >= 3 && strings.Contains(name, w) {
+				score++
+			}
+		}
+		switch {
+		case score > bestScore:
+			best, bestScore, tied = i, score, false
+		case score == bestScore && score > 0:
+			tied = true
+		}
+	}
+	if best >= 0 && !tied {
+		return c.options[best], true
+	}
+
+	for _, w := range words {
+		if i, ok := ordinals[w]; ok && i < len(c.options) {
+			return c.options[i], true
+		}
+	}
+	return domain.Device{}, false
+}
+
+func answerWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func containsAny(words, set []string) bool {
+	return slices.ContainsFunc(words, func(w string) bool { return slices.Contains(set, w) })
+}
+
+// joinOr lists names the way they are read out: "A, B o C".
+func joinOr(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " o " + names[len(names)-1]
+}
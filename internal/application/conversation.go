@@ -0,0 +1,150 @@
+package application
+
+import (
+	"sync"
+	"time"
+
+	"smart-home/internal/domain"
+)
+
+const (
+	// DefaultSessionTimeout is how long a conversation is remembered after
+	// its last turn.
+	DefaultSessionTimeout = 5 * time.Minute
+
+	maxConversationTurns = 5
+)
+
+// Turn is one exchange of a conversation: what the user said and what the
+// assistant did about it.
+type Turn struct {
+	Text     string
+	Result   string
+	Commands []*domain.Command
+}
+
+// Conversation is the recent history of a session, handed to the intent
+// parser so follow-ups like "now make it dimmer" can be resolved.
+type Conversation struct {
+	ID    string
+	Turns []Turn
+	// LastTargets are the devices, scenes or areas the last turn acted on
+	LastTargets []string
+}
+
+type session struct {
+	conversation Conversation
+	pending      *clarification
+	lastSeen     time.Time
+}
+
+// Sessions keeps one conversation per source (an Alexa session, an HTTP
+// client, the microphone...). Conversations idle for longer than the
+// timeout are forgotten.
+type Sessions struct {
+	mu       sync.Mutex
+	timeout  time.Duration
+	sessions map[string]*session
+}
+
+func NewSessions(timeout time.Duration) *Sessions {
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+	return &Sessions{
+		timeout:  timeout,
+		sessions: make(map[string]*session),
+	}
+}
+
+// Get returns a snapshot of the session's conversation, or nil if there is
+// none or it expired.
+func (s *Sessions) Get(id string) *Conversation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+
+	conv := sess.conversation
+	conv.Turns = append([]Turn(nil), conv.Turns...)
+	conv.LastTargets = append([]string(nil), conv.LastTargets...)
+	return &conv
+}
+
+// Record appends a turn to the session, keeping only the latest ones.
+func (s *Sessions) Record(id string, turn Turn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	conv := &s.session(id).conversation
+	conv.Turns = append(conv.Turns, turn)
+	if len(conv.Turns) > maxConversationTurns {
+		conv.Turns = conv.Turns[len(conv.Turns)-maxConversationTurns:]
+	}
+
+	var targets []string
+	for _, cmd := range turn.Commands {
+		if cmd.TargetName != "" {
+			targets = append(targets, cmd.TargetName)
+		}
+	}
+	if len(targets) > 0 {
+		conv.LastTargets = targets
+	}
+}
+
+// ask stores a question the next utterance of the session may answer,
+// replacing any earlier one.
+func (s *Sessions) ask(id string, c *clarification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+	s.session(id).pending = c
+}
+
+// takePending returns the question waiting for an answer, if any, and
+// forgets it: the next utterance either answers it or moves on.
+func (s *Sessions) takePending(id string) *clarification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	c := sess.pending
+	sess.pending = nil
+	return c
+}
+
+// session returns the session, creating it if needed, and marks it as just
+// seen. Callers must hold the lock.
+func (s *Sessions) session(id string) *session {
+	sess, ok := s.sessions[id]
+	if !ok {
+		sess = &session{conversation: Conversation{ID: id}}
+		s.sessions[id] = sess
+	}
+	sess.lastSeen = time.Now()
+	return sess
+}
+
+// expire drops idle sessions. Callers must hold the lock.
+func (s *Sessions) expire() {
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if now.Sub(sess.lastSeen) > s.timeout {
+			delete(s.sessions, id)
+		}
+	}
+}
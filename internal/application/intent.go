@@ -6,7 +6,12 @@ import (
 	"smart-home/internal/domain"
 )
 
+// IntentParser turns an utterance into the ordered list of commands it
+// contains. A sentence like "turn off the kitchen light and turn on the lamp"
+// yields two commands, to be executed in the order they were spoken.
+//
+// conv holds the recent turns of the same session, used to resolve
+// references like "it" or "a bit more"; it is nil for a fresh session.
 type IntentParser interface {
-	Parse(ctx context.Context, text string, registry DeviceRegistry) (*domain.Command, error)
+	Parse(ctx context.Context, text string, registry DeviceRegistry, conv *Conversation) ([]*domain.Command, error)
 }
-
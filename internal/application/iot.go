@@ -10,6 +10,7 @@ import (
 type DeviceController interface {
 	ExecuteCommand(ctx context.Context, cmd *domain.Command) error
 	TriggerScene(ctx context.Context, sceneID string) error
+	GetState(ctx context.Context, deviceID string) (*domain.DeviceState, error)
 }
 
 type DeviceRegistry interface {
@@ -18,7 +19,6 @@ type DeviceRegistry interface {
 	GetScenes() []domain.Scene
 	FindDeviceByName(name string) (*domain.Device, bool)
 	FindSceneByName(name string) (*domain.Scene, bool)
-	Summary() string
 	StartPeriodicSync(ctx context.Context, interval time.Duration)
 }
 
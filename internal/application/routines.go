@@ -0,0 +1,310 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"smart-home/internal/domain"
+)
+
+// ErrRoutineNotFound is reported for a routine name that isn't configured.
+var ErrRoutineNotFound = errors.New("routine not found")
+
+// Schedule says when a routine runs: a cron expression, or a time relative
+// to sunrise or sunset.
+type Schedule interface {
+	// Next returns the first time after the given one the routine is due,
+	// or the zero time if it never is.
+	Next(after time.Time) time.Time
+	String() string
+}
+
+// Routine is a list of things to do on a schedule: phrases said as if the
+// user had, and explicit commands.
+type Routine struct {
+	Name     string
+	Schedule Schedule
+	Phrases  []string
+	Commands []domain.Command
+}
+
+// RoutineState is what changes of a routine at runtime, kept across
+// restarts.
+type RoutineState struct {
+	Enabled    bool
+	LastRun    time.Time
+	LastResult string
+	LastError  string
+}
+
+// RoutineStatus describes a routine and how it has been doing.
+type RoutineStatus struct {
+	Name     string
+	Schedule string
+	Next     time.Time
+	RoutineState
+}
+
+// RoutineStore keeps the state of the routines across restarts, by name.
+type RoutineStore interface {
+	Load() (map[string]RoutineState, error)
+	Save(states map[string]RoutineState) error
+}
+
+type routine struct {
+	Routine
+	state RoutineState
+	next  time.Time
+}
+
+// Routines runs the configured routines when they are due and records how
+// each run went.
+type Routines struct {
+	store  RoutineStore
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	routines []*routine
+}
+
+// NewRoutines creates the runner of the given routines, all enabled until
+// Load says otherwise. store may be nil to keep the state in memory only.
+func NewRoutines(routines []Routine, store RoutineStore, logger *slog.Logger) *Routines {
+	r := &Routines{store: store, logger: logger}
+	for _, def := range routines {
+		r.routines = append(r.routines, &routine{Routine: def, state: RoutineState{Enabled: true}})
+	}
+	return r
+}
+
+// Load restores the state saved by a previous run. Routines missed while
+// the assistant was down are not caught up on.
+func (r *Routines) Load() error {
+	if r.store == nil {
+		return nil
+	}
+	states, err := r.store.Load()
+	if err != nil {
+		return fmt.Errorf("loading routines: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.routines {
+		if state, ok := states[rt.Name]; ok {
+			rt.state = state
+		}
+	}
+	return nil
+}
+
+// List returns the routines in the order they were configured.
+func (r *Routines) List() []RoutineStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	list := make([]RoutineStatus, 0, len(r.routines))
+	for _, rt := range r.routines {
+		list = append(list, rt.status(now))
+	}
+	return list
+}
+
+// SetEnabled turns a routine on or off, matching its name
+// case-insensitively.
+func (r *Routines) SetEnabled(name string, enabled bool) (RoutineStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.routines {
+		if !strings.EqualFold(rt.Name, name) {
+			continue
+		}
+		previous := rt.state.Enabled
+		rt.state.Enabled = enabled
+		if err := r.save(); err != nil {
+			rt.state.Enabled = previous
+			return RoutineStatus{}, err
+		}
+		r.logger.Info("routine toggled", "routine", rt.Name, "enabled", enabled)
+		return rt.status(time.Now()), nil
+	}
+	return RoutineStatus{}, fmt.Errorf("%w: %s", ErrRoutineNotFound, name)
+}
+
+func (rt *routine) status(now time.Time) RoutineStatus {
+	next := rt.next
+	if next.IsZero() {
+		next = rt.Schedule.Next(now)
+	}
+	return RoutineStatus{
+		Name:         rt.Name,
+		Schedule:     rt.Schedule.String(),
+		Next:         next,
+		RoutineState: rt.state,
+	}
+}
+
+// Start runs the routines in the background until ctx is done, calling run
+// for each one that is due and enabled.
+func (r *Routines) Start(ctx context.Context, run func(ctx context.Context, routine Routine) (string, error)) {
+	go func() {
+		r.schedule(time.Now())
+		for {
+			for _, rt := range r.due(time.Now()) {
+				r.logger.Info("running routine", "routine", rt.Name)
+				result, err := run(ctx, rt)
+				r.record(rt.Name, result, err)
+			}
+
+			wait := maxTimerWait
+			if next, ok := r.soonest(); ok {
+				wait = min(max(time.Until(next), 0), maxTimerWait)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// schedule sets the next run of every routine from now on.
+func (r *Routines) schedule(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.routines {
+		rt.next = rt.Schedule.Next(now)
+		if rt.next.IsZero() {
+			r.logger.Warn("routine will never run", "routine", rt.Name, "schedule", rt.Schedule.String())
+		}
+	}
+}
+
+// due returns the routines whose time has come and moves them on to their
+// next run. Disabled ones are skipped.
+func (r *Routines) due(now time.Time) []Routine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var due []Routine
+	for _, rt := range r.routines {
+		if rt.next.IsZero() || rt.next.After(now) {
+			continue
+		}
+		if rt.state.Enabled {
+			due = append(due, rt.Routine)
+		} else {
+			r.logger.Debug("skipping disabled routine", "routine", rt.Name)
+		}
+		rt.next = rt.Schedule.Next(now)
+	}
+	return due
+}
+
+func (r *Routines) soonest() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var soonest time.Time
+	for _, rt := range r.routines {
+		if !rt.next.IsZero() && (soonest.IsZero() || rt.next.Before(soonest)) {
+			soonest = rt.next
+		}
+	}
+	return soonest, !soonest.IsZero()
+}
+
+// record keeps the outcome of a run.
+func (r *Routines) record(name, result string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.routines {
+		if rt.Name != name {
+			continue
+		}
+		rt.state.LastRun = time.Now()
+		rt.state.LastResult = result
+		rt.state.LastError = ""
+		if err != nil {
+			rt.state.LastError = err.Error()
+			r.logger.Error("routine failed", "routine", name, "error", err)
+		}
+	}
+	if err := r.save(); err != nil {
+		r.logger.Error("saving routines", "error", err)
+	}
+}
+
+// save writes the state of every routine. Callers must hold the lock.
+func (r *Routines) save() error {
+	if r.store == nil {
+		return nil
+	}
+	states := make(map[string]RoutineState, len(r.routines))
+	for _, rt := range r.routines {
+		states[rt.Name] = rt.state
+	}
+	if err := r.store.Save(states); err != nil {
+		return fmt.Errorf("saving routines: %w", err)
+	}
+	return nil
+}
+
+// runRoutine says the phrases of a routine through the same pipeline as the
+// user's, in a session of its own, then runs its explicit commands. Nobody
+// is there to answer a question, so a phrase the assistant would ask about
+// fails instead.
+func (a *Assistant) runRoutine(ctx context.Context, routine Routine) (string, error) {
+	session := "routine:" + routine.Name
+
+	var (
+		lines []string
+		errs  []error
+	)
+	for _, phrase := range routine.Phrases {
+		resp := a.handle(ctx, &Request{Audio: []byte(domain.TextCommandPrefix + phrase), SessionID: session})
+		switch {
+		case resp.Question:
+			a.sessions.takePending(session)
+			errs = append(errs, fmt.Errorf("%q needs an answer: %s", phrase, resp.Text))
+			continue
+		case resp.Err != nil:
+			errs = append(errs, fmt.Errorf("%q: %w", phrase, resp.Err))
+		}
+		if resp.Text != "" {
+			lines = append(lines, resp.Text)
+		}
+	}
+
+	if len(routine.Commands) > 0 {
+		cmds := make([]*domain.Command, len(routine.Commands))
+		for i, cmd := range routine.Commands {
+			cmd.Parameters = maps.Clone(cmd.Parameters)
+			cmds[i] = &cmd
+		}
+		result, err := a.executeAll(ctx, cmds)
+		lines = append(lines, result)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if err := a.notifier.Notify(ctx, fmt.Sprintf("Rutina '%s': %s", routine.Name, result)); err != nil {
+			a.logger.Error("notifying routine", "error", err)
+		}
+	}
+
+	return strings.Join(lines, "\n"), errors.Join(errs...)
+}
@@ -0,0 +1,275 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"smart-home/internal/domain"
+)
+
+// ErrTimerNotFound is reported when cancelling a timer that already ran or
+// never existed.
+var ErrTimerNotFound = errors.New("timer not found")
+
+// maxTimerWait bounds how long the scheduler sleeps, so that a clock set
+// after boot (a Raspberry Pi without a battery) doesn't leave timers late.
+const maxTimerWait = time.Minute
+
+// Timer is a command waiting for its time: "apagá la estufa en 30
+// minutos", or the end of "prendé el ventilador por 10 minutos".
+type Timer struct {
+	ID      string
+	At      time.Time
+	Command domain.Command
+}
+
+// String describes the timer the way it is read out.
+func (t Timer) String() string {
+	return fmt.Sprintf("%s en '%s' a las %s", t.Command.Action, t.Command.TargetName, clock(t.At, time.Now()))
+}
+
+// clock formats a time of the day, with the date when it isn't today.
+func clock(t, now time.Time) string {
+	if y, m, d := t.Date(); y == now.Year() && m == now.Month() && d == now.Day() {
+		return t.Format("15:04")
+	}
+	return t.Format("15:04 del 02/01")
+}
+
+// TimerStore keeps the pending timers across restarts.
+type TimerStore interface {
+	Load() ([]Timer, error)
+	Save(timers []Timer) error
+}
+
+// Scheduler holds the pending timers and runs each one when its time
+// comes. Every change is saved to the store, so timers survive a restart.
+type Scheduler struct {
+	store  TimerStore
+	logger *slog.Logger
+	wake   chan struct{}
+
+	mu     sync.Mutex
+	timers []Timer
+	lastID int
+}
+
+// NewScheduler creates a scheduler saving its timers to store, or keeping
+// them in memory only when store is nil.
+func NewScheduler(store TimerStore, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		store:  store,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Load reads the timers saved by a previous run. Those whose time passed
+// while the assistant was down run as soon as the scheduler starts.
+func (s *Scheduler) Load() error {
+	if s.store == nil {
+		return nil
+	}
+	timers, err := s.store.Load()
+	if err != nil {
+		return fmt.Errorf("loading timers: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.timers = timers
+	s.sort()
+	for _, t := range timers {
+		if id, err := strconv.Atoi(t.ID); err == nil && id > s.lastID {
+			s.lastID = id
+		}
+	}
+	s.signal()
+	return nil
+}
+
+// Add schedules a command to run at the given time.
+func (s *Scheduler) Add(at time.Time, cmd domain.Command) (Timer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	t := Timer{ID: strconv.Itoa(s.lastID), At: at, Command: cmd}
+
+	previous := s.timers
+	s.timers = append(slices.Clone(s.timers), t)
+	s.sort()
+	if err := s.save(); err != nil {
+		s.timers = previous
+		return Timer{}, err
+	}
+
+	s.logger.Info("timer set", "id", t.ID, "action", cmd.Action, "target", cmd.TargetName, "at", at)
+	s.signal()
+	return t, nil
+}
+
+// List returns the pending timers, soonest first.
+func (s *Scheduler) List() []Timer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.timers)
+}
+
+// Cancel removes a timer by its ID.
+func (s *Scheduler) Cancel(id string) (Timer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.timers, func(t Timer) bool { return t.ID == id })
+	if i < 0 {
+		return Timer{}, fmt.Errorf("%w: %s", ErrTimerNotFound, id)
+	}
+	t := s.timers[i]
+	if err := s.remove(func(other Timer) bool { return other.ID == id }); err != nil {
+		return Timer{}, err
+	}
+	return t, nil
+}
+
+// CancelTarget removes the timers acting on a device, scene or area whose
+// name contains target, or every timer when target is empty.
+func (s *Scheduler) CancelTarget(target string) ([]Timer, error) {
+	key := strings.ToLower(strings.TrimSpace(target))
+	matches := func(t Timer) bool {
+		return strings.Contains(strings.ToLower(t.Command.TargetName), key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cancelled []Timer
+	for _, t := range s.timers {
+		if matches(t) {
+			cancelled = append(cancelled, t)
+		}
+	}
+	if len(cancelled) == 0 {
+		return nil, nil
+	}
+	if err := s.remove(matches); err != nil {
+		return nil, err
+	}
+	return cancelled, nil
+}
+
+// Start runs the timers in the background until ctx is done, calling
+// execute with the command of each one when its time comes.
+func (s *Scheduler) Start(ctx context.Context, execute func(ctx context.Context, cmd *domain.Command)) {
+	go func() {
+		for {
+			for _, t := range s.due(time.Now()) {
+				s.logger.Info("running timer", "id", t.ID, "action", t.Command.Action, "target", t.Command.TargetName, "late", time.Since(t.At).Round(time.Second))
+				cmd := t.Command
+				execute(ctx, &cmd)
+			}
+
+			wait := maxTimerWait
+			if next, ok := s.next(); ok {
+				wait = min(max(time.Until(next), 0), maxTimerWait)
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-s.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}()
+}
+
+// due takes the timers whose time has come out of the schedule.
+func (s *Scheduler) due(now time.Time) []Timer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for n < len(s.timers) && !s.timers[n].At.After(now) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	due := slices.Clone(s.timers[:n])
+	s.timers = slices.Clone(s.timers[n:])
+	if err := s.save(); err != nil {
+		// The timers still run; a restart may run them again
+		s.logger.Error("saving timers", "error", err)
+	}
+	return due
+}
+
+func (s *Scheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.timers) == 0 {
+		return time.Time{}, false
+	}
+	return s.timers[0].At, true
+}
+
+// remove drops the matching timers and saves the rest, leaving the
+// schedule untouched if that fails. Callers must hold the lock.
+func (s *Scheduler) remove(match func(Timer) bool) error {
+	previous := s.timers
+	s.timers = slices.DeleteFunc(slices.Clone(s.timers), match)
+	if err := s.save(); err != nil {
+		s.timers = previous
+		return err
+	}
+	s.signal()
+	return nil
+}
+
+// save writes the timers to the store. Callers must hold the lock.
+func (s *Scheduler) save() error {
+	if s.store == nil {
+		return nil
+	}
+	if err := s.store.Save(s.timers); err != nil {
+		return fmt.Errorf("saving timers: %w", err)
+	}
+	return nil
+}
+
+// sort keeps the timers soonest first. Callers must hold the lock.
+func (s *Scheduler) sort() {
+	slices.SortStableFunc(s.timers, func(a, b Timer) int { return a.At.Compare(b.At) })
+}
+
+// signal wakes the scheduler up to look at the timers again.
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NextAt returns the next time the clock reads hour:minute, today or
+// tomorrow: "a las 7" said at 8 means 7 tomorrow.
+func NextAt(now time.Time, hour, minute int) time.Time {
+	at := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at
+}
@@ -14,6 +14,36 @@ type SpeechToText interface {
 type NoopSTT struct{}
 
 func (n *NoopSTT) Transcribe(ctx context.Context, audio []byte) (string, error) {
-	return "", fmt.Errorf("speech-to-text not configured: set openai.api_key to enable audio transcription")
+	return "", fmt.Errorf("speech-to-text not configured: set openai.api_key or stt.provider to enable audio transcription")
 }
 
+// StreamingSpeechToText transcribes audio while it is being recorded, so
+// the text is ready as soon as the user stops talking.
+type StreamingSpeechToText interface {
+	// StartStream begins transcribing an utterance of 16-bit little-endian
+	// mono PCM at sampleRate.
+	StartStream(ctx context.Context, sampleRate int) (SpeechStream, error)
+}
+
+// SpeechStream is one utterance being transcribed.
+type SpeechStream interface {
+	// Write sends the next chunk of audio.
+	Write(pcm []byte) error
+	// Results delivers hypotheses as they come: partial ones while the user
+	// talks and a final one whenever the recognizer hears the end of a
+	// sentence. When the reader falls behind, older results are dropped to
+	// make room. The channel is closed when the stream ends.
+	Results() <-chan Transcript
+	// Finish tells the recognizer the audio is over and returns the final
+	// text of the whole utterance.
+	Finish(ctx context.Context) (string, error)
+	// Close abandons the stream. It is safe to call after Finish.
+	Close() error
+}
+
+// Transcript is a hypothesis of a SpeechStream.
+type Transcript struct {
+	Text string
+	// Final reports that the recognizer won't revise Text any more.
+	Final bool
+}
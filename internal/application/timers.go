@@ -0,0 +1,154 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"smart-home/internal/domain"
+)
+
+// undoActions are the actions that end a command said with a duration:
+// "prendé el ventilador por 10 minutos" turns it off when they are up.
+var undoActions = map[domain.Action]domain.Action{
+	domain.ActionTurnOn:  domain.ActionTurnOff,
+	domain.ActionTurnOff: domain.ActionTurnOn,
+	domain.ActionOpen:    domain.ActionClose,
+	domain.ActionClose:   domain.ActionOpen,
+	domain.ActionLock:    domain.ActionUnlock,
+	domain.ActionUnlock:  domain.ActionLock,
+	domain.ActionPlay:    domain.ActionPause,
+	domain.ActionPause:   domain.ActionPlay,
+	domain.ActionStart:   domain.ActionDock,
+}
+
+// schedule sets a timer for a postponed command, or runs it now when only
+// its duration is limited, plus a timer to undo it when the duration is
+// up. The target is checked right away so that mistakes are reported while
+// the user is still listening.
+func (a *Assistant) schedule(ctx context.Context, cmd *domain.Command) (string, error) {
+	if err := a.checkTarget(cmd); err != nil {
+		return "", err
+	}
+
+	var undo *domain.Command
+	if cmd.Duration > 0 {
+		action, ok := undoActions[cmd.Action]
+		if !ok {
+			return "", fmt.Errorf("%w: %s can't be undone after a while", ErrUnsupportedAction, cmd.Action)
+		}
+		undo = &domain.Command{
+			Action:     action,
+			TargetName: cmd.TargetName,
+			TargetType: cmd.TargetType,
+			DeviceType: cmd.DeviceType,
+			RawText:    cmd.RawText,
+		}
+	}
+
+	now := time.Now()
+	at := cmd.At
+	if at.IsZero() {
+		at = now.Add(cmd.Delay)
+	}
+
+	run := *cmd
+	run.Parameters = maps.Clone(cmd.Parameters)
+	run.Delay, run.At, run.Duration = 0, time.Time{}, 0
+
+	var lines []string
+	if at.After(now) {
+		t, err := a.timers.Add(at, run)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, "Programado: "+t.String())
+	} else {
+		result, err := a.executeCommand(ctx, &run)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, result)
+	}
+
+	if undo != nil {
+		t, err := a.timers.Add(at.Add(cmd.Duration), *undo)
+		if err != nil {
+			return strings.Join(lines, "\n"), err
+		}
+		lines = append(lines, "Programado: "+t.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// checkTarget reports a command whose device, scene or area doesn't
+// exist.
+func (a *Assistant) checkTarget(cmd *domain.Command) error {
+	switch cmd.TargetType {
+	case domain.TargetTypeScene:
+		if _, ok := a.registry.FindSceneByName(cmd.TargetName); !ok {
+			return fmt.Errorf("scene not found: %s", cmd.TargetName)
+		}
+	case domain.TargetTypeDevice:
+		if _, ok := a.registry.FindDeviceByName(cmd.TargetName); !ok {
+			return fmt.Errorf("device not found: %s", cmd.TargetName)
+		}
+	case domain.TargetTypeArea:
+		if len(devicesInArea(a.registry.GetDevices(), cmd)) == 0 {
+			return fmt.Errorf("no devices found in area: %s", cmd.TargetName)
+		}
+	default:
+		return fmt.Errorf("unknown target type: %s", cmd.TargetType)
+	}
+	return nil
+}
+
+// runTimer executes a command whose time has come. Nobody is waiting for a
+// reply, so the outcome goes to the notifier.
+func (a *Assistant) runTimer(ctx context.Context, cmd *domain.Command) {
+	result, err := a.executeCommand(ctx, cmd)
+	if err != nil {
+		a.logger.Error("executing timer", "action", cmd.Action, "target", cmd.TargetName, "error", err)
+		result = fmt.Sprintf("Error: %s", err.Error())
+	}
+	if err := a.notifier.Notify(ctx, "Timer: "+result); err != nil {
+		a.logger.Error("notifying timer", "error", err)
+	}
+}
+
+// listTimers reads out the pending timers.
+func (a *Assistant) listTimers() string {
+	timers := a.timers.List()
+	if len(timers) == 0 {
+		return "No hay timers pendientes"
+	}
+
+	lines := make([]string, len(timers))
+	for i, t := range timers {
+		lines[i] = t.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cancelTimers cancels the timers of the command's target, or all of them
+// when it has none.
+func (a *Assistant) cancelTimers(cmd *domain.Command) (string, error) {
+	cancelled, err := a.timers.CancelTarget(cmd.TargetName)
+	if err != nil {
+		return "", err
+	}
+	if len(cancelled) == 0 {
+		if cmd.TargetName != "" {
+			return fmt.Sprintf("No hay timers pendientes para '%s'", cmd.TargetName), nil
+		}
+		return "No hay timers pendientes", nil
+	}
+
+	lines := make([]string, len(cancelled))
+	for i, t := range cancelled {
+		lines[i] = "Cancelado: " + t.String()
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Capabilities is what a device can do according to its backend: the
+// actions it accepts, the bounds of its numeric parameters and the allowed
+// values of its enumerated ones.
+type Capabilities struct {
+	Actions []Action
+	// Ranges bounds numeric parameters, keyed by parameter name, e.g.
+	// "temperature" for a thermostat that goes from 16 to 30.
+	Ranges map[string]Range
+	// Options lists the accepted values of a parameter, e.g. the
+	// "hvac_mode"s of an air conditioner.
+	Options map[string][]string
+}
+
+type Range struct {
+	Min float64
+	Max float64
+}
+
+// Supports reports whether the device accepts the action. Status queries
+// are always supported, and a nil Capabilities (backend reported nothing)
+// supports everything.
+func (c *Capabilities) Supports(a Action) bool {
+	if c == nil || a == ActionGetStatus {
+		return true
+	}
+	return slices.Contains(c.Actions, a)
+}
+
+// Add records a supported action, ignoring duplicates.
+func (c *Capabilities) Add(actions ...Action) {
+	for _, a := range actions {
+		if !slices.Contains(c.Actions, a) {
+			c.Actions = append(c.Actions, a)
+		}
+	}
+}
+
+// SetRange bounds a numeric parameter.
+func (c *Capabilities) SetRange(param string, min, max float64) {
+	if c.Ranges == nil {
+		c.Ranges = make(map[string]Range)
+	}
+	c.Ranges[param] = Range{Min: min, Max: max}
+}
+
+// SetOptions lists the accepted values of a parameter.
+func (c *Capabilities) SetOptions(param string, options []string) {
+	if c.Options == nil {
+		c.Options = make(map[string][]string)
+	}
+	c.Options[param] = options
+}
+
+// Clamp brings a numeric parameter into the device's range, reporting
+// whether it had to change it.
+func (c *Capabilities) Clamp(param string, v float64) (float64, bool) {
+	if c == nil {
+		return v, false
+	}
+	r, ok := c.Ranges[param]
+	if !ok {
+		return v, false
+	}
+	clamped := min(max(v, r.Min), r.Max)
+	return clamped, clamped != v
+}
+
+// String renders the capabilities for the intent parser prompt, e.g.
+// "turn_on, turn_off, set_temperature (temperature 16-30)".
+func (c *Capabilities) String() string {
+	if c == nil {
+		return ""
+	}
+
+	parts := make([]string, 0, len(c.Actions))
+	for _, a := range c.Actions {
+		parts = append(parts, string(a))
+	}
+
+	var details []string
+	for _, param := range sortedKeys(c.Ranges) {
+		r := c.Ranges[param]
+		details = append(details, fmt.Sprintf("%s %s-%s", param, formatNumber(r.Min), formatNumber(r.Max)))
+	}
+	for _, param := range sortedKeys(c.Options) {
+		details = append(details, fmt.Sprintf("%s: %s", param, strings.Join(c.Options[param], "|")))
+	}
+
+	s := strings.Join(parts, ", ")
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -1,17 +1,78 @@
 package domain
 
+import "time"
+
 type Action string
 
 const (
-	ActionTurnOn    Action = "turn_on"
-	ActionTurnOff   Action = "turn_off"
-	ActionSetLevel  Action = "set_level"
-	ActionSetColor  Action = "set_color"
-	ActionRunScene  Action = "run_scene"
-	ActionGetStatus Action = "get_status"
-	ActionUnknown   Action = "unknown"
+	ActionTurnOn         Action = "turn_on"
+	ActionTurnOff        Action = "turn_off"
+	ActionSetLevel       Action = "set_level"
+	ActionSetColor       Action = "set_color"
+	ActionOpen           Action = "open"
+	ActionClose          Action = "close"
+	ActionSetPosition    Action = "set_position"
+	ActionLock           Action = "lock"
+	ActionUnlock         Action = "unlock"
+	ActionSetTemperature Action = "set_temperature"
+	ActionSetHVACMode    Action = "set_hvac_mode"
+	ActionPlay           Action = "play"
+	ActionPause          Action = "pause"
+	ActionSetVolume      Action = "set_volume"
+	ActionStart          Action = "start"
+	ActionDock           Action = "dock"
+	ActionRunScene       Action = "run_scene"
+	ActionGetStatus      Action = "get_status"
+	ActionListTimers     Action = "list_timers"
+	ActionCancelTimer    Action = "cancel_timer"
+	ActionUnknown        Action = "unknown"
 )
 
+// Valid reports whether a is one of the actions above, so that whatever an
+// intent parser returns can be checked before it is executed.
+func (a Action) Valid() bool {
+	switch a {
+	case ActionTurnOn, ActionTurnOff, ActionSetLevel, ActionSetColor,
+		ActionOpen, ActionClose, ActionSetPosition, ActionLock, ActionUnlock,
+		ActionSetTemperature, ActionSetHVACMode, ActionPlay, ActionPause,
+		ActionSetVolume, ActionStart, ActionDock, ActionRunScene,
+		ActionGetStatus, ActionListTimers, ActionCancelTimer, ActionUnknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Targets reports whether the action makes sense on a device type. It is
+// used to pick the devices of an area a command applies to.
+func (a Action) Targets(t DeviceType) bool {
+	switch a {
+	case ActionGetStatus:
+		return true
+	case ActionTurnOn, ActionTurnOff, ActionSetLevel:
+		return t != DeviceTypeSensor && t != DeviceTypeLock
+	case ActionSetColor:
+		return t == DeviceTypeLight
+	case ActionOpen, ActionClose, ActionSetPosition:
+		return t == DeviceTypeCover
+	case ActionLock, ActionUnlock:
+		return t == DeviceTypeLock
+	case ActionSetTemperature, ActionSetHVACMode:
+		return t == DeviceTypeThermostat
+	case ActionPlay, ActionPause, ActionSetVolume:
+		return t == DeviceTypeMediaPlayer
+	case ActionStart, ActionDock:
+		return t == DeviceTypeVacuum
+	default:
+		return false
+	}
+}
+
+// ParamDelta is the parameter of a relative adjustment, in the unit of the
+// value the action sets: "a bit brighter" is set_level with {"delta": 10},
+// "two degrees cooler" set_temperature with {"delta": -2}.
+const ParamDelta = "delta"
+
 // TextCommandPrefix is the marker used to indicate text commands (vs audio)
 const TextCommandPrefix = "__TEXT__:"
 
@@ -20,9 +81,29 @@ type Command struct {
 	TargetName string
 	TargetID   string
 	TargetType TargetType
+	// DeviceType narrows an area command to one kind of device, e.g. only
+	// the lights of a room. Empty means every controllable device.
+	DeviceType DeviceType
 	Parameters map[string]any
 	RawText    string
 	Confidence float64
+	// Delay or At postpone the command ("in 30 minutes", "at 7"), and
+	// Duration undoes it after a while ("for 10 minutes"). Left zero, the
+	// command runs now and for good.
+	Delay    time.Duration
+	At       time.Time
+	Duration time.Duration
+}
+
+// Timed reports whether the command is postponed or limited in time.
+func (c *Command) Timed() bool {
+	return c.Delay > 0 || !c.At.IsZero() || c.Duration > 0
+}
+
+// Delta returns the relative adjustment of the command, if it is one.
+func (c *Command) Delta() (float64, bool) {
+	v, ok := c.Parameters[ParamDelta].(float64)
+	return v, ok
 }
 
 type TargetType string
@@ -30,5 +111,6 @@ type TargetType string
 const (
 	TargetTypeDevice TargetType = "device"
 	TargetTypeScene  TargetType = "scene"
+	TargetTypeArea   TargetType = "area"
 )
 
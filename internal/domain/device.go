@@ -1,23 +1,49 @@
 package domain
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type DeviceType string
 
 const (
-	DeviceTypeLight      DeviceType = "light"
-	DeviceTypePlug       DeviceType = "plug"
-	DeviceTypeSwitch     DeviceType = "switch"
-	DeviceTypeThermostat DeviceType = "thermostat"
-	DeviceTypeSensor     DeviceType = "sensor"
-	DeviceTypeOther      DeviceType = "other"
+	DeviceTypeLight       DeviceType = "light"
+	DeviceTypePlug        DeviceType = "plug"
+	DeviceTypeSwitch      DeviceType = "switch"
+	DeviceTypeThermostat  DeviceType = "thermostat"
+	DeviceTypeSensor      DeviceType = "sensor"
+	DeviceTypeCover       DeviceType = "cover"
+	DeviceTypeFan         DeviceType = "fan"
+	DeviceTypeLock        DeviceType = "lock"
+	DeviceTypeMediaPlayer DeviceType = "media_player"
+	DeviceTypeVacuum      DeviceType = "vacuum"
+	DeviceTypeOther       DeviceType = "other"
 )
 
 type Device struct {
-	ID        string
-	Name      string
-	Type      DeviceType
-	Category  string
+	ID       string
+	Name     string
+	Type     DeviceType
+	Category string
+	// Area is the room the device is in, when the backend knows it
+	Area      string
 	Online    bool
 	Functions []DeviceFunction
+	// Capabilities is what the device supports, nil when unknown
+	Capabilities *Capabilities
+	// State is the last known state, when the backend reports it
+	State *DeviceState
+}
+
+// Supports reports whether the action applies to the device, going by its
+// capabilities when the backend reported them and by its type otherwise.
+func (d Device) Supports(a Action) bool {
+	if d.Capabilities != nil {
+		return d.Capabilities.Supports(a)
+	}
+	return a.Targets(d.Type)
 }
 
 type DeviceFunction struct {
@@ -26,3 +52,65 @@ type DeviceFunction struct {
 	Values map[string]any
 }
 
+// DeviceState is a point-in-time reading of a device, as reported by the
+// backend that controls it. Fields the device doesn't expose are left empty.
+type DeviceState struct {
+	// Status is the backend's main state, e.g. "on", "off", "unavailable",
+	// or the reading of a sensor.
+	Status string
+	// Unit qualifies a numeric Status, e.g. "°C" or "%".
+	Unit string
+	// Level is the brightness, fan speed, cover position or volume as a
+	// percentage (0-100).
+	Level *int
+	Color string
+	// Temperature is the current measured temperature, TargetTemperature
+	// the setpoint of a thermostat.
+	Temperature       *float64
+	TargetTemperature *float64
+}
+
+// Describe renders the state as a short sentence, e.g. "Luz Living is on at 40%".
+func (s DeviceState) Describe(name string) string {
+	return name + " is " + s.Summary()
+}
+
+// Summary renders the state without the device name, e.g. "on at 40%".
+func (s DeviceState) Summary() string {
+	var sb strings.Builder
+
+	sb.WriteString(s.Status)
+	if s.Unit != "" {
+		sb.WriteString(" " + s.Unit)
+	}
+
+	if s.Level != nil && s.active() {
+		fmt.Fprintf(&sb, " at %d%%", *s.Level)
+	}
+	if s.Color != "" && s.Status == "on" {
+		fmt.Fprintf(&sb, ", color %s", s.Color)
+	}
+	if s.TargetTemperature != nil {
+		fmt.Fprintf(&sb, ", set to %s°", formatNumber(*s.TargetTemperature))
+	}
+	if s.Temperature != nil {
+		fmt.Fprintf(&sb, ", currently %s°", formatNumber(*s.Temperature))
+	}
+
+	return sb.String()
+}
+
+// active reports whether the device is doing something a level applies to,
+// e.g. a light that is on, an open cover or a playing speaker.
+func (s DeviceState) active() bool {
+	switch s.Status {
+	case "off", "closed", "unavailable", "unknown", "":
+		return false
+	default:
+		return true
+	}
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -7,12 +7,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"smart-home/internal/application"
 	"smart-home/internal/domain"
 	"smart-home/internal/infra"
+	"smart-home/internal/infra/llm"
 )
 
 type ClaudeClient struct {
@@ -20,6 +20,7 @@ type ClaudeClient struct {
 	httpClient *http.Client
 	baseURL    string
 	model      string
+	prompt     *llm.Prompt
 }
 
 func NewClaudeClient(apiKey, model string) *ClaudeClient {
@@ -35,63 +36,75 @@ func NewClaudeClientWithURL(apiKey, model, baseURL string) *ClaudeClient {
 		httpClient: &http.Client{Timeout: 30 * time.Second},
 		baseURL:    baseURL,
 		model:      model,
+		prompt:     llm.DefaultPrompt(),
 	}
 }
 
+// SetPrompt replaces the built-in prompt, e.g. with one loaded from the
+// config. It must be called before Parse.
+func (c *ClaudeClient) SetPrompt(p *llm.Prompt) {
+	c.prompt = p
+}
+
 type message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
+type tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type toolChoice struct {
+	Type string `json:"type"`
+}
+
 type request struct {
-	Model     string    `json:"model"`
-	MaxTokens int       `json:"max_tokens"`
-	System    string    `json:"system"`
-	Messages  []message `json:"messages"`
+	Model      string     `json:"model"`
+	MaxTokens  int        `json:"max_tokens"`
+	System     string     `json:"system"`
+	Messages   []message  `json:"messages"`
+	Tools      []tool     `json:"tools"`
+	ToolChoice toolChoice `json:"tool_choice"`
 }
 
 type response struct {
 	Content []struct {
-		Text string `json:"text"`
+		Type  string         `json:"type"`
+		Text  string         `json:"text"`
+		Name  string         `json:"name"`
+		Input map[string]any `json:"input"`
 	} `json:"content"`
 }
 
-type parsedIntent struct {
-	Action     string         `json:"action"`
-	TargetName string         `json:"target_name"`
-	TargetType string         `json:"target_type"`
-	Parameters map[string]any `json:"parameters"`
-	Confidence float64        `json:"confidence"`
-}
-
-func (c *ClaudeClient) Parse(ctx context.Context, text string, registry application.DeviceRegistry) (*domain.Command, error) {
-	systemPrompt := fmt.Sprintf(`You are a smart home assistant. Your task is to interpret voice commands and extract the intent.
-
-%s
-
-IMPORTANT:
-- If the user mentions a scene, use target_type "scene"
-- If the user mentions a device, use target_type "device"
-- Use the EXACT name of the device or scene as it appears in the list
-- If you don't understand the command, use action "unknown"
-- The user may speak in English or Spanish, understand both
-
-Respond ONLY with valid JSON (no markdown, no backticks):
-{
-  "action": "turn_on|turn_off|set_level|set_color|run_scene|get_status|unknown",
-  "target_name": "exact device or scene name",
-  "target_type": "device|scene",
-  "parameters": {"level": 50, "color": "red"},
-  "confidence": 0.95
-}`, registry.Summary())
+func (c *ClaudeClient) Parse(ctx context.Context, text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	systemPrompt, err := c.prompt.Build(registry, conv, true)
+	if err != nil {
+		return nil, err
+	}
 
+	tools := llm.Tools(registry)
 	reqBody := request{
 		Model:     c.model,
-		MaxTokens: 256,
+		MaxTokens: 512,
 		System:    systemPrompt,
 		Messages: []message{
 			{Role: "user", Content: text},
 		},
+		Tools: make([]tool, 0, len(tools)),
+		// "any" makes the model answer with at least one tool call instead
+		// of prose
+		ToolChoice: toolChoice{Type: "any"},
+	}
+	for _, t := range tools {
+		schema := t.Parameters
+		// Claude needs a schema even for tools without arguments
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		reqBody.Tools = append(reqBody.Tools, tool{Name: t.Name, Description: t.Description, InputSchema: schema})
 	}
 
 	bodyBytes, err := json.Marshal(reqBody)
@@ -135,28 +148,20 @@ Respond ONLY with valid JSON (no markdown, no backticks):
 		return nil, retryErr
 	}
 
-	if len(result.Content) == 0 {
-		return nil, fmt.Errorf("empty response from claude")
+	var cmds []*domain.Command
+	for _, block := range result.Content {
+		if block.Type == "tool_use" {
+			cmds = append(cmds, llm.FromToolCall(block.Name, block.Input, text))
+		}
 	}
 
-	responseText := strings.TrimSpace(result.Content[0].Text)
-	responseText = strings.TrimPrefix(responseText, "```json")
-	responseText = strings.TrimPrefix(responseText, "```")
-	responseText = strings.TrimSuffix(responseText, "```")
-	responseText = strings.TrimSpace(responseText)
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("claude did not call any tool")
+	}
 
-	var intent parsedIntent
-	if err = json.Unmarshal([]byte(responseText), &intent); err != nil {
-		return nil, fmt.Errorf("parsing intent JSON (%s): %w", responseText, err)
+	if err = llm.Validate(cmds, registry); err != nil {
+		return nil, fmt.Errorf("invalid intent from claude: %w", err)
 	}
 
-	return &domain.Command{
-		Action:     domain.Action(intent.Action),
-		TargetName: intent.TargetName,
-		TargetType: domain.TargetType(intent.TargetType),
-		Parameters: intent.Parameters,
-		RawText:    text,
-		Confidence: intent.Confidence,
-	}, nil
+	return cmds, nil
 }
-
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,46 +15,101 @@ import (
 
 type mockRegistry struct{}
 
-func (m *mockRegistry) Sync(_ context.Context) error           { return nil }
-func (m *mockRegistry) GetDevices() []domain.Device            { return nil }
-func (m *mockRegistry) GetScenes() []domain.Scene              { return nil }
-func (m *mockRegistry) FindDeviceByName(_ string) (*domain.Device, bool) { return nil, false }
-func (m *mockRegistry) FindSceneByName(_ string) (*domain.Scene, bool)   { return nil, false }
-func (m *mockRegistry) Summary() string {
-	return `## Dispositivos:
-- Luz Living (tipo: light)
-- Luz Cocina (tipo: light)
-## Escenas:
-- Buenas Noches`
+var (
+	devices = []domain.Device{
+		{ID: "1", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living"},
+		{ID: "2", Name: "Luz Cocina", Type: domain.DeviceTypeLight, Area: "Cocina"},
+	}
+	scenes = []domain.Scene{{ID: "s1", Name: "Buenas Noches"}}
+)
+
+func (m *mockRegistry) Sync(_ context.Context) error { return nil }
+func (m *mockRegistry) GetDevices() []domain.Device  { return devices }
+func (m *mockRegistry) GetScenes() []domain.Scene    { return scenes }
+func (m *mockRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
+	for i, d := range devices {
+		if strings.EqualFold(d.Name, name) {
+			return &devices[i], true
+		}
+	}
+	return nil, false
+}
+func (m *mockRegistry) FindSceneByName(name string) (*domain.Scene, bool) {
+	for i, s := range scenes {
+		if strings.EqualFold(s.Name, name) {
+			return &scenes[i], true
+		}
+	}
+	return nil, false
 }
 
 func (m *mockRegistry) StartPeriodicSync(_ context.Context, _ time.Duration) {}
 
-func TestClaudeClient_Parse(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+func toolUse(name string, input map[string]any) map[string]any {
+	return map[string]any{"type": "tool_use", "id": "toolu_" + name, "name": name, "input": input}
+}
+
+func claudeServer(t *testing.T, check func(body map[string]any), blocks ...map[string]any) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/messages" {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
 
-		response := map[string]any{
-			"content": []map[string]string{
-				{"text": `{"action":"turn_on","target_name":"Luz Living","target_type":"device","parameters":{},"confidence":0.95}`},
-			},
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if check != nil {
+			check(body)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(map[string]any{"content": blocks, "stop_reason": "tool_use"})
 	}))
+}
+
+func TestClaudeClient_Parse(t *testing.T) {
+	server := claudeServer(t, func(body map[string]any) {
+		if choice := body["tool_choice"].(map[string]any); choice["type"] != "any" {
+			t.Errorf("tool_choice: got %v", choice)
+		}
+
+		var turnOn map[string]any
+		for _, tl := range body["tools"].([]any) {
+			tl := tl.(map[string]any)
+			if tl["name"] == "turn_on" {
+				turnOn = tl
+			}
+			if schema, _ := tl["input_schema"].(map[string]any); schema["type"] != "object" {
+				t.Errorf("tool %v: input_schema %v", tl["name"], tl["input_schema"])
+			}
+		}
+		if turnOn == nil {
+			t.Fatal("expected a turn_on tool")
+		}
+		schema := turnOn["input_schema"].(map[string]any)
+		target := schema["properties"].(map[string]any)["target_name"].(map[string]any)
+		names, _ := json.Marshal(target["enum"])
+		if string(names) != `["Luz Living","Luz Cocina","Living","Cocina"]` {
+			t.Errorf("target names: got %s", names)
+		}
+	}, toolUse("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device", "confidence": 0.95}))
 	defer server.Close()
 
 	client := anthropic.NewClaudeClientWithURL("test-key", "claude-test", server.URL)
 
-	cmd, err := client.Parse(context.Background(), "prende la luz del living", &mockRegistry{})
+	cmds, err := client.Parse(context.Background(), "prende la luz del living", &mockRegistry{}, nil)
 	if err != nil {
 		t.Fatalf("Parse error: %v", err)
 	}
 
+	if len(cmds) != 1 {
+		t.Fatalf("commands: got %d, want 1", len(cmds))
+	}
+	cmd := cmds[0]
+
 	if cmd.Action != domain.ActionTurnOn {
 		t.Errorf("Action: got %s, want turn_on", cmd.Action)
 	}
@@ -65,27 +121,24 @@ func TestClaudeClient_Parse(t *testing.T) {
 	if cmd.TargetType != domain.TargetTypeDevice {
 		t.Errorf("TargetType: got %s, want device", cmd.TargetType)
 	}
+
+	if cmd.Confidence != 0.95 {
+		t.Errorf("Confidence: got %v, want 0.95", cmd.Confidence)
+	}
 }
 
 func TestClaudeClient_ParseScene(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		response := map[string]any{
-			"content": []map[string]string{
-				{"text": `{"action":"run_scene","target_name":"Buenas Noches","target_type":"scene","parameters":{},"confidence":0.98}`},
-			},
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-	}))
+	server := claudeServer(t, nil,
+		toolUse("run_scene", map[string]any{"target_name": "Buenas Noches", "target_type": "scene", "confidence": 0.98}))
 	defer server.Close()
 
 	client := anthropic.NewClaudeClientWithURL("test-key", "claude-test", server.URL)
 
-	cmd, err := client.Parse(context.Background(), "activa la escena buenas noches", &mockRegistry{})
+	cmds, err := client.Parse(context.Background(), "activa la escena buenas noches", &mockRegistry{}, nil)
 	if err != nil {
 		t.Fatalf("Parse error: %v", err)
 	}
+	cmd := cmds[0]
 
 	if cmd.Action != domain.ActionRunScene {
 		t.Errorf("Action: got %s, want run_scene", cmd.Action)
@@ -97,27 +150,74 @@ func TestClaudeClient_ParseScene(t *testing.T) {
 }
 
 func TestClaudeClient_ParseUnknown(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		response := map[string]any{
-			"content": []map[string]string{
-				{"text": `{"action":"unknown","target_name":"","target_type":"","parameters":{},"confidence":0.1}`},
-			},
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-	}))
+	server := claudeServer(t, nil, toolUse("unknown", map[string]any{}))
 	defer server.Close()
 
 	client := anthropic.NewClaudeClientWithURL("test-key", "claude-test", server.URL)
 
-	cmd, err := client.Parse(context.Background(), "qué hora es", &mockRegistry{})
+	cmds, err := client.Parse(context.Background(), "qué hora es", &mockRegistry{}, nil)
 	if err != nil {
 		t.Fatalf("Parse error: %v", err)
 	}
+	cmd := cmds[0]
 
 	if cmd.Action != domain.ActionUnknown {
 		t.Errorf("Action: got %s, want unknown", cmd.Action)
 	}
 }
 
+func TestClaudeClient_ParseMultipleCommands(t *testing.T) {
+	server := claudeServer(t, nil,
+		map[string]any{"type": "text", "text": "Apago la cocina y prendo el living."},
+		toolUse("turn_off", map[string]any{"target_name": "Luz Cocina", "target_type": "device", "confidence": 0.95}),
+		toolUse("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device", "level": 40, "confidence": 0.93}),
+	)
+	defer server.Close()
+
+	client := anthropic.NewClaudeClientWithURL("test-key", "claude-test", server.URL)
+
+	cmds, err := client.Parse(context.Background(), "apaga la cocina y poné el living al 40", &mockRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(cmds) != 2 {
+		t.Fatalf("commands: got %d, want 2", len(cmds))
+	}
+
+	if cmds[0].Action != domain.ActionTurnOff || cmds[0].TargetName != "Luz Cocina" {
+		t.Errorf("first command: got %s %s, want turn_off Luz Cocina", cmds[0].Action, cmds[0].TargetName)
+	}
+
+	if cmds[1].Action != domain.ActionSetLevel || cmds[1].TargetName != "Luz Living" || cmds[1].Parameters["level"] != 40.0 {
+		t.Errorf("second command: got %s %s %v, want set_level Luz Living 40", cmds[1].Action, cmds[1].TargetName, cmds[1].Parameters)
+	}
+
+	for _, cmd := range cmds {
+		if cmd.RawText != "apaga la cocina y poné el living al 40" {
+			t.Errorf("RawText: got %q", cmd.RawText)
+		}
+	}
+}
+
+func TestClaudeClient_RejectsInvalidIntent(t *testing.T) {
+	tests := map[string]map[string]any{
+		"no tool call":      {"type": "text", "text": `{"action":"turn_on","target_name":"Luz Living"}`},
+		"unknown action":    toolUse("dim", map[string]any{"target_name": "Luz Living", "target_type": "device"}),
+		"unknown device":    toolUse("turn_on", map[string]any{"target_name": "Luz Baño", "target_type": "device"}),
+		"missing parameter": toolUse("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device"}),
+		"device as scene":   toolUse("run_scene", map[string]any{"target_name": "Luz Living", "target_type": "scene"}),
+	}
+
+	for name, block := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := claudeServer(t, nil, block)
+			defer server.Close()
+
+			client := anthropic.NewClaudeClientWithURL("test-key", "claude-test", server.URL)
+			if cmds, err := client.Parse(context.Background(), "prende la luz", &mockRegistry{}, nil); err == nil {
+				t.Errorf("expected an error, got %+v", cmds[0])
+			}
+		})
+	}
+}
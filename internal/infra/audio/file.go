@@ -7,21 +7,39 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
+
+	"smart-home/internal/infra/audiofile"
 )
 
 type FileSource struct {
-	dir       string
-	processed map[string]bool
-	mu        sync.Mutex
+	dir         string
+	processed   map[string]bool
+	mu          sync.Mutex
+	maxDuration time.Duration
+	compressed  []audiofile.Format
 }
 
 func NewFileSource(dir string) *FileSource {
 	return &FileSource{
-		dir:       dir,
-		processed: make(map[string]bool),
+		dir:         dir,
+		processed:   make(map[string]bool),
+		maxDuration: audiofile.DefaultMaxDuration,
+		compressed:  audiofile.Compressed,
 	}
 }
 
+// SetMaxDuration changes the longest clip the source accepts.
+func (f *FileSource) SetMaxDuration(d time.Duration) {
+	f.maxDuration = d
+}
+
+// SetCompressedFormats changes the compressed formats the source passes on
+// to speech-to-text; files in other formats are rejected. WAV is always
+// accepted.
+func (f *FileSource) SetCompressedFormats(formats []audiofile.Format) {
+	f.compressed = formats
+}
+
 func (f *FileSource) Name() string {
 	return "file"
 }
@@ -72,7 +90,7 @@ func (f *FileSource) checkForNewFile() ([]byte, error) {
 		}
 
 		ext := filepath.Ext(entry.Name())
-		if ext != ".wav" && ext != ".mp3" && ext != ".m4a" && ext != ".webm" {
+		if ext != ".wav" && ext != ".mp3" && ext != ".m4a" && ext != ".webm" && ext != ".ogg" && ext != ".flac" {
 			continue
 		}
 
@@ -91,7 +109,11 @@ func (f *FileSource) checkForNewFile() ([]byte, error) {
 		processedPath := path + ".processed"
 		os.Rename(path, processedPath)
 
-		return data, nil
+		audio, _, err := audiofile.Normalize(data, f.maxDuration, f.compressed)
+		if err != nil {
+			return nil, fmt.Errorf("reading file %s: %w", path, err)
+		}
+		return audio, nil
 	}
 
 	return nil, nil
@@ -3,37 +3,57 @@ package audio
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"smart-home/internal/application"
 	"smart-home/internal/domain"
+	"smart-home/internal/infra/audiofile"
+	"smart-home/internal/infra/composite"
+)
+
+// defaultReplyTimeout bounds how long a handler waits for the assistant to
+// finish a command. It stays under Alexa's 8 second response limit.
+const defaultReplyTimeout = 7 * time.Second
+
+var (
+	errQueueFull    = errors.New("queue full")
+	errReplyTimeout = errors.New("timed out waiting for the assistant")
 )
 
 type HTTPSource struct {
-	addr        string
-	server      *http.Server
-	audioChan   chan []byte
-	logger      *slog.Logger
-	mu          sync.Mutex
-	running     bool
-	mux         *http.ServeMux
-	closeOnce   sync.Once
-	rateLimiter *RateLimiter
-	authToken   string
+	addr         string
+	server       *http.Server
+	audioChan    chan *application.Request
+	logger       *slog.Logger
+	mu           sync.Mutex
+	running      bool
+	mux          *http.ServeMux
+	closeOnce    sync.Once
+	rateLimiter  *RateLimiter
+	authToken    string
+	replyTimeout time.Duration
+	maxDuration  time.Duration
+	compressed   []audiofile.Format
 }
 
 func NewHTTPSource(addr string, authToken string, logger *slog.Logger) *HTTPSource {
 	h := &HTTPSource{
-		addr:        addr,
-		audioChan:   make(chan []byte, 10),
-		logger:      logger,
-		mux:         http.NewServeMux(),
-		rateLimiter: NewRateLimiter(30, time.Minute), // 30 requests per minute per IP
-		authToken:   authToken,
+		addr:         addr,
+		audioChan:    make(chan *application.Request, 10),
+		logger:       logger,
+		mux:          http.NewServeMux(),
+		rateLimiter:  NewRateLimiter(30, time.Minute), // 30 requests per minute per IP
+		authToken:    authToken,
+		replyTimeout: defaultReplyTimeout,
+		maxDuration:  audiofile.DefaultMaxDuration,
+		compressed:   audiofile.Compressed,
 	}
 	// Apply rate limiting to command endpoints
 	h.mux.HandleFunc("POST /audio", h.rateLimiter.Middleware(h.handleAudio))
@@ -102,14 +122,24 @@ func (h *HTTPSource) Stop() error {
 }
 
 func (h *HTTPSource) NextCommand(ctx context.Context) ([]byte, error) {
+	req, err := h.NextRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return req.Audio, nil
+}
+
+// NextRequest returns the next queued command along with the callback that
+// delivers its outcome to the waiting HTTP caller, if any.
+func (h *HTTPSource) NextRequest(ctx context.Context) (*application.Request, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case audio, ok := <-h.audioChan:
+	case req, ok := <-h.audioChan:
 		if !ok {
 			return nil, fmt.Errorf("audio channel closed")
 		}
-		return audio, nil
+		return req, nil
 	}
 }
 
@@ -117,13 +147,236 @@ func (h *HTTPSource) Handler() http.Handler {
 	return h.mux
 }
 
+// SetReplyTimeout changes how long synchronous handlers wait for the
+// assistant before answering.
+func (h *HTTPSource) SetReplyTimeout(d time.Duration) {
+	h.replyTimeout = d
+}
+
+// SetMaxDuration changes the longest clip POST /audio accepts.
+func (h *HTTPSource) SetMaxDuration(d time.Duration) {
+	h.maxDuration = d
+}
+
+// SetCompressedFormats changes the compressed formats POST /audio passes on
+// to speech-to-text; the rest get 415. WAV is always accepted.
+func (h *HTTPSource) SetCompressedFormats(formats []audiofile.Format) {
+	h.compressed = formats
+}
+
+// SetTimers lists the pending timers at GET /timers and cancels them with
+// DELETE /timers/{id}.
+func (h *HTTPSource) SetTimers(timers *application.Scheduler) {
+	h.mux.HandleFunc("GET /timers", h.rateLimiter.Middleware(h.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		list := timers.List()
+		body := make([]timerJSON, 0, len(list))
+		for _, t := range list {
+			body = append(body, newTimerJSON(t))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})))
+	h.mux.HandleFunc("DELETE /timers/{id}", h.rateLimiter.Middleware(h.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		t, err := timers.Cancel(r.PathValue("id"))
+		switch {
+		case errors.Is(err, application.ErrTimerNotFound):
+			http.Error(w, "timer not found", http.StatusNotFound)
+			return
+		case err != nil:
+			h.logger.Error("cancelling timer", "error", err)
+			http.Error(w, "failed to cancel timer", http.StatusInternalServerError)
+			return
+		}
+		h.logger.Info("timer cancelled via HTTP", "id", t.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newTimerJSON(t))
+	})))
+}
+
+type timerJSON struct {
+	ID          string    `json:"id"`
+	At          time.Time `json:"at"`
+	Action      string    `json:"action"`
+	Target      string    `json:"target"`
+	Description string    `json:"description"`
+}
+
+func newTimerJSON(t application.Timer) timerJSON {
+	return timerJSON{
+		ID:          t.ID,
+		At:          t.At,
+		Action:      string(t.Command.Action),
+		Target:      t.Command.TargetName,
+		Description: t.String(),
+	}
+}
+
+// SetIntentStats reports at GET /metrics how each LLM of the intent chain
+// has been doing.
+func (h *HTTPSource) SetIntentStats(parser *composite.Parser) {
+	h.mux.HandleFunc("GET /metrics", h.rateLimiter.Middleware(h.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		providers := make(map[string]providerStatsJSON)
+		for name, s := range parser.Stats() {
+			providers[name] = providerStatsJSON(s)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"intent_providers": providers})
+	})))
+}
+
+type providerStatsJSON struct {
+	Answered int  `json:"answered"`
+	Failed   int  `json:"failed"`
+	Skipped  int  `json:"skipped"`
+	Open     bool `json:"open"`
+}
+
+// SetRoutines lists the routines at GET /routines and turns them on and off
+// with POST /routines/{name}/enable and /disable.
+func (h *HTTPSource) SetRoutines(routines *application.Routines) {
+	h.mux.HandleFunc("GET /routines", h.rateLimiter.Middleware(h.requireToken(func(w http.ResponseWriter, r *http.Request) {
+		list := routines.List()
+		body := make([]routineJSON, 0, len(list))
+		for _, rt := range list {
+			body = append(body, newRoutineJSON(rt))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})))
+	toggle := func(enabled bool) http.HandlerFunc {
+		return h.rateLimiter.Middleware(h.requireToken(func(w http.ResponseWriter, r *http.Request) {
+			rt, err := routines.SetEnabled(r.PathValue("name"), enabled)
+			switch {
+			case errors.Is(err, application.ErrRoutineNotFound):
+				http.Error(w, "routine not found", http.StatusNotFound)
+				return
+			case err != nil:
+				h.logger.Error("toggling routine", "error", err)
+				http.Error(w, "failed to update routine", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(newRoutineJSON(rt))
+		}))
+	}
+	h.mux.HandleFunc("POST /routines/{name}/enable", toggle(true))
+	h.mux.HandleFunc("POST /routines/{name}/disable", toggle(false))
+}
+
+type routineJSON struct {
+	Name       string     `json:"name"`
+	Schedule   string     `json:"schedule"`
+	Enabled    bool       `json:"enabled"`
+	Next       *time.Time `json:"next,omitempty"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	LastResult string     `json:"last_result,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+func newRoutineJSON(rt application.RoutineStatus) routineJSON {
+	body := routineJSON{
+		Name:       rt.Name,
+		Schedule:   rt.Schedule,
+		Enabled:    rt.Enabled,
+		LastResult: rt.LastResult,
+		LastError:  rt.LastError,
+	}
+	if !rt.Next.IsZero() {
+		body.Next = &rt.Next
+	}
+	if !rt.LastRun.IsZero() {
+		body.LastRun = &rt.LastRun
+	}
+	return body
+}
+
 func (h *HTTPSource) InjectAudio(data []byte) {
+	h.enqueue(data, "", nil)
+}
+
+func (h *HTTPSource) enqueue(data []byte, sessionID string, reply func(application.Response)) bool {
 	select {
-	case h.audioChan <- data:
+	case h.audioChan <- &application.Request{Audio: data, SessionID: sessionID, Reply: reply}:
+		return true
 	default:
+		return false
 	}
 }
 
+// await queues a command and blocks until the assistant reports its outcome,
+// the reply timeout passes or the caller goes away.
+func (h *HTTPSource) await(ctx context.Context, data []byte, sessionID string) (application.Response, error) {
+	replies := make(chan application.Response, 1)
+	if !h.enqueue(data, sessionID, func(resp application.Response) { replies <- resp }) {
+		return application.Response{}, errQueueFull
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.replyTimeout)
+	defer cancel()
+
+	select {
+	case resp := <-replies:
+		return resp, nil
+	case <-ctx.Done():
+		return application.Response{}, errReplyTimeout
+	}
+}
+
+// sessionID identifies the conversation an /audio or /text call belongs to:
+// the X-Session-ID header when the client sends one, its address otherwise.
+func sessionID(r *http.Request) string {
+	if id := r.Header.Get("X-Session-ID"); id != "" {
+		return "http:" + id
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "http:" + host
+}
+
+// wantsReply reports whether the caller asked to wait for the result
+// instead of getting 202 Accepted as soon as the command is queued.
+func wantsReply(r *http.Request) bool {
+	switch r.URL.Query().Get("wait") {
+	case "1", "true":
+		return true
+	default:
+		return false
+	}
+}
+
+// writeResult answers a synchronous /audio or /text call with the outcome
+// reported by the assistant.
+func (h *HTTPSource) writeResult(w http.ResponseWriter, resp application.Response, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case errors.Is(err, errQueueFull):
+		http.Error(w, "queue full, try again", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusGatewayTimeout)
+		json.NewEncoder(w).Encode(map[string]string{"status": "timeout", "error": err.Error()})
+		return
+	}
+
+	body := map[string]string{"status": "done", "result": resp.Text}
+	statusCode := http.StatusOK
+	if resp.Question {
+		// The answer goes in the next call with the same session
+		body["status"] = "question"
+	}
+	if resp.Err != nil {
+		body["status"] = "error"
+		body["error"] = resp.Err.Error()
+		statusCode = http.StatusUnprocessableEntity
+	}
+
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
 func (h *HTTPSource) handleAudio(w http.ResponseWriter, r *http.Request) {
 	data, err := io.ReadAll(io.LimitReader(r.Body, 10*1024*1024))
 	if err != nil {
@@ -138,12 +391,32 @@ func (h *HTTPSource) handleAudio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	select {
-	case h.audioChan <- data:
-		h.logger.Info("received audio via HTTP", "bytes", len(data))
+	data, format, err := audiofile.Normalize(data, h.maxDuration, h.compressed)
+	if err != nil {
+		h.logger.Warn("rejecting audio", "error", err)
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, audiofile.ErrUnsupported):
+			status = http.StatusUnsupportedMediaType
+		case errors.Is(err, audiofile.ErrTooLong):
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if wantsReply(r) {
+		h.logger.Info("received audio via HTTP, waiting for result", "format", format, "bytes", len(data))
+		resp, err := h.await(r.Context(), data, sessionID(r))
+		h.writeResult(w, resp, err)
+		return
+	}
+
+	if h.enqueue(data, sessionID(r), nil) {
+		h.logger.Info("received audio via HTTP", "format", format, "bytes", len(data))
 		w.WriteHeader(http.StatusAccepted)
 		fmt.Fprintf(w, `{"status":"received","bytes":%d}`, len(data))
-	default:
+	} else {
 		http.Error(w, "queue full, try again", http.StatusServiceUnavailable)
 	}
 }
@@ -164,18 +437,27 @@ func (h *HTTPSource) handleText(w http.ResponseWriter, r *http.Request) {
 
 	marker := []byte(domain.TextCommandPrefix + text)
 
-	select {
-	case h.audioChan <- marker:
+	if wantsReply(r) {
+		h.logger.Info("received text command via HTTP, waiting for result", "text", text)
+		resp, err := h.await(r.Context(), marker, sessionID(r))
+		h.writeResult(w, resp, err)
+		return
+	}
+
+	if h.enqueue(marker, sessionID(r), nil) {
 		h.logger.Info("received text command via HTTP", "text", text)
 		w.WriteHeader(http.StatusAccepted)
 		fmt.Fprintf(w, `{"status":"received","text":"%s"}`, text)
-	default:
+	} else {
 		http.Error(w, "queue full, try again", http.StatusServiceUnavailable)
 	}
 }
 
 type alexaRequest struct {
 	Version string `json:"version"`
+	Session struct {
+		SessionID string `json:"sessionId"`
+	} `json:"session"`
 	Request struct {
 		Type   string `json:"type"`
 		Intent struct {
@@ -201,20 +483,60 @@ func alexaResponse(text string, endSession bool) []byte {
 	return []byte(resp)
 }
 
-func (h *HTTPSource) handleAlexa(w http.ResponseWriter, r *http.Request) {
-	if h.authToken != "" {
-		token := r.Header.Get("X-Auth-Token")
-		if token == "" {
-			token = r.URL.Query().Get("token")
+// alexaQuestion keeps the session open so the user's answer comes back as
+// the next request, repeating the question if they stay silent.
+func alexaQuestion(text string) []byte {
+	resp := fmt.Sprintf(`{
+		"version": "1.0",
+		"response": {
+			"outputSpeech": {
+				"type": "PlainText",
+				"text": %q
+			},
+			"reprompt": {
+				"outputSpeech": {
+					"type": "PlainText",
+					"text": %q
+				}
+			},
+			"shouldEndSession": false
 		}
+	}`, text, text)
+	return []byte(resp)
+}
 
-		if token != h.authToken {
-			h.logger.Warn("unauthorized alexa request", "remote_addr", r.RemoteAddr)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write(alexaResponse("No autorizado", true))
+// authorized checks the token of a request, sent in the X-Auth-Token header
+// or the token query parameter, when the source has one.
+func (h *HTTPSource) authorized(r *http.Request) bool {
+	if h.authToken == "" {
+		return true
+	}
+	token := r.Header.Get("X-Auth-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return token == h.authToken
+}
+
+// requireToken answers 401 to requests without the source's token.
+func (h *HTTPSource) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			h.logger.Warn("unauthorized request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		next(w, r)
+	}
+}
+
+func (h *HTTPSource) handleAlexa(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.logger.Warn("unauthorized alexa request", "remote_addr", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(alexaResponse("No autorizado", true))
+		return
 	}
 
 	data, err := io.ReadAll(io.LimitReader(r.Body, 8192))
@@ -285,16 +607,33 @@ func (h *HTTPSource) handleAlexa(w http.ResponseWriter, r *http.Request) {
 	text := commandSlot.Value
 	marker := []byte(domain.TextCommandPrefix + text)
 
-	select {
-	case h.audioChan <- marker:
-		h.logger.Info("received command from Alexa", "text", text)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(alexaResponse(fmt.Sprintf("Ejecutando: %s", text), true))
+	h.logger.Info("received command from Alexa", "text", text)
+	resp, err := h.await(r.Context(), marker, "alexa:"+alexaReq.Session.SessionID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err == nil && resp.Question {
+		w.Write(alexaQuestion(resp.Text))
+		return
+	}
+	w.Write(alexaResponse(alexaSpeech(resp, err), true))
+}
+
+// alexaSpeech turns the outcome of a command into what Alexa should say.
+func alexaSpeech(resp application.Response, err error) string {
+	switch {
+	case errors.Is(err, errQueueFull):
+		return "Estoy ocupado, probá en un momento"
+	case errors.Is(err, errReplyTimeout):
+		return "Estoy tardando más de lo normal, el comando sigue en proceso"
+	case errors.Is(resp.Err, application.ErrUnknownCommand):
+		return "No entendí el comando, probá de nuevo"
+	case resp.Text != "":
+		return resp.Text
+	case resp.Err != nil:
+		return fmt.Sprintf("No pude hacerlo: %s", resp.Err.Error())
 	default:
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write(alexaResponse("Estoy ocupado, probá en un momento", true))
+		return "Listo"
 	}
 }
 
@@ -3,16 +3,24 @@ package audio_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
 	"smart-home/internal/infra/audio"
+	"smart-home/internal/infra/audiofile"
+	"smart-home/internal/infra/composite"
+	"smart-home/internal/infra/schedule"
 )
 
 func TestHTTPSource_ReceiveAudio(t *testing.T) {
@@ -50,7 +58,7 @@ func TestHTTPSource_HandleAudioEndpoint(t *testing.T) {
 
 	handler := source.Handler()
 
-	testAudio := []byte("test audio content")
+	testAudio := audiofile.EncodeWAV(make([]int16, 16000), 16000)
 	req := httptest.NewRequest(http.MethodPost, "/audio", bytes.NewReader(testAudio))
 	rec := httptest.NewRecorder()
 
@@ -61,11 +69,101 @@ func TestHTTPSource_HandleAudioEndpoint(t *testing.T) {
 	}
 }
 
+func TestHTTPSource_NormalizesAudio(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "", logger)
+	source.SetMaxDuration(5 * time.Second)
+	handler := source.Handler()
+
+	post := func(body []byte) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/audio", bytes.NewReader(body)))
+		return rec.Code
+	}
+
+	// A second at 44.1 kHz arrives as a second at 16 kHz
+	if code := post(audiofile.EncodeWAV(make([]int16, 44100), 44100)); code != http.StatusAccepted {
+		t.Fatalf("44.1 kHz WAV: got %d", code)
+	}
+	received, err := source.NextCommand(context.Background())
+	if err != nil {
+		t.Fatalf("receiving audio: %v", err)
+	}
+	clip, err := audiofile.DecodeWAV(received)
+	if err != nil {
+		t.Fatalf("decoding received audio: %v", err)
+	}
+	if clip.SampleRate != 16000 || len(clip.Samples) != 16000 {
+		t.Errorf("got %d samples at %d Hz, want 16000 at 16000 Hz", len(clip.Samples), clip.SampleRate)
+	}
+
+	if code := post([]byte("test audio content")); code != http.StatusUnsupportedMediaType {
+		t.Errorf("unknown format: got %d, want %d", code, http.StatusUnsupportedMediaType)
+	}
+	if code := post(audiofile.EncodeWAV(make([]int16, 16000*6), 16000)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("6s clip: got %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+
+	// A backend that only reads WAV gets no MP3
+	source.SetCompressedFormats(nil)
+	if code := post([]byte("\xFF\xFB\x90\x00 frames")); code != http.StatusUnsupportedMediaType {
+		t.Errorf("MP3 for a WAV-only backend: got %d, want %d", code, http.StatusUnsupportedMediaType)
+	}
+}
+
+const alexaIntentBody = `{
+	"version": "1.0",
+	"request": {
+		"type": "IntentRequest",
+		"intent": {
+			"name": "SmartHomeIntent",
+			"slots": {"command": {"value": "turn on the lights"}}
+		}
+	}
+}`
+
+// answerRequests plays the assistant's part: it takes queued requests from
+// the source and replies to each one with the given response.
+func answerRequests(ctx context.Context, source *audio.HTTPSource, resp application.Response) {
+	go func() {
+		for {
+			req, err := source.NextRequest(ctx)
+			if err != nil {
+				return
+			}
+			if req.Reply != nil {
+				req.Reply(resp)
+			}
+		}
+	}()
+}
+
+func alexaSpeech(t *testing.T, rec *httptest.ResponseRecorder) (string, bool) {
+	t.Helper()
+
+	var body struct {
+		Response struct {
+			OutputSpeech struct {
+				Text string `json:"text"`
+			} `json:"outputSpeech"`
+			ShouldEndSession bool `json:"shouldEndSession"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding alexa response %q: %v", rec.Body.String(), err)
+	}
+	return body.Response.OutputSpeech.Text, body.Response.ShouldEndSession
+}
+
 func TestHTTPSource_AlexaEndpointWithToken(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	authToken := "test-secret-token-123"
 	source := audio.NewHTTPSource(":0", authToken, logger)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerRequests(ctx, source, application.Response{Text: "Command 'turn_on' executed on 'Luz Living'"})
+
 	handler := source.Handler()
 
 	tests := []struct {
@@ -78,13 +176,13 @@ func TestHTTPSource_AlexaEndpointWithToken(t *testing.T) {
 			name:       "valid token in header",
 			token:      authToken,
 			method:     "header",
-			wantStatus: http.StatusAccepted,
+			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "valid token in query",
 			token:      authToken,
 			method:     "query",
-			wantStatus: http.StatusAccepted,
+			wantStatus: http.StatusOK,
 		},
 		{
 			name:       "invalid token",
@@ -102,13 +200,12 @@ func TestHTTPSource_AlexaEndpointWithToken(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			testText := []byte("turn on the lights")
 			var req *http.Request
 
 			if tt.method == "query" {
-				req = httptest.NewRequest(http.MethodPost, "/alexa?token="+tt.token, bytes.NewReader(testText))
+				req = httptest.NewRequest(http.MethodPost, "/alexa?token="+tt.token, strings.NewReader(alexaIntentBody))
 			} else {
-				req = httptest.NewRequest(http.MethodPost, "/alexa", bytes.NewReader(testText))
+				req = httptest.NewRequest(http.MethodPost, "/alexa", strings.NewReader(alexaIntentBody))
 				if tt.token != "" {
 					req.Header.Set("X-Auth-Token", tt.token)
 				}
@@ -120,6 +217,13 @@ func TestHTTPSource_AlexaEndpointWithToken(t *testing.T) {
 			if rec.Code != tt.wantStatus {
 				t.Errorf("status code: got %d, want %d", rec.Code, tt.wantStatus)
 			}
+
+			if tt.wantStatus == http.StatusOK {
+				speech, _ := alexaSpeech(t, rec)
+				if speech != "Command 'turn_on' executed on 'Luz Living'" {
+					t.Errorf("speech: got %q, want the assistant's result", speech)
+				}
+			}
 		})
 	}
 }
@@ -128,17 +232,104 @@ func TestHTTPSource_AlexaEndpointWithoutToken(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	source := audio.NewHTTPSource(":0", "", logger) // No token configured
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerRequests(ctx, source, application.Response{Text: "ok"})
+
 	handler := source.Handler()
 
-	testText := []byte("turn on the lights")
-	req := httptest.NewRequest(http.MethodPost, "/alexa", bytes.NewReader(testText))
+	req := httptest.NewRequest(http.MethodPost, "/alexa", strings.NewReader(alexaIntentBody))
 	rec := httptest.NewRecorder()
 
 	handler.ServeHTTP(rec, req)
 
 	// Should accept request when no token is configured
-	if rec.Code != http.StatusAccepted {
-		t.Errorf("status code: got %d, want %d (auth should be disabled)", rec.Code, http.StatusAccepted)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status code: got %d, want %d (auth should be disabled)", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHTTPSource_AlexaReportsOutcome(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name       string
+		resp       *application.Response
+		wantSpeech string
+	}{
+		{
+			name:       "device not found",
+			resp:       &application.Response{Err: errors.New("device not found: Luz Garage")},
+			wantSpeech: "No pude hacerlo: device not found: Luz Garage",
+		},
+		{
+			name:       "unknown command",
+			resp:       &application.Response{Err: application.ErrUnknownCommand},
+			wantSpeech: "No entendí el comando, probá de nuevo",
+		},
+		{
+			name:       "status answer",
+			resp:       &application.Response{Text: "Luz Living is on at 40%"},
+			wantSpeech: "Luz Living is on at 40%",
+		},
+		{
+			name:       "no reply before deadline",
+			wantSpeech: "Estoy tardando más de lo normal, el comando sigue en proceso",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := audio.NewHTTPSource(":0", "", logger)
+			source.SetReplyTimeout(100 * time.Millisecond)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.resp != nil {
+				answerRequests(ctx, source, *tt.resp)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/alexa", strings.NewReader(alexaIntentBody))
+			rec := httptest.NewRecorder()
+			source.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status code: got %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			speech, endSession := alexaSpeech(t, rec)
+			if speech != tt.wantSpeech {
+				t.Errorf("speech: got %q, want %q", speech, tt.wantSpeech)
+			}
+			if !endSession {
+				t.Error("session should end after a command")
+			}
+		})
+	}
+}
+
+func TestHTTPSource_TextEndpointWaitsForResult(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "", logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerRequests(ctx, source, application.Response{Text: "Command 'turn_on' executed on 'Luz Living'"})
+
+	req := httptest.NewRequest(http.MethodPost, "/text?wait=true", strings.NewReader("prende la luz del living"))
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status code: got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if body["result"] != "Command 'turn_on' executed on 'Luz Living'" {
+		t.Errorf("result: got %q", body["result"])
 	}
 }
 
@@ -149,8 +340,8 @@ func TestFileSource_LoadFromDirectory(t *testing.T) {
 		filename string
 		content  []byte
 	}{
-		{"command1.wav", []byte("RIFF....WAVEfmt audio data 1")},
-		{"command2.wav", []byte("RIFF....WAVEfmt audio data 2")},
+		{"command1.wav", audiofile.EncodeWAV(make([]int16, 8000), 16000)},
+		{"command2.wav", audiofile.EncodeWAV(make([]int16, 8000), 16000)},
 	}
 
 	for _, tc := range testCases {
@@ -219,3 +410,276 @@ func TestFileSource_LoadSampleAudios(t *testing.T) {
 	t.Logf("loaded sample audio: %d bytes", len(audioData))
 }
 
+
+func TestHTTPSource_SessionIDs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	alexaBody := strings.Replace(alexaIntentBody, `"version": "1.0",`,
+		`"version": "1.0", "session": {"sessionId": "amzn1.echo-api.session.abc"},`, 1)
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		wantID string
+	}{
+		{
+			name:   "alexa session",
+			req:    httptest.NewRequest(http.MethodPost, "/alexa", strings.NewReader(alexaBody)),
+			wantID: "alexa:amzn1.echo-api.session.abc",
+		},
+		{
+			name: "session header",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/text", strings.NewReader("prende la luz"))
+				r.Header.Set("X-Session-ID", "kitchen-tablet")
+				return r
+			}(),
+			wantID: "http:kitchen-tablet",
+		},
+		{
+			name:   "client address",
+			req:    httptest.NewRequest(http.MethodPost, "/text", strings.NewReader("prende la luz")),
+			wantID: "http:192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := audio.NewHTTPSource(":0", "", logger)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go source.Handler().ServeHTTP(httptest.NewRecorder(), tt.req)
+
+			req, err := source.NextRequest(ctx)
+			if err != nil {
+				t.Fatalf("NextRequest error: %v", err)
+			}
+			if req.SessionID != tt.wantID {
+				t.Errorf("session ID: got %q, want %q", req.SessionID, tt.wantID)
+			}
+			if req.Reply != nil {
+				req.Reply(application.Response{Text: "ok"})
+			}
+		})
+	}
+}
+
+func TestHTTPSource_AlexaKeepsSessionForQuestions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "", logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	answerRequests(ctx, source, application.Response{Text: "¿Cuál lámpara?", Question: true})
+
+	req := httptest.NewRequest(http.MethodPost, "/alexa", strings.NewReader(alexaIntentBody))
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, req)
+
+	speech, endSession := alexaSpeech(t, rec)
+	if speech != "¿Cuál lámpara?" {
+		t.Errorf("speech: got %q", speech)
+	}
+	if endSession {
+		t.Error("session should stay open for the answer")
+	}
+	if !strings.Contains(rec.Body.String(), `"reprompt"`) {
+		t.Error("questions should carry a reprompt")
+	}
+}
+
+func TestHTTPSource_Timers(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "", logger)
+
+	timers := application.NewScheduler(nil, logger)
+	at := time.Now().Add(time.Hour)
+	timer, err := timers.Add(at, domain.Command{Action: domain.ActionTurnOff, TargetName: "Estufa", TargetType: domain.TargetTypeDevice})
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	source.SetTimers(timers)
+
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/timers", nil))
+	var listed []struct {
+		ID     string    `json:"id"`
+		At     time.Time `json:"at"`
+		Action string    `json:"action"`
+		Target string    `json:"target"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatalf("decoding list: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != timer.ID || listed[0].Action != "turn_off" || listed[0].Target != "Estufa" || !listed[0].At.Equal(at) {
+		t.Errorf("unexpected list: %+v", listed)
+	}
+
+	rec = httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/timers/"+timer.ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("cancel status: got %d", rec.Code)
+	}
+	if len(timers.List()) != 0 {
+		t.Error("the timer should be cancelled")
+	}
+
+	rec = httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/timers/"+timer.ID, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("cancelling twice: got %d, want 404", rec.Code)
+	}
+}
+
+func TestHTTPSource_TimersNeedToken(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "secret-token", logger)
+
+	timers := application.NewScheduler(nil, logger)
+	timer, err := timers.Add(time.Now().Add(time.Hour), domain.Command{Action: domain.ActionTurnOff, TargetName: "Estufa", TargetType: domain.TargetTypeDevice})
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	source.SetTimers(timers)
+
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/timers/"+timer.ID, nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("cancel without token: got %d, want 401", rec.Code)
+	}
+	if len(timers.List()) != 1 {
+		t.Fatal("the timer shouldn't be cancelled without the token")
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/timers/"+timer.ID, nil)
+	req.Header.Set("X-Auth-Token", "secret-token")
+	rec = httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || len(timers.List()) != 0 {
+		t.Errorf("cancel with token: status %d, %d timers left", rec.Code, len(timers.List()))
+	}
+}
+
+func TestHTTPSource_Routines(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "", logger)
+
+	morning, err := schedule.ParseCron("30 7 * * *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routines := application.NewRoutines([]application.Routine{
+		{Name: "Buenos días", Schedule: morning, Phrases: []string{"prendé la luz de la cocina"}},
+	}, nil, logger)
+	source.SetRoutines(routines)
+
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/routines/buenos%20d%C3%ADas/disable", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("disable status: got %d", rec.Code)
+	}
+	if routines.List()[0].Enabled {
+		t.Error("the routine should be disabled")
+	}
+
+	rec = httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/routines", nil))
+	var listed []struct {
+		Name     string     `json:"name"`
+		Schedule string     `json:"schedule"`
+		Enabled  bool       `json:"enabled"`
+		Next     *time.Time `json:"next"`
+		LastRun  *time.Time `json:"last_run"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatalf("decoding list: %v", err)
+	}
+	if len(listed) != 1 || listed[0].Name != "Buenos días" || listed[0].Schedule != "30 7 * * *" || listed[0].Enabled || listed[0].Next == nil || listed[0].LastRun != nil {
+		t.Errorf("unexpected list: %+v", listed)
+	}
+
+	rec = httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/routines/Buenos%20d%C3%ADas/enable", nil))
+	if rec.Code != http.StatusOK || !routines.List()[0].Enabled {
+		t.Errorf("enable: status %d, enabled %v", rec.Code, routines.List()[0].Enabled)
+	}
+
+	rec = httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/routines/nada/enable", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown routine: got %d, want 404", rec.Code)
+	}
+}
+
+func TestHTTPSource_RoutinesAndMetricsNeedToken(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "secret-token", logger)
+
+	morning, err := schedule.ParseCron("30 7 * * *", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routines := application.NewRoutines([]application.Routine{
+		{Name: "Despertador", Schedule: morning, Phrases: []string{"prendé la luz del dormitorio"}},
+	}, nil, logger)
+	source.SetRoutines(routines)
+	source.SetTimers(application.NewScheduler(nil, logger))
+	source.SetIntentStats(composite.NewParser(logger))
+
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/routines/despertador/disable"},
+		{http.MethodPost, "/routines/despertador/enable"},
+		{http.MethodGet, "/routines"},
+		{http.MethodGet, "/timers"},
+		{http.MethodGet, "/metrics"},
+	} {
+		rec := httptest.NewRecorder()
+		source.Handler().ServeHTTP(rec, httptest.NewRequest(req.method, req.path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: got %d, want 401", req.method, req.path, rec.Code)
+		}
+	}
+	if !routines.List()[0].Enabled {
+		t.Fatal("the routine shouldn't be disabled without the token")
+	}
+
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/routines/despertador/disable?token=secret-token", nil))
+	if rec.Code != http.StatusOK || routines.List()[0].Enabled {
+		t.Errorf("disable with token: status %d, enabled %v", rec.Code, routines.List()[0].Enabled)
+	}
+}
+
+type failingParser struct{}
+
+func (failingParser) Parse(context.Context, string, application.DeviceRegistry, *application.Conversation) ([]*domain.Command, error) {
+	return nil, errors.New("quota exceeded")
+}
+
+func TestHTTPSource_IntentStats(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	source := audio.NewHTTPSource(":0", "", logger)
+	chain := composite.NewParser(logger, composite.Provider{Name: "anthropic", Parser: failingParser{}})
+	source.SetIntentStats(chain)
+
+	chain.Parse(context.Background(), "prendé la luz", nil, nil)
+
+	rec := httptest.NewRecorder()
+	source.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var body struct {
+		IntentProviders map[string]struct {
+			Answered int  `json:"answered"`
+			Failed   int  `json:"failed"`
+			Skipped  int  `json:"skipped"`
+			Open     bool `json:"open"`
+		} `json:"intent_providers"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding metrics: %v", err)
+	}
+	if s, ok := body.IntentProviders["anthropic"]; !ok || s.Failed != 1 || s.Answered != 0 || s.Open {
+		t.Errorf("unexpected metrics: %+v", body)
+	}
+}
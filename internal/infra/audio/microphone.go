@@ -4,42 +4,78 @@
 package audio
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"log/slog"
-	"sync"
+	"slices"
 	"time"
 
 	"github.com/gordonklaus/portaudio"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/audiofile"
 )
 
 type MicrophoneSource struct {
 	stream     *portaudio.Stream
-	wakeWord   string
+	frame      []int16
+	wakeWord   *WakeWordDetector
+	vad        *VAD
 	sampleRate int
+	stt        application.SpeechToText
+	streaming  application.StreamingSpeechToText
+	complete   func(command string) bool
 	logger     *slog.Logger
-
-	mu        sync.Mutex
-	buffer    []int16
-	recording bool
 }
 
-func NewMicrophoneSource(wakeWord string, sampleRate int, logger *slog.Logger) *MicrophoneSource {
+// NewMicrophoneSource creates a source listening to the default input
+// device. With a wake word detector only what follows the wake word becomes
+// a command; wakeWord may be nil to take everything the microphone hears.
+func NewMicrophoneSource(wakeWord *WakeWordDetector, sampleRate int, logger *slog.Logger) *MicrophoneSource {
 	return &MicrophoneSource{
 		wakeWord:   wakeWord,
+		vad:        NewVAD(DefaultVADConfig(sampleRate)),
 		sampleRate: sampleRate,
 		logger:     logger,
-		buffer:     make([]int16, 0),
 	}
 }
 
+// SetStreamingSTT transcribes the audio while it is recorded, so that
+// NextCommand returns the text as soon as the user stops talking instead of
+// the recording. It must be called before Start.
+func (m *MicrophoneSource) SetStreamingSTT(stt application.StreamingSpeechToText) {
+	m.streaming = stt
+}
+
+// SetEarlyEnd lets a streaming recognizer end the utterance as soon as its
+// partial transcript is a whole command according to complete, instead of
+// waiting for the user to stay quiet. It must be called before Start.
+func (m *MicrophoneSource) SetEarlyEnd(complete func(command string) bool) {
+	m.complete = complete
+}
+
+// SetVAD changes how utterances are told apart from the background noise.
+// It must be called before Start.
+func (m *MicrophoneSource) SetVAD(cfg VADConfig) {
+	m.vad = NewVAD(cfg)
+}
+
+// SetSpeechToText sets the recognizer used to listen for the wake word when
+// there is no streaming one. It must be called before Start.
+func (m *MicrophoneSource) SetSpeechToText(stt application.SpeechToText) {
+	m.stt = stt
+}
+
 func (m *MicrophoneSource) Name() string {
 	return "microphone"
 }
 
 func (m *MicrophoneSource) Start(_ context.Context) error {
+	if m.wakeWord != nil && m.stt == nil && m.streaming == nil {
+		return fmt.Errorf("listening for the wake word needs speech-to-text: configure stt")
+	}
+
 	if err := portaudio.Initialize(); err != nil {
 		return fmt.Errorf("initializing portaudio: %w", err)
 	}
@@ -62,6 +98,7 @@ func (m *MicrophoneSource) Start(_ context.Context) error {
 	}
 
 	m.stream = stream
+	m.frame = buffer
 
 	if err := m.stream.Start(); err != nil {
 		return fmt.Errorf("starting stream: %w", err)
@@ -81,90 +118,106 @@ func (m *MicrophoneSource) Stop() error {
 }
 
 func (m *MicrophoneSource) NextCommand(ctx context.Context) ([]byte, error) {
-	m.logger.Info("waiting for wake word", "wakeWord", m.wakeWord)
-
-	samples := make([]int16, 0, m.sampleRate*5)
-	silenceThreshold := int16(500)
-	silenceDuration := 0
-	maxSilenceFrames := m.sampleRate
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+	if m.wakeWord == nil {
+		m.logger.Info("listening")
+		if m.streaming != nil {
+			for {
+				text, err := m.transcribe(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if text != "" {
+					return []byte(domain.TextCommandPrefix + text), nil
+				}
+			}
 		}
-
-		buffer := make([]int16, 1024)
-		if err := m.stream.Read(); err != nil {
-			return nil, fmt.Errorf("reading from stream: %w", err)
+		samples, err := m.record(ctx)
+		if err != nil {
+			return nil, err
 		}
+		return m.wav(samples), nil
+	}
 
-		samples = append(samples, buffer...)
-
-		isSilent := true
-		for _, sample := range buffer {
-			if sample > silenceThreshold || sample < -silenceThreshold {
-				isSilent = false
-				break
-			}
+	m.logger.Info("waiting for wake word")
+	for {
+		text, err := m.transcribe(ctx)
+		if err != nil {
+			return nil, err
 		}
-
-		if isSilent {
-			silenceDuration += len(buffer)
-		} else {
-			silenceDuration = 0
+		if command, ok := m.wakeWord.Detect(text, time.Now()); ok {
+			m.logger.Info("wake word heard", "command", command)
+			return []byte(domain.TextCommandPrefix + command), nil
 		}
-
-		if silenceDuration > maxSilenceFrames && len(samples) > m.sampleRate {
-			break
+		if text != "" {
+			m.logger.Debug("ignoring speech without the wake word", "text", text)
 		}
+	}
+}
 
-		if len(samples) > m.sampleRate*10 {
-			break
+// transcribe records the next utterance and returns its text.
+func (m *MicrophoneSource) transcribe(ctx context.Context) (string, error) {
+	if m.streaming != nil {
+		text, err := transcribeStream(ctx, m.readFrame, m.vad, m.streaming, m.sampleRate, m.partialComplete(), m.logger)
+		if err != nil {
+			return "", fmt.Errorf("transcribing: %w", err)
 		}
+		return text, nil
 	}
 
-	return samplesToWav(samples, m.sampleRate)
+	samples, err := m.record(ctx)
+	if err != nil {
+		return "", err
+	}
+	text, err := m.stt.Transcribe(ctx, m.wav(samples))
+	if err != nil {
+		return "", fmt.Errorf("transcribing: %w", err)
+	}
+	return text, nil
 }
 
-func samplesToWav(samples []int16, sampleRate int) ([]byte, error) {
-	var buf bytes.Buffer
-
-	dataSize := len(samples) * 2
-	fileSize := 36 + dataSize
-
-	buf.WriteString("RIFF")
-	binary.Write(&buf, binary.LittleEndian, int32(fileSize))
-	buf.WriteString("WAVE")
-
-	buf.WriteString("fmt ")
-	binary.Write(&buf, binary.LittleEndian, int32(16))
-	binary.Write(&buf, binary.LittleEndian, int16(1))
-	binary.Write(&buf, binary.LittleEndian, int16(1))
-	binary.Write(&buf, binary.LittleEndian, int32(sampleRate))
-	binary.Write(&buf, binary.LittleEndian, int32(sampleRate*2))
-	binary.Write(&buf, binary.LittleEndian, int16(2))
-	binary.Write(&buf, binary.LittleEndian, int16(16))
-
-	buf.WriteString("data")
-	binary.Write(&buf, binary.LittleEndian, int32(dataSize))
-	for _, sample := range samples {
-		binary.Write(&buf, binary.LittleEndian, sample)
+// partialComplete checks partial transcripts for a whole command, past the
+// wake word when there is one.
+func (m *MicrophoneSource) partialComplete() func(string) bool {
+	if m.complete == nil || m.wakeWord == nil {
+		return m.complete
+	}
+	return func(text string) bool {
+		command, ok := m.wakeWord.Peek(text, time.Now())
+		return ok && m.complete(command)
 	}
-
-	return buf.Bytes(), nil
 }
 
-type WakeWordDetector struct {
-	wakeWords []string
-	timeout   time.Duration
+// record waits for the VAD to hear an utterance and returns it.
+func (m *MicrophoneSource) record(ctx context.Context) ([]int16, error) {
+	var samples []int16
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		frame, err := m.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		speech, state := m.vad.Write(frame)
+		samples = append(samples, speech...)
+		if state == VADEnded {
+			return samples, nil
+		}
+	}
 }
 
-func NewWakeWordDetector(wakeWords []string, timeout time.Duration) *WakeWordDetector {
-	return &WakeWordDetector{
-		wakeWords: wakeWords,
-		timeout:   timeout,
+// readFrame waits for the next buffer of samples from the stream.
+func (m *MicrophoneSource) readFrame() ([]int16, error) {
+	if err := m.stream.Read(); err != nil {
+		return nil, fmt.Errorf("reading from stream: %w", err)
 	}
+	return slices.Clone(m.frame), nil
 }
 
+// wav encodes an utterance in application.DefaultAudioFormat(), whatever
+// rate the microphone records at.
+func (m *MicrophoneSource) wav(samples []int16) []byte {
+	rate := application.DefaultAudioFormat().SampleRate
+	return audiofile.EncodeWAV(audiofile.Resample(samples, m.sampleRate, rate), rate)
+}
@@ -7,6 +7,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+
+	"smart-home/internal/application"
 )
 
 // MicrophoneSource stub when portaudio is not available
@@ -14,10 +16,18 @@ type MicrophoneSource struct {
 	logger *slog.Logger
 }
 
-func NewMicrophoneSource(wakeWord string, sampleRate int, logger *slog.Logger) *MicrophoneSource {
+func NewMicrophoneSource(wakeWord *WakeWordDetector, sampleRate int, logger *slog.Logger) *MicrophoneSource {
 	return &MicrophoneSource{logger: logger}
 }
 
+func (m *MicrophoneSource) SetStreamingSTT(stt application.StreamingSpeechToText) {}
+
+func (m *MicrophoneSource) SetSpeechToText(stt application.SpeechToText) {}
+
+func (m *MicrophoneSource) SetVAD(cfg VADConfig) {}
+
+func (m *MicrophoneSource) SetEarlyEnd(complete func(command string) bool) {}
+
 func (m *MicrophoneSource) Name() string {
 	return "microphone"
 }
@@ -0,0 +1,105 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"time"
+
+	"smart-home/internal/application"
+)
+
+// partialSettle is how long a partial transcript that already is a whole
+// command must stay the same for the utterance to end there, without
+// waiting for the VAD's hangover.
+const partialSettle = 300 * time.Millisecond
+
+// transcribeStream waits for the VAD to hear speech, then sends it to a
+// streaming recognizer as it arrives, and returns the text once the
+// recognizer hears the end of a sentence or the VAD the end of the
+// utterance. complete, when set, is asked about every new partial
+// transcript: once one is a whole command and stops changing, the
+// utterance ends early.
+func transcribeStream(ctx context.Context, read func() ([]int16, error), vad *VAD, stt application.StreamingSpeechToText, sampleRate int, complete func(string) bool, logger *slog.Logger) (string, error) {
+	var (
+		stream application.SpeechStream
+		// The latest partial transcript, whether it is a whole command and
+		// how many samples were sent since it last changed
+		partial   string
+		done      bool
+		unchanged int
+	)
+	defer func() {
+		if stream != nil {
+			stream.Close()
+		}
+	}()
+	settle := int(partialSettle * time.Duration(sampleRate) / time.Second)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		frame, err := read()
+		if err != nil {
+			return "", err
+		}
+		speech, state := vad.Write(frame)
+		if len(speech) > 0 && stream == nil {
+			if stream, err = stt.StartStream(ctx, sampleRate); err != nil {
+				return "", err
+			}
+		}
+		if len(speech) > 0 {
+			if err := stream.Write(pcmBytes(speech)); err != nil {
+				return "", err
+			}
+		}
+		if stream == nil {
+			continue
+		}
+		unchanged += len(speech)
+
+		if state == VADEnded {
+			return stream.Finish(ctx)
+		}
+
+	results:
+		for {
+			select {
+			case t, ok := <-stream.Results():
+				if !ok || (t.Final && t.Text != "") {
+					vad.Reset()
+					return stream.Finish(ctx)
+				}
+				if !t.Final && t.Text != partial {
+					partial, unchanged = t.Text, 0
+					done = complete != nil && partial != "" && complete(partial)
+					logger.Debug("partial transcript", "text", partial, "complete", done)
+				}
+			default:
+				break results
+			}
+		}
+
+		if done && unchanged >= settle {
+			logger.Debug("ending the utterance early on a whole command", "text", partial)
+			vad.Reset()
+			text, err := stream.Finish(ctx)
+			if err == nil && text == "" {
+				text = partial
+			}
+			return text, err
+		}
+	}
+}
+
+// pcmBytes encodes samples as 16-bit little-endian PCM.
+func pcmBytes(samples []int16) []byte {
+	data := make([]byte, 0, len(samples)*2)
+	for _, s := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(s))
+	}
+	return data
+}
@@ -0,0 +1,94 @@
+package audio
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"smart-home/internal/application"
+)
+
+// fakeStreamingSTT answers the first audio of every stream with a partial
+// transcript that never changes.
+type fakeStreamingSTT struct {
+	partial string
+}
+
+func (f *fakeStreamingSTT) StartStream(_ context.Context, _ int) (application.SpeechStream, error) {
+	return &fakeSpeechStream{partial: f.partial, results: make(chan application.Transcript, 16)}, nil
+}
+
+type fakeSpeechStream struct {
+	partial string
+	sent    bool
+	results chan application.Transcript
+}
+
+func (s *fakeSpeechStream) Write(_ []byte) error {
+	if !s.sent {
+		s.sent = true
+		s.results <- application.Transcript{Text: s.partial}
+	}
+	return nil
+}
+
+func (s *fakeSpeechStream) Results() <-chan application.Transcript { return s.results }
+
+func (s *fakeSpeechStream) Finish(_ context.Context) (string, error) { return "", nil }
+
+func (s *fakeSpeechStream) Close() error { return nil }
+
+func TestTranscribeStream_EndsEarlyOnWholeCommand(t *testing.T) {
+	const sampleRate = 16000
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, tc := range []struct {
+		name     string
+		complete func(string) bool
+		early    bool
+	}{
+		{"whole command", func(text string) bool { return text == "prendé la luz del living" }, true},
+		{"not a command yet", func(string) bool { return false }, false},
+		{"no check", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultVADConfig(sampleRate)
+			cfg.MaxUtterance = 2 * time.Second
+			vad := NewVAD(cfg)
+
+			// Silence, then someone talking without a pause
+			read, frames := 0, 0
+			readFrame := func() ([]int16, error) {
+				frames++
+				frame := make([]int16, sampleRate/50)
+				if frames > 10 {
+					for i := range frame {
+						frame[i] = 3000
+						if i/40%2 == 0 {
+							frame[i] = -3000
+						}
+					}
+				}
+				read += len(frame)
+				return frame, nil
+			}
+
+			stt := &fakeStreamingSTT{partial: "prendé la luz del living"}
+			text, err := transcribeStream(context.Background(), readFrame, vad, stt, sampleRate, tc.complete, logger)
+			if err != nil {
+				t.Fatalf("transcribeStream error: %v", err)
+			}
+			heard := time.Duration(read) * time.Second / sampleRate
+			switch {
+			case tc.early && text != "prendé la luz del living":
+				t.Errorf("text: got %q, want the partial transcript", text)
+			case tc.early && heard > time.Second:
+				t.Errorf("ended after %s of audio, want right after the command settled", heard)
+			case !tc.early && heard < cfg.MaxUtterance:
+				t.Errorf("ended after %s without a whole command, want the VAD to end it", heard)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// VADConfig tunes the voice activity detection of the microphone.
+type VADConfig struct {
+	SampleRate int
+	// PreRoll is the audio kept from before speech is detected, so that the
+	// first syllable isn't cut
+	PreRoll time.Duration
+	// Hangover is how long the user must stay quiet for the utterance to end
+	Hangover time.Duration
+	// MaxUtterance cuts utterances that go on for longer
+	MaxUtterance time.Duration
+	// MinSpeech is how long speech must last to start an utterance; shorter
+	// sounds, like a door or a click, are ignored
+	MinSpeech time.Duration
+	// Margin is how far above the noise floor, in dB, a frame must be to
+	// count as speech
+	Margin float64
+}
+
+// DefaultVADConfig works for a microphone a few metres away in a room.
+func DefaultVADConfig(sampleRate int) VADConfig {
+	return VADConfig{
+		SampleRate:   sampleRate,
+		PreRoll:      300 * time.Millisecond,
+		Hangover:     800 * time.Millisecond,
+		MaxUtterance: 10 * time.Second,
+		MinSpeech:    60 * time.Millisecond,
+		Margin:       10,
+	}
+}
+
+// VADState is where a VAD is in an utterance.
+type VADState int
+
+const (
+	// VADWaiting means no speech has been heard yet
+	VADWaiting VADState = iota
+	// VADSpeaking means an utterance is going on
+	VADSpeaking
+	// VADEnded means the utterance is over; the VAD waits for the next one
+	VADEnded
+)
+
+const (
+	vadFrame = 20 * time.Millisecond
+	// Frames quieter than this, in dB (an RMS of 200), are never speech, so
+	// that a silent input doesn't make every breath an utterance
+	vadMinEnergy = 46
+	// Fricatives ("s", "f") are quiet but cross zero often; above this rate
+	// they keep an utterance going with half the margin
+	vadFricativeZCR = 0.25
+	// How fast the noise floor follows the level of the room, in dB per
+	// frame and dB of difference: quickly down, slowly up, and very slowly
+	// while someone talks, so that a fan turned on mid-sentence is
+	// eventually learned too
+	vadFloorFall   = 0.1
+	vadFloorRise   = 0.01
+	vadFloorSpeech = 0.001
+)
+
+// VAD splits the samples of a microphone into utterances. It compares the
+// energy of every 20 ms frame with an adaptive estimate of the background
+// noise, helped by the zero-crossing rate for unvoiced sounds, and keeps
+// the state across utterances.
+type VAD struct {
+	cfg       VADConfig
+	frameSize int
+
+	floor   float64 // dB, NaN until the first frame
+	pending []int16
+	history []int16 // the recent audio while waiting
+
+	state     VADState
+	utterance int // samples in the current utterance
+	speech    int // consecutive speech samples while waiting
+	quiet     int // consecutive quiet samples while speaking
+}
+
+func NewVAD(cfg VADConfig) *VAD {
+	return &VAD{
+		cfg:       cfg,
+		frameSize: max(int(vadFrame)*cfg.SampleRate/int(time.Second), 1),
+		floor:     math.NaN(),
+	}
+}
+
+// Write feeds the next samples to the VAD. It returns those that belong to
+// an utterance, with the pre-roll once speech starts, and the state after
+// them. After VADEnded the next Write starts looking for a new utterance;
+// samples after the end of the utterance are kept as pre-roll for it.
+func (v *VAD) Write(samples []int16) ([]int16, VADState) {
+	if v.state == VADEnded {
+		v.state = VADWaiting
+	}
+
+	v.pending = append(v.pending, samples...)
+	var out []int16
+	for len(v.pending) >= v.frameSize {
+		frame := v.pending[:v.frameSize]
+		v.pending = v.pending[v.frameSize:]
+
+		if v.state == VADEnded {
+			v.remember(frame)
+			continue
+		}
+		out = v.process(frame, out)
+	}
+	// Don't let the leftovers keep the whole buffer alive
+	v.pending = append([]int16(nil), v.pending...)
+
+	return out, v.state
+}
+
+// Reset drops the current utterance, e.g. when the recognizer heard it end
+// before the VAD did. The noise floor is kept.
+func (v *VAD) Reset() {
+	v.state = VADWaiting
+	v.utterance, v.speech, v.quiet = 0, 0, 0
+	v.history = v.history[:0]
+}
+
+func (v *VAD) process(frame, out []int16) []int16 {
+	energy, zcr := frameStats(frame)
+	if math.IsNaN(v.floor) {
+		v.floor = energy
+	}
+
+	floor := max(v.floor, vadMinEnergy-v.cfg.Margin)
+	speech := energy > floor+v.cfg.Margin
+	if v.state == VADSpeaking && !speech {
+		speech = zcr > vadFricativeZCR && energy > floor+v.cfg.Margin/2
+	}
+	v.adapt(energy, speech)
+
+	switch v.state {
+	case VADWaiting:
+		v.remember(frame)
+		if !speech {
+			v.speech = 0
+			return out
+		}
+		v.speech += len(frame)
+		if v.speech < v.samples(v.cfg.MinSpeech) {
+			return out
+		}
+		v.state = VADSpeaking
+		v.utterance, v.quiet, v.speech = len(v.history), 0, 0
+		out = append(out, v.history...)
+		v.history = v.history[:0]
+		return out
+
+	case VADSpeaking:
+		out = append(out, frame...)
+		v.utterance += len(frame)
+		if speech {
+			v.quiet = 0
+		} else {
+			v.quiet += len(frame)
+		}
+		if v.quiet >= v.samples(v.cfg.Hangover) || v.utterance >= v.samples(v.cfg.MaxUtterance) {
+			v.state = VADEnded
+		}
+	}
+	return out
+}
+
+// adapt moves the noise floor towards the energy of the frame.
+func (v *VAD) adapt(energy float64, speech bool) {
+	rate := vadFloorRise
+	switch {
+	case speech || v.state == VADSpeaking:
+		rate = vadFloorSpeech
+	case energy < v.floor:
+		rate = vadFloorFall
+	}
+	v.floor += (energy - v.floor) * rate
+}
+
+// remember keeps the frame as pre-roll, along with the speech that may be
+// about to start an utterance.
+func (v *VAD) remember(frame []int16) {
+	v.history = append(v.history, frame...)
+	if excess := len(v.history) - v.samples(v.cfg.PreRoll+v.cfg.MinSpeech); excess > 0 {
+		v.history = append(v.history[:0], v.history[excess:]...)
+	}
+}
+
+func (v *VAD) samples(d time.Duration) int {
+	return int(d * time.Duration(v.cfg.SampleRate) / time.Second)
+}
+
+// frameStats returns the mean energy of a frame, in dB, and the fraction of
+// its samples where the signal changes sign.
+func frameStats(frame []int16) (energy, zcr float64) {
+	crossings := 0
+	for i, s := range frame {
+		energy += float64(s) * float64(s)
+		if i > 0 && (s >= 0) != (frame[i-1] >= 0) {
+			crossings++
+		}
+	}
+	n := float64(len(frame))
+	return 10 * math.Log10(energy/n+1), float64(crossings) / n
+}
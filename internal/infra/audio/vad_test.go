@@ -0,0 +1,131 @@
+package audio_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"smart-home/internal/infra/audio"
+)
+
+// readWAV returns the samples of a 16-bit mono WAV fixture.
+func readWAV(t *testing.T, path string) ([]int16, int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sampleRate := 0
+	for chunk := data[12:]; len(chunk) >= 8; {
+		id, size := string(chunk[:4]), int(binary.LittleEndian.Uint32(chunk[4:8]))
+		body := chunk[8:]
+		switch id {
+		case "fmt ":
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+		case "data":
+			samples := make([]int16, size/2)
+			if err := binary.Read(bytes.NewReader(body[:size]), binary.LittleEndian, samples); err != nil {
+				t.Fatal(err)
+			}
+			return samples, sampleRate
+		}
+		chunk = body[size+size%2:]
+	}
+	t.Fatalf("%s has no audio", path)
+	return nil, 0
+}
+
+type segment struct{ start, end time.Duration }
+
+func TestVAD_Fixtures(t *testing.T) {
+	// The speech in each fixture, as described in testdata/audio/vad/README.md
+	tests := []struct {
+		file   string
+		speech []segment
+	}{
+		{"quiet_room.wav", []segment{{800 * time.Millisecond, 1950 * time.Millisecond}}},
+		{"noisy_room.wav", []segment{{1200 * time.Millisecond, 2400 * time.Millisecond}}},
+		{"pause.wav", []segment{{600 * time.Millisecond, 2200 * time.Millisecond}}},
+		{"two_commands.wav", []segment{{400 * time.Millisecond, 1000 * time.Millisecond}, {2200 * time.Millisecond, 2900 * time.Millisecond}}},
+		{"clicks.wav", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			samples, sampleRate := readWAV(t, filepath.Join("../../../testdata/audio/vad", tt.file))
+			cfg := audio.DefaultVADConfig(sampleRate)
+			vad := audio.NewVAD(cfg)
+			at := func(n int) time.Duration { return time.Duration(n) * time.Second / time.Duration(sampleRate) }
+
+			// Fed in the buffers the microphone reads
+			var got []segment
+			var current []int16
+			for fed := 0; fed < len(samples); {
+				n := min(1024, len(samples)-fed)
+				out, state := vad.Write(samples[fed : fed+n])
+				fed += n
+				current = append(current, out...)
+				if state == audio.VADEnded {
+					got = append(got, segment{at(fed - len(current)), at(fed)})
+					current = nil
+				}
+			}
+			if len(current) > 0 {
+				t.Errorf("an utterance was still going on at the end of the file")
+			}
+
+			if len(got) != len(tt.speech) {
+				t.Fatalf("got utterances %v, want %d around %v", got, len(tt.speech), tt.speech)
+			}
+			// An utterance holds all the speech with at most the pre-roll
+			// before, and ends a hangover after it; the buffers blur the
+			// edges a little
+			const slack = 100 * time.Millisecond
+			for i, want := range tt.speech {
+				u := got[i]
+				if u.start > want.start || u.start < want.start-cfg.PreRoll-slack {
+					t.Errorf("utterance %d starts at %v, want shortly before %v", i, u.start, want.start)
+				}
+				if end := want.end + cfg.Hangover; u.end < end-slack || u.end > end+slack {
+					t.Errorf("utterance %d ends at %v, want about %v", i, u.end, end)
+				}
+			}
+		})
+	}
+}
+
+func TestVAD_MaxUtterance(t *testing.T) {
+	cfg := audio.DefaultVADConfig(16000)
+	cfg.MaxUtterance = time.Second
+	vad := audio.NewVAD(cfg)
+
+	// A quiet second to learn the floor, then someone who doesn't stop
+	quiet := make([]int16, 16000)
+	for i := range quiet {
+		quiet[i] = int16(i%7 - 3)
+	}
+	if _, state := vad.Write(quiet); state != audio.VADWaiting {
+		t.Fatalf("silence: state %v", state)
+	}
+
+	loud := make([]int16, 320)
+	for i := range loud {
+		loud[i] = int16(8000 * (i%40/20*2 - 1))
+	}
+	total := 0
+	for range 200 {
+		out, state := vad.Write(loud)
+		total += len(out)
+		if state == audio.VADEnded {
+			if got := time.Duration(total) * time.Second / 16000; got > cfg.MaxUtterance+cfg.PreRoll {
+				t.Errorf("utterance lasted %v, want at most %v", got, cfg.MaxUtterance)
+			}
+			return
+		}
+	}
+	t.Error("the utterance never ended")
+}
@@ -0,0 +1,141 @@
+package audio
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultWakeWordTimeout is how long the detector waits for the command
+// after hearing the wake word on its own.
+const DefaultWakeWordTimeout = 8 * time.Second
+
+// WakeWordDetector picks the commands out of what the microphone hears:
+// only speech following one of the wake words counts. The wake word may
+// come with the command ("casa, prendé la luz") or alone, and then the next
+// utterance within the timeout is the command.
+type WakeWordDetector struct {
+	wakeWords [][]string
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	armed time.Time
+}
+
+// NewWakeWordDetector creates a detector for the given phrases, matched
+// ignoring case, accents and punctuation.
+func NewWakeWordDetector(wakeWords []string, timeout time.Duration) *WakeWordDetector {
+	d := &WakeWordDetector{timeout: timeout}
+	for _, w := range wakeWords {
+		if words := wakeWordTokens(w); len(words) > 0 {
+			d.wakeWords = append(d.wakeWords, words)
+		}
+	}
+	return d
+}
+
+// Detect looks at the transcript of an utterance heard at now. It returns
+// the command, with the wake word and whatever came before it stripped, and
+// whether there is one.
+func (d *WakeWordDetector) Detect(transcript string, now time.Time) (string, bool) {
+	return d.detect(transcript, now, true)
+}
+
+// Peek is Detect without arming or disarming the detector, for partial
+// transcripts of an utterance still going on.
+func (d *WakeWordDetector) Peek(transcript string, now time.Time) (string, bool) {
+	return d.detect(transcript, now, false)
+}
+
+func (d *WakeWordDetector) detect(transcript string, now time.Time, update bool) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if rest, ok := d.strip(transcript); ok {
+		if update {
+			d.armed = time.Time{}
+			if rest == "" {
+				d.armed = now.Add(d.timeout)
+			}
+		}
+		return rest, rest != ""
+	}
+
+	text := strings.TrimSpace(transcript)
+	if text == "" || d.armed.IsZero() || now.After(d.armed) {
+		return "", false
+	}
+	if update {
+		d.armed = time.Time{}
+	}
+	return text, true
+}
+
+// strip finds the first wake word in the transcript and returns what
+// follows it.
+func (d *WakeWordDetector) strip(transcript string) (string, bool) {
+	words := wakeWordSpans(transcript)
+	for i := range words {
+		for _, wake := range d.wakeWords {
+			if i+len(wake) > len(words) {
+				continue
+			}
+			match := true
+			for j, w := range wake {
+				if words[i+j].norm != w {
+					match = false
+					break
+				}
+			}
+			if match {
+				rest := transcript[words[i+len(wake)-1].end:]
+				rest = strings.TrimLeftFunc(rest, func(r rune) bool {
+					return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '¿' && r != '¡'
+				})
+				return strings.TrimSpace(rest), true
+			}
+		}
+	}
+	return "", false
+}
+
+type wordSpan struct {
+	norm string
+	end  int
+}
+
+// wakeWordSpans splits a transcript into normalized words, remembering
+// where each ends so that the command can be cut out of the original text.
+func wakeWordSpans(text string) []wordSpan {
+	var spans []wordSpan
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			spans = append(spans, wordSpan{norm: foldWord(text[start:i]), end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, wordSpan{norm: foldWord(text[start:]), end: len(text)})
+	}
+	return spans
+}
+
+func wakeWordTokens(phrase string) []string {
+	var words []string
+	for _, s := range wakeWordSpans(phrase) {
+		words = append(words, s.norm)
+	}
+	return words
+}
+
+var accents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u")
+
+func foldWord(s string) string {
+	return accents.Replace(strings.ToLower(s))
+}
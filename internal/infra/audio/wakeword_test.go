@@ -0,0 +1,105 @@
+package audio_test
+
+import (
+	"testing"
+	"time"
+
+	"smart-home/internal/infra/audio"
+)
+
+func TestWakeWordDetector(t *testing.T) {
+	start := time.Date(2026, 10, 17, 20, 0, 0, 0, time.UTC)
+
+	type heard struct {
+		after      time.Duration
+		transcript string
+		want       string
+		wantOK     bool
+	}
+	tests := []struct {
+		name    string
+		phrases []string
+		heard   []heard
+	}{
+		{
+			name:    "wake word with the command",
+			phrases: []string{"casa"},
+			heard: []heard{
+				{0, "Casa, prendé la luz del living.", "prendé la luz del living.", true},
+			},
+		},
+		{
+			name:    "speech without the wake word is ignored",
+			phrases: []string{"casa"},
+			heard: []heard{
+				{0, "prendé la luz", "", false},
+				{time.Second, "la casa de mi abuela", "de mi abuela", true},
+			},
+		},
+		{
+			name:    "accents, case and whatever came before",
+			phrases: []string{"Oye Jarvis"},
+			heard: []heard{
+				{0, "Eh... oye, JARVIS: ¿qué temperatura hace?", "¿qué temperatura hace?", true},
+			},
+		},
+		{
+			name:    "several wake words",
+			phrases: []string{"casa", "hey home"},
+			heard: []heard{
+				{0, "Hey home turn off the fan", "turn off the fan", true},
+				{time.Second, "casa apagá todo", "apagá todo", true},
+			},
+		},
+		{
+			name:    "wake word alone, then the command in time",
+			phrases: []string{"casa"},
+			heard: []heard{
+				{0, "¿Casa?", "", false},
+				{3 * time.Second, "Cerrá la persiana.", "Cerrá la persiana.", true},
+				// Only that one command
+				{time.Second, "abrí la puerta", "", false},
+			},
+		},
+		{
+			name:    "wake word alone, then too late",
+			phrases: []string{"casa"},
+			heard: []heard{
+				{0, "casa", "", false},
+				{9 * time.Second, "cerrá la persiana", "", false},
+			},
+		},
+		{
+			name:    "silence doesn't use up the wake word",
+			phrases: []string{"casa"},
+			heard: []heard{
+				{0, "casa", "", false},
+				{2 * time.Second, "", "", false},
+				{2 * time.Second, "prendé la luz", "prendé la luz", true},
+			},
+		},
+		{
+			name:    "the whole phrase is needed",
+			phrases: []string{"oye casa"},
+			heard: []heard{
+				{0, "casa, prendé la luz", "", false},
+				{0, "oye, casa", "", false},
+				{time.Second, "prendé la luz", "prendé la luz", true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := audio.NewWakeWordDetector(tt.phrases, audio.DefaultWakeWordTimeout)
+			now := start
+			for _, h := range tt.heard {
+				now = now.Add(h.after)
+				got, ok := d.Detect(h.transcript, now)
+				if got != h.want || ok != h.wantOK {
+					t.Errorf("Detect(%q) = %q, %v; want %q, %v", h.transcript, got, ok, h.want, h.wantOK)
+				}
+			}
+		})
+	}
+}
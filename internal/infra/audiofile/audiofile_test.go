@@ -0,0 +1,352 @@
+package audiofile_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"smart-home/internal/infra/audiofile"
+)
+
+// wav builds a WAV file around raw sample data.
+func wav(encoding uint16, channels, sampleRate, bits int, data []byte) []byte {
+	le := binary.LittleEndian
+	buf := []byte("RIFF")
+	buf = le.AppendUint32(buf, uint32(36+len(data)))
+	buf = append(buf, "WAVEfmt "...)
+	buf = le.AppendUint32(buf, 16)
+	buf = le.AppendUint16(buf, encoding)
+	buf = le.AppendUint16(buf, uint16(channels))
+	buf = le.AppendUint32(buf, uint32(sampleRate))
+	buf = le.AppendUint32(buf, uint32(sampleRate*channels*bits/8))
+	buf = le.AppendUint16(buf, uint16(channels*bits/8))
+	buf = le.AppendUint16(buf, uint16(bits))
+	buf = append(buf, "data"...)
+	buf = le.AppendUint32(buf, uint32(len(data)))
+	return append(buf, data...)
+}
+
+// tone returns a sine of the given frequency and amplitude (out of 1).
+func tone(freq float64, sampleRate int, d time.Duration, amplitude float64) []float64 {
+	out := make([]float64, int(d)*sampleRate/int(time.Second))
+	for i := range out {
+		out[i] = amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(sampleRate))
+	}
+	return out
+}
+
+func pcm16(samples []float64) []byte {
+	var data []byte
+	for _, s := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(int16(s*32767)))
+	}
+	return data
+}
+
+func rms(samples []int16) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+func TestDetect(t *testing.T) {
+	tests := map[string]audiofile.Format{
+		"RIFF\x24\x00\x00\x00WAVEfmt ":     audiofile.WAV,
+		"ID3\x04\x00\x00\x00\x00\x00\x00":  audiofile.MP3,
+		"\xFF\xFB\x90\x00":                 audiofile.MP3,
+		"\xFF\xF1\x50\x80":                 audiofile.Unknown, // AAC in ADTS
+		"\x00\x00\x00\x20ftypM4A \x00\x00": audiofile.M4A,
+		"\x1A\x45\xDF\xA3\x9F\x42\x86\x81": audiofile.WebM,
+		"OggS\x00\x02":                     audiofile.Ogg,
+		"fLaC\x00\x00\x00\x22":             audiofile.FLAC,
+		"test audio content":               audiofile.Unknown,
+		"":                                 audiofile.Unknown,
+	}
+	for data, want := range tests {
+		if got := audiofile.Detect([]byte(data)); got != want {
+			t.Errorf("Detect(%q) = %s, want %s", data, got, want)
+		}
+	}
+}
+
+func TestFormat_PartHeader(t *testing.T) {
+	h := audiofile.M4A.PartHeader("file")
+	if got := h.Get("Content-Disposition"); got != `form-data; name="file"; filename="audio.m4a"` {
+		t.Errorf("Content-Disposition: got %q", got)
+	}
+	if got := h.Get("Content-Type"); got != "audio/mp4" {
+		t.Errorf("Content-Type: got %q", got)
+	}
+}
+
+func TestDecodeWAV_Encodings(t *testing.T) {
+	// The same half-scale ramp in every encoding
+	values := []float64{-0.5, -0.25, 0, 0.25, 0.5}
+	want := []int16{-16384, -8192, 0, 8192, 16384}
+
+	encode := map[string]func(v float64) []byte{
+		"8-bit": func(v float64) []byte { return []byte{byte(128 + v*128)} },
+		"16-bit": func(v float64) []byte {
+			return binary.LittleEndian.AppendUint16(nil, uint16(int16(v*(1<<15))))
+		},
+		"24-bit": func(v float64) []byte {
+			s := int32(v * (1 << 23))
+			return []byte{byte(s), byte(s >> 8), byte(s >> 16)}
+		},
+		"32-bit": func(v float64) []byte {
+			return binary.LittleEndian.AppendUint32(nil, uint32(int32(v*(1<<31))))
+		},
+		"float": func(v float64) []byte {
+			return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(v)))
+		},
+	}
+	bits := map[string]int{"8-bit": 8, "16-bit": 16, "24-bit": 24, "32-bit": 32, "float": 32}
+
+	for name, enc := range encode {
+		t.Run(name, func(t *testing.T) {
+			var data []byte
+			for _, v := range values {
+				data = append(data, enc(v)...)
+			}
+			encoding := uint16(1)
+			if name == "float" {
+				encoding = 3
+			}
+
+			clip, err := audiofile.DecodeWAV(wav(encoding, 1, 8000, bits[name], data))
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			if clip.SampleRate != 8000 || len(clip.Samples) != len(want) {
+				t.Fatalf("got %d samples at %d Hz", len(clip.Samples), clip.SampleRate)
+			}
+			for i, s := range clip.Samples {
+				if math.Abs(float64(s-want[i])) > 128 {
+					t.Errorf("sample %d: got %d, want %d", i, s, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeWAV_Downmix(t *testing.T) {
+	// Left at +8000, right at -2000
+	var data []byte
+	for i := 0; i < 10; i++ {
+		data = binary.LittleEndian.AppendUint16(data, uint16(8000))
+		data = binary.LittleEndian.AppendUint16(data, uint16(0x10000-2000))
+	}
+
+	clip, err := audiofile.DecodeWAV(wav(1, 2, 44100, 16, data))
+	if err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if len(clip.Samples) != 10 || clip.Samples[0] != 3000 {
+		t.Errorf("got %d samples, first %d; want 10 of 3000", len(clip.Samples), clip.Samples[0])
+	}
+}
+
+func TestDecodeWAV_Errors(t *testing.T) {
+	tests := map[string]struct {
+		data        []byte
+		unsupported bool
+	}{
+		"not a WAV":    {[]byte("ID3\x04 not a wav"), true},
+		"ADPCM":        {wav(2, 1, 8000, 4, make([]byte, 10)), true},
+		"12-bit":       {wav(1, 1, 8000, 12, make([]byte, 10)), true},
+		"no audio":     {wav(1, 1, 8000, 16, nil)[:36], false},
+		"bogus header": {[]byte("RIFF....WAVEfmt audio data 1"), false},
+	}
+	for name, tc := range tests {
+		_, err := audiofile.DecodeWAV(tc.data)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		if errors.Is(err, audiofile.ErrUnsupported) != tc.unsupported {
+			t.Errorf("%s: got %v, ErrUnsupported expected: %v", name, err, tc.unsupported)
+		}
+	}
+}
+
+func TestResample(t *testing.T) {
+	// A 440 Hz tone survives 48 kHz to 16 kHz; a 12 kHz one, which 16 kHz
+	// can't represent, must be filtered out instead of folding back to 4 kHz
+	for _, tc := range []struct {
+		freq    float64
+		wantRMS float64
+	}{
+		{440, 0.5 / math.Sqrt2 * 32767},
+		{12000, 0},
+	} {
+		in := make([]int16, 0, 48000)
+		for _, s := range tone(tc.freq, 48000, time.Second, 0.5) {
+			in = append(in, int16(s*32767))
+		}
+
+		out := audiofile.Resample(in, 48000, 16000)
+		if len(out) != 16000 {
+			t.Fatalf("%v Hz: got %d samples, want 16000", tc.freq, len(out))
+		}
+		// Leave out the edges, where the filter runs out of input
+		if got := rms(out[100 : len(out)-100]); math.Abs(got-tc.wantRMS) > 0.02*32767 {
+			t.Errorf("%v Hz: RMS %.0f, want %.0f", tc.freq, got, tc.wantRMS)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	stereo48k := make([]float64, 0)
+	for _, s := range tone(440, 48000, 2*time.Second, 0.3) {
+		stereo48k = append(stereo48k, s, s)
+	}
+
+	data, format, err := audiofile.Normalize(wav(1, 2, 48000, 16, pcm16(stereo48k)), audiofile.DefaultMaxDuration, audiofile.Compressed)
+	if err != nil {
+		t.Fatalf("normalizing: %v", err)
+	}
+	if format != audiofile.WAV {
+		t.Errorf("format: got %s", format)
+	}
+	clip, err := audiofile.DecodeWAV(data)
+	if err != nil {
+		t.Fatalf("decoding the result: %v", err)
+	}
+	if clip.SampleRate != 16000 || clip.Duration() != 2*time.Second {
+		t.Errorf("got %s at %d Hz, want 2s at 16000 Hz", clip.Duration(), clip.SampleRate)
+	}
+	if channels := binary.LittleEndian.Uint16(data[22:24]); channels != 1 {
+		t.Errorf("channels: got %d", channels)
+	}
+}
+
+func TestNormalize_PassesCompressedFormats(t *testing.T) {
+	mp3 := []byte("ID3\x04\x00\x00\x00\x00\x00\x00 frames")
+	data, format, err := audiofile.Normalize(mp3, audiofile.DefaultMaxDuration, audiofile.Compressed)
+	if err != nil || format != audiofile.MP3 || string(data) != string(mp3) {
+		t.Errorf("got %q, %s, %v; want the MP3 unchanged", data, format, err)
+	}
+	if _, _, err := audiofile.Normalize(mp3, audiofile.DefaultMaxDuration, nil); !errors.Is(err, audiofile.ErrUnsupported) {
+		t.Errorf("MP3 for a WAV-only backend: got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestNormalize_Rejects(t *testing.T) {
+	long := wav(1, 1, 16000, 16, make([]byte, 16000*2*31))
+	if _, _, err := audiofile.Normalize(long, 30*time.Second, nil); !errors.Is(err, audiofile.ErrTooLong) {
+		t.Errorf("31s clip: got %v, want ErrTooLong", err)
+	}
+	if _, _, err := audiofile.Normalize(long, 0, nil); err != nil {
+		t.Errorf("31s clip without a limit: %v", err)
+	}
+	if _, _, err := audiofile.Normalize([]byte("test audio content"), 0, audiofile.Compressed); !errors.Is(err, audiofile.ErrUnsupported) {
+		t.Errorf("text: got %v, want ErrUnsupported", err)
+	}
+
+	// 31s of MP3 at 128 kbps, and a WebM without a duration that would take
+	// longer than 30s even at 320 kbps
+	mp3 := append([]byte{0xFF, 0xFB, 0x90, 0x00}, make([]byte, 16000*31)...)
+	if _, _, err := audiofile.Normalize(mp3, 30*time.Second, audiofile.Compressed); !errors.Is(err, audiofile.ErrTooLong) {
+		t.Errorf("31s MP3: got %v, want ErrTooLong", err)
+	}
+	webm := append(webmHeader(), make([]byte, 40000*31)...)
+	if _, _, err := audiofile.Normalize(webm, 30*time.Second, audiofile.Compressed); !errors.Is(err, audiofile.ErrTooLong) {
+		t.Errorf("1.2 MB WebM: got %v, want ErrTooLong", err)
+	}
+	if _, _, err := audiofile.Normalize(webm[:40000*5], 30*time.Second, audiofile.Compressed); err != nil {
+		t.Errorf("200 KB WebM: %v", err)
+	}
+}
+
+// webmHeader is an EBML header and the start of a segment of unknown size,
+// as browsers record them.
+func webmHeader() []byte {
+	return []byte{
+		0x1A, 0x45, 0xDF, 0xA3, 0x84, 0x42, 0x82, 0x81, 0x77, // EBML, DocType "w"
+		0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // Segment
+	}
+}
+
+func TestDuration(t *testing.T) {
+	be := binary.BigEndian
+	le := binary.LittleEndian
+
+	// MPEG-1 layer III, 128 kbps, 44.1 kHz, stereo
+	mp3Frame := []byte{0xFF, 0xFB, 0x90, 0x00}
+	cbr := append(append([]byte("ID3\x04\x00\x00\x00\x00\x00\x0A"), make([]byte, 10)...), mp3Frame...)
+	cbr = append(cbr, make([]byte, 32000-4)...)
+
+	xing := append([]byte{}, mp3Frame...)
+	xing = append(xing, make([]byte, 32)...)
+	xing = append(xing, "Xing"...)
+	xing = be.AppendUint32(xing, 1)
+	xing = be.AppendUint32(xing, 115) // 115 frames of 1152 samples
+	xing = append(xing, make([]byte, 400)...)
+
+	mvhd := []byte{0, 0, 0, 0} // version and flags
+	mvhd = append(mvhd, make([]byte, 8)...)
+	mvhd = be.AppendUint32(mvhd, 1000) // timescale
+	mvhd = be.AppendUint32(mvhd, 4500) // duration
+	mvhd = append(mvhd, make([]byte, 80)...)
+	m4a := be.AppendUint32(nil, 16)
+	m4a = append(m4a, "ftypM4A \x00\x00\x00\x00"...)
+	m4a = be.AppendUint32(m4a, uint32(16+len(mvhd)))
+	m4a = append(m4a, "moov"...)
+	m4a = be.AppendUint32(m4a, uint32(8+len(mvhd)))
+	m4a = append(m4a, "mvhd"...)
+	m4a = append(m4a, mvhd...)
+
+	webm := webmHeader()
+	webm = append(webm, 0x15, 0x49, 0xA9, 0x66, 0x8E)             // Info of 14 bytes
+	webm = append(webm, 0x2A, 0xD7, 0xB1, 0x83, 0x0F, 0x42, 0x40) // TimecodeScale 1ms
+	webm = append(webm, 0x44, 0x89, 0x84)                         // Duration, float32
+	webm = be.AppendUint32(webm, math.Float32bits(2500))
+
+	oggPage := func(granule uint64, packet string) []byte {
+		page := append([]byte("OggS\x00\x02"), le.AppendUint64(nil, granule)...)
+		page = append(page, make([]byte, 12)...)
+		page = append(page, 1, byte(len(packet)))
+		return append(page, packet...)
+	}
+	opusHead := "OpusHead\x01\x01" + string(le.AppendUint16(nil, 312)) + "\x80\xBB\x00\x00\x00\x00\x00"
+	ogg := append(oggPage(0, opusHead), oggPage(48000*3+312, "audio")...)
+
+	// 16 kHz, mono, 16-bit, 24000 samples
+	flac := []byte("fLaC\x00\x00\x00\x22")
+	flac = append(flac, make([]byte, 10)...)
+	flac = append(flac, 0x03, 0xE8, 0x00, 0xF0)
+	flac = be.AppendUint32(flac, 24000)
+	flac = append(flac, make([]byte, 16)...)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format audiofile.Format
+		want   time.Duration
+	}{
+		{"MP3 at constant bitrate", cbr, audiofile.MP3, 2 * time.Second},
+		{"MP3 with a Xing header", xing, audiofile.MP3, 3004081632},
+		{"M4A", m4a, audiofile.M4A, 4500 * time.Millisecond},
+		{"WebM", webm, audiofile.WebM, 2500 * time.Millisecond},
+		{"Ogg Opus", ogg, audiofile.Ogg, 3 * time.Second},
+		{"FLAC", flac, audiofile.FLAC, 1500 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		if got := audiofile.Detect(tc.data); got != tc.format {
+			t.Errorf("%s: detected as %s", tc.name, got)
+			continue
+		}
+		d, ok := audiofile.Duration(tc.data, tc.format)
+		if !ok || (d-tc.want).Abs() > time.Millisecond {
+			t.Errorf("%s: got %s, %v; want %s", tc.name, d, ok, tc.want)
+		}
+	}
+
+	if _, ok := audiofile.Duration(webmHeader(), audiofile.WebM); ok {
+		t.Error("WebM without a duration: expected none")
+	}
+}
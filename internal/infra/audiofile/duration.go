@@ -0,0 +1,314 @@
+package audiofile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// maxBitrate is the highest bitrate, in bits per second, expected from a
+// compressed clip. When the container doesn't tell its duration, the size
+// at this bitrate gives the shortest it can be.
+const maxBitrate = 320_000
+
+// Duration reads how long a compressed clip is from its headers. ok is false
+// when they don't say, as in WebM recorded live by a browser.
+func Duration(data []byte, format Format) (d time.Duration, ok bool) {
+	switch format {
+	case MP3:
+		return mp3Duration(data)
+	case M4A:
+		return mp4Duration(data)
+	case WebM:
+		return webmDuration(data)
+	case Ogg:
+		return oggDuration(data)
+	case FLAC:
+		return flacDuration(data)
+	default:
+		return 0, false
+	}
+}
+
+// minDuration is the shortest a compressed clip of this size can be.
+func minDuration(data []byte) time.Duration {
+	return time.Duration(len(data)) * 8 * time.Second / maxBitrate
+}
+
+func seconds(n, rate uint64) time.Duration {
+	if rate == 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / float64(rate) * float64(time.Second))
+}
+
+var (
+	// Bitrates in kbps by version (MPEG-1 or 2/2.5) and layer (1 to 3)
+	mp3Bitrates = [2][3][15]uint64{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	// Sample rates by version: MPEG-2.5, reserved, MPEG-2, MPEG-1
+	mp3Rates = [4][3]uint64{
+		{11025, 12000, 8000},
+		{},
+		{22050, 24000, 16000},
+		{44100, 48000, 32000},
+	}
+)
+
+// mp3Duration counts the frames of the Xing (VBR) header when there is one,
+// and otherwise takes the bitrate of the first frame as constant.
+func mp3Duration(data []byte) (time.Duration, bool) {
+	start := 0
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9])
+		start = 10 + size
+		if data[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	for ; start+4 <= len(data); start++ {
+		if data[start] == 0xFF && data[start+1]&0xE0 == 0xE0 {
+			break
+		}
+	}
+	if start+4 > len(data) {
+		return 0, false
+	}
+
+	h := data[start : start+4]
+	version, layer := h[1]>>3&3, 4-int(h[1]>>1&3)
+	bitrateIndex, rateIndex := h[2]>>4, h[2]>>2&3
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return 0, false
+	}
+	mpeg1 := version == 3
+	table := 1
+	if mpeg1 {
+		table = 0
+	}
+	bitrate := mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+	rate := mp3Rates[version][rateIndex]
+
+	samplesPerFrame := uint64(1152)
+	switch {
+	case layer == 1:
+		samplesPerFrame = 384
+	case layer == 3 && !mpeg1:
+		samplesPerFrame = 576
+	}
+
+	if layer == 3 {
+		mono := h[3]>>6 == 3
+		side := 32
+		switch {
+		case mpeg1 && mono, !mpeg1 && !mono:
+			side = 17
+		case !mpeg1 && mono:
+			side = 9
+		}
+		if x := start + 4 + side; x+12 <= len(data) {
+			tag := string(data[x : x+4])
+			flags := binary.BigEndian.Uint32(data[x+4 : x+8])
+			if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+				frames := uint64(binary.BigEndian.Uint32(data[x+8 : x+12]))
+				return seconds(frames*samplesPerFrame, rate), true
+			}
+		}
+	}
+
+	return seconds(uint64(len(data)-start)*8, bitrate), true
+}
+
+// mp4Duration reads the movie header box (moov/mvhd).
+func mp4Duration(data []byte) (time.Duration, bool) {
+	moov, ok := mp4Box(data, "moov")
+	if !ok {
+		return 0, false
+	}
+	mvhd, ok := mp4Box(moov, "mvhd")
+	if !ok || len(mvhd) < 20 {
+		return 0, false
+	}
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, false
+		}
+		return seconds(binary.BigEndian.Uint64(mvhd[24:32]), uint64(binary.BigEndian.Uint32(mvhd[20:24]))), true
+	}
+	return seconds(uint64(binary.BigEndian.Uint32(mvhd[16:20])), uint64(binary.BigEndian.Uint32(mvhd[12:16]))), true
+}
+
+// mp4Box returns the body of the first box of the given type.
+func mp4Box(data []byte, boxType string) ([]byte, bool) {
+	for len(data) >= 8 {
+		size, header := uint64(binary.BigEndian.Uint32(data[:4])), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, false
+			}
+			size, header = binary.BigEndian.Uint64(data[8:16]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, false
+		}
+		if string(data[4:8]) == boxType {
+			return data[header:size], true
+		}
+		data = data[size:]
+	}
+	return nil, false
+}
+
+// EBML ids of the WebM elements on the way to the duration.
+const (
+	ebmlSegment   = 0x18538067
+	ebmlInfo      = 0x1549A966
+	ebmlTimescale = 0x2AD7B1
+	ebmlDuration  = 0x4489
+)
+
+// webmDuration reads Segment/Info/Duration, in units of its TimecodeScale.
+func webmDuration(data []byte) (time.Duration, bool) {
+	for len(data) > 0 {
+		id, size, body, rest, ok := ebmlElement(data)
+		if !ok {
+			return 0, false
+		}
+		switch id {
+		case ebmlSegment:
+			data = body
+			continue
+		case ebmlInfo:
+			scale, duration := uint64(1_000_000), -1.0
+			for len(body) > 0 {
+				id, size, value, next, ok := ebmlElement(body)
+				if !ok || size < 0 {
+					break
+				}
+				switch {
+				case id == ebmlTimescale:
+					scale = 0
+					for _, b := range value {
+						scale = scale<<8 | uint64(b)
+					}
+				case id == ebmlDuration && size == 4:
+					duration = float64(math.Float32frombits(binary.BigEndian.Uint32(value)))
+				case id == ebmlDuration && size == 8:
+					duration = math.Float64frombits(binary.BigEndian.Uint64(value))
+				}
+				body = next
+			}
+			if duration < 0 {
+				return 0, false
+			}
+			return time.Duration(duration * float64(scale)), true
+		}
+		if size < 0 {
+			// An element of unknown size other than the segment: can't skip it
+			return 0, false
+		}
+		data = rest
+	}
+	return 0, false
+}
+
+// ebmlElement splits the first element off data. size is -1 for elements
+// of unknown size, whose body runs to the end of data.
+func ebmlElement(data []byte) (id uint64, size int, body, rest []byte, ok bool) {
+	id, n := ebmlVarint(data, true)
+	if n == 0 {
+		return 0, 0, nil, nil, false
+	}
+	s, m := ebmlVarint(data[n:], false)
+	if m == 0 {
+		return 0, 0, nil, nil, false
+	}
+	data = data[n+m:]
+	if s == 1<<(7*m)-1 {
+		return id, -1, data, nil, true
+	}
+	if s > uint64(len(data)) {
+		s = uint64(len(data))
+	}
+	return id, int(s), data[:s], data[s:], true
+}
+
+// ebmlVarint reads a variable-length integer; ids keep their length marker.
+func ebmlVarint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	n := 1
+	for data[0]&(0x80>>(n-1)) == 0 {
+		n++
+	}
+	if n > 8 || n > len(data) {
+		return 0, 0
+	}
+	v := uint64(data[0])
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	for _, b := range data[1:n] {
+		v = v<<8 | uint64(b)
+	}
+	return v, n
+}
+
+// oggDuration takes the granule position of the last page, in samples, at
+// the rate of the Opus or Vorbis stream the first page starts.
+func oggDuration(data []byte) (time.Duration, bool) {
+	if len(data) < 27 {
+		return 0, false
+	}
+	packet := data[27+int(data[26]):]
+
+	var rate, preSkip uint64
+	switch {
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		// Opus always counts at 48 kHz
+		rate, preSkip = 48000, uint64(binary.LittleEndian.Uint16(packet[10:12]))
+	case len(packet) >= 16 && string(packet[:7]) == "\x01vorbis":
+		rate = uint64(binary.LittleEndian.Uint32(packet[12:16]))
+	default:
+		return 0, false
+	}
+
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if last < 0 || last+14 > len(data) {
+		return 0, false
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6 : last+14])
+	if granule < preSkip {
+		return 0, false
+	}
+	return seconds(granule-preSkip, rate), true
+}
+
+// flacDuration reads the sample count of the STREAMINFO block.
+func flacDuration(data []byte) (time.Duration, bool) {
+	if len(data) < 8+18 || data[4]&0x7F != 0 {
+		return 0, false
+	}
+	info := data[8:]
+	rate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	total := uint64(info[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	if rate == 0 || total == 0 {
+		return 0, false
+	}
+	return seconds(total, rate), true
+}
@@ -0,0 +1,91 @@
+// Package audiofile reads the audio files that reach the assistant: it
+// recognises their container and turns WAV files, whatever their rate,
+// channels or sample size, into the 16 kHz mono 16-bit PCM that the
+// speech-to-text backends work best with.
+package audiofile
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+)
+
+// Format is the container of an audio file.
+type Format string
+
+const (
+	Unknown Format = ""
+	WAV     Format = "wav"
+	MP3     Format = "mp3"
+	M4A     Format = "m4a"
+	WebM    Format = "webm"
+	Ogg     Format = "ogg"
+	FLAC    Format = "flac"
+)
+
+// Detect recognises the container from the first bytes of the file.
+func Detect(data []byte) Format {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return WAV
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		return M4A
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return WebM
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return Ogg
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return FLAC
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return MP3
+	// An MPEG frame sync; layer bits 00 would be AAC in ADTS instead
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 != 0:
+		return MP3
+	default:
+		return Unknown
+	}
+}
+
+// Filename is a name for uploads of the format: some APIs, like OpenAI's,
+// go by the extension.
+func (f Format) Filename() string {
+	if f == Unknown {
+		return "audio"
+	}
+	return "audio." + string(f)
+}
+
+func (f Format) MIMEType() string {
+	switch f {
+	case WAV:
+		return "audio/wav"
+	case MP3:
+		return "audio/mpeg"
+	case M4A:
+		return "audio/mp4"
+	case WebM:
+		return "audio/webm"
+	case Ogg:
+		return "audio/ogg"
+	case FLAC:
+		return "audio/flac"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// PartHeader is the header of a multipart form field carrying a file of
+// the format, for multipart.Writer.CreatePart.
+func (f Format) PartHeader(field string) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, f.Filename()))
+	h.Set("Content-Type", f.MIMEType())
+	return h
+}
+
+func (f Format) String() string {
+	if f == Unknown {
+		return "unknown"
+	}
+	return string(f)
+}
@@ -0,0 +1,109 @@
+package audiofile
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"smart-home/internal/application"
+)
+
+// DefaultMaxDuration is the longest clip accepted by default: far longer
+// than any command, short enough not to send a whole recording to STT.
+const DefaultMaxDuration = 30 * time.Second
+
+var (
+	ErrUnsupported = errors.New("unsupported audio format")
+	ErrTooLong     = errors.New("audio clip too long")
+)
+
+// Compressed lists the formats this package can't decode, which speech-to-
+// text backends like OpenAI's Whisper read themselves.
+var Compressed = []Format{MP3, M4A, WebM, Ogg, FLAC}
+
+// Normalize prepares a clip for speech-to-text. WAV files are converted to
+// application.DefaultAudioFormat(); the compressed formats listed in
+// passthrough are returned as they are, and anything else is rejected with
+// ErrUnsupported. Clips longer than maxDuration are rejected with ErrTooLong;
+// zero means no limit. A compressed clip whose headers don't tell its
+// duration is only rejected when its size couldn't fit in maxDuration even
+// at 320 kbps.
+func Normalize(data []byte, maxDuration time.Duration, passthrough []Format) ([]byte, Format, error) {
+	format := Detect(data)
+	switch {
+	case format == WAV:
+	case format == Unknown:
+		return nil, Unknown, fmt.Errorf("%w: use WAV, MP3, M4A, WebM, Ogg or FLAC", ErrUnsupported)
+	case !slices.Contains(passthrough, format):
+		return nil, format, fmt.Errorf("%w: the speech-to-text backend can't read %s, use WAV", ErrUnsupported, format)
+	default:
+		if maxDuration > 0 {
+			d, ok := Duration(data, format)
+			if !ok {
+				d = minDuration(data)
+			}
+			if d > maxDuration {
+				return nil, format, fmt.Errorf("%w: %s, the limit is %s", ErrTooLong, d.Round(100*time.Millisecond), maxDuration)
+			}
+		}
+		return data, format, nil
+	}
+
+	clip, err := DecodeWAV(data)
+	if err != nil {
+		return nil, WAV, err
+	}
+	if maxDuration > 0 && clip.Duration() > maxDuration {
+		return nil, WAV, fmt.Errorf("%w: %s, the limit is %s", ErrTooLong, clip.Duration().Round(100*time.Millisecond), maxDuration)
+	}
+
+	want := application.DefaultAudioFormat()
+	return EncodeWAV(Resample(clip.Samples, clip.SampleRate, want.SampleRate), want.SampleRate), WAV, nil
+}
+
+// resampleZeros is how many zero crossings of the sinc the resampling
+// filter spans on each side.
+const resampleZeros = 8
+
+// Resample converts samples from one rate to another by band-limited
+// interpolation, filtering out what the new rate can't represent.
+func Resample(samples []int16, from, to int) []int16 {
+	if from == to || from <= 0 || to <= 0 || len(samples) == 0 {
+		return samples
+	}
+
+	ratio := float64(from) / float64(to)
+	// Cut at the lower of the two Nyquist frequencies
+	cutoff := min(1, 1/ratio)
+	half := int(math.Ceil(resampleZeros / cutoff))
+
+	out := make([]int16, len(samples)*to/from)
+	for i := range out {
+		t := float64(i) * ratio
+		center := int(t)
+		var sum float64
+		for k := max(center-half+1, 0); k <= min(center+half, len(samples)-1); k++ {
+			x := (t - float64(k)) * cutoff
+			sum += float64(samples[k]) * cutoff * sinc(x) * blackman(x/resampleZeros)
+		}
+		out[i] = int16(max(min(math.Round(sum), math.MaxInt16), math.MinInt16))
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman is the Blackman window over [-1, 1].
+func blackman(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*x) + 0.08*math.Cos(2*math.Pi*x)
+}
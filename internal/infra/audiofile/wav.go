@@ -0,0 +1,169 @@
+package audiofile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// WAV encodings this package decodes.
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xFFFE
+)
+
+// Clip is decoded audio: mono 16-bit samples at SampleRate.
+type Clip struct {
+	SampleRate int
+	Samples    []int16
+}
+
+func (c *Clip) Duration() time.Duration {
+	if c.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(len(c.Samples)) * time.Second / time.Duration(c.SampleRate)
+}
+
+type wavFormat struct {
+	encoding   uint16
+	channels   int
+	sampleRate int
+	bits       int
+}
+
+// DecodeWAV reads a WAV file of integer (8 to 32-bit) or float PCM, mixing
+// its channels down to mono.
+func DecodeWAV(data []byte) (*Clip, error) {
+	if Detect(data) != WAV {
+		return nil, fmt.Errorf("%w: not a WAV file", ErrUnsupported)
+	}
+
+	var format *wavFormat
+	for chunk := data[12:]; len(chunk) >= 8; {
+		id, size := string(chunk[:4]), int(binary.LittleEndian.Uint32(chunk[4:8]))
+		body := chunk[8:]
+		// Files written while recording may leave the size at 0 or 0xFFFFFFFF
+		if size > len(body) || (id == "data" && size == 0) {
+			size = len(body)
+		}
+
+		switch id {
+		case "fmt ":
+			f, err := parseWAVFormat(body[:size])
+			if err != nil {
+				return nil, err
+			}
+			format = f
+		case "data":
+			if format == nil {
+				return nil, errors.New("WAV data before its format")
+			}
+			return &Clip{SampleRate: format.sampleRate, Samples: format.decode(body[:size])}, nil
+		}
+
+		// Chunks are padded to an even size
+		chunk = body[min(size+size%2, len(body)):]
+	}
+	return nil, errors.New("WAV file without audio data")
+}
+
+func parseWAVFormat(body []byte) (*wavFormat, error) {
+	if len(body) < 16 {
+		return nil, errors.New("invalid WAV format chunk")
+	}
+	f := &wavFormat{
+		encoding:   binary.LittleEndian.Uint16(body[0:2]),
+		channels:   int(binary.LittleEndian.Uint16(body[2:4])),
+		sampleRate: int(binary.LittleEndian.Uint32(body[4:8])),
+		bits:       int(binary.LittleEndian.Uint16(body[14:16])),
+	}
+	// The real encoding of an extensible format is in the first bytes of
+	// its sub-format GUID
+	if f.encoding == wavExtensible && len(body) >= 26 {
+		f.encoding = binary.LittleEndian.Uint16(body[24:26])
+	}
+
+	switch {
+	case f.channels == 0 || f.sampleRate == 0:
+		return nil, errors.New("invalid WAV format chunk")
+	case f.encoding == wavPCM && (f.bits == 8 || f.bits == 16 || f.bits == 24 || f.bits == 32):
+	case f.encoding == wavFloat && (f.bits == 32 || f.bits == 64):
+	default:
+		return nil, fmt.Errorf("%w: WAV encoding %d with %d bits", ErrUnsupported, f.encoding, f.bits)
+	}
+	return f, nil
+}
+
+// decode mixes the frames of data down to mono. A truncated last frame is
+// dropped.
+func (f *wavFormat) decode(data []byte) []int16 {
+	width := f.bits / 8
+	frames := len(data) / (width * f.channels)
+	samples := make([]int16, frames)
+	for i := range samples {
+		var sum float64
+		for c := 0; c < f.channels; c++ {
+			off := (i*f.channels + c) * width
+			sum += f.sample(data[off : off+width])
+		}
+		samples[i] = toInt16(sum / float64(f.channels))
+	}
+	return samples
+}
+
+// sample returns one sample in [-1, 1].
+func (f *wavFormat) sample(b []byte) float64 {
+	if f.encoding == wavFloat {
+		if f.bits == 64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	switch f.bits {
+	case 8:
+		// 8-bit WAV is unsigned
+		return (float64(b[0]) - 128) / 128
+	case 16:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	case 24:
+		v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+		return float64(v) / (1 << 23)
+	default:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	}
+}
+
+func toInt16(v float64) int16 {
+	v = math.Round(v * (1 << 15))
+	return int16(max(min(v, math.MaxInt16), math.MinInt16))
+}
+
+// EncodeWAV writes mono 16-bit samples as a WAV file.
+func EncodeWAV(samples []int16, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 0, 44+dataSize)
+
+	buf = append(buf, "RIFF"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(36+dataSize))
+	buf = append(buf, "WAVE"...)
+
+	buf = append(buf, "fmt "...)
+	buf = binary.LittleEndian.AppendUint32(buf, 16)
+	buf = binary.LittleEndian.AppendUint16(buf, wavPCM)
+	buf = binary.LittleEndian.AppendUint16(buf, 1)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sampleRate))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(sampleRate*2))
+	buf = binary.LittleEndian.AppendUint16(buf, 2)
+	buf = binary.LittleEndian.AppendUint16(buf, 16)
+
+	buf = append(buf, "data"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(dataSize))
+	for _, s := range samples {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(s))
+	}
+	return buf
+}
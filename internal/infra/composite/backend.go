@@ -0,0 +1,159 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// Backend is one smart home integration (Home Assistant, Tuya, ...) that
+// owns the devices and scenes listed by its registry.
+type Backend struct {
+	Name         string
+	Controller   application.DeviceController
+	Registry     application.DeviceRegistry
+	SyncInterval time.Duration
+}
+
+// Controller routes every command to the backend that owns its target.
+type Controller struct {
+	backends []Backend
+}
+
+func NewController(backends ...Backend) *Controller {
+	return &Controller{backends: backends}
+}
+
+func (c *Controller) ExecuteCommand(ctx context.Context, cmd *domain.Command) error {
+	b, err := c.deviceOwner(cmd.TargetID)
+	if err != nil {
+		return err
+	}
+	return b.Controller.ExecuteCommand(ctx, cmd)
+}
+
+func (c *Controller) TriggerScene(ctx context.Context, sceneID string) error {
+	for _, b := range c.backends {
+		for _, s := range b.Registry.GetScenes() {
+			if s.ID == sceneID {
+				return b.Controller.TriggerScene(ctx, sceneID)
+			}
+		}
+	}
+	return fmt.Errorf("no backend owns scene %s", sceneID)
+}
+
+func (c *Controller) GetState(ctx context.Context, deviceID string) (*domain.DeviceState, error) {
+	b, err := c.deviceOwner(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return b.Controller.GetState(ctx, deviceID)
+}
+
+func (c *Controller) deviceOwner(deviceID string) (*Backend, error) {
+	for i, b := range c.backends {
+		for _, d := range b.Registry.GetDevices() {
+			if d.ID == deviceID {
+				return &c.backends[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no backend owns device %s", deviceID)
+}
+
+// Registry merges the devices and scenes of several backends.
+type Registry struct {
+	backends []Backend
+	logger   *slog.Logger
+}
+
+func NewRegistry(logger *slog.Logger, backends ...Backend) *Registry {
+	return &Registry{backends: backends, logger: logger}
+}
+
+// Sync refreshes every backend. A backend that fails is logged and skipped
+// so one integration being down doesn't take the others with it; Sync only
+// fails when none of them could be synced.
+func (r *Registry) Sync(ctx context.Context) error {
+	var errs []error
+	for _, b := range r.backends {
+		if err := b.Registry.Sync(ctx); err != nil {
+			r.logger.Error("backend sync failed", "backend", b.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+
+	if len(errs) == len(r.backends) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+func (r *Registry) GetDevices() []domain.Device {
+	var devices []domain.Device
+	for _, b := range r.backends {
+		devices = append(devices, b.Registry.GetDevices()...)
+	}
+	return devices
+}
+
+func (r *Registry) GetScenes() []domain.Scene {
+	var scenes []domain.Scene
+	for _, b := range r.backends {
+		scenes = append(scenes, b.Registry.GetScenes()...)
+	}
+	return scenes
+}
+
+// FindDeviceByName prefers an exact name match on any backend over the
+// partial matches each backend falls back to.
+func (r *Registry) FindDeviceByName(name string) (*domain.Device, bool) {
+	key := strings.TrimSpace(name)
+	for _, d := range r.GetDevices() {
+		if strings.EqualFold(d.Name, key) {
+			return &d, true
+		}
+	}
+
+	for _, b := range r.backends {
+		if d, ok := b.Registry.FindDeviceByName(name); ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) FindSceneByName(name string) (*domain.Scene, bool) {
+	key := strings.TrimSpace(name)
+	for _, s := range r.GetScenes() {
+		if strings.EqualFold(s.Name, key) {
+			return &s, true
+		}
+	}
+
+	for _, b := range r.backends {
+		if s, ok := b.Registry.FindSceneByName(name); ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// StartPeriodicSync starts each backend's own periodic sync. Backends
+// without a SyncInterval use the given interval.
+func (r *Registry) StartPeriodicSync(ctx context.Context, interval time.Duration) {
+	for _, b := range r.backends {
+		backendInterval := b.SyncInterval
+		if backendInterval <= 0 {
+			backendInterval = interval
+		}
+		b.Registry.StartPeriodicSync(ctx, backendInterval)
+	}
+}
@@ -0,0 +1,150 @@
+package composite_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/composite"
+)
+
+type fakeController struct {
+	executed []string
+	scenes   []string
+}
+
+func (f *fakeController) ExecuteCommand(_ context.Context, cmd *domain.Command) error {
+	f.executed = append(f.executed, cmd.TargetID)
+	return nil
+}
+
+func (f *fakeController) TriggerScene(_ context.Context, sceneID string) error {
+	f.scenes = append(f.scenes, sceneID)
+	return nil
+}
+
+func (f *fakeController) GetState(_ context.Context, _ string) (*domain.DeviceState, error) {
+	return &domain.DeviceState{Status: "on"}, nil
+}
+
+type fakeRegistry struct {
+	devices []domain.Device
+	scenes  []domain.Scene
+	syncErr error
+}
+
+func (f *fakeRegistry) Sync(_ context.Context) error { return f.syncErr }
+func (f *fakeRegistry) GetDevices() []domain.Device  { return f.devices }
+func (f *fakeRegistry) GetScenes() []domain.Scene    { return f.scenes }
+
+func (f *fakeRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
+	for i, d := range f.devices {
+		if d.Name == name {
+			return &f.devices[i], true
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeRegistry) FindSceneByName(name string) (*domain.Scene, bool) {
+	for i, s := range f.scenes {
+		if s.Name == name {
+			return &f.scenes[i], true
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeRegistry) StartPeriodicSync(_ context.Context, _ time.Duration) {}
+
+func newBackends() (composite.Backend, composite.Backend, *fakeController, *fakeController) {
+	haController := &fakeController{}
+	tuyaController := &fakeController{}
+
+	ha := composite.Backend{
+		Name:       "Home Assistant",
+		Controller: haController,
+		Registry: &fakeRegistry{
+			devices: []domain.Device{{ID: "light.living", Name: "Luz Living"}},
+			scenes:  []domain.Scene{{ID: "scene.movie", Name: "Película"}},
+		},
+	}
+	ty := composite.Backend{
+		Name:       "Tuya",
+		Controller: tuyaController,
+		Registry: &fakeRegistry{
+			devices: []domain.Device{{ID: "bf123", Name: "Enchufe Cocina"}},
+			scenes:  []domain.Scene{{ID: "sc456", Name: "Buenas Noches"}},
+		},
+	}
+	return ha, ty, haController, tuyaController
+}
+
+func TestController_RoutesToOwner(t *testing.T) {
+	ha, ty, haController, tuyaController := newBackends()
+	controller := composite.NewController(ha, ty)
+	ctx := context.Background()
+
+	if err := controller.ExecuteCommand(ctx, &domain.Command{Action: domain.ActionTurnOn, TargetID: "bf123"}); err != nil {
+		t.Fatalf("ExecuteCommand error: %v", err)
+	}
+	if err := controller.ExecuteCommand(ctx, &domain.Command{Action: domain.ActionTurnOn, TargetID: "light.living"}); err != nil {
+		t.Fatalf("ExecuteCommand error: %v", err)
+	}
+	if err := controller.TriggerScene(ctx, "sc456"); err != nil {
+		t.Fatalf("TriggerScene error: %v", err)
+	}
+
+	if len(tuyaController.executed) != 1 || tuyaController.executed[0] != "bf123" {
+		t.Errorf("tuya executed: got %v, want [bf123]", tuyaController.executed)
+	}
+	if len(haController.executed) != 1 || haController.executed[0] != "light.living" {
+		t.Errorf("home assistant executed: got %v, want [light.living]", haController.executed)
+	}
+	if len(tuyaController.scenes) != 1 || len(haController.scenes) != 0 {
+		t.Errorf("scene routed to wrong backend: tuya %v, home assistant %v", tuyaController.scenes, haController.scenes)
+	}
+
+	if err := controller.ExecuteCommand(ctx, &domain.Command{TargetID: "missing"}); err == nil {
+		t.Error("expected error for a device no backend owns")
+	}
+}
+
+func TestRegistry_MergesBackends(t *testing.T) {
+	ha, ty, _, _ := newBackends()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := composite.NewRegistry(logger, ha, ty)
+
+	if got := len(registry.GetDevices()); got != 2 {
+		t.Errorf("devices: got %d, want 2", got)
+	}
+
+	d, ok := registry.FindDeviceByName("enchufe cocina")
+	if !ok || d.ID != "bf123" {
+		t.Errorf("FindDeviceByName: got %v, %t", d, ok)
+	}
+
+	s, ok := registry.FindSceneByName("Película")
+	if !ok || s.ID != "scene.movie" {
+		t.Errorf("FindSceneByName: got %v, %t", s, ok)
+	}
+}
+
+func TestRegistry_SyncToleratesOneFailingBackend(t *testing.T) {
+	ha, ty, _, _ := newBackends()
+	ty.Registry.(*fakeRegistry).syncErr = errors.New("tuya cloud down")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if err := composite.NewRegistry(logger, ha, ty).Sync(context.Background()); err != nil {
+		t.Errorf("Sync should succeed while one backend is up: %v", err)
+	}
+
+	ha.Registry.(*fakeRegistry).syncErr = errors.New("home assistant down")
+	if err := composite.NewRegistry(logger, ha, ty).Sync(context.Background()); err == nil {
+		t.Error("Sync should fail when every backend is down")
+	}
+}
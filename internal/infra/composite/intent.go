@@ -0,0 +1,181 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+const (
+	// DefaultFailureThreshold is how many failures in a row open a
+	// provider's circuit.
+	DefaultFailureThreshold = 3
+	// DefaultCooldown is how long an open circuit skips its provider before
+	// trying it again.
+	DefaultCooldown = time.Minute
+)
+
+// Provider is one intent parser of a chain (Claude, Gemini, ...). Timeout
+// bounds each call to it; zero means no limit besides the caller's.
+type Provider struct {
+	Name    string
+	Parser  application.IntentParser
+	Timeout time.Duration
+}
+
+// ProviderStats counts how a provider has been doing.
+type ProviderStats struct {
+	Answered int
+	Failed   int
+	Skipped  int
+	// Open reports that the provider is being skipped after failing
+	// repeatedly.
+	Open bool
+}
+
+type provider struct {
+	Provider
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	stats     ProviderStats
+}
+
+// Parser tries its providers in order until one answers, so an outage or
+// quota error of one LLM falls over to the next. A provider that fails
+// several times in a row is skipped for a while instead of making every
+// command wait for its timeout.
+type Parser struct {
+	providers []*provider
+	logger    *slog.Logger
+
+	failureThreshold int
+	cooldown         time.Duration
+}
+
+func NewParser(logger *slog.Logger, providers ...Provider) *Parser {
+	p := &Parser{
+		logger:           logger,
+		failureThreshold: DefaultFailureThreshold,
+		cooldown:         DefaultCooldown,
+	}
+	for _, pr := range providers {
+		p.providers = append(p.providers, &provider{Provider: pr})
+	}
+	return p
+}
+
+// SetBreaker changes how many failures in a row open a provider's circuit
+// and how long it stays open. It must be called before Parse.
+func (p *Parser) SetBreaker(failures int, cooldown time.Duration) {
+	if failures > 0 {
+		p.failureThreshold = failures
+	}
+	if cooldown > 0 {
+		p.cooldown = cooldown
+	}
+}
+
+func (p *Parser) Parse(ctx context.Context, text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	var errs []error
+
+	for _, pr := range p.providers {
+		if !pr.allow(time.Now()) {
+			p.logger.Debug("skipping intent provider with open circuit", "provider", pr.Name)
+			errs = append(errs, fmt.Errorf("%s: skipped after repeated failures", pr.Name))
+			continue
+		}
+
+		start := time.Now()
+		cmds, err := pr.parse(ctx, text, registry, conv)
+		elapsed := time.Since(start)
+
+		if err == nil {
+			pr.succeeded()
+			p.logger.Info("intent parsed", "provider", pr.Name, "duration", elapsed)
+			return cmds, nil
+		}
+
+		// The caller giving up says nothing about the provider
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if pr.failed(time.Now(), p.failureThreshold, p.cooldown) {
+			p.logger.Warn("intent provider failing, skipping it for a while",
+				"provider", pr.Name, "cooldown", p.cooldown, "error", err)
+		} else {
+			p.logger.Warn("intent provider failed, trying next", "provider", pr.Name, "duration", elapsed, "error", err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", pr.Name, err))
+	}
+
+	return nil, fmt.Errorf("no intent provider answered: %w", errors.Join(errs...))
+}
+
+// Stats returns the counters of every provider by name.
+func (p *Parser) Stats() map[string]ProviderStats {
+	stats := make(map[string]ProviderStats, len(p.providers))
+	now := time.Now()
+	for _, pr := range p.providers {
+		pr.mu.Lock()
+		s := pr.stats
+		s.Open = now.Before(pr.openUntil)
+		pr.mu.Unlock()
+		stats[pr.Name] = s
+	}
+	return stats
+}
+
+func (pr *provider) parse(ctx context.Context, text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	if pr.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pr.Timeout)
+		defer cancel()
+	}
+	return pr.Parser.Parse(ctx, text, registry, conv)
+}
+
+// allow reports whether the provider may be tried. Once the cooldown is
+// over it is tried again: a success closes the circuit, a single failure
+// opens it again.
+func (pr *provider) allow(now time.Time) bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if now.Before(pr.openUntil) {
+		pr.stats.Skipped++
+		return false
+	}
+	return true
+}
+
+func (pr *provider) succeeded() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.failures = 0
+	pr.openUntil = time.Time{}
+	pr.stats.Answered++
+}
+
+// failed records a failure and reports whether it opened the circuit.
+func (pr *provider) failed(now time.Time, threshold int, cooldown time.Duration) bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.failures++
+	pr.stats.Failed++
+	if pr.failures < threshold {
+		return false
+	}
+	pr.openUntil = now.Add(cooldown)
+	return true
+}
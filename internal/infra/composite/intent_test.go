@@ -0,0 +1,135 @@
+package composite_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/composite"
+)
+
+type fakeParser struct {
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (f *fakeParser) Parse(ctx context.Context, text string, _ application.DeviceRegistry, _ *application.Conversation) ([]*domain.Command, error) {
+	f.calls++
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []*domain.Command{{Action: domain.ActionTurnOn, TargetName: text}}, nil
+}
+
+func TestParser_FailsOver(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	claude := &fakeParser{err: errors.New("quota exceeded")}
+	gemini := &fakeParser{}
+
+	parser := composite.NewParser(logger,
+		composite.Provider{Name: "anthropic", Parser: claude},
+		composite.Provider{Name: "gemini", Parser: gemini},
+	)
+
+	cmds, err := parser.Parse(context.Background(), "Luz Living", &fakeRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0].TargetName != "Luz Living" {
+		t.Errorf("unexpected commands: %+v", cmds)
+	}
+
+	stats := parser.Stats()
+	if stats["anthropic"].Failed != 1 || stats["gemini"].Answered != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestParser_Timeout(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	slow := &fakeParser{delay: time.Second}
+	fast := &fakeParser{}
+
+	parser := composite.NewParser(logger,
+		composite.Provider{Name: "slow", Parser: slow, Timeout: 20 * time.Millisecond},
+		composite.Provider{Name: "fast", Parser: fast},
+	)
+
+	start := time.Now()
+	if _, err := parser.Parse(context.Background(), "Luz Living", &fakeRegistry{}, nil); err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the slow provider should have been cut short, took %s", elapsed)
+	}
+	if fast.calls != 1 {
+		t.Errorf("fast provider calls: got %d, want 1", fast.calls)
+	}
+}
+
+func TestParser_CircuitBreaker(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	flaky := &fakeParser{err: errors.New("503 service unavailable")}
+	backup := &fakeParser{}
+
+	parser := composite.NewParser(logger,
+		composite.Provider{Name: "flaky", Parser: flaky},
+		composite.Provider{Name: "backup", Parser: backup},
+	)
+	parser.SetBreaker(2, 50*time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		if _, err := parser.Parse(context.Background(), "Luz Living", &fakeRegistry{}, nil); err != nil {
+			t.Fatalf("Parse %d error: %v", i, err)
+		}
+	}
+	if flaky.calls != 2 {
+		t.Errorf("flaky provider should be skipped after 2 failures, got %d calls", flaky.calls)
+	}
+	if stats := parser.Stats()["flaky"]; !stats.Open || stats.Skipped != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// After the cooldown the provider is tried again and closes the circuit
+	// once it recovers
+	time.Sleep(60 * time.Millisecond)
+	flaky.err = nil
+	cmds, err := parser.Parse(context.Background(), "Luz Cocina", &fakeRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if flaky.calls != 3 || cmds[0].TargetName != "Luz Cocina" {
+		t.Errorf("recovered provider should answer, calls %d", flaky.calls)
+	}
+	if parser.Stats()["flaky"].Open {
+		t.Error("circuit should be closed again")
+	}
+}
+
+func TestParser_AllFail(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	quota := errors.New("quota exceeded")
+
+	parser := composite.NewParser(logger,
+		composite.Provider{Name: "anthropic", Parser: &fakeParser{err: quota}},
+		composite.Provider{Name: "gemini", Parser: &fakeParser{err: errors.New("network down")}},
+	)
+
+	_, err := parser.Parse(context.Background(), "Luz Living", &fakeRegistry{}, nil)
+	if !errors.Is(err, quota) {
+		t.Errorf("expected every provider error to be reported, got %v", err)
+	}
+}
@@ -7,12 +7,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"time"
 
 	"smart-home/internal/application"
 	"smart-home/internal/domain"
 	"smart-home/internal/infra"
+	"smart-home/internal/infra/llm"
 )
 
 type Client struct {
@@ -20,6 +20,7 @@ type Client struct {
 	httpClient *http.Client
 	baseURL    string
 	model      string
+	prompt     *llm.Prompt
 }
 
 func NewClient(apiKey, model string) *Client {
@@ -35,21 +36,52 @@ func NewClientWithURL(apiKey, model, baseURL string) *Client {
 		httpClient: &http.Client{Timeout: 30 * time.Second},
 		baseURL:    baseURL,
 		model:      model,
+		prompt:     llm.DefaultPrompt(),
 	}
 }
 
+// SetPrompt replaces the built-in prompt, e.g. with one loaded from the
+// config. It must be called before Parse.
+func (c *Client) SetPrompt(p *llm.Prompt) {
+	c.prompt = p
+}
+
 type content struct {
 	Parts []part `json:"parts"`
 	Role  string `json:"role,omitempty"`
 }
 
 type part struct {
-	Text string `json:"text"`
+	Text         string        `json:"text,omitempty"`
+	FunctionCall *functionCall `json:"functionCall,omitempty"`
+}
+
+type functionCall struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+type functionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type tool struct {
+	FunctionDeclarations []functionDeclaration `json:"functionDeclarations"`
+}
+
+type toolConfig struct {
+	FunctionCallingConfig struct {
+		Mode string `json:"mode"`
+	} `json:"functionCallingConfig"`
 }
 
 type request struct {
 	Contents         []content        `json:"contents"`
 	SystemInstruct   *content         `json:"systemInstruction,omitempty"`
+	Tools            []tool           `json:"tools"`
+	ToolConfig       toolConfig       `json:"toolConfig"`
 	GenerationConfig generationConfig `json:"generationConfig"`
 }
 
@@ -60,11 +92,7 @@ type generationConfig struct {
 
 type response struct {
 	Candidates []struct {
-		Content struct {
-			Parts []struct {
-				Text string `json:"text"`
-			} `json:"parts"`
-		} `json:"content"`
+		Content content `json:"content"`
 	} `json:"candidates"`
 	Error *struct {
 		Message string `json:"message"`
@@ -72,34 +100,11 @@ type response struct {
 	} `json:"error,omitempty"`
 }
 
-type parsedIntent struct {
-	Action     string         `json:"action"`
-	TargetName string         `json:"target_name"`
-	TargetType string         `json:"target_type"`
-	Parameters map[string]any `json:"parameters"`
-	Confidence float64        `json:"confidence"`
-}
-
-func (c *Client) Parse(ctx context.Context, text string, registry application.DeviceRegistry) (*domain.Command, error) {
-	systemPrompt := fmt.Sprintf(`You are a smart home assistant. Your task is to interpret voice commands and extract the intent.
-
-%s
-
-IMPORTANT:
-- If the user mentions a scene, use target_type "scene"
-- If the user mentions a device, use target_type "device"
-- Use the EXACT name of the device or scene as it appears in the list
-- If you don't understand the command, use action "unknown"
-- The user may speak in English or Spanish, understand both
-
-Respond ONLY with valid JSON (no markdown, no backticks):
-{
-  "action": "turn_on|turn_off|set_level|set_color|run_scene|get_status|unknown",
-  "target_name": "exact device or scene name",
-  "target_type": "device|scene",
-  "parameters": {"level": 50, "color": "red"},
-  "confidence": 0.95
-}`, registry.Summary())
+func (c *Client) Parse(ctx context.Context, text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	systemPrompt, err := c.prompt.Build(registry, conv, true)
+	if err != nil {
+		return nil, err
+	}
 
 	reqBody := request{
 		SystemInstruct: &content{
@@ -112,10 +117,17 @@ Respond ONLY with valid JSON (no markdown, no backticks):
 			},
 		},
 		GenerationConfig: generationConfig{
-			MaxOutputTokens: 256,
+			MaxOutputTokens: 512,
 			Temperature:     0.1,
 		},
 	}
+	var declarations []functionDeclaration
+	for _, t := range llm.Tools(registry) {
+		declarations = append(declarations, functionDeclaration{Name: t.Name, Description: t.Description, Parameters: t.Parameters})
+	}
+	reqBody.Tools = []tool{{FunctionDeclarations: declarations}}
+	// "ANY" makes the model answer with function calls instead of prose
+	reqBody.ToolConfig.FunctionCallingConfig.Mode = "ANY"
 
 	bodyBytes, err := json.Marshal(reqBody)
 	if err != nil {
@@ -165,27 +177,24 @@ Respond ONLY with valid JSON (no markdown, no backticks):
 		return nil, fmt.Errorf("gemini error: %s", result.Error.Message)
 	}
 
-	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
+	if len(result.Candidates) == 0 {
 		return nil, fmt.Errorf("empty response from gemini")
 	}
 
-	responseText := strings.TrimSpace(result.Candidates[0].Content.Parts[0].Text)
-	responseText = strings.TrimPrefix(responseText, "```json")
-	responseText = strings.TrimPrefix(responseText, "```")
-	responseText = strings.TrimSuffix(responseText, "```")
-	responseText = strings.TrimSpace(responseText)
+	var cmds []*domain.Command
+	for _, p := range result.Candidates[0].Content.Parts {
+		if p.FunctionCall != nil {
+			cmds = append(cmds, llm.FromToolCall(p.FunctionCall.Name, p.FunctionCall.Args, text))
+		}
+	}
+
+	if len(cmds) == 0 {
+		return nil, fmt.Errorf("gemini did not call any function")
+	}
 
-	var intent parsedIntent
-	if err = json.Unmarshal([]byte(responseText), &intent); err != nil {
-		return nil, fmt.Errorf("parsing intent JSON (%s): %w", responseText, err)
+	if err = llm.Validate(cmds, registry); err != nil {
+		return nil, fmt.Errorf("invalid intent from gemini: %w", err)
 	}
 
-	return &domain.Command{
-		Action:     domain.Action(intent.Action),
-		TargetName: intent.TargetName,
-		TargetType: domain.TargetType(intent.TargetType),
-		Parameters: intent.Parameters,
-		RawText:    text,
-		Confidence: intent.Confidence,
-	}, nil
+	return cmds, nil
 }
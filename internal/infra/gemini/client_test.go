@@ -0,0 +1,172 @@
+package gemini_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/gemini"
+)
+
+type mockRegistry struct{}
+
+var (
+	devices = []domain.Device{
+		{ID: "1", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living"},
+		{ID: "2", Name: "Luz Cocina", Type: domain.DeviceTypeLight, Area: "Cocina"},
+	}
+	scenes = []domain.Scene{{ID: "s1", Name: "Buenas Noches"}}
+)
+
+func (m *mockRegistry) Sync(_ context.Context) error { return nil }
+func (m *mockRegistry) GetDevices() []domain.Device  { return devices }
+func (m *mockRegistry) GetScenes() []domain.Scene    { return scenes }
+func (m *mockRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
+	for i, d := range devices {
+		if strings.EqualFold(d.Name, name) {
+			return &devices[i], true
+		}
+	}
+	return nil, false
+}
+func (m *mockRegistry) FindSceneByName(name string) (*domain.Scene, bool) {
+	for i, s := range scenes {
+		if strings.EqualFold(s.Name, name) {
+			return &scenes[i], true
+		}
+	}
+	return nil, false
+}
+
+func (m *mockRegistry) StartPeriodicSync(_ context.Context, _ time.Duration) {}
+
+func functionCall(name string, args map[string]any) map[string]any {
+	return map[string]any{"functionCall": map[string]any{"name": name, "args": args}}
+}
+
+func geminiServer(t *testing.T, check func(body map[string]any), parts ...map[string]any) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/gemini-test:generateContent" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if check != nil {
+			check(body)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"candidates": []map[string]any{{"content": map[string]any{"role": "model", "parts": parts}}},
+		})
+	}))
+}
+
+// enum returns the allowed target names of a function declaration.
+func enum(t *testing.T, declaration map[string]any) string {
+	t.Helper()
+	params, _ := declaration["parameters"].(map[string]any)
+	props, _ := params["properties"].(map[string]any)
+	target, _ := props["target_name"].(map[string]any)
+	names, _ := json.Marshal(target["enum"])
+	return string(names)
+}
+
+func TestClient_Parse(t *testing.T) {
+	server := geminiServer(t, func(body map[string]any) {
+		config := body["toolConfig"].(map[string]any)["functionCallingConfig"].(map[string]any)
+		if config["mode"] != "ANY" {
+			t.Errorf("function calling mode: got %v, want ANY", config["mode"])
+		}
+
+		declarations := make(map[string]map[string]any)
+		for _, tl := range body["tools"].([]any) {
+			for _, d := range tl.(map[string]any)["functionDeclarations"].([]any) {
+				d := d.(map[string]any)
+				declarations[d["name"].(string)] = d
+			}
+		}
+		if names := enum(t, declarations["turn_on"]); names != `["Luz Living","Luz Cocina","Living","Cocina"]` {
+			t.Errorf("turn_on targets: got %s", names)
+		}
+		if names := enum(t, declarations["run_scene"]); names != `["Buenas Noches"]` {
+			t.Errorf("run_scene targets: got %s", names)
+		}
+		// Gemini rejects object schemas without properties
+		if _, ok := declarations["unknown"]["parameters"]; ok {
+			t.Errorf("unknown should have no parameters: %v", declarations["unknown"])
+		}
+	},
+		functionCall("turn_off", map[string]any{"target_name": "Luz Cocina", "target_type": "device", "confidence": 0.95}),
+		functionCall("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device", "level": 40, "confidence": 0.93}),
+	)
+	defer server.Close()
+
+	client := gemini.NewClientWithURL("test-key", "gemini-test", server.URL)
+
+	cmds, err := client.Parse(context.Background(), "apagá la cocina y poné el living al 40", &mockRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(cmds) != 2 {
+		t.Fatalf("commands: got %d, want 2", len(cmds))
+	}
+	if cmds[0].Action != domain.ActionTurnOff || cmds[0].TargetName != "Luz Cocina" || cmds[0].TargetType != domain.TargetTypeDevice {
+		t.Errorf("first command: got %s %s (%s), want turn_off Luz Cocina", cmds[0].Action, cmds[0].TargetName, cmds[0].TargetType)
+	}
+	if cmds[1].Action != domain.ActionSetLevel || cmds[1].TargetName != "Luz Living" || cmds[1].Parameters["level"] != 40.0 {
+		t.Errorf("second command: got %s %s %v, want set_level Luz Living 40", cmds[1].Action, cmds[1].TargetName, cmds[1].Parameters)
+	}
+	if cmds[0].Confidence != 0.95 || cmds[0].RawText != "apagá la cocina y poné el living al 40" {
+		t.Errorf("first command: confidence %v, raw text %q", cmds[0].Confidence, cmds[0].RawText)
+	}
+}
+
+func TestClient_ParseScene(t *testing.T) {
+	server := geminiServer(t, nil,
+		functionCall("run_scene", map[string]any{"target_name": "Buenas Noches", "target_type": "scene", "confidence": 0.98}))
+	defer server.Close()
+
+	client := gemini.NewClientWithURL("test-key", "gemini-test", server.URL)
+
+	cmds, err := client.Parse(context.Background(), "activá la escena buenas noches", &mockRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmds[0].Action != domain.ActionRunScene || cmds[0].TargetType != domain.TargetTypeScene {
+		t.Errorf("got %s (%s), want run_scene on a scene", cmds[0].Action, cmds[0].TargetType)
+	}
+}
+
+func TestClient_RejectsInvalidIntent(t *testing.T) {
+	tests := map[string]map[string]any{
+		"no function call":  {"text": `{"action":"turn_on","target_name":"Luz Living"}`},
+		"unknown action":    functionCall("dim", map[string]any{"target_name": "Luz Living", "target_type": "device"}),
+		"unknown device":    functionCall("turn_on", map[string]any{"target_name": "Luz Baño", "target_type": "device"}),
+		"missing parameter": functionCall("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device"}),
+		"device as scene":   functionCall("run_scene", map[string]any{"target_name": "Luz Living", "target_type": "scene"}),
+	}
+
+	for name, p := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := geminiServer(t, nil, p)
+			defer server.Close()
+
+			client := gemini.NewClientWithURL("test-key", "gemini-test", server.URL)
+			if cmds, err := client.Parse(context.Background(), "prendé la luz", &mockRegistry{}, nil); err == nil {
+				t.Errorf("expected an error, got %+v", cmds[0])
+			}
+		})
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,9 +49,9 @@ type Scene struct {
 }
 
 func (c *Client) ExecuteCommand(ctx context.Context, cmd *domain.Command) error {
-	service, data := c.buildServiceCall(cmd)
-	if service == "" {
-		return fmt.Errorf("unknown action: %s", cmd.Action)
+	service, data, err := c.buildServiceCall(cmd)
+	if err != nil {
+		return err
 	}
 
 	// Split service into domain and service name (e.g., "light.turn_on" -> "light", "turn_on")
@@ -67,8 +70,7 @@ func (c *Client) ExecuteCommand(ctx context.Context, cmd *domain.Command) error
 		return fmt.Errorf("marshaling request: %w", err)
 	}
 
-	_, err = c.doRequest(ctx, http.MethodPost, path, body)
-	if err != nil {
+	if _, err := c.doRequest(ctx, http.MethodPost, path, body); err != nil {
 		return fmt.Errorf("executing command: %w", err)
 	}
 
@@ -94,7 +96,59 @@ func (c *Client) TriggerScene(ctx context.Context, sceneID string) error {
 	return nil
 }
 
-func (c *Client) buildServiceCall(cmd *domain.Command) (string, map[string]interface{}) {
+func (c *Client) GetState(ctx context.Context, deviceID string) (*domain.DeviceState, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/api/states/"+deviceID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching state: %w", err)
+	}
+
+	var entity Entity
+	if err := json.Unmarshal(resp, &entity); err != nil {
+		return nil, fmt.Errorf("parsing state: %w", err)
+	}
+
+	return entityState(entity), nil
+}
+
+// entityState extracts the attributes worth reporting from an entity.
+func entityState(e Entity) *domain.DeviceState {
+	state := &domain.DeviceState{Status: e.State}
+
+	if unit, ok := e.Attributes["unit_of_measurement"].(string); ok {
+		state.Unit = unit
+	}
+
+	// Lights report brightness as 0-255, fans a percentage, covers a
+	// position and media players a volume between 0 and 1
+	if brightness, ok := e.Attributes["brightness"].(float64); ok {
+		level := int(math.Round(brightness / 2.55))
+		state.Level = &level
+	} else if percentage, ok := e.Attributes["percentage"].(float64); ok {
+		level := int(percentage)
+		state.Level = &level
+	} else if position, ok := e.Attributes["current_position"].(float64); ok {
+		level := int(position)
+		state.Level = &level
+	} else if volume, ok := e.Attributes["volume_level"].(float64); ok {
+		level := int(math.Round(volume * 100))
+		state.Level = &level
+	}
+
+	if color, ok := e.Attributes["rgb_color"].([]interface{}); ok && len(color) == 3 {
+		state.Color = fmt.Sprintf("rgb(%v, %v, %v)", color[0], color[1], color[2])
+	}
+
+	if current, ok := e.Attributes["current_temperature"].(float64); ok {
+		state.Temperature = &current
+	}
+	if target, ok := e.Attributes["temperature"].(float64); ok {
+		state.TargetTemperature = &target
+	}
+
+	return state
+}
+
+func (c *Client) buildServiceCall(cmd *domain.Command) (string, map[string]interface{}, error) {
 	data := make(map[string]interface{})
 
 	// Determine entity domain from entity_id (e.g., "light.living_room" -> "light")
@@ -105,30 +159,179 @@ func (c *Client) buildServiceCall(cmd *domain.Command) (string, map[string]inter
 
 	switch cmd.Action {
 	case domain.ActionTurnOn:
-		return entityDomain + ".turn_on", data
+		switch entityDomain {
+		case "cover":
+			return "cover.open_cover", data, nil
+		case "lock":
+			return "", nil, fmt.Errorf("%s is a lock: use lock or unlock", cmd.TargetID)
+		case "vacuum":
+			return "vacuum.start", data, nil
+		}
+		return entityDomain + ".turn_on", data, nil
 
 	case domain.ActionTurnOff:
-		return entityDomain + ".turn_off", data
+		switch entityDomain {
+		case "cover":
+			return "cover.close_cover", data, nil
+		case "lock":
+			return "", nil, fmt.Errorf("%s is a lock: use lock or unlock", cmd.TargetID)
+		case "vacuum":
+			return "vacuum.return_to_base", data, nil
+		}
+		return entityDomain + ".turn_off", data, nil
 
 	case domain.ActionSetLevel:
 		level, ok := cmd.Parameters["level"].(float64)
-		if !ok {
+		delta, relative := cmd.Delta()
+		if !ok && !relative {
 			level = 100
 		}
+		switch entityDomain {
+		case "fan":
+			if !ok && relative {
+				// The current speed is unknown; let Home Assistant step it
+				data["percentage_step"] = int(math.Abs(delta))
+				if delta < 0 {
+					return "fan.decrease_speed", data, nil
+				}
+				return "fan.increase_speed", data, nil
+			}
+			data["percentage"] = int(level)
+			return "fan.set_percentage", data, nil
+		case "cover":
+			if !ok && relative {
+				return "", nil, missingValue(cmd, "position")
+			}
+			data["position"] = int(level)
+			return "cover.set_cover_position", data, nil
+		case "media_player":
+			if !ok && relative {
+				return "", nil, missingValue(cmd, "volume")
+			}
+			data["volume_level"] = level / 100
+			return "media_player.volume_set", data, nil
+		}
+		if relative {
+			// Lights step natively, which doesn't depend on the last known
+			// brightness being current
+			data["brightness_step_pct"] = int(delta)
+			return "light.turn_on", data, nil
+		}
 		// Home Assistant uses brightness 0-255
 		data["brightness"] = int(level * 2.55)
-		return "light.turn_on", data
+		return "light.turn_on", data, nil
 
 	case domain.ActionSetColor:
 		// Home Assistant accepts various color formats
-		if color, ok := cmd.Parameters["color"].(string); ok {
-			data["color_name"] = color
+		if temp, ok := cmd.Parameters["color_temp"].(float64); ok {
+			data["color_temp_kelvin"] = int(temp)
+		} else if color, ok := cmd.Parameters["color"].(string); ok {
+			if rgb, ok := parseHexColor(color); ok {
+				data["rgb_color"] = rgb
+			} else {
+				data["color_name"] = color
+			}
+		}
+		return "light.turn_on", data, nil
+
+	case domain.ActionOpen:
+		return "cover.open_cover", data, nil
+
+	case domain.ActionClose:
+		return "cover.close_cover", data, nil
+
+	case domain.ActionSetPosition:
+		position, ok := numberParam(cmd.Parameters, "position", "level")
+		if !ok {
+			return "", nil, missingValue(cmd, "position")
+		}
+		data["position"] = int(position)
+		return "cover.set_cover_position", data, nil
+
+	case domain.ActionLock, domain.ActionUnlock:
+		if code, ok := cmd.Parameters["code"].(string); ok {
+			data["code"] = code
+		}
+		return "lock." + string(cmd.Action), data, nil
+
+	case domain.ActionSetTemperature:
+		temperature, ok := numberParam(cmd.Parameters, "temperature")
+		if !ok {
+			return "", nil, missingValue(cmd, "temperature")
 		}
-		return "light.turn_on", data
+		data["temperature"] = temperature
+		if mode, ok := cmd.Parameters["hvac_mode"].(string); ok {
+			data["hvac_mode"] = mode
+		}
+		return "climate.set_temperature", data, nil
+
+	case domain.ActionSetHVACMode:
+		mode, ok := cmd.Parameters["hvac_mode"].(string)
+		if !ok {
+			mode, ok = cmd.Parameters["mode"].(string)
+		}
+		if !ok {
+			return "", nil, fmt.Errorf("set_hvac_mode needs a mode")
+		}
+		data["hvac_mode"] = mode
+		return "climate.set_hvac_mode", data, nil
+
+	case domain.ActionPlay:
+		return "media_player.media_play", data, nil
+
+	case domain.ActionPause:
+		return "media_player.media_pause", data, nil
+
+	case domain.ActionSetVolume:
+		volume, ok := numberParam(cmd.Parameters, "volume", "level")
+		if !ok {
+			return "", nil, missingValue(cmd, "volume")
+		}
+		// Home Assistant uses volume 0-1
+		data["volume_level"] = min(max(volume, 0), 100) / 100
+		return "media_player.volume_set", data, nil
+
+	case domain.ActionStart:
+		return "vacuum.start", data, nil
+
+	case domain.ActionDock:
+		return "vacuum.return_to_base", data, nil
 
 	default:
-		return "", nil
+		return "", nil, fmt.Errorf("unknown action: %s", cmd.Action)
+	}
+}
+
+// parseHexColor turns "#rrggbb" into the [r, g, b] Home Assistant expects.
+func parseHexColor(color string) ([]int, bool) {
+	hex, ok := strings.CutPrefix(color, "#")
+	if !ok || len(hex) != 6 {
+		return nil, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	return []int{int(v >> 16 & 0xFF), int(v >> 8 & 0xFF), int(v & 0xFF)}, true
+}
+
+// missingValue reports a command without the value it sets. For a relative
+// adjustment that means the current value couldn't be read.
+func missingValue(cmd *domain.Command, name string) error {
+	if _, ok := cmd.Delta(); ok {
+		return fmt.Errorf("%s needs the current %s to adjust it, which is unknown", cmd.Action, name)
 	}
+	return fmt.Errorf("%s needs a %s", cmd.Action, name)
+}
+
+// numberParam returns the first of the given parameters holding a number.
+func numberParam(params map[string]any, names ...string) (float64, bool) {
+	for _, name := range names {
+		if v, ok := params[name].(float64); ok {
+			return v, true
+		}
+	}
+	return 0, false
 }
 
 func (c *Client) GetDevices(ctx context.Context) ([]domain.Device, error) {
@@ -144,26 +347,36 @@ func (c *Client) GetDevices(ctx context.Context) ([]domain.Device, error) {
 
 	devices := make([]domain.Device, 0)
 	for _, e := range entities {
-		deviceType := entityDomainToDeviceType(e.EntityID)
-		if deviceType == "" {
-			continue // Skip non-device entities
+		if d, ok := entityToDevice(e); ok {
+			devices = append(devices, d)
 		}
+	}
 
-		name := e.EntityID
-		if friendlyName, ok := e.Attributes["friendly_name"].(string); ok {
-			name = friendlyName
-		}
+	return devices, nil
+}
 
-		devices = append(devices, domain.Device{
-			ID:       e.EntityID,
-			Name:     name,
-			Category: strings.SplitN(e.EntityID, ".", 2)[0],
-			Type:     deviceType,
-			Online:   e.State != "unavailable",
-		})
+// entityToDevice converts an entity into a device, skipping entity types
+// the assistant can't control or report on.
+func entityToDevice(e Entity) (domain.Device, bool) {
+	deviceType := entityDomainToDeviceType(e.EntityID)
+	if deviceType == "" {
+		return domain.Device{}, false
 	}
 
-	return devices, nil
+	name := e.EntityID
+	if friendlyName, ok := e.Attributes["friendly_name"].(string); ok {
+		name = friendlyName
+	}
+
+	return domain.Device{
+		ID:           e.EntityID,
+		Name:         name,
+		Category:     strings.SplitN(e.EntityID, ".", 2)[0],
+		Type:         deviceType,
+		Online:       e.State != "unavailable",
+		State:        entityState(e),
+		Capabilities: entityCapabilities(e),
+	}, true
 }
 
 func (c *Client) GetScenes(ctx context.Context) ([]domain.Scene, error) {
@@ -179,23 +392,29 @@ func (c *Client) GetScenes(ctx context.Context) ([]domain.Scene, error) {
 
 	scenes := make([]domain.Scene, 0)
 	for _, e := range entities {
-		if !strings.HasPrefix(e.EntityID, "scene.") {
-			continue
+		if scene, ok := entityToScene(e); ok {
+			scenes = append(scenes, scene)
 		}
+	}
 
-		name := e.EntityID
-		if friendlyName, ok := e.Attributes["friendly_name"].(string); ok {
-			name = friendlyName
-		}
+	return scenes, nil
+}
 
-		scenes = append(scenes, domain.Scene{
-			ID:     e.EntityID,
-			Name:   name,
-			Status: e.State,
-		})
+func entityToScene(e Entity) (domain.Scene, bool) {
+	if !strings.HasPrefix(e.EntityID, "scene.") {
+		return domain.Scene{}, false
 	}
 
-	return scenes, nil
+	name := e.EntityID
+	if friendlyName, ok := e.Attributes["friendly_name"].(string); ok {
+		name = friendlyName
+	}
+
+	return domain.Scene{
+		ID:     e.EntityID,
+		Name:   name,
+		Status: e.State,
+	}, true
 }
 
 func (c *Client) doRequest(ctx context.Context, method, path string, body []byte) ([]byte, error) {
@@ -263,9 +482,144 @@ func entityDomainToDeviceType(entityID string) domain.DeviceType {
 		return domain.DeviceTypeThermostat
 	case "binary_sensor", "sensor":
 		return domain.DeviceTypeSensor
+	case "cover":
+		return domain.DeviceTypeCover
 	case "fan":
-		return domain.DeviceTypeOther
+		return domain.DeviceTypeFan
+	case "lock":
+		return domain.DeviceTypeLock
+	case "media_player":
+		return domain.DeviceTypeMediaPlayer
+	case "vacuum":
+		return domain.DeviceTypeVacuum
 	default:
 		return "" // Skip unknown entity types
 	}
 }
+
+// Feature flags from Home Assistant's supported_features attribute.
+const (
+	fanFeatureSetSpeed = 1
+
+	coverFeatureOpen        = 1
+	coverFeatureClose       = 2
+	coverFeatureSetPosition = 4
+
+	climateFeatureTargetTemperature = 1
+
+	mediaFeaturePause     = 1
+	mediaFeatureVolumeSet = 4
+	mediaFeatureTurnOn    = 128
+	mediaFeatureTurnOff   = 256
+	mediaFeaturePlay      = 16384
+
+	vacuumFeatureReturnHome = 16
+	vacuumFeatureStart      = 8192
+)
+
+// entityCapabilities works out what an entity supports from its
+// supported_features, supported_color_modes and min/max attributes.
+func entityCapabilities(e Entity) *domain.Capabilities {
+	caps := &domain.Capabilities{}
+	features := 0
+	if f, ok := e.Attributes["supported_features"].(float64); ok {
+		features = int(f)
+	}
+	has := func(flag int) bool { return features&flag != 0 }
+
+	switch strings.SplitN(e.EntityID, ".", 2)[0] {
+	case "light":
+		caps.Add(domain.ActionTurnOn, domain.ActionTurnOff)
+		modes, ok := e.Attributes["supported_color_modes"].([]interface{})
+		if !ok {
+			// Lights that don't report color modes predate them; assume
+			// they can at least be dimmed
+			caps.Add(domain.ActionSetLevel)
+			break
+		}
+		for _, m := range modes {
+			switch m {
+			case "brightness", "white":
+				caps.Add(domain.ActionSetLevel)
+			case "color_temp":
+				// Shades of white go as set_color {"color_temp": K}
+				caps.Add(domain.ActionSetLevel, domain.ActionSetColor)
+			case "hs", "xy", "rgb", "rgbw", "rgbww":
+				caps.Add(domain.ActionSetLevel, domain.ActionSetColor)
+			}
+		}
+
+	case "switch":
+		caps.Add(domain.ActionTurnOn, domain.ActionTurnOff)
+
+	case "fan":
+		caps.Add(domain.ActionTurnOn, domain.ActionTurnOff)
+		if has(fanFeatureSetSpeed) {
+			caps.Add(domain.ActionSetLevel)
+		}
+
+	case "cover":
+		if has(coverFeatureOpen) {
+			caps.Add(domain.ActionOpen, domain.ActionTurnOn)
+		}
+		if has(coverFeatureClose) {
+			caps.Add(domain.ActionClose, domain.ActionTurnOff)
+		}
+		if has(coverFeatureSetPosition) {
+			caps.Add(domain.ActionSetPosition, domain.ActionSetLevel)
+		}
+
+	case "lock":
+		caps.Add(domain.ActionLock, domain.ActionUnlock)
+
+	case "climate":
+		if has(climateFeatureTargetTemperature) {
+			caps.Add(domain.ActionSetTemperature)
+		}
+		minTemp, okMin := e.Attributes["min_temp"].(float64)
+		maxTemp, okMax := e.Attributes["max_temp"].(float64)
+		if okMin && okMax {
+			caps.SetRange("temperature", minTemp, maxTemp)
+		}
+		if modes, ok := e.Attributes["hvac_modes"].([]interface{}); ok && len(modes) > 0 {
+			var options []string
+			for _, m := range modes {
+				if mode, ok := m.(string); ok {
+					options = append(options, mode)
+				}
+			}
+			caps.Add(domain.ActionSetHVACMode)
+			caps.SetOptions("hvac_mode", options)
+			if slices.Contains(options, "off") {
+				caps.Add(domain.ActionTurnOn, domain.ActionTurnOff)
+			}
+		}
+
+	case "media_player":
+		if has(mediaFeatureTurnOn) {
+			caps.Add(domain.ActionTurnOn)
+		}
+		if has(mediaFeatureTurnOff) {
+			caps.Add(domain.ActionTurnOff)
+		}
+		if has(mediaFeaturePlay) {
+			caps.Add(domain.ActionPlay)
+		}
+		if has(mediaFeaturePause) {
+			caps.Add(domain.ActionPause)
+		}
+		if has(mediaFeatureVolumeSet) {
+			caps.Add(domain.ActionSetVolume, domain.ActionSetLevel)
+		}
+
+	case "vacuum":
+		if has(vacuumFeatureStart) {
+			caps.Add(domain.ActionStart, domain.ActionTurnOn)
+		}
+		if has(vacuumFeatureReturnHome) {
+			caps.Add(domain.ActionDock, domain.ActionTurnOff)
+		}
+	}
+
+	return caps
+}
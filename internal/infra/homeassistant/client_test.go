@@ -0,0 +1,192 @@
+package homeassistant_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/homeassistant"
+)
+
+func TestClient_ExecuteCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		cmd         domain.Command
+		wantPath    string
+		wantPayload map[string]any
+	}{
+		{
+			name:        "dim light",
+			cmd:         domain.Command{Action: domain.ActionSetLevel, TargetID: "light.living", Parameters: map[string]any{"level": 40.0}},
+			wantPath:    "/api/services/light/turn_on",
+			wantPayload: map[string]any{"entity_id": "light.living", "brightness": 102.0},
+		},
+		{
+			name:        "brighten light",
+			cmd:         domain.Command{Action: domain.ActionSetLevel, TargetID: "light.living", Parameters: map[string]any{"delta": 10.0}},
+			wantPath:    "/api/services/light/turn_on",
+			wantPayload: map[string]any{"entity_id": "light.living", "brightness_step_pct": 10.0},
+		},
+		{
+			name:        "hex color",
+			cmd:         domain.Command{Action: domain.ActionSetColor, TargetID: "light.living", Parameters: map[string]any{"color": "#ff8800"}},
+			wantPath:    "/api/services/light/turn_on",
+			wantPayload: map[string]any{"entity_id": "light.living", "rgb_color": []any{255.0, 136.0, 0.0}},
+		},
+		{
+			name:        "fan speed",
+			cmd:         domain.Command{Action: domain.ActionSetLevel, TargetID: "fan.bedroom", Parameters: map[string]any{"level": 60.0}},
+			wantPath:    "/api/services/fan/set_percentage",
+			wantPayload: map[string]any{"entity_id": "fan.bedroom", "percentage": 60.0},
+		},
+		{
+			name:        "slow fan down",
+			cmd:         domain.Command{Action: domain.ActionSetLevel, TargetID: "fan.bedroom", Parameters: map[string]any{"delta": -10.0}},
+			wantPath:    "/api/services/fan/decrease_speed",
+			wantPayload: map[string]any{"entity_id": "fan.bedroom", "percentage_step": 10.0},
+		},
+		{
+			name:        "open cover",
+			cmd:         domain.Command{Action: domain.ActionOpen, TargetID: "cover.blinds"},
+			wantPath:    "/api/services/cover/open_cover",
+			wantPayload: map[string]any{"entity_id": "cover.blinds"},
+		},
+		{
+			name:        "turn off cover closes it",
+			cmd:         domain.Command{Action: domain.ActionTurnOff, TargetID: "cover.blinds"},
+			wantPath:    "/api/services/cover/close_cover",
+			wantPayload: map[string]any{"entity_id": "cover.blinds"},
+		},
+		{
+			name:        "cover position",
+			cmd:         domain.Command{Action: domain.ActionSetPosition, TargetID: "cover.blinds", Parameters: map[string]any{"position": 30.0}},
+			wantPath:    "/api/services/cover/set_cover_position",
+			wantPayload: map[string]any{"entity_id": "cover.blinds", "position": 30.0},
+		},
+		{
+			name:        "lock",
+			cmd:         domain.Command{Action: domain.ActionLock, TargetID: "lock.front_door"},
+			wantPath:    "/api/services/lock/lock",
+			wantPayload: map[string]any{"entity_id": "lock.front_door"},
+		},
+		{
+			name:        "thermostat",
+			cmd:         domain.Command{Action: domain.ActionSetTemperature, TargetID: "climate.living", Parameters: map[string]any{"temperature": 22.5}},
+			wantPath:    "/api/services/climate/set_temperature",
+			wantPayload: map[string]any{"entity_id": "climate.living", "temperature": 22.5},
+		},
+		{
+			name:        "hvac mode",
+			cmd:         domain.Command{Action: domain.ActionSetHVACMode, TargetID: "climate.living", Parameters: map[string]any{"hvac_mode": "cool"}},
+			wantPath:    "/api/services/climate/set_hvac_mode",
+			wantPayload: map[string]any{"entity_id": "climate.living", "hvac_mode": "cool"},
+		},
+		{
+			name:        "pause",
+			cmd:         domain.Command{Action: domain.ActionPause, TargetID: "media_player.tv"},
+			wantPath:    "/api/services/media_player/media_pause",
+			wantPayload: map[string]any{"entity_id": "media_player.tv"},
+		},
+		{
+			name:        "volume",
+			cmd:         domain.Command{Action: domain.ActionSetVolume, TargetID: "media_player.tv", Parameters: map[string]any{"volume": 25.0}},
+			wantPath:    "/api/services/media_player/volume_set",
+			wantPayload: map[string]any{"entity_id": "media_player.tv", "volume_level": 0.25},
+		},
+		{
+			name:        "dock vacuum",
+			cmd:         domain.Command{Action: domain.ActionDock, TargetID: "vacuum.roomba"},
+			wantPath:    "/api/services/vacuum/return_to_base",
+			wantPayload: map[string]any{"entity_id": "vacuum.roomba"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			var gotPayload map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				json.NewDecoder(r.Body).Decode(&gotPayload)
+				w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+
+			client := homeassistant.NewClient(server.URL, "test-token")
+			if err := client.ExecuteCommand(context.Background(), &tt.cmd); err != nil {
+				t.Fatalf("ExecuteCommand error: %v", err)
+			}
+
+			if gotPath != tt.wantPath {
+				t.Errorf("path: got %s, want %s", gotPath, tt.wantPath)
+			}
+			if !reflect.DeepEqual(gotPayload, tt.wantPayload) {
+				t.Errorf("payload: got %v, want %v", gotPayload, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestClient_ExecuteCommand_MissingParameter(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	client := homeassistant.NewClient(server.URL, "test-token")
+	err := client.ExecuteCommand(context.Background(), &domain.Command{
+		Action:   domain.ActionSetTemperature,
+		TargetID: "climate.living",
+	})
+	if err == nil {
+		t.Fatal("expected an error without a temperature")
+	}
+	if called {
+		t.Error("no service should be called")
+	}
+}
+
+func TestClient_GetDevices_Capabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"entity_id": "light.plain", "state": "on", "attributes": map[string]any{"supported_color_modes": []string{"onoff"}}},
+			{"entity_id": "light.color", "state": "on", "attributes": map[string]any{"supported_color_modes": []string{"color_temp", "hs"}}},
+			{"entity_id": "cover.blinds", "state": "open", "attributes": map[string]any{"supported_features": 3}},
+			{"entity_id": "climate.ac", "state": "cool", "attributes": map[string]any{
+				"supported_features": 1, "min_temp": 16, "max_temp": 30, "hvac_modes": []string{"off", "cool", "heat"},
+			}},
+			{"entity_id": "sensor.temp", "state": "21", "attributes": map[string]any{}},
+		})
+	}))
+	defer server.Close()
+
+	client := homeassistant.NewClient(server.URL, "test-token")
+	devices, err := client.GetDevices(context.Background())
+	if err != nil {
+		t.Fatalf("GetDevices error: %v", err)
+	}
+
+	want := map[string]string{
+		"light.plain":  "turn_on, turn_off",
+		"light.color":  "turn_on, turn_off, set_level, set_color",
+		"cover.blinds": "open, turn_on, close, turn_off",
+		"climate.ac":   "set_temperature, set_hvac_mode, turn_on, turn_off (temperature 16-30, hvac_mode: off|cool|heat)",
+		"sensor.temp":  "",
+	}
+	for _, d := range devices {
+		if got := d.Capabilities.String(); got != want[d.ID] {
+			t.Errorf("%s capabilities: got %q, want %q", d.ID, got, want[d.ID])
+		}
+	}
+
+	for _, d := range devices {
+		if d.ID == "sensor.temp" && (d.Supports(domain.ActionTurnOn) || !d.Supports(domain.ActionGetStatus)) {
+			t.Error("sensors should only support status queries")
+		}
+	}
+}
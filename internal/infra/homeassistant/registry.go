@@ -2,8 +2,10 @@ package homeassistant
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -13,23 +15,34 @@ import (
 
 type Registry struct {
 	client *Client
+	// ws is nil when the WebSocket API is off: no areas nor live updates
+	ws     *WSClient
 	logger *slog.Logger
 
 	mu      sync.RWMutex
 	devices []domain.Device
 	scenes  []domain.Scene
+	// areas maps entity ids to the name of their area
+	areas map[string]string
 
 	deviceIndex map[string]*domain.Device
 	sceneIndex  map[string]*domain.Scene
 }
 
-func NewRegistry(client *Client, logger *slog.Logger) *Registry {
-	return &Registry{
+// NewRegistry creates a registry fed by the REST API. With webSocket, it
+// also reads the areas of the entities and can follow their state changes
+// live through the WebSocket API.
+func NewRegistry(client *Client, webSocket bool, logger *slog.Logger) *Registry {
+	r := &Registry{
 		client:      client,
 		logger:      logger,
 		deviceIndex: make(map[string]*domain.Device),
 		sceneIndex:  make(map[string]*domain.Scene),
 	}
+	if webSocket {
+		r.ws = NewWSClient(client.baseURL, client.token)
+	}
+	return r
 }
 
 func (r *Registry) Sync(ctx context.Context) error {
@@ -45,12 +58,39 @@ func (r *Registry) Sync(ctx context.Context) error {
 		return fmt.Errorf("fetching scenes: %w", err)
 	}
 
+	var areas map[string]string
+	if r.ws != nil {
+		areas, err = r.ws.EntityAreas(ctx)
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	switch {
+	case err != nil:
+		// Areas are a nice-to-have: keep the last known ones and carry on
+		r.logger.Warn("fetching areas", "error", err)
+	case r.ws != nil:
+		r.areas = areas
+	}
+	for i := range devices {
+		devices[i].Area = r.areas[devices[i].ID]
+	}
+
 	r.devices = devices
 	r.scenes = scenes
+	r.reindex()
+
+	r.logger.Info("sync complete",
+		"devices", len(r.devices),
+		"scenes", len(r.scenes),
+	)
+
+	return nil
+}
 
+// reindex rebuilds the name lookups. Callers must hold the write lock.
+func (r *Registry) reindex() {
 	r.deviceIndex = make(map[string]*domain.Device)
 	for i := range r.devices {
 		key := strings.ToLower(r.devices[i].Name)
@@ -62,13 +102,6 @@ func (r *Registry) Sync(ctx context.Context) error {
 		key := strings.ToLower(r.scenes[i].Name)
 		r.sceneIndex[key] = &r.scenes[i]
 	}
-
-	r.logger.Info("sync complete",
-		"devices", len(r.devices),
-		"scenes", len(r.scenes),
-	)
-
-	return nil
 }
 
 func (r *Registry) GetDevices() []domain.Device {
@@ -87,6 +120,8 @@ func (r *Registry) GetScenes() []domain.Scene {
 	return result
 }
 
+// FindDeviceByName returns a copy of the device: live updates change the
+// registry in place, so its own entries must not leak past the lock.
 func (r *Registry) FindDeviceByName(name string) (*domain.Device, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -94,7 +129,8 @@ func (r *Registry) FindDeviceByName(name string) (*domain.Device, bool) {
 	key := strings.ToLower(strings.TrimSpace(name))
 
 	if d, ok := r.deviceIndex[key]; ok {
-		return d, true
+		device := *d
+		return &device, true
 	}
 
 	for _, d := range r.devices {
@@ -106,6 +142,7 @@ func (r *Registry) FindDeviceByName(name string) (*domain.Device, bool) {
 	return nil, false
 }
 
+// FindSceneByName returns a copy of the scene, like FindDeviceByName.
 func (r *Registry) FindSceneByName(name string) (*domain.Scene, bool) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -113,7 +150,8 @@ func (r *Registry) FindSceneByName(name string) (*domain.Scene, bool) {
 	key := strings.ToLower(strings.TrimSpace(name))
 
 	if s, ok := r.sceneIndex[key]; ok {
-		return s, true
+		scene := *s
+		return &scene, true
 	}
 
 	for _, s := range r.scenes {
@@ -125,29 +163,6 @@ func (r *Registry) FindSceneByName(name string) (*domain.Scene, bool) {
 	return nil, false
 }
 
-func (r *Registry) Summary() string {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	var sb strings.Builder
-
-	sb.WriteString("## Dispositivos disponibles:\n")
-	for _, d := range r.devices {
-		status := "offline"
-		if d.Online {
-			status = "online"
-		}
-		sb.WriteString(fmt.Sprintf("- %s (tipo: %s, estado: %s)\n", d.Name, d.Type, status))
-	}
-
-	sb.WriteString("\n## Escenas disponibles:\n")
-	for _, s := range r.scenes {
-		sb.WriteString(fmt.Sprintf("- %s\n", s.Name))
-	}
-
-	return sb.String()
-}
-
 func (r *Registry) StartPeriodicSync(ctx context.Context, interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
@@ -165,3 +180,102 @@ func (r *Registry) StartPeriodicSync(ctx context.Context, interval time.Duration
 		}
 	}()
 }
+
+// StartLiveUpdates keeps devices and scenes current from Home Assistant's
+// state_changed events. When the connection drops it reconnects with
+// backoff; periodic sync keeps running meanwhile and a full sync after each
+// reconnect catches up on anything missed.
+func (r *Registry) StartLiveUpdates(ctx context.Context) {
+	if r.ws == nil {
+		r.logger.Warn("live updates need the WebSocket API, which is off")
+		return
+	}
+	go func() {
+		backoff := time.Second
+		reconnecting := false
+
+		for {
+			connectedAt := time.Now()
+			err := r.ws.Subscribe(ctx, "state_changed", func() {
+				r.logger.Info("live updates connected")
+				if reconnecting {
+					if err := r.Sync(ctx); err != nil {
+						r.logger.Error("resync after reconnect failed", "error", err)
+					}
+				}
+			}, r.handleStateChanged)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if time.Since(connectedAt) > time.Minute {
+				backoff = time.Second
+			}
+			r.logger.Warn("live updates disconnected, falling back to polling", "error", err, "retry_in", backoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			reconnecting = true
+			backoff = min(backoff*2, time.Minute)
+		}
+	}()
+}
+
+func (r *Registry) handleStateChanged(event json.RawMessage) {
+	var e struct {
+		Data struct {
+			EntityID string  `json:"entity_id"`
+			NewState *Entity `json:"new_state"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(event, &e); err != nil {
+		r.logger.Warn("parsing state_changed event", "error", err)
+		return
+	}
+
+	r.applyState(e.Data.EntityID, e.Data.NewState)
+}
+
+// applyState updates, adds or (when state is nil) removes a single entity.
+func (r *Registry) applyState(entityID string, state *Entity) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if strings.HasPrefix(entityID, "scene.") {
+		r.scenes = slices.DeleteFunc(r.scenes, func(s domain.Scene) bool { return s.ID == entityID })
+		if state != nil {
+			if scene, ok := entityToScene(*state); ok {
+				r.scenes = append(r.scenes, scene)
+			}
+		}
+		r.reindex()
+		return
+	}
+
+	var device domain.Device
+	ok := false
+	if state != nil {
+		device, ok = entityToDevice(*state)
+	}
+
+	i := slices.IndexFunc(r.devices, func(d domain.Device) bool { return d.ID == entityID })
+	device.Area = r.areas[entityID]
+
+	switch {
+	case i >= 0 && ok:
+		r.devices[i] = device
+	case i >= 0:
+		r.devices = slices.Delete(r.devices, i, i+1)
+	case ok:
+		r.devices = append(r.devices, device)
+	default:
+		return
+	}
+
+	r.reindex()
+}
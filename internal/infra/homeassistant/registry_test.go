@@ -0,0 +1,298 @@
+package homeassistant_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/homeassistant"
+	"smart-home/internal/infra/websocket"
+)
+
+// fakeHomeAssistant serves /api/states and a WebSocket API that answers
+// commands from results and pushes the given events once a client subscribes
+// to state_changed.
+func fakeHomeAssistant(t *testing.T, states []map[string]any, results map[string]any, events []map[string]any) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/states", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(states)
+	})
+	mux.HandleFunc("GET /api/websocket", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(map[string]any{"type": "auth_required"})
+
+		var auth map[string]any
+		if err := conn.ReadJSON(&auth); err != nil || auth["access_token"] != "test-token" {
+			conn.WriteJSON(map[string]any{"type": "auth_invalid", "message": "bad token"})
+			return
+		}
+		conn.WriteJSON(map[string]any{"type": "auth_ok"})
+
+		for {
+			var msg map[string]any
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			msgType, _ := msg["type"].(string)
+			conn.WriteJSON(map[string]any{"id": msg["id"], "type": "result", "success": true, "result": results[msgType]})
+
+			if msg["type"] == "subscribe_events" && msg["event_type"] == "state_changed" {
+				for _, event := range events {
+					conn.WriteJSON(map[string]any{"id": msg["id"], "type": "event", "event": event})
+				}
+			}
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func stateChanged(entityID string, newState map[string]any) map[string]any {
+	return map[string]any{
+		"event_type": "state_changed",
+		"data": map[string]any{
+			"entity_id": entityID,
+			"new_state": newState,
+		},
+	}
+}
+
+func TestRegistry_LiveUpdates(t *testing.T) {
+	states := []map[string]any{
+		{"entity_id": "light.living", "state": "off", "attributes": map[string]any{"friendly_name": "Luz Living"}},
+		{"entity_id": "switch.heater", "state": "on", "attributes": map[string]any{"friendly_name": "Estufa"}},
+		{"entity_id": "scene.movie", "state": "scening", "attributes": map[string]any{"friendly_name": "Película"}},
+	}
+	events := []map[string]any{
+		stateChanged("light.living", map[string]any{
+			"entity_id":  "light.living",
+			"state":      "on",
+			"attributes": map[string]any{"friendly_name": "Luz Living", "brightness": 102.0},
+		}),
+		stateChanged("switch.heater", nil),
+		stateChanged("light.kitchen", map[string]any{
+			"entity_id":  "light.kitchen",
+			"state":      "unavailable",
+			"attributes": map[string]any{"friendly_name": "Luz Cocina"},
+		}),
+	}
+
+	server := fakeHomeAssistant(t, states, nil, events)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	client := homeassistant.NewClient(server.URL, "test-token")
+	registry := homeassistant.NewRegistry(client, true, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := registry.Sync(ctx); err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+
+	registry.StartLiveUpdates(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := registry.FindDeviceByName("Luz Cocina"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	living, ok := registry.FindDeviceByName("Luz Living")
+	if !ok {
+		t.Fatal("Luz Living missing after updates")
+	}
+	if living.State == nil || living.State.Describe(living.Name) != "Luz Living is on at 40%" {
+		t.Errorf("Luz Living state: got %+v", living.State)
+	}
+
+	if _, ok := registry.FindDeviceByName("Estufa"); ok {
+		t.Error("removed entity should be gone from the registry")
+	}
+
+	kitchen, ok := registry.FindDeviceByName("Luz Cocina")
+	if !ok {
+		t.Fatal("new entity should be added to the registry")
+	}
+	if kitchen.Online || kitchen.Type != domain.DeviceTypeLight {
+		t.Errorf("Luz Cocina: got online=%t type=%s", kitchen.Online, kitchen.Type)
+	}
+
+	if len(registry.GetScenes()) != 1 {
+		t.Errorf("scenes: got %d, want 1", len(registry.GetScenes()))
+	}
+}
+
+// Live updates must not change the devices callers already looked up, nor
+// race with the lookups. Run with -race.
+func TestRegistry_LiveUpdatesDontTouchLookedUpDevices(t *testing.T) {
+	states := []map[string]any{
+		{"entity_id": "switch.heater", "state": "on", "attributes": map[string]any{"friendly_name": "Estufa"}},
+		{"entity_id": "light.living", "state": "off", "attributes": map[string]any{"friendly_name": "Luz Living"}},
+		{"entity_id": "switch.fridge", "state": "on", "attributes": map[string]any{"friendly_name": "Heladera"}},
+	}
+	var events []map[string]any
+	for i := 0; i < 200; i++ {
+		state := "off"
+		if i%2 == 0 {
+			state = "on"
+		}
+		events = append(events, stateChanged("light.living", map[string]any{
+			"entity_id":  "light.living",
+			"state":      state,
+			"attributes": map[string]any{"friendly_name": "Luz Living"},
+		}))
+	}
+	// Removing the first device shifts the others down
+	events = append(events, stateChanged("switch.heater", nil))
+
+	server := fakeHomeAssistant(t, states, nil, events)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := homeassistant.NewRegistry(homeassistant.NewClient(server.URL, "test-token"), true, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := registry.Sync(ctx); err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+	living, ok := registry.FindDeviceByName("Luz Living")
+	if !ok {
+		t.Fatal("Luz Living missing")
+	}
+
+	registry.StartLiveUpdates(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d, ok := registry.FindDeviceByName("Luz Living")
+		if !ok || d.Name != "Luz Living" || d.ID != "light.living" {
+			t.Fatalf("lookup during updates: got %+v", d)
+		}
+		if _, ok := registry.FindDeviceByName("Estufa"); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := registry.FindDeviceByName("Estufa"); ok {
+		t.Fatal("Estufa should have been removed")
+	}
+
+	if living.ID != "light.living" || living.Name != "Luz Living" {
+		t.Errorf("device looked up before the updates changed to %s (%s)", living.Name, living.ID)
+	}
+}
+
+func TestRegistry_WithoutWebSocket(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/states", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"entity_id": "light.living", "state": "on", "attributes": map[string]any{"friendly_name": "Luz Living"}},
+		})
+	})
+	mux.HandleFunc("/api/websocket", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the WebSocket API is off and shouldn't be used")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := homeassistant.NewRegistry(homeassistant.NewClient(server.URL, "test-token"), false, logger)
+
+	if err := registry.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+	if _, ok := registry.FindDeviceByName("Luz Living"); !ok {
+		t.Error("Luz Living missing")
+	}
+}
+
+func TestRegistry_TunableWhiteLight(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/states", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"entity_id": "light.desk", "state": "on", "attributes": map[string]any{
+				"friendly_name": "Luz Escritorio", "supported_color_modes": []string{"color_temp"},
+			}},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := homeassistant.NewRegistry(homeassistant.NewClient(server.URL, "test-token"), false, logger)
+	if err := registry.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+
+	device, ok := registry.FindDeviceByName("Luz Escritorio")
+	if !ok {
+		t.Fatal("Luz Escritorio missing")
+	}
+	// "Poné la luz en blanco cálido" is set_color with a color temperature
+	if !device.Supports(domain.ActionSetColor) || !device.Supports(domain.ActionSetLevel) {
+		t.Errorf("capabilities: got %s, want set_level and set_color", device.Capabilities)
+	}
+}
+
+func TestRegistry_Areas(t *testing.T) {
+	states := []map[string]any{
+		{"entity_id": "light.bedroom", "state": "on", "attributes": map[string]any{"friendly_name": "Luz Dormitorio"}},
+		{"entity_id": "light.lamp", "state": "off", "attributes": map[string]any{"friendly_name": "Velador"}},
+		{"entity_id": "switch.garage", "state": "off", "attributes": map[string]any{"friendly_name": "Portón"}},
+	}
+	results := map[string]any{
+		"config/area_registry/list": []map[string]any{
+			{"area_id": "bedroom", "name": "Dormitorio"},
+			{"area_id": "living", "name": "Living"},
+		},
+		"config/device_registry/list": []map[string]any{
+			{"id": "hue-1", "area_id": "bedroom"},
+		},
+		"config/entity_registry/list": []map[string]any{
+			{"entity_id": "light.bedroom", "device_id": "hue-1", "area_id": nil},
+			{"entity_id": "light.lamp", "device_id": "hue-1", "area_id": "living"},
+			{"entity_id": "switch.garage", "device_id": nil, "area_id": nil},
+		},
+	}
+
+	server := fakeHomeAssistant(t, states, results, nil)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	registry := homeassistant.NewRegistry(homeassistant.NewClient(server.URL, "test-token"), true, logger)
+
+	if err := registry.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync error: %v", err)
+	}
+
+	want := map[string]string{
+		"Luz Dormitorio": "Dormitorio",
+		"Velador":        "Living",
+		"Portón":         "",
+	}
+	for name, area := range want {
+		device, ok := registry.FindDeviceByName(name)
+		if !ok {
+			t.Fatalf("%s missing", name)
+		}
+		if device.Area != area {
+			t.Errorf("%s area: got %q, want %q", name, device.Area, area)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"smart-home/internal/infra/websocket"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout  = 90 * time.Second
+)
+
+// WSClient talks to the Home Assistant WebSocket API (/api/websocket).
+type WSClient struct {
+	url   string
+	token string
+}
+
+func NewWSClient(baseURL, token string) *WSClient {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	wsURL := baseURL
+	switch {
+	case strings.HasPrefix(baseURL, "https://"):
+		wsURL = "wss://" + strings.TrimPrefix(baseURL, "https://")
+	case strings.HasPrefix(baseURL, "http://"):
+		wsURL = "ws://" + strings.TrimPrefix(baseURL, "http://")
+	}
+
+	return &WSClient{
+		url:   wsURL + "/api/websocket",
+		token: token,
+	}
+}
+
+type wsMessage struct {
+	ID      int64           `json:"id"`
+	Type    string          `json:"type"`
+	Success bool            `json:"success"`
+	Result  json.RawMessage `json:"result"`
+	Event   json.RawMessage `json:"event"`
+	Message string          `json:"message"`
+	Error   *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// wsSession is an authenticated WebSocket connection.
+type wsSession struct {
+	conn   *websocket.Conn
+	nextID atomic.Int64
+}
+
+func (c *WSClient) connect(ctx context.Context) (*wsSession, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	conn, err := websocket.Dial(dialCtx, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", c.url, err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading auth request: %w", err)
+	}
+	if msg.Type != "auth_required" {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected message %q", msg.Type)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "auth", "access_token": c.token}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending auth: %w", err)
+	}
+
+	if err := conn.ReadJSON(&msg); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading auth result: %w", err)
+	}
+	if msg.Type != "auth_ok" {
+		conn.Close()
+		return nil, fmt.Errorf("unauthorized: check your Home Assistant token (%s)", msg.Message)
+	}
+
+	return &wsSession{conn: conn}, nil
+}
+
+func (s *wsSession) send(msgType string, params map[string]any) (int64, error) {
+	id := s.nextID.Add(1)
+
+	msg := map[string]any{"id": id, "type": msgType}
+	for k, v := range params {
+		msg[k] = v
+	}
+
+	if err := s.conn.WriteJSON(msg); err != nil {
+		return 0, fmt.Errorf("sending %s: %w", msgType, err)
+	}
+	return id, nil
+}
+
+// call sends a command and waits for its result, skipping any events that
+// arrive in between.
+func (s *wsSession) call(msgType string, params map[string]any) (json.RawMessage, error) {
+	id, err := s.send(msgType, params)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var msg wsMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			return nil, fmt.Errorf("reading %s result: %w", msgType, err)
+		}
+		if msg.Type != "result" || msg.ID != id {
+			continue
+		}
+		if !msg.Success {
+			if msg.Error != nil {
+				return nil, fmt.Errorf("%s failed: %s", msgType, msg.Error.Message)
+			}
+			return nil, fmt.Errorf("%s failed", msgType)
+		}
+		return msg.Result, nil
+	}
+}
+
+// Subscribe streams events of the given type to handle until ctx is done or
+// the connection drops. onConnect runs once the subscription is in place.
+func (c *WSClient) Subscribe(ctx context.Context, eventType string, onConnect func(), handle func(event json.RawMessage)) error {
+	s, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.conn.Close()
+
+	if _, err := s.call("subscribe_events", map[string]any{"event_type": eventType}); err != nil {
+		return err
+	}
+
+	if onConnect != nil {
+		onConnect()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Home Assistant stays quiet while nothing changes, so ping it to notice
+	// dead connections
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				s.conn.Close()
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				s.send("ping", nil)
+			}
+		}
+	}()
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+		var msg wsMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("reading event: %w", err)
+		}
+
+		if msg.Type == "event" {
+			handle(msg.Event)
+		}
+	}
+}
+
+// EntityAreas returns the area name of every entity assigned to one, either
+// directly or through the device it belongs to. The area, device and entity
+// registries are only exposed over the WebSocket API.
+func (c *WSClient) EntityAreas(ctx context.Context) (map[string]string, error) {
+	s, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.conn.Close()
+
+	s.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+
+	var areas []struct {
+		AreaID string `json:"area_id"`
+		Name   string `json:"name"`
+	}
+	if err := s.callInto("config/area_registry/list", &areas); err != nil {
+		return nil, err
+	}
+
+	var devices []struct {
+		ID     string  `json:"id"`
+		AreaID *string `json:"area_id"`
+	}
+	if err := s.callInto("config/device_registry/list", &devices); err != nil {
+		return nil, err
+	}
+
+	var entities []struct {
+		EntityID string  `json:"entity_id"`
+		DeviceID *string `json:"device_id"`
+		AreaID   *string `json:"area_id"`
+	}
+	if err := s.callInto("config/entity_registry/list", &entities); err != nil {
+		return nil, err
+	}
+
+	areaNames := make(map[string]string, len(areas))
+	for _, a := range areas {
+		areaNames[a.AreaID] = a.Name
+	}
+
+	deviceAreas := make(map[string]string, len(devices))
+	for _, d := range devices {
+		if d.AreaID != nil {
+			deviceAreas[d.ID] = *d.AreaID
+		}
+	}
+
+	result := make(map[string]string)
+	for _, e := range entities {
+		// An area set on the entity overrides the one of its device
+		areaID := ""
+		if e.AreaID != nil {
+			areaID = *e.AreaID
+		} else if e.DeviceID != nil {
+			areaID = deviceAreas[*e.DeviceID]
+		}
+
+		if name, ok := areaNames[areaID]; ok {
+			result[e.EntityID] = name
+		}
+	}
+
+	return result, nil
+}
+
+func (s *wsSession) callInto(msgType string, v any) error {
+	result, err := s.call(msgType, nil)
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(result, v); err != nil {
+		return fmt.Errorf("parsing %s result: %w", msgType, err)
+	}
+	return nil
+}
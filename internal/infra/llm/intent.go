@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"smart-home/internal/domain"
+)
+
+// Intent is a command as the prompt asks models without tool calling to
+// write it.
+type Intent struct {
+	Action     string         `json:"action"`
+	TargetName string         `json:"target_name"`
+	TargetType string         `json:"target_type"`
+	DeviceType string         `json:"device_type"`
+	Parameters map[string]any `json:"parameters"`
+	Confidence float64        `json:"confidence"`
+}
+
+type intents struct {
+	Commands []Intent `json:"commands"`
+}
+
+func (i Intent) Command(text string) *domain.Command {
+	return &domain.Command{
+		Action:     domain.Action(i.Action),
+		TargetName: i.TargetName,
+		TargetType: domain.TargetType(i.TargetType),
+		DeviceType: domain.DeviceType(i.DeviceType),
+		Parameters: i.Parameters,
+		RawText:    text,
+		Confidence: i.Confidence,
+	}
+}
+
+// ParseJSON reads the commands out of a JSON answer. It tolerates the
+// answer wrapped in a code block and a bare intent without the "commands"
+// wrapper, which smaller models often produce.
+func ParseJSON(answer, text string) ([]*domain.Command, error) {
+	answer = strings.TrimSpace(answer)
+	answer = strings.TrimPrefix(answer, "```json")
+	answer = strings.TrimPrefix(answer, "```")
+	answer = strings.TrimSuffix(answer, "```")
+	answer = strings.TrimSpace(answer)
+
+	var parsed intents
+	if err := json.Unmarshal([]byte(answer), &parsed); err != nil {
+		return nil, fmt.Errorf("parsing intent JSON (%s): %w", answer, err)
+	}
+
+	if len(parsed.Commands) == 0 {
+		var intent Intent
+		if err := json.Unmarshal([]byte(answer), &intent); err == nil && intent.Action != "" {
+			parsed.Commands = []Intent{intent}
+		}
+	}
+
+	if len(parsed.Commands) == 0 {
+		return nil, fmt.Errorf("no commands in answer: %s", answer)
+	}
+
+	cmds := make([]*domain.Command, 0, len(parsed.Commands))
+	for _, intent := range parsed.Commands {
+		cmds = append(cmds, intent.Command(text))
+	}
+	return cmds, nil
+}
@@ -0,0 +1,139 @@
+package llm
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+//go:embed prompt.tmpl
+var defaultTemplate string
+
+// Prompt builds the system prompt of the LLM parsers from a text/template,
+// so it can be tuned from a file without recompiling.
+type Prompt struct {
+	tmpl *template.Template
+}
+
+// PromptData is what the template is executed with.
+type PromptData struct {
+	Devices     []PromptDevice
+	Areas       []string
+	Scenes      []string
+	Turns       []PromptTurn
+	LastTargets []string
+	// Now is the current local time, for commands like "at 7"
+	Now string
+	// Actions and DeviceTypes are the values the intent schema accepts
+	Actions     []string
+	DeviceTypes []string
+	// ToolCalling is set for clients that get the answer as tool calls;
+	// the others need the prompt to spell out the JSON to answer with.
+	ToolCalling bool
+}
+
+type PromptDevice struct {
+	Name string
+	Type string
+	Area string
+	// Status is "online" or "offline", followed by the state when known
+	Status string
+	// Actions lists the capabilities, e.g. "turn_on, set_level (level 0-100)"
+	Actions string
+}
+
+type PromptTurn struct {
+	Text   string
+	Result string
+}
+
+var funcs = template.FuncMap{"join": strings.Join}
+
+// DefaultPrompt returns the built-in prompt.
+func DefaultPrompt() *Prompt {
+	return &Prompt{tmpl: template.Must(template.New("prompt").Funcs(funcs).Parse(defaultTemplate))}
+}
+
+// LoadPrompt reads a prompt template from a file, or returns the built-in
+// one when path is empty.
+func LoadPrompt(path string) (*Prompt, error) {
+	if path == "" {
+		return DefaultPrompt(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading prompt template: %w", err)
+	}
+	return ParsePrompt(string(data))
+}
+
+func ParsePrompt(text string) (*Prompt, error) {
+	tmpl, err := template.New("prompt").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing prompt template: %w", err)
+	}
+	return &Prompt{tmpl: tmpl}, nil
+}
+
+// Build renders the prompt for the current devices, scenes and
+// conversation.
+func (p *Prompt) Build(registry application.DeviceRegistry, conv *application.Conversation, toolCalling bool) (string, error) {
+	var sb strings.Builder
+	if err := p.tmpl.Execute(&sb, promptData(registry, conv, toolCalling)); err != nil {
+		return "", fmt.Errorf("rendering prompt: %w", err)
+	}
+	return sb.String(), nil
+}
+
+func promptData(registry application.DeviceRegistry, conv *application.Conversation, toolCalling bool) PromptData {
+	data := PromptData{
+		Now:         time.Now().Format("Monday 15:04"),
+		DeviceTypes: deviceTypes,
+		ToolCalling: toolCalling,
+	}
+
+	for _, d := range registry.GetDevices() {
+		status := "offline"
+		if d.Online {
+			status = "online"
+			if d.State != nil && d.State.Status != "" {
+				status += ", " + d.State.Summary()
+			}
+		}
+		data.Devices = append(data.Devices, PromptDevice{
+			Name:    d.Name,
+			Type:    string(d.Type),
+			Area:    d.Area,
+			Status:  status,
+			Actions: d.Capabilities.String(),
+		})
+		data.Areas = appendUnique(data.Areas, d.Area)
+	}
+	for _, s := range registry.GetScenes() {
+		data.Scenes = append(data.Scenes, s.Name)
+	}
+
+	if conv != nil {
+		for _, t := range conv.Turns {
+			data.Turns = append(data.Turns, PromptTurn{
+				Text:   t.Text,
+				Result: strings.ReplaceAll(t.Result, "\n", "; "),
+			})
+		}
+		data.LastTargets = conv.LastTargets
+	}
+
+	for _, spec := range actions {
+		data.Actions = append(data.Actions, string(spec.action))
+	}
+	data.Actions = append(data.Actions, string(domain.ActionListTimers), string(domain.ActionCancelTimer), string(domain.ActionUnknown))
+
+	return data
+}
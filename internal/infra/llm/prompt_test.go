@@ -0,0 +1,74 @@
+package llm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"smart-home/internal/application"
+	"smart-home/internal/infra/llm"
+)
+
+func TestPrompt_Build(t *testing.T) {
+	conv := &application.Conversation{
+		Turns:       []application.Turn{{Text: "prendé la luz del living", Result: "Luz Living: on\nLuz Cocina: off"}},
+		LastTargets: []string{"Luz Living"},
+	}
+
+	prompt, err := llm.DefaultPrompt().Build(&mockRegistry{}, conv, false)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+
+	for _, want := range []string{
+		"- Luz Living (type: light, area: Living, status: offline)",
+		"- Velador (type: light, area: Living, status: online, off, actions: turn_on, turn_off, set_level)",
+		"## Areas:\n- Living\n- Dormitorio\n",
+		"## Scenes:\n- Película\n",
+		"- User: prendé la luz del living\n  Result: Luz Living: on; Luz Cocina: off\n",
+		"Last target mentioned: Luz Living",
+		`"action": "turn_on|turn_off|set_level|`,
+		`|list_timers|cancel_timer|unknown"`,
+		"Current time: ",
+		"use the user's words as target_name",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt should contain %q:\n%s", want, prompt)
+		}
+	}
+
+	tools, err := llm.DefaultPrompt().Build(&mockRegistry{}, nil, true)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if strings.Contains(tools, "Respond ONLY with valid JSON") || strings.Contains(tools, "Recent conversation") {
+		t.Errorf("tool calling prompt should not ask for JSON nor list an empty conversation:\n%s", tools)
+	}
+}
+
+func TestLoadPrompt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.tmpl")
+	if err := os.WriteFile(path, []byte(`Casa: {{range .Devices}}{{.Name}};{{end}} {{if .ToolCalling}}tools{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	prompt, err := llm.LoadPrompt(path)
+	if err != nil {
+		t.Fatalf("LoadPrompt error: %v", err)
+	}
+	got, err := prompt.Build(&mockRegistry{}, nil, true)
+	if err != nil {
+		t.Fatalf("Build error: %v", err)
+	}
+	if got != "Casa: Luz Living;Aire Acondicionado;Velador; tools" {
+		t.Errorf("got %q", got)
+	}
+
+	if _, err := llm.LoadPrompt(filepath.Join(t.TempDir(), "missing.tmpl")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if _, err := llm.ParsePrompt("{{.Devices"); err == nil {
+		t.Error("expected an error for a broken template")
+	}
+}
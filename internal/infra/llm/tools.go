@@ -0,0 +1,229 @@
+// Package llm holds what the LLM intent parsers share so they all behave
+// the same: the prompt, the schema of the actions they may call and the
+// normalisation and validation of what comes back.
+package llm
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// Tool is one action the model can call, with the JSON schema of its
+// arguments, or nil when it takes none: Gemini rejects object schemas
+// without properties. Claude takes it as a tool and Gemini as a function
+// declaration.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+}
+
+// Argument names every tool shares. The rest of the arguments become the
+// command's Parameters.
+const (
+	argTargetName = "target_name"
+	argTargetType = "target_type"
+	argDeviceType = "device_type"
+	argConfidence = "confidence"
+)
+
+// Timing parameters, moved to the command's Delay, At and Duration by
+// Validate.
+const (
+	paramDelay    = "delay_minutes"
+	paramAt       = "at"
+	paramDuration = "duration_minutes"
+)
+
+var confidence = map[string]any{"type": "number", "minimum": 0, "maximum": 1, "description": "How sure you are of the interpretation, 0-1"}
+
+var timing = map[string]any{
+	paramDelay:    map[string]any{"type": "number", "description": `Only to postpone the command: minutes from now ("in half an hour" is 30)`},
+	paramAt:       map[string]any{"type": "string", "description": `Only to run the command at a time of the day, 24-hour "HH:MM" local time`},
+	paramDuration: map[string]any{"type": "number", "description": `Only for commands that last a while ("for 10 minutes"): minutes until they are undone`},
+}
+
+// HVACModes are the values accepted for "hvac_mode".
+var HVACModes = []string{"off", "heat", "cool", "auto", "dry", "fan_only", "heat_cool"}
+
+var deviceTypes = []string{
+	string(domain.DeviceTypeLight), string(domain.DeviceTypeSwitch), string(domain.DeviceTypePlug),
+	string(domain.DeviceTypeThermostat), string(domain.DeviceTypeCover), string(domain.DeviceTypeFan),
+	string(domain.DeviceTypeLock), string(domain.DeviceTypeMediaPlayer), string(domain.DeviceTypeVacuum),
+}
+
+type actionSpec struct {
+	action      domain.Action
+	description string
+	params      map[string]any
+	required    []string
+}
+
+var actions = []actionSpec{
+	{domain.ActionTurnOn, "Turn a device or every device of an area on", gang(), nil},
+	{domain.ActionTurnOff, "Turn a device or every device of an area off", gang(), nil},
+	{domain.ActionSetLevel, "Set the brightness of a light or the speed of a fan, or change it by an amount",
+		map[string]any{"level": percent("Brightness or speed, 0-100"), domain.ParamDelta: delta("percentage points")}, nil},
+	{domain.ActionSetColor, "Set the colour of a light, by name or hex code, or its white temperature",
+		map[string]any{
+			"color":      map[string]any{"type": "string", "description": `Colour name in English ("red") or hex code ("#ff8800")`},
+			"color_temp": map[string]any{"type": "integer", "description": "White temperature in Kelvin, e.g. 2700"},
+		}, nil},
+	{domain.ActionOpen, "Open a blind or curtain", nil, nil},
+	{domain.ActionClose, "Close a blind or curtain", nil, nil},
+	{domain.ActionSetPosition, "Move a blind or curtain to a position, or by an amount",
+		map[string]any{"position": percent("Position, 0 closed to 100 open"), domain.ParamDelta: delta("percentage points")}, nil},
+	{domain.ActionLock, "Lock a lock", nil, nil},
+	{domain.ActionUnlock, "Unlock a lock", nil, nil},
+	{domain.ActionSetTemperature, "Set the target temperature of a thermostat or air conditioner, or change it by an amount",
+		map[string]any{"temperature": map[string]any{"type": "number", "description": "Temperature in °C"}, domain.ParamDelta: delta("°C")}, nil},
+	{domain.ActionSetHVACMode, "Set the mode of a thermostat or air conditioner",
+		map[string]any{"hvac_mode": map[string]any{"type": "string", "enum": HVACModes}}, []string{"hvac_mode"}},
+	{domain.ActionPlay, "Play on a speaker or TV", nil, nil},
+	{domain.ActionPause, "Pause a speaker or TV", nil, nil},
+	{domain.ActionSetVolume, "Set the volume of a speaker or TV, or change it by an amount",
+		map[string]any{"volume": percent("Volume, 0-100"), domain.ParamDelta: delta("percentage points")}, nil},
+	{domain.ActionStart, "Start a vacuum cleaning", nil, nil},
+	{domain.ActionDock, "Send a vacuum back to its base", nil, nil},
+	{domain.ActionRunScene, "Run a scene", nil, nil},
+	{domain.ActionGetStatus, "Answer a question about a device or area: is it on, how bright, what temperature", nil, nil},
+}
+
+func percent(description string) map[string]any {
+	return map[string]any{"type": "integer", "minimum": 0, "maximum": 100, "description": description}
+}
+
+// delta describes the relative alternative to an absolute value, for
+// "a bit brighter" or "two degrees warmer".
+func delta(unit string) map[string]any {
+	return map[string]any{"type": "number", "description": "Instead of an absolute value: how much to raise (positive) or lower (negative) it, in " + unit}
+}
+
+func gang() map[string]any {
+	return map[string]any{
+		"switch": map[string]any{"type": "integer", "minimum": 1, "description": "Gang of a multi-gang switch, only to control a single one"},
+	}
+}
+
+// Tools returns one tool per action, with the device, area and scene names
+// of the registry as the only accepted targets, plus an "unknown" tool for
+// requests that aren't a smart home command.
+func Tools(registry application.DeviceRegistry) []Tool {
+	var deviceNames, sceneNames []string
+	for _, d := range registry.GetDevices() {
+		deviceNames = appendUnique(deviceNames, d.Name)
+	}
+	for _, d := range registry.GetDevices() {
+		deviceNames = appendUnique(deviceNames, d.Area)
+	}
+	for _, s := range registry.GetScenes() {
+		sceneNames = appendUnique(sceneNames, s.Name)
+	}
+
+	tools := make([]Tool, 0, len(actions)+3)
+	for _, spec := range actions {
+		names, types := deviceNames, []string{string(domain.TargetTypeDevice), string(domain.TargetTypeArea)}
+		if spec.action == domain.ActionRunScene {
+			names, types = sceneNames, []string{string(domain.TargetTypeScene)}
+		}
+
+		properties := map[string]any{
+			argTargetName: enum("Exact name of the device, area or scene", names),
+			argTargetType: enum("", types),
+			argConfidence: confidence,
+		}
+		if spec.action != domain.ActionRunScene {
+			properties[argDeviceType] = enum("Only for areas: limit the command to one kind of device", deviceTypes)
+		}
+		if spec.action != domain.ActionGetStatus {
+			maps.Copy(properties, timing)
+		}
+		maps.Copy(properties, spec.params)
+
+		tools = append(tools, Tool{
+			Name:        string(spec.action),
+			Description: spec.description,
+			Parameters: map[string]any{
+				"type":       "object",
+				"properties": properties,
+				"required":   append([]string{argTargetName, argTargetType}, spec.required...),
+			},
+		})
+	}
+
+	return append(tools, Tool{
+		Name:        string(domain.ActionListTimers),
+		Description: "List the pending timers: commands postponed or set to be undone later",
+	}, Tool{
+		Name:        string(domain.ActionCancelTimer),
+		Description: "Cancel the pending timers of a device, area or scene, or all of them when no target is given",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				argTargetName: enum("Exact name of the device, area or scene whose timers to cancel", append(slices.Clone(deviceNames), sceneNames...)),
+				argConfidence: confidence,
+			},
+		},
+	}, Tool{
+		Name:        string(domain.ActionUnknown),
+		Description: "Call this when the request isn't a smart home command or you can't tell what to do",
+	})
+}
+
+func enum(description string, values []string) map[string]any {
+	schema := map[string]any{"type": "string"}
+	if description != "" {
+		schema["description"] = description
+	}
+	// An empty enum is invalid JSON schema; an empty registry accepts any name
+	if len(values) > 0 {
+		schema["enum"] = values
+	}
+	return schema
+}
+
+func appendUnique(names []string, name string) []string {
+	if name == "" {
+		return names
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// FromToolCall turns a call to one of the Tools into a command.
+func FromToolCall(name string, args map[string]any, text string) *domain.Command {
+	cmd := &domain.Command{
+		Action:  domain.Action(name),
+		RawText: text,
+	}
+
+	for key, value := range args {
+		switch key {
+		case argTargetName:
+			cmd.TargetName, _ = value.(string)
+		case argTargetType:
+			s, _ := value.(string)
+			cmd.TargetType = domain.TargetType(s)
+		case argDeviceType:
+			s, _ := value.(string)
+			cmd.DeviceType = domain.DeviceType(s)
+		case argConfidence:
+			cmd.Confidence, _ = value.(float64)
+		default:
+			if cmd.Parameters == nil {
+				cmd.Parameters = make(map[string]any)
+			}
+			cmd.Parameters[key] = value
+		}
+	}
+
+	return cmd
+}
@@ -0,0 +1,222 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// Validate normalises the commands returned by a model and checks them
+// before they reach the assistant: the action must exist, the target must
+// be in the registry and the parameters the action needs must be there with
+// the right type. An error lets the caller fall back to another parser
+// instead of executing a hallucinated command.
+func Validate(cmds []*domain.Command, registry application.DeviceRegistry) error {
+	if len(cmds) == 0 {
+		return errors.New("no commands")
+	}
+
+	now := time.Now()
+	var errs []error
+	for i, cmd := range cmds {
+		normalize(cmd, registry)
+		err := validate(cmd, registry)
+		if err == nil {
+			err = applyTiming(cmd, now)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("command %d (%s %q): %w", i+1, cmd.Action, cmd.TargetName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// numericParams are the parameters models sometimes quote: {"level": "50"}.
+var numericParams = []string{"level", "position", "volume", "temperature", "color_temp", "switch", domain.ParamDelta, paramDelay, paramDuration}
+
+// normalize fixes what models get loosely right: casing, a missing target
+// type, numbers written as strings, and scenes "turned on" (or devices
+// "run"), the same way the rule-based parser reads them.
+func normalize(cmd *domain.Command, registry application.DeviceRegistry) {
+	cmd.Action = domain.Action(strings.ToLower(strings.TrimSpace(string(cmd.Action))))
+	cmd.TargetType = domain.TargetType(strings.ToLower(strings.TrimSpace(string(cmd.TargetType))))
+	cmd.DeviceType = domain.DeviceType(strings.ToLower(strings.TrimSpace(string(cmd.DeviceType))))
+	cmd.TargetName = strings.TrimSpace(cmd.TargetName)
+	if cmd.Action == domain.ActionUnknown || cmd.Action == domain.ActionListTimers || cmd.TargetName == "" {
+		return
+	}
+
+	if cmd.TargetType == "" {
+		if _, ok := registry.FindDeviceByName(cmd.TargetName); ok {
+			cmd.TargetType = domain.TargetTypeDevice
+		} else if _, ok := registry.FindSceneByName(cmd.TargetName); ok {
+			cmd.TargetType = domain.TargetTypeScene
+		} else if hasArea(registry.GetDevices(), cmd.TargetName) {
+			cmd.TargetType = domain.TargetTypeArea
+		}
+	}
+
+	switch {
+	case cmd.TargetType == domain.TargetTypeScene && cmd.Action == domain.ActionTurnOn:
+		cmd.Action = domain.ActionRunScene
+	case cmd.TargetType != domain.TargetTypeScene && cmd.Action == domain.ActionRunScene:
+		cmd.Action = domain.ActionTurnOn
+	}
+
+	// Exact names take the registry's spelling; partial ones are left for
+	// the assistant to ask about
+	switch cmd.TargetType {
+	case domain.TargetTypeDevice:
+		if d, ok := registry.FindDeviceByName(cmd.TargetName); ok && strings.EqualFold(d.Name, cmd.TargetName) {
+			cmd.TargetName = d.Name
+		}
+	case domain.TargetTypeScene:
+		if s, ok := registry.FindSceneByName(cmd.TargetName); ok && strings.EqualFold(s.Name, cmd.TargetName) {
+			cmd.TargetName = s.Name
+		}
+	}
+
+	for _, name := range numericParams {
+		if s, ok := cmd.Parameters[name].(string); ok {
+			if v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64); err == nil {
+				cmd.Parameters[name] = v
+			}
+		}
+	}
+	if mode, ok := cmd.Parameters["hvac_mode"].(string); ok {
+		cmd.Parameters["hvac_mode"] = strings.ToLower(strings.TrimSpace(mode))
+	}
+}
+
+func validate(cmd *domain.Command, registry application.DeviceRegistry) error {
+	if !cmd.Action.Valid() {
+		return errors.New("unknown action")
+	}
+	switch cmd.Action {
+	case domain.ActionUnknown, domain.ActionListTimers, domain.ActionCancelTimer:
+		// The timers to cancel are matched loosely by target name
+		return nil
+	}
+
+	switch cmd.TargetType {
+	case domain.TargetTypeScene:
+		if cmd.Action != domain.ActionRunScene {
+			return errors.New("scenes can only be run")
+		}
+		if _, ok := registry.FindSceneByName(cmd.TargetName); !ok {
+			return errors.New("no such scene")
+		}
+	case domain.TargetTypeDevice:
+		if _, ok := registry.FindDeviceByName(cmd.TargetName); !ok {
+			return errors.New("no such device")
+		}
+	case domain.TargetTypeArea:
+		if !hasArea(registry.GetDevices(), cmd.TargetName) {
+			return errors.New("no such area")
+		}
+		if cmd.DeviceType != "" && !slices.Contains(deviceTypes, string(cmd.DeviceType)) {
+			return fmt.Errorf("unknown device type %q", cmd.DeviceType)
+		}
+	default:
+		return fmt.Errorf("unknown target type %q", cmd.TargetType)
+	}
+
+	return validateParameters(cmd)
+}
+
+func validateParameters(cmd *domain.Command) error {
+	switch cmd.Action {
+	case domain.ActionSetLevel:
+		return percentParam(cmd, "level")
+	case domain.ActionSetPosition:
+		return percentParam(cmd, "position")
+	case domain.ActionSetVolume:
+		return percentParam(cmd, "volume")
+	case domain.ActionSetTemperature:
+		_, absolute := cmd.Parameters["temperature"].(float64)
+		if _, relative := cmd.Delta(); !absolute && !relative {
+			return errors.New(`missing numeric "temperature" or "delta"`)
+		}
+	case domain.ActionSetHVACMode:
+		mode, _ := cmd.Parameters["hvac_mode"].(string)
+		if !slices.Contains(HVACModes, mode) {
+			return fmt.Errorf("unknown hvac_mode %q", mode)
+		}
+	case domain.ActionSetColor:
+		color, _ := cmd.Parameters["color"].(string)
+		_, temp := cmd.Parameters["color_temp"].(float64)
+		if color == "" && !temp {
+			return errors.New(`missing "color" or numeric "color_temp"`)
+		}
+	}
+	return nil
+}
+
+// percentParam checks a percentage, or the delta of a relative adjustment
+// in its place.
+func percentParam(cmd *domain.Command, name string) error {
+	v, ok := cmd.Parameters[name].(float64)
+	if !ok {
+		delta, relative := cmd.Delta()
+		if !relative {
+			return fmt.Errorf("missing numeric %q or %q", name, domain.ParamDelta)
+		}
+		if delta < -100 || delta > 100 {
+			return fmt.Errorf("%q out of range: %v", domain.ParamDelta, delta)
+		}
+		return nil
+	}
+	if v < 0 || v > 100 {
+		return fmt.Errorf("%q out of range: %v", name, v)
+	}
+	return nil
+}
+
+// applyTiming moves the timing parameters of a command to its Delay, At
+// and Duration.
+func applyTiming(cmd *domain.Command, now time.Time) error {
+	if v, ok := cmd.Parameters[paramAt]; ok {
+		s, _ := v.(string)
+		at, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not a time of the day: %v", paramAt, v)
+		}
+		cmd.At = application.NextAt(now, at.Hour(), at.Minute())
+		delete(cmd.Parameters, paramAt)
+	}
+
+	for name, d := range map[string]*time.Duration{paramDelay: &cmd.Delay, paramDuration: &cmd.Duration} {
+		v, ok := cmd.Parameters[name]
+		if !ok {
+			continue
+		}
+		minutes, _ := v.(float64)
+		if minutes <= 0 {
+			return fmt.Errorf("%q must be a positive number of minutes: %v", name, v)
+		}
+		*d = time.Duration(minutes * float64(time.Minute)).Round(time.Second)
+		delete(cmd.Parameters, name)
+	}
+	return nil
+}
+
+// hasArea matches the area like the assistant does: case-insensitively,
+// falling back to a substring.
+func hasArea(devices []domain.Device, name string) bool {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == "" {
+		return false
+	}
+	for _, d := range devices {
+		if d.Area != "" && strings.Contains(strings.ToLower(d.Area), key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package llm_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/llm"
+)
+
+type mockRegistry struct{}
+
+var devices = []domain.Device{
+	{ID: "1", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living"},
+	{ID: "2", Name: "Aire Acondicionado", Type: domain.DeviceTypeThermostat, Area: "Dormitorio"},
+	{
+		ID: "3", Name: "Velador", Type: domain.DeviceTypeLight, Area: "Living", Online: true,
+		State:        &domain.DeviceState{Status: "off"},
+		Capabilities: capabilities(domain.ActionTurnOn, domain.ActionTurnOff, domain.ActionSetLevel),
+	},
+}
+
+func capabilities(actions ...domain.Action) *domain.Capabilities {
+	caps := &domain.Capabilities{}
+	caps.Add(actions...)
+	return caps
+}
+
+func (m *mockRegistry) Sync(_ context.Context) error { return nil }
+func (m *mockRegistry) GetDevices() []domain.Device  { return devices }
+func (m *mockRegistry) GetScenes() []domain.Scene {
+	return []domain.Scene{{ID: "s1", Name: "Película"}}
+}
+func (m *mockRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
+	for i, d := range devices {
+		if strings.Contains(strings.ToLower(d.Name), strings.ToLower(name)) {
+			return &devices[i], true
+		}
+	}
+	return nil, false
+}
+func (m *mockRegistry) FindSceneByName(name string) (*domain.Scene, bool) {
+	if !strings.EqualFold(name, "Película") {
+		return nil, false
+	}
+	return &domain.Scene{ID: "s1", Name: "Película"}, true
+}
+func (m *mockRegistry) StartPeriodicSync(_ context.Context, _ time.Duration) {}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cmd   *domain.Command
+		valid bool
+	}{
+		{"device", llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device"}, ""), true},
+		{"partial device name", llm.FromToolCall("turn_off", map[string]any{"target_name": "aire", "target_type": "device"}, ""), true},
+		{"area with device type", llm.FromToolCall("turn_off", map[string]any{"target_name": "living", "target_type": "area", "device_type": "light"}, ""), true},
+		{"scene", llm.FromToolCall("run_scene", map[string]any{"target_name": "Película", "target_type": "scene"}, ""), true},
+		{"unknown", llm.FromToolCall("unknown", nil, ""), true},
+		{"hvac mode", llm.FromToolCall("set_hvac_mode", map[string]any{"target_name": "Aire Acondicionado", "target_type": "device", "hvac_mode": "cool"}, ""), true},
+		{"colour temperature", llm.FromToolCall("set_color", map[string]any{"target_name": "Luz Living", "target_type": "device", "color_temp": 2700.0}, ""), true},
+		{"relative level", llm.FromToolCall("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device", "delta": -10.0}, ""), true},
+		{"relative temperature", llm.FromToolCall("set_temperature", map[string]any{"target_name": "Aire Acondicionado", "target_type": "device", "delta": 2.0}, ""), true},
+		{"list timers", llm.FromToolCall("list_timers", nil, ""), true},
+		{"cancel every timer", llm.FromToolCall("cancel_timer", nil, ""), true},
+		{"time of the day", llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device", "at": "19:30"}, ""), true},
+		{"made-up action", llm.FromToolCall("blink", map[string]any{"target_name": "Luz Living", "target_type": "device"}, ""), false},
+		{"made-up area", llm.FromToolCall("turn_off", map[string]any{"target_name": "Garage", "target_type": "area"}, ""), false},
+		{"made-up device type", llm.FromToolCall("turn_off", map[string]any{"target_name": "Living", "target_type": "area", "device_type": "toaster"}, ""), false},
+		{"made-up target", llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Baño"}, ""), false},
+		{"level out of range", llm.FromToolCall("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device", "level": 150.0}, ""), false},
+		{"level as text", llm.FromToolCall("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device", "level": "mucho"}, ""), false},
+		{"no temperature nor delta", llm.FromToolCall("set_temperature", map[string]any{"target_name": "Aire Acondicionado", "target_type": "device"}, ""), false},
+		{"made-up hvac mode", llm.FromToolCall("set_hvac_mode", map[string]any{"target_name": "Aire Acondicionado", "target_type": "device", "hvac_mode": "turbo"}, ""), false},
+		{"time as text", llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device", "at": "tonight"}, ""), false},
+		{"negative delay", llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device", "delay_minutes": -5.0}, ""), false},
+		{"scene turned off", llm.FromToolCall("turn_off", map[string]any{"target_name": "Película", "target_type": "scene"}, ""), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := llm.Validate([]*domain.Command{tt.cmd}, &mockRegistry{})
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidate_Normalizes(t *testing.T) {
+	cmds, err := llm.ParseJSON("```json\n"+`{"commands":[
+		{"action":"Set_Level","target_name":"luz living","parameters":{"level":"40%"}},
+		{"action":"turn_on","target_name":"película","target_type":"scene"}
+	]}`+"\n```", "poné la luz al 40 y la película")
+	if err != nil {
+		t.Fatalf("ParseJSON error: %v", err)
+	}
+	if err := llm.Validate(cmds, &mockRegistry{}); err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+
+	if got := cmds[0]; got.Action != domain.ActionSetLevel || got.TargetName != "Luz Living" || got.TargetType != domain.TargetTypeDevice || got.Parameters["level"] != 40.0 {
+		t.Errorf("first command: got %s %q (%s) %v", got.Action, got.TargetName, got.TargetType, got.Parameters)
+	}
+	if got := cmds[1]; got.Action != domain.ActionRunScene || got.TargetName != "Película" {
+		t.Errorf("second command: got %s %q", got.Action, got.TargetName)
+	}
+}
+
+func TestValidate_Timing(t *testing.T) {
+	cmds := []*domain.Command{
+		llm.FromToolCall("turn_off", map[string]any{"target_name": "Aire Acondicionado", "target_type": "device", "delay_minutes": "30"}, ""),
+		llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device", "duration_minutes": 0.5}, ""),
+		llm.FromToolCall("turn_on", map[string]any{"target_name": "Luz Living", "target_type": "device", "at": "07:15"}, ""),
+	}
+	if err := llm.Validate(cmds, &mockRegistry{}); err != nil {
+		t.Fatalf("Validate error: %v", err)
+	}
+
+	if cmds[0].Delay != 30*time.Minute || len(cmds[0].Parameters) != 0 {
+		t.Errorf("delay: got %v %v", cmds[0].Delay, cmds[0].Parameters)
+	}
+	if cmds[1].Duration != 30*time.Second {
+		t.Errorf("duration: got %v", cmds[1].Duration)
+	}
+	if at := cmds[2].At; at.Hour() != 7 || at.Minute() != 15 || !at.After(time.Now()) {
+		t.Errorf("at: got %v, want the next 07:15", at)
+	}
+}
+
+func TestTools(t *testing.T) {
+	tools := llm.Tools(&mockRegistry{})
+
+	byName := make(map[string]llm.Tool)
+	for _, tool := range tools {
+		if !domain.Action(tool.Name).Valid() {
+			t.Errorf("tool %q is not a domain action", tool.Name)
+		}
+		byName[tool.Name] = tool
+	}
+
+	for _, tool := range tools {
+		if tool.Parameters == nil {
+			continue
+		}
+		// Gemini rejects object schemas with no properties
+		if props, _ := tool.Parameters["properties"].(map[string]any); len(props) == 0 {
+			t.Errorf("tool %q has an empty parameters schema; leave it nil", tool.Name)
+		}
+	}
+	if byName["unknown"].Parameters != nil || byName["list_timers"].Parameters != nil {
+		t.Error("tools without arguments should have no parameters schema")
+	}
+
+	scene := byName["run_scene"].Parameters["properties"].(map[string]any)["target_name"].(map[string]any)
+	if names := scene["enum"].([]string); len(names) != 1 || names[0] != "Película" {
+		t.Errorf("run_scene targets: got %v", names)
+	}
+
+	required := byName["set_hvac_mode"].Parameters["required"].([]string)
+	if strings.Join(required, ",") != "target_name,target_type,hvac_mode" {
+		t.Errorf("set_hvac_mode required: got %v", required)
+	}
+
+	cmd := llm.FromToolCall("set_level", map[string]any{"target_name": "Luz Living", "target_type": "device", "level": 30.0, "confidence": 0.9}, "poné la luz al 30")
+	if cmd.Action != domain.ActionSetLevel || cmd.TargetName != "Luz Living" || cmd.Confidence != 0.9 || cmd.Parameters["level"] != 30.0 || len(cmd.Parameters) != 1 {
+		t.Errorf("unexpected command: %+v", cmd)
+	}
+}
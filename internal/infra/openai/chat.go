@@ -0,0 +1,159 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+	"smart-home/internal/infra"
+	"smart-home/internal/infra/llm"
+)
+
+// ChatClient parses intents through the OpenAI Chat Completions API or any
+// server speaking it, such as Ollama or the llama.cpp server, so the parser
+// can run on a machine at home.
+type ChatClient struct {
+	apiKey     string
+	httpClient *http.Client
+	baseURL    string
+	model      string
+	prompt     *llm.Prompt
+}
+
+// NewChatClient creates a chat client for baseURL, e.g.
+// "https://api.openai.com/v1" or "http://localhost:11434/v1" for Ollama.
+// apiKey may be empty for local servers.
+func NewChatClient(baseURL, apiKey, model string) *ChatClient {
+	return &ChatClient{
+		apiKey: apiKey,
+		// Local models on small hardware can take a while to answer
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		model:      model,
+		prompt:     llm.DefaultPrompt(),
+	}
+}
+
+// SetPrompt replaces the built-in prompt, e.g. with one loaded from the
+// config. It must be called before Parse.
+func (c *ChatClient) SetPrompt(p *llm.Prompt) {
+	c.prompt = p
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type responseFormat struct {
+	Type string `json:"type"`
+}
+
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []chatMessage   `json:"messages"`
+	MaxTokens      int             `json:"max_tokens"`
+	Temperature    float64         `json:"temperature"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (c *ChatClient) Parse(ctx context.Context, text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	systemPrompt, err := c.prompt.Build(registry, conv, false)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := chatRequest{
+		Model: c.model,
+		Messages: []chatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: text},
+		},
+		MaxTokens:      512,
+		Temperature:    0.1,
+		ResponseFormat: &responseFormat{Type: "json_object"},
+	}
+
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	var result chatResponse
+	retryErr := infra.WithRetry(ctx, infra.DefaultRetryConfig(), func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/chat/completions", bytes.NewReader(bodyBytes))
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if c.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("sending request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("reading response: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			if infra.IsRetryableHTTPStatus(resp.StatusCode) {
+				return fmt.Errorf("chat API error %d: %s (retryable)", resp.StatusCode, string(respBody))
+			}
+			return fmt.Errorf("chat API error %d: %s", resp.StatusCode, string(respBody))
+		}
+
+		if err = json.Unmarshal(respBody, &result); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+
+		return nil
+	})
+
+	if retryErr != nil {
+		return nil, retryErr
+	}
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("chat API error: %s", result.Error.Message)
+	}
+
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from chat API")
+	}
+
+	cmds, err := llm.ParseJSON(result.Choices[0].Message.Content, text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Local models can't be forced into a schema, so what they return is
+	// checked instead
+	if err = llm.Validate(cmds, registry); err != nil {
+		return nil, fmt.Errorf("invalid intent from chat model: %w", err)
+	}
+
+	return cmds, nil
+}
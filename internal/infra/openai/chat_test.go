@@ -0,0 +1,151 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/openai"
+)
+
+type mockRegistry struct{}
+
+func (m *mockRegistry) Sync(_ context.Context) error { return nil }
+func (m *mockRegistry) GetDevices() []domain.Device {
+	return []domain.Device{{ID: "1", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living"}}
+}
+func (m *mockRegistry) GetScenes() []domain.Scene {
+	return []domain.Scene{{ID: "s1", Name: "Buenas Noches"}}
+}
+func (m *mockRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
+	if !strings.EqualFold(name, "Luz Living") {
+		return nil, false
+	}
+	return &m.GetDevices()[0], true
+}
+func (m *mockRegistry) FindSceneByName(name string) (*domain.Scene, bool) {
+	if !strings.EqualFold(name, "Buenas Noches") {
+		return nil, false
+	}
+	return &m.GetScenes()[0], true
+}
+func (m *mockRegistry) StartPeriodicSync(_ context.Context, _ time.Duration) {}
+
+func chatServer(t *testing.T, content string, check func(r *http.Request, body map[string]any)) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if check != nil {
+			check(r, body)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{
+				{"message": map[string]string{"role": "assistant", "content": content}},
+			},
+		})
+	}))
+}
+
+func TestChatClient_Parse(t *testing.T) {
+	server := chatServer(t,
+		`{"commands":[{"action":"set_level","target_name":"Luz Living","target_type":"device","parameters":{"level":30},"confidence":0.9}]}`,
+		func(r *http.Request, body map[string]any) {
+			if body["model"] != "llama3.2" {
+				t.Errorf("model: got %v", body["model"])
+			}
+			if r.Header.Get("Authorization") != "" {
+				t.Error("no key should be sent to a local server")
+			}
+			messages := body["messages"].([]any)
+			system := messages[0].(map[string]any)
+			if system["role"] != "system" || !strings.Contains(system["content"].(string), "Luz Living") {
+				t.Errorf("system prompt should list the devices, got %v", system)
+			}
+			user := messages[1].(map[string]any)
+			if user["content"] != "bajá la luz del living al 30" {
+				t.Errorf("user message: got %v", user["content"])
+			}
+		})
+	defer server.Close()
+
+	client := openai.NewChatClient(server.URL+"/v1/", "", "llama3.2")
+
+	cmds, err := client.Parse(context.Background(), "bajá la luz del living al 30", &mockRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	if len(cmds) != 1 {
+		t.Fatalf("commands: got %d, want 1", len(cmds))
+	}
+	cmd := cmds[0]
+	if cmd.Action != domain.ActionSetLevel || cmd.TargetName != "Luz Living" || cmd.TargetType != domain.TargetTypeDevice {
+		t.Errorf("got %s %q (%s)", cmd.Action, cmd.TargetName, cmd.TargetType)
+	}
+	if cmd.Parameters["level"] != 30.0 {
+		t.Errorf("level: got %v", cmd.Parameters["level"])
+	}
+	if cmd.RawText != "bajá la luz del living al 30" {
+		t.Errorf("raw text: got %q", cmd.RawText)
+	}
+}
+
+func TestChatClient_ParseFencedSingleIntent(t *testing.T) {
+	// Small local models often wrap the JSON in a code block and drop the
+	// "commands" wrapper
+	server := chatServer(t, "```json\n{\"action\":\"run_scene\",\"target_name\":\"Buenas Noches\",\"target_type\":\"scene\"}\n```",
+		func(r *http.Request, _ map[string]any) {
+			if r.Header.Get("Authorization") != "Bearer sk-test" {
+				t.Errorf("authorization: got %q", r.Header.Get("Authorization"))
+			}
+		})
+	defer server.Close()
+
+	client := openai.NewChatClient(server.URL+"/v1", "sk-test", "gpt-4o-mini")
+
+	cmds, err := client.Parse(context.Background(), "buenas noches", &mockRegistry{}, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(cmds) != 1 || cmds[0].Action != domain.ActionRunScene || cmds[0].TargetName != "Buenas Noches" {
+		t.Errorf("unexpected commands: %+v", cmds)
+	}
+}
+
+func TestChatClient_ParseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := openai.NewChatClient(server.URL, "", "missing")
+	if _, err := client.Parse(context.Background(), "prende la luz", &mockRegistry{}, nil); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestChatClient_RejectsHallucinatedIntent(t *testing.T) {
+	server := chatServer(t,
+		`{"commands":[{"action":"dim","target_name":"Luz Living","target_type":"device","parameters":{"level":30}}]}`, nil)
+	defer server.Close()
+
+	client := openai.NewChatClient(server.URL+"/v1", "", "llama3.2")
+	if _, err := client.Parse(context.Background(), "bajá la luz", &mockRegistry{}, nil); err == nil {
+		t.Error("expected an unknown action to be rejected")
+	}
+}
@@ -8,27 +8,47 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"strings"
 	"time"
 
 	"smart-home/internal/infra"
+	"smart-home/internal/infra/audiofile"
 )
 
+// WhisperClient transcribes audio through the OpenAI transcriptions API or
+// any server speaking it, such as faster-whisper-server or LocalAI, so the
+// audio doesn't have to leave the house.
 type WhisperClient struct {
 	apiKey     string
 	httpClient *http.Client
 	baseURL    string
+	model      string
 	language   string
 }
 
 func NewWhisperClient(apiKey, language string) *WhisperClient {
+	return NewCompatibleWhisperClient("https://api.openai.com/v1", apiKey, "whisper-1", language)
+}
+
+// NewCompatibleWhisperClient creates a client for the /audio/transcriptions
+// endpoint under baseURL, e.g. "http://localhost:8000/v1". apiKey may be
+// empty for local servers.
+func NewCompatibleWhisperClient(baseURL, apiKey, model, language string) *WhisperClient {
 	return &WhisperClient{
 		apiKey:     apiKey,
 		httpClient: &http.Client{Timeout: 30 * time.Second},
-		baseURL:    "https://api.openai.com/v1",
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		model:      model,
 		language:   language,
 	}
 }
 
+// SetTimeout changes how long a transcription may take; a local server on
+// small hardware can need more than the default 30 seconds.
+func (c *WhisperClient) SetTimeout(d time.Duration) {
+	c.httpClient.Timeout = d
+}
+
 type transcriptionResponse struct {
 	Text string `json:"text"`
 }
@@ -40,7 +60,8 @@ func (c *WhisperClient) Transcribe(ctx context.Context, audio []byte) (string, e
 		body := &bytes.Buffer{}
 		writer := multipart.NewWriter(body)
 
-		part, err := writer.CreateFormFile("file", "audio.wav")
+		// Name the upload after what it is: the server may go by the extension
+		part, err := writer.CreatePart(audiofile.Detect(audio).PartHeader("file"))
 		if err != nil {
 			return fmt.Errorf("creating form file: %w", err)
 		}
@@ -49,12 +70,14 @@ func (c *WhisperClient) Transcribe(ctx context.Context, audio []byte) (string, e
 			return fmt.Errorf("writing audio: %w", err)
 		}
 
-		if err = writer.WriteField("model", "whisper-1"); err != nil {
+		if err = writer.WriteField("model", c.model); err != nil {
 			return fmt.Errorf("writing model field: %w", err)
 		}
 
-		if err = writer.WriteField("language", c.language); err != nil {
-			return fmt.Errorf("writing language field: %w", err)
+		if c.language != "" {
+			if err = writer.WriteField("language", c.language); err != nil {
+				return fmt.Errorf("writing language field: %w", err)
+			}
 		}
 
 		if err = writer.Close(); err != nil {
@@ -66,7 +89,9 @@ func (c *WhisperClient) Transcribe(ctx context.Context, audio []byte) (string, e
 			return fmt.Errorf("creating request: %w", err)
 		}
 
-		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+		if c.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
 		req.Header.Set("Content-Type", writer.FormDataContentType())
 
 		resp, err := c.httpClient.Do(req)
@@ -0,0 +1,103 @@
+package openai_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"smart-home/internal/infra/openai"
+)
+
+func TestWhisperClient_CompatibleServer(t *testing.T) {
+	audio := []byte("RIFF fake wav")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("no API key should be sent to a local server, got %q", auth)
+		}
+		if got := r.FormValue("model"); got != "Systran/faster-whisper-small" {
+			t.Errorf("model: got %q", got)
+		}
+		if got := r.FormValue("language"); got != "es" {
+			t.Errorf("language: got %q", got)
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("reading file: %v", err)
+		}
+		if data, _ := io.ReadAll(file); string(data) != string(audio) {
+			t.Errorf("audio: got %q", data)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"text": "prendé la luz del living"})
+	}))
+	defer server.Close()
+
+	client := openai.NewCompatibleWhisperClient(server.URL+"/v1/", "", "Systran/faster-whisper-small", "es")
+	text, err := client.Transcribe(context.Background(), audio)
+	if err != nil {
+		t.Fatalf("Transcribe error: %v", err)
+	}
+	if text != "prendé la luz del living" {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestWhisperClient_NamesUploadByFormat(t *testing.T) {
+	tests := map[string]struct {
+		audio    string
+		filename string
+		mimeType string
+	}{
+		"wav":  {"RIFF\x24\x00\x00\x00WAVEfmt ", "audio.wav", "audio/wav"},
+		"mp3":  {"ID3\x04\x00\x00\x00\x00\x00\x00", "audio.mp3", "audio/mpeg"},
+		"m4a":  {"\x00\x00\x00\x20ftypM4A \x00\x00", "audio.m4a", "audio/mp4"},
+		"webm": {"\x1A\x45\xDF\xA3\x9F\x42\x86\x81", "audio.webm", "audio/webm"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, header, err := r.FormFile("file")
+				if err != nil {
+					t.Fatalf("reading file: %v", err)
+				}
+				if header.Filename != tc.filename {
+					t.Errorf("filename: got %q, want %q", header.Filename, tc.filename)
+				}
+				if got := header.Header.Get("Content-Type"); got != tc.mimeType {
+					t.Errorf("content type: got %q, want %q", got, tc.mimeType)
+				}
+				json.NewEncoder(w).Encode(map[string]string{"text": "ok"})
+			}))
+			defer server.Close()
+
+			client := openai.NewCompatibleWhisperClient(server.URL, "", "whisper-1", "es")
+			if _, err := client.Transcribe(context.Background(), []byte(tc.audio)); err != nil {
+				t.Fatalf("Transcribe error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWhisperClient_APIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("missing API key, got %q", r.Header.Get("Authorization"))
+		}
+		http.Error(w, `{"error":"invalid model"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := openai.NewCompatibleWhisperClient(server.URL, "secret", "nope", "")
+	if _, err := client.Transcribe(context.Background(), []byte("audio")); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,246 @@
+package rules
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"smart-home/internal/domain"
+)
+
+// token is one word, as written and normalized (lower case, no accents)
+// for matching.
+type token struct {
+	orig string
+	norm string
+}
+
+// actionSet stands for the verbs that need a value to become an action:
+// "poné la luz al 30%" is set_level, "poné la luz en rojo" is set_color.
+const actionSet domain.Action = "set"
+
+// actionTurn is the English "turn"/"switch", completed by "on" or "off"
+// anywhere in the clause ("turn the kitchen light off").
+const actionTurn domain.Action = "turn"
+
+// actionUp and actionDown are the verbs of relative adjustments ("subí la
+// luz", "bajá el aire dos grados"), unless the value is given as a target:
+// "bajá la luz al 30%".
+const (
+	actionUp   domain.Action = "up"
+	actionDown domain.Action = "down"
+)
+
+// The amounts of a relative adjustment that doesn't say how much.
+const (
+	percentStep = 10
+	degreeStep  = 1
+)
+
+var verbs = map[string]domain.Action{
+	"prende": domain.ActionTurnOn, "prender": domain.ActionTurnOn, "prenda": domain.ActionTurnOn, "prendan": domain.ActionTurnOn,
+	"encende": domain.ActionTurnOn, "encender": domain.ActionTurnOn, "enciende": domain.ActionTurnOn, "encienda": domain.ActionTurnOn,
+
+	"apaga": domain.ActionTurnOff, "apagar": domain.ActionTurnOff, "apague": domain.ActionTurnOff, "apaguen": domain.ActionTurnOff,
+
+	"turn": actionTurn, "switch": actionTurn,
+
+	"pone": actionSet, "pon": actionSet, "poner": actionSet, "ponga": actionSet,
+	"setea": actionSet, "setear": actionSet, "ajusta": actionSet, "ajustar": actionSet,
+	"regula": actionSet, "cambia": actionSet, "cambiar": actionSet, "deja": actionSet,
+	"set": actionSet, "put": actionSet, "make": actionSet, "change": actionSet, "adjust": actionSet,
+
+	"sube": actionUp, "subi": actionUp, "subir": actionUp, "aumenta": actionUp, "aumentar": actionUp,
+	"raise": actionUp, "increase": actionUp, "brighten": actionUp,
+	"baja": actionDown, "bajar": actionDown, "reduci": actionDown, "reduce": actionDown, "reducir": actionDown,
+	"disminui": actionDown, "disminuye": actionDown, "lower": actionDown, "decrease": actionDown, "dim": actionDown,
+
+	"abri": domain.ActionOpen, "abre": domain.ActionOpen, "abrir": domain.ActionOpen, "open": domain.ActionOpen,
+	"cerra": domain.ActionClose, "cierra": domain.ActionClose, "cerrar": domain.ActionClose, "close": domain.ActionClose, "shut": domain.ActionClose,
+
+	"traba": domain.ActionLock, "trabar": domain.ActionLock, "bloquea": domain.ActionLock, "lock": domain.ActionLock,
+	"destraba": domain.ActionUnlock, "destrabar": domain.ActionUnlock, "desbloquea": domain.ActionUnlock, "unlock": domain.ActionUnlock,
+
+	"reproduci": domain.ActionPlay, "reproduce": domain.ActionPlay, "reanuda": domain.ActionPlay, "play": domain.ActionPlay, "resume": domain.ActionPlay,
+	"pausa": domain.ActionPause, "pausar": domain.ActionPause, "pause": domain.ActionPause,
+
+	"aspira": domain.ActionStart, "start": domain.ActionStart,
+	"dock": domain.ActionDock,
+
+	"activa": domain.ActionRunScene, "activar": domain.ActionRunScene, "ejecuta": domain.ActionRunScene,
+	"ejecutar": domain.ActionRunScene, "run": domain.ActionRunScene, "activate": domain.ActionRunScene,
+
+	"esta": domain.ActionGetStatus, "estan": domain.ActionGetStatus, "estado": domain.ActionGetStatus,
+	"is": domain.ActionGetStatus, "are": domain.ActionGetStatus, "status": domain.ActionGetStatus,
+}
+
+// clitics are the pronouns Spanish glues to imperatives: "apagala",
+// "prendémelo".
+var clitics = []string{"melas", "melos", "mela", "melo", "las", "los", "les", "la", "lo", "le", "me"}
+
+// fillers are skipped before the verb: "por favor apagá...", "che, prendé...".
+var fillers = set("por", "favor", "porfa", "che", "podes", "podrias", "quiero", "que", "please", "can", "could", "you", "hey", "ok", "okay")
+
+// stopwords carry no meaning for matching names.
+var stopwords = set(
+	"el", "la", "los", "las", "lo", "un", "una", "unos", "unas", "de", "del", "en", "al", "a", "con", "por", "favor", "porfa",
+	"mi", "mis", "su", "sus", "que", "hasta", "ahora", "ya", "tambien", "escena", "scene", "poco", "poquito",
+	"the", "of", "in", "to", "at", "my", "please", "now", "too", "also", "it", "them", "that", "this", "bit", "little",
+	"eso", "esa", "ese", "esto", "esta", "este",
+)
+
+// articles followed by "de" stand for a device left unnamed: "la de la
+// cocina", "el del living".
+var articles = set("el", "la", "los", "las")
+
+// clauseBreaks split "apagá la luz y prendé la tele" into two commands.
+var clauseBreaks = set("y", "and", "luego", "despues", "then")
+
+// everything marks a whole-area command: "apagá todo el living".
+var everything = set("todo", "toda", "todos", "todas", "everything", "all")
+
+// statusWords are the states asked about in "¿está prendida la luz?".
+var statusWords = set(
+	"prendido", "prendida", "prendidos", "prendidas", "encendido", "encendida", "encendidos", "encendidas",
+	"apagado", "apagada", "apagados", "apagadas", "abierto", "abierta", "abiertos", "abiertas",
+	"cerrado", "cerrada", "cerrados", "cerradas", "on", "off", "open", "closed",
+)
+
+// typeWords narrow an area command to one kind of device: "las luces del
+// dormitorio".
+var typeWords = map[string]domain.DeviceType{
+	"luz": domain.DeviceTypeLight, "luces": domain.DeviceTypeLight, "light": domain.DeviceTypeLight, "lights": domain.DeviceTypeLight,
+	"lampara": domain.DeviceTypeLight, "lamparas": domain.DeviceTypeLight, "lamp": domain.DeviceTypeLight, "lamps": domain.DeviceTypeLight,
+	"persiana": domain.DeviceTypeCover, "persianas": domain.DeviceTypeCover, "cortina": domain.DeviceTypeCover, "cortinas": domain.DeviceTypeCover,
+	"blinds": domain.DeviceTypeCover, "curtains": domain.DeviceTypeCover,
+	"enchufe": domain.DeviceTypePlug, "enchufes": domain.DeviceTypePlug, "plug": domain.DeviceTypePlug, "plugs": domain.DeviceTypePlug,
+	"ventilador": domain.DeviceTypeFan, "ventiladores": domain.DeviceTypeFan, "fan": domain.DeviceTypeFan, "fans": domain.DeviceTypeFan,
+}
+
+// colours maps colour names to the English names both backends accept.
+var colours = map[string]string{
+	"rojo": "red", "roja": "red", "red": "red",
+	"naranja": "orange", "orange": "orange",
+	"amarillo": "yellow", "amarilla": "yellow", "yellow": "yellow",
+	"verde": "green", "green": "green",
+	"celeste": "cyan", "cian": "cyan", "cyan": "cyan",
+	"azul": "blue", "blue": "blue",
+	"violeta": "purple", "morado": "purple", "lila": "purple", "purple": "purple",
+	"magenta": "magenta", "fucsia": "magenta",
+	"rosa": "pink", "rosado": "pink", "pink": "pink",
+	"blanco": "white", "blanca": "white", "white": "white",
+}
+
+var hvacModes = map[string]string{
+	"frio": "cool", "cool": "cool", "calor": "heat", "calefaccion": "heat", "heat": "heat",
+	"auto": "auto", "automatico": "auto", "seco": "dry", "dry": "dry", "ventilacion": "fan_only",
+}
+
+// upWords and downWords give the direction of a relative adjustment said
+// without an up or down verb: "make it brighter", "turn the volume down".
+var (
+	upWords   = set("mas", "more", "up", "brighter", "warmer", "louder", "higher")
+	downWords = set("menos", "less", "down", "dimmer", "darker", "cooler", "colder", "quieter")
+)
+
+// targetWords before a number make it the value to reach rather than an
+// amount to add: "bajá la luz al 30%".
+var targetWords = set("al", "a", "en", "hasta", "to", "at")
+
+// numberWords are the small amounts said in words: "subí el aire dos grados".
+var numberWords = map[string]float64{
+	"dos": 2, "tres": 3, "cuatro": 4, "cinco": 5, "seis": 6, "siete": 7, "ocho": 8, "nueve": 9, "diez": 10,
+	"two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+}
+
+// Words that say what a number is about.
+var (
+	percentWords     = set("%", "porciento", "percent")
+	temperatureWords = set("grados", "grado", "degrees", "°", "temperatura", "temperature")
+	volumeWords      = set("volumen", "volume")
+	positionWords    = set("posicion", "position")
+	modeWords        = set("modo", "mode")
+)
+
+func set(words ...string) map[string]bool {
+	m := make(map[string]bool, len(words))
+	for _, w := range words {
+		m[w] = true
+	}
+	return m
+}
+
+// tokenize splits an utterance into words, keeping numbers with their
+// decimals ("21,5"), hex colours and the "%" and "°" signs as tokens.
+func tokenize(text string) []token {
+	var tokens []token
+	var word []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			orig := string(word)
+			tokens = append(tokens, token{orig: orig, norm: normalize(orig)})
+			word = word[:0]
+		}
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '#':
+			word = append(word, r)
+		case (r == '.' || r == ',') && len(word) > 0 && unicode.IsDigit(word[len(word)-1]) &&
+			i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			word = append(word, '.')
+		case r == '%' || r == '°':
+			flush()
+			tokens = append(tokens, token{orig: string(r), norm: string(r)})
+		case r == ',' || r == ';':
+			flush()
+			tokens = append(tokens, token{orig: "y", norm: "y"})
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	// "por ciento" is a single unit
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].norm == "por" && tokens[i+1].norm == "ciento" {
+			tokens[i] = token{orig: "%", norm: "%"}
+			tokens = append(tokens[:i+1], tokens[i+2:]...)
+		}
+	}
+	return tokens
+}
+
+var accents = strings.NewReplacer("á", "a", "é", "e", "í", "i", "ó", "o", "ú", "u", "ü", "u")
+
+func normalize(s string) string {
+	return accents.Replace(strings.ToLower(s))
+}
+
+// verbAction recognizes a verb, with or without clitics.
+func verbAction(word string) (domain.Action, bool) {
+	if a, ok := verbs[word]; ok {
+		return a, true
+	}
+	for _, c := range clitics {
+		if base, ok := strings.CutSuffix(word, c); ok {
+			if a, ok := verbs[base]; ok {
+				return a, true
+			}
+		}
+	}
+	return "", false
+}
+
+// number parses a numeric token, with "," or "." as decimal separator, or
+// a small number in words.
+func number(word string) (float64, bool) {
+	if v, ok := numberWords[word]; ok {
+		return v, true
+	}
+	v, err := strconv.ParseFloat(word, 64)
+	return v, err == nil
+}
@@ -0,0 +1,238 @@
+package rules
+
+import (
+	"strings"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// candidate is something a command can target: a device, a scene or an
+// area, with the words of its name.
+type candidate struct {
+	name  string
+	kind  domain.TargetType
+	words []token
+	// device is the type of a device candidate
+	device domain.DeviceType
+}
+
+// target is the result of matching the words of a clause against the
+// registry.
+type target struct {
+	name       string
+	kind       domain.TargetType
+	deviceType domain.DeviceType
+	device     domain.DeviceType
+	score      float64
+}
+
+// ambiguousScore is reported when the words fit several devices equally
+// well; the assistant then asks which one was meant.
+const ambiguousScore = 0.8
+
+func candidates(registry application.DeviceRegistry) []candidate {
+	var result []candidate
+	areas := make(map[string]bool)
+
+	for _, d := range registry.GetDevices() {
+		result = append(result, candidate{name: d.Name, kind: domain.TargetTypeDevice, words: nameWords(d.Name), device: d.Type})
+		if d.Area != "" && !areas[d.Area] {
+			areas[d.Area] = true
+			result = append(result, candidate{name: d.Area, kind: domain.TargetTypeArea, words: nameWords(d.Area)})
+		}
+	}
+	for _, s := range registry.GetScenes() {
+		result = append(result, candidate{name: s.Name, kind: domain.TargetTypeScene, words: nameWords(s.Name)})
+	}
+	return result
+}
+
+func nameWords(name string) []token {
+	var words []token
+	for _, t := range tokenize(name) {
+		if !stopwords[t.norm] {
+			words = append(words, t)
+		}
+	}
+	return words
+}
+
+// resolve picks the best target for the words of a clause. Areas can also
+// be named together with a kind of device ("las luces del living") or
+// "todo". On a tie devices win over areas and areas over scenes, unless
+// the action is a scene's. single reports that the user meant one device
+// without naming its kind ("la de la cocina"), so a whole area is only a
+// guess.
+func resolve(words []token, cands []candidate, action domain.Action, all, single bool) (target, bool) {
+	// Area words without the device kind
+	var areaWords []token
+	var deviceType domain.DeviceType
+	for _, w := range words {
+		if t, ok := typeWords[w.norm]; ok && deviceType == "" {
+			deviceType = t
+			continue
+		}
+		areaWords = append(areaWords, w)
+	}
+
+	type scored struct {
+		target
+		rank    int
+		covered bool
+		words   []token
+	}
+	var top []scored
+	for _, c := range cands {
+		if all && c.kind != domain.TargetTypeArea {
+			continue
+		}
+		said := words
+		var dt domain.DeviceType
+		if c.kind == domain.TargetTypeArea {
+			said = areaWords
+			dt = deviceType
+		}
+		score, covered := similarity(said, c.words)
+		if score == 0 {
+			continue
+		}
+
+		s := scored{
+			target:  target{name: c.name, kind: c.kind, deviceType: dt, device: c.device, score: score},
+			rank:    kindRank(c.kind, action),
+			covered: covered,
+			words:   c.words,
+		}
+		switch {
+		case len(top) == 0 || score > top[0].score || (score == top[0].score && s.rank > top[0].rank):
+			top = []scored{s}
+		case score == top[0].score && s.rank == top[0].rank:
+			top = append(top, s)
+		}
+	}
+
+	if len(top) == 0 {
+		return target{}, false
+	}
+
+	best := top[0].target
+	if len(top) == 1 {
+		// The only name containing every word said is a safe bet
+		if top[0].covered && best.score < ambiguousScore {
+			best.score = ambiguousScore
+		}
+		if single && best.kind == domain.TargetTypeArea && best.deviceType == "" {
+			best.score /= 2
+		}
+		return best, true
+	}
+
+	for _, s := range top {
+		if !s.covered || s.kind != domain.TargetTypeDevice {
+			// A coin toss between names: not worth acting on
+			best.score /= 2
+			return best, true
+		}
+	}
+
+	// Several devices contain every word said ("la lámpara"): name them by
+	// those words and let the assistant ask
+	best.name = sharedWords(top[0].words, words)
+	best.score = ambiguousScore
+	return best, true
+}
+
+func kindRank(kind domain.TargetType, action domain.Action) int {
+	switch {
+	case kind == domain.TargetTypeScene && action == domain.ActionRunScene:
+		return 3
+	case kind == domain.TargetTypeDevice:
+		return 2
+	case kind == domain.TargetTypeArea:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// similarity is the share of words the utterance and the name have in
+// common, over the longer of the two. covered reports that every word said
+// is in the name.
+func similarity(said, name []token) (score float64, covered bool) {
+	if len(said) == 0 || len(name) == 0 {
+		return 0, false
+	}
+
+	matched := 0
+	for _, s := range said {
+		for _, n := range name {
+			if sameWord(s.norm, n.norm) {
+				matched++
+				break
+			}
+		}
+	}
+
+	longest := max(len(said), len(name))
+	return float64(matched) / float64(longest), matched == len(said)
+}
+
+// sameWord tolerates plurals and a one-letter slip in longer words, which
+// is what speech recognition usually gets wrong ("cosina").
+func sameWord(a, b string) bool {
+	if a == b || singular(a) == singular(b) {
+		return true
+	}
+	if len(a) >= 5 && len(b) >= 5 {
+		return distance(a, b) <= 1
+	}
+	return false
+}
+
+func singular(w string) string {
+	if s, ok := strings.CutSuffix(w, "es"); ok && len(s) > 2 {
+		return s
+	}
+	if s, ok := strings.CutSuffix(w, "s"); ok && len(s) > 2 {
+		return s
+	}
+	return w
+}
+
+// distance is the Levenshtein distance between two words.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// sharedWords returns the words of a name that were said, as the name
+// spells them.
+func sharedWords(name, said []token) string {
+	var words []string
+	for _, n := range name {
+		for _, s := range said {
+			if sameWord(s.norm, n.norm) {
+				words = append(words, n.orig)
+				break
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,408 @@
+package rules
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// confidentScore is how well the words of a clause must match a registry
+// name for the parser to act on it without asking the fallback.
+const confidentScore = 0.75
+
+// Parser understands everyday commands ("apagá la luz del living", "turn
+// the fan to 40%", "poné el aire a 22 grados") with a small Spanish and
+// English grammar and fuzzy matching against the registry, so they work
+// without an API call or an internet connection.
+//
+// With a fallback parser, anything it can't match confidently is handed
+// over, and its own result is kept when the fallback fails.
+type Parser struct {
+	fallback application.IntentParser
+	logger   *slog.Logger
+}
+
+// NewParser creates a rule-based parser. fallback may be nil to use the
+// rules alone, in which case the best guess is returned with its
+// confidence for the assistant to confirm.
+func NewParser(fallback application.IntentParser, logger *slog.Logger) *Parser {
+	return &Parser{
+		fallback: fallback,
+		logger:   logger,
+	}
+}
+
+func (p *Parser) Parse(ctx context.Context, text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, error) {
+	cmds, confident := parse(text, registry, conv)
+	if confident || p.fallback == nil {
+		if confident {
+			p.logger.Debug("parsed locally", "text", text)
+		}
+		return cmds, nil
+	}
+
+	p.logger.Debug("no confident local parse, using fallback parser", "text", text)
+	fallbackCmds, err := p.fallback.Parse(ctx, text, registry, conv)
+	if err != nil {
+		if known(cmds) {
+			p.logger.Warn("fallback parser failed, using local parse", "text", text, "error", err)
+			return cmds, nil
+		}
+		return nil, err
+	}
+	return fallbackCmds, nil
+}
+
+// Complete reports whether text already is a whole command naming each of
+// its targets outright, so that a recognizer still listening can stop
+// there. Ambiguous names ("la lámpara") aren't.
+func Complete(text string, registry application.DeviceRegistry) bool {
+	cmds, confident := parse(text, registry, nil)
+	if !confident {
+		return false
+	}
+	for _, cmd := range cmds {
+		if cmd.Confidence <= ambiguousScore {
+			return false
+		}
+	}
+	return true
+}
+
+func known(cmds []*domain.Command) bool {
+	for _, cmd := range cmds {
+		if cmd.Action != domain.ActionUnknown {
+			return true
+		}
+	}
+	return false
+}
+
+// parse returns one command per clause and whether every one of them was
+// matched confidently.
+func parse(text string, registry application.DeviceRegistry, conv *application.Conversation) ([]*domain.Command, bool) {
+	cands := candidates(registry)
+
+	var (
+		cmds      []*domain.Command
+		confident = true
+		last      clause
+	)
+	now := time.Now()
+	for _, tokens := range splitClauses(tokenize(text)) {
+		tokens, when := extractTiming(tokens, now)
+		c := parseClause(tokens, last, cands, conv)
+		when.apply(c.cmds)
+		if c.verb != "" {
+			last = c
+		}
+		for _, cmd := range c.cmds {
+			cmd.RawText = text
+			if cmd.Action == domain.ActionUnknown || cmd.Confidence < confidentScore {
+				confident = false
+			}
+		}
+		cmds = append(cmds, c.cmds...)
+	}
+
+	if len(cmds) == 0 {
+		return []*domain.Command{{Action: domain.ActionUnknown, RawText: text}}, false
+	}
+	return cmds, confident
+}
+
+func splitClauses(tokens []token) [][]token {
+	var clauses [][]token
+	var current []token
+	for _, t := range tokens {
+		if clauseBreaks[t.norm] {
+			if len(current) > 0 {
+				clauses = append(clauses, current)
+			}
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		clauses = append(clauses, current)
+	}
+	return clauses
+}
+
+// clause is what one clause of the utterance turned into. The verb and the
+// kind of device are carried over to the next clause when that one leaves
+// them out: "apagá la luz del living y la de la cocina".
+type clause struct {
+	verb domain.Action
+	noun *token
+	cmds []*domain.Command
+}
+
+type values struct {
+	number *float64
+	// numberToken is the number as said, for names like "enchufe 2"
+	numberToken token
+	// target reports that the number is the value to reach ("al 30%")
+	// rather than an amount to add
+	target bool
+	// direction is +1 or -1 for relative adjustments ("más", "down")
+	direction   float64
+	percent     bool
+	temperature bool
+	volume      bool
+	position    bool
+	mode        bool
+	colour      string
+	hvacMode    string
+	all         bool
+	turn        domain.Action
+}
+
+func parseClause(tokens []token, last clause, cands []candidate, conv *application.Conversation) clause {
+	unknown := clause{cmds: []*domain.Command{{Action: domain.ActionUnknown}}}
+
+	for len(tokens) > 0 && fillers[tokens[0].norm] {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return clause{}
+	}
+	if c, ok := timerClause(tokens, cands); ok {
+		return c
+	}
+
+	elided := elidedNoun(tokens)
+	verb, ok := verbAction(tokens[0].norm)
+	continued := !ok
+	if ok {
+		tokens = tokens[1:]
+	} else if last.verb != "" {
+		verb = last.verb
+	} else {
+		return unknown
+	}
+
+	var v values
+	var words []token
+	for i, t := range tokens {
+		switch {
+		case upWords[t.norm]:
+			v.direction = 1
+		case downWords[t.norm]:
+			v.direction = -1
+		case verb == actionTurn && (t.norm == "on" || t.norm == "off"):
+			v.turn = domain.ActionTurnOn
+			if t.norm == "off" {
+				v.turn = domain.ActionTurnOff
+			}
+		case verb == domain.ActionGetStatus && statusWords[t.norm]:
+		case percentWords[t.norm]:
+			v.percent = true
+		case temperatureWords[t.norm]:
+			v.temperature = true
+		case volumeWords[t.norm]:
+			v.volume = true
+		case positionWords[t.norm]:
+			v.position = true
+		case modeWords[t.norm]:
+			v.mode = true
+		case everything[t.norm]:
+			v.all = true
+		case colours[t.norm] != "" && verb != domain.ActionGetStatus:
+			v.colour = colours[t.norm]
+		case len(t.norm) == 7 && t.norm[0] == '#':
+			v.colour = t.norm
+		case hvacModes[t.norm] != "":
+			v.hvacMode = hvacModes[t.norm]
+			words = append(words, t)
+		default:
+			if n, ok := number(t.norm); ok && v.number == nil {
+				v.number = &n
+				v.numberToken = t
+				v.target = i > 0 && targetWords[tokens[i-1].norm]
+				continue
+			}
+			if !stopwords[t.norm] {
+				words = append(words, t)
+			}
+		}
+	}
+
+	noun := deviceNoun(words)
+	if noun == nil && (continued || elided) && last.noun != nil && len(words) > 0 {
+		noun = last.noun
+		words = append([]token{*noun}, words...)
+	}
+
+	action, params, ok := actionFor(verb, &v)
+	if !ok {
+		return clause{verb: verb, noun: noun, cmds: unknown.cmds}
+	}
+	if v.hvacMode != "" && action == domain.ActionSetHVACMode {
+		words = withoutWord(words, v.hvacMode)
+	}
+	if v.number != nil && params == nil {
+		// A number nobody asked for is part of the name: "el enchufe 2"
+		words = append(words, v.numberToken)
+	}
+
+	if len(words) == 0 {
+		return clause{verb: verb, noun: noun, cmds: fromConversation(action, params, &v, conv, cands)}
+	}
+
+	t, ok := resolve(words, cands, action, v.all, elided && noun == nil)
+	if !ok {
+		return clause{verb: verb, noun: noun, cmds: unknown.cmds}
+	}
+	return clause{verb: verb, noun: noun, cmds: []*domain.Command{command(action, params, &v, t)}}
+}
+
+// elidedNoun reports whether the clause refers to a device without naming
+// its kind: "apagá la de la cocina".
+func elidedNoun(tokens []token) bool {
+	for i := 0; i+1 < len(tokens); i++ {
+		if articles[tokens[i].norm] && (tokens[i+1].norm == "de" || tokens[i+1].norm == "del") {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceNoun returns the word naming a kind of device ("luz", "persiana"),
+// if any.
+func deviceNoun(words []token) *token {
+	for i, w := range words {
+		if _, ok := typeWords[w.norm]; ok {
+			return &words[i]
+		}
+	}
+	return nil
+}
+
+// actionFor settles the action from the verb and the values said with it.
+// ok is false when the verb needs a value that isn't there ("bajá la luz").
+func actionFor(verb domain.Action, v *values) (domain.Action, map[string]any, bool) {
+	switch verb {
+	case actionUp, actionDown:
+		v.direction = 1
+		if verb == actionDown {
+			v.direction = -1
+		}
+		verb = actionSet
+	case actionTurn:
+		if v.turn == "" && v.number == nil && v.direction == 0 {
+			return "", nil, false
+		}
+		verb = v.turn
+		if verb == "" {
+			verb = actionSet
+		}
+	}
+
+	switch verb {
+	case actionSet, domain.ActionTurnOn:
+		switch {
+		case v.colour != "":
+			return domain.ActionSetColor, map[string]any{"color": v.colour}, true
+		case v.mode && v.hvacMode != "":
+			return domain.ActionSetHVACMode, map[string]any{"hvac_mode": v.hvacMode}, true
+		case v.direction != 0 && (v.number == nil || !v.target):
+			action, params := relative(v)
+			return action, params, true
+		case v.number != nil && v.temperature:
+			return domain.ActionSetTemperature, map[string]any{"temperature": *v.number}, true
+		case v.number != nil && v.volume:
+			return domain.ActionSetVolume, map[string]any{"volume": *v.number}, true
+		case v.number != nil && v.position:
+			return domain.ActionSetPosition, map[string]any{"position": *v.number}, true
+		case v.number != nil && (verb == actionSet || v.percent):
+			return domain.ActionSetLevel, map[string]any{"level": *v.number}, true
+		case verb == domain.ActionTurnOn:
+			return domain.ActionTurnOn, nil, true
+		}
+		return "", nil, false
+	case domain.ActionRunScene:
+		return domain.ActionRunScene, nil, true
+	default:
+		return verb, nil, true
+	}
+}
+
+// relative builds a relative adjustment: "subí la luz", "bajá el volumen
+// un 20%", "make it two degrees warmer".
+func relative(v *values) (domain.Action, map[string]any) {
+	action, amount := domain.ActionSetLevel, float64(percentStep)
+	switch {
+	case v.temperature:
+		action, amount = domain.ActionSetTemperature, degreeStep
+	case v.volume:
+		action = domain.ActionSetVolume
+	case v.position:
+		action = domain.ActionSetPosition
+	}
+	if v.number != nil {
+		amount = *v.number
+	}
+	return action, map[string]any{domain.ParamDelta: v.direction * amount}
+}
+
+// command builds the command for a resolved target. Scene verbs on a
+// device mean turning it on, and any other verb on a scene runs it.
+// Thermostats are turned up and down in degrees, not in percentage points.
+func command(action domain.Action, params map[string]any, v *values, t target) *domain.Command {
+	switch {
+	case t.kind == domain.TargetTypeScene:
+		action = domain.ActionRunScene
+	case action == domain.ActionRunScene:
+		action = domain.ActionTurnOn
+	case action == domain.ActionSetLevel && t.device == domain.DeviceTypeThermostat && v.direction != 0:
+		amount := float64(degreeStep)
+		if v.number != nil {
+			amount = *v.number
+		}
+		action, params = domain.ActionSetTemperature, map[string]any{domain.ParamDelta: v.direction * amount}
+	}
+
+	return &domain.Command{
+		Action:     action,
+		TargetName: t.name,
+		TargetType: t.kind,
+		DeviceType: t.deviceType,
+		Parameters: params,
+		Confidence: t.score,
+	}
+}
+
+// fromConversation resolves "apagala" or "make it red" to what the last
+// turn acted on.
+func fromConversation(action domain.Action, params map[string]any, v *values, conv *application.Conversation, cands []candidate) []*domain.Command {
+	if conv == nil || len(conv.LastTargets) == 0 {
+		return []*domain.Command{{Action: domain.ActionUnknown}}
+	}
+
+	var cmds []*domain.Command
+	for _, name := range conv.LastTargets {
+		t, ok := resolve(nameWords(name), cands, action, false, false)
+		if !ok {
+			return []*domain.Command{{Action: domain.ActionUnknown}}
+		}
+		cmd := command(action, params, v, t)
+		cmd.TargetName = name
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func withoutWord(words []token, hvacMode string) []token {
+	var result []token
+	for _, w := range words {
+		if hvacModes[w.norm] != hvacMode {
+			result = append(result, w)
+		}
+	}
+	return result
+}
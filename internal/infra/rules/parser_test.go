@@ -0,0 +1,315 @@
+package rules_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/rules"
+)
+
+type mockRegistry struct {
+	devices []domain.Device
+	scenes  []domain.Scene
+}
+
+func (m *mockRegistry) Sync(_ context.Context) error                         { return nil }
+func (m *mockRegistry) GetDevices() []domain.Device                          { return m.devices }
+func (m *mockRegistry) GetScenes() []domain.Scene                            { return m.scenes }
+func (m *mockRegistry) FindDeviceByName(_ string) (*domain.Device, bool)     { return nil, false }
+func (m *mockRegistry) FindSceneByName(_ string) (*domain.Scene, bool)       { return nil, false }
+func (m *mockRegistry) StartPeriodicSync(_ context.Context, _ time.Duration) {}
+
+var house = &mockRegistry{
+	devices: []domain.Device{
+		{ID: "1", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living"},
+		{ID: "2", Name: "Luz Cocina", Type: domain.DeviceTypeLight, Area: "Cocina"},
+		{ID: "3", Name: "Lámpara Dormitorio", Type: domain.DeviceTypeLight, Area: "Dormitorio"},
+		{ID: "4", Name: "Lámpara Escritorio", Type: domain.DeviceTypeLight, Area: "Escritorio"},
+		{ID: "5", Name: "Ventilador", Type: domain.DeviceTypeFan, Area: "Dormitorio"},
+		{ID: "6", Name: "Aire Acondicionado", Type: domain.DeviceTypeThermostat, Area: "Living"},
+		{ID: "7", Name: "Persiana Living", Type: domain.DeviceTypeCover, Area: "Living"},
+		{ID: "8", Name: "Enchufe 2", Type: domain.DeviceTypePlug},
+	},
+	scenes: []domain.Scene{
+		{ID: "s1", Name: "Buenas Noches"},
+		{ID: "s2", Name: "Película"},
+	},
+}
+
+type stubParser struct {
+	cmds  []*domain.Command
+	err   error
+	calls int
+}
+
+func (s *stubParser) Parse(_ context.Context, _ string, _ application.DeviceRegistry, _ *application.Conversation) ([]*domain.Command, error) {
+	s.calls++
+	return s.cmds, s.err
+}
+
+func newParser(fallback application.IntentParser) *rules.Parser {
+	return rules.NewParser(fallback, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+type want struct {
+	action     domain.Action
+	target     string
+	targetType domain.TargetType
+	deviceType domain.DeviceType
+	params     map[string]any
+}
+
+func TestParser_Parse(t *testing.T) {
+	tests := []struct {
+		text string
+		want []want
+	}{
+		{"apagá la luz del living", []want{{domain.ActionTurnOff, "Luz Living", domain.TargetTypeDevice, "", nil}}},
+		{"Prendé la luz de la cosina", []want{{domain.ActionTurnOn, "Luz Cocina", domain.TargetTypeDevice, "", nil}}},
+		{"turn the kitchen light off", nil},
+		{"por favor encendé el ventilador", []want{{domain.ActionTurnOn, "Ventilador", domain.TargetTypeDevice, "", nil}}},
+		{"poné la luz del living al 30%", []want{{domain.ActionSetLevel, "Luz Living", domain.TargetTypeDevice, "", map[string]any{"level": 30.0}}}},
+		{"prendé la luz del living al 50 por ciento", []want{{domain.ActionSetLevel, "Luz Living", domain.TargetTypeDevice, "", map[string]any{"level": 50.0}}}},
+		{"poné la luz de la cocina en rojo", []want{{domain.ActionSetColor, "Luz Cocina", domain.TargetTypeDevice, "", map[string]any{"color": "red"}}}},
+		{"poné el aire acondicionado a 22,5 grados", []want{{domain.ActionSetTemperature, "Aire Acondicionado", domain.TargetTypeDevice, "", map[string]any{"temperature": 22.5}}}},
+		{"poné el aire acondicionado en modo frío", []want{{domain.ActionSetHVACMode, "Aire Acondicionado", domain.TargetTypeDevice, "", map[string]any{"hvac_mode": "cool"}}}},
+		{"cerrá la persiana del living", []want{{domain.ActionClose, "Persiana Living", domain.TargetTypeDevice, "", nil}}},
+		{"apagá las luces del dormitorio", []want{{domain.ActionTurnOff, "Dormitorio", domain.TargetTypeArea, domain.DeviceTypeLight, nil}}},
+		{"apagá todo en el living", []want{{domain.ActionTurnOff, "Living", domain.TargetTypeArea, "", nil}}},
+		{"activá la escena buenas noches", []want{{domain.ActionRunScene, "Buenas Noches", domain.TargetTypeScene, "", nil}}},
+		{"prendé el enchufe 2", []want{{domain.ActionTurnOn, "Enchufe 2", domain.TargetTypeDevice, "", nil}}},
+		{"¿está prendida la luz de la cocina?", []want{{domain.ActionGetStatus, "Luz Cocina", domain.TargetTypeDevice, "", nil}}},
+		{"apagá la luz del living y prendé el ventilador", []want{
+			{domain.ActionTurnOff, "Luz Living", domain.TargetTypeDevice, "", nil},
+			{domain.ActionTurnOn, "Ventilador", domain.TargetTypeDevice, "", nil},
+		}},
+		{"apagá la luz del living y la de la cocina", []want{
+			{domain.ActionTurnOff, "Luz Living", domain.TargetTypeDevice, "", nil},
+			{domain.ActionTurnOff, "Luz Cocina", domain.TargetTypeDevice, "", nil},
+		}},
+		{"prendé la lámpara", []want{{domain.ActionTurnOn, "Lámpara", domain.TargetTypeDevice, "", nil}}},
+		{"set the ventilador to 40%", []want{{domain.ActionSetLevel, "Ventilador", domain.TargetTypeDevice, "", map[string]any{"level": 40.0}}}},
+		{"bajá la luz del living", []want{{domain.ActionSetLevel, "Luz Living", domain.TargetTypeDevice, "", map[string]any{"delta": -10.0}}}},
+		{"subí la luz del living al 80%", []want{{domain.ActionSetLevel, "Luz Living", domain.TargetTypeDevice, "", map[string]any{"level": 80.0}}}},
+		{"subí el aire acondicionado dos grados", []want{{domain.ActionSetTemperature, "Aire Acondicionado", domain.TargetTypeDevice, "", map[string]any{"delta": 2.0}}}},
+		{"bajá un poco el aire acondicionado", []want{{domain.ActionSetTemperature, "Aire Acondicionado", domain.TargetTypeDevice, "", map[string]any{"delta": -1.0}}}},
+		{"turn the ventilador up", []want{{domain.ActionSetLevel, "Ventilador", domain.TargetTypeDevice, "", map[string]any{"delta": 10.0}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			fallback := &stubParser{cmds: []*domain.Command{{Action: domain.ActionUnknown}}}
+			cmds, err := newParser(fallback).Parse(context.Background(), tt.text, house, nil)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+
+			if tt.want == nil {
+				if fallback.calls != 1 {
+					t.Errorf("expected the fallback to be asked, got %+v", cmds[0])
+				}
+				return
+			}
+			if fallback.calls != 0 {
+				t.Fatalf("fallback should not be needed")
+			}
+
+			if len(cmds) != len(tt.want) {
+				t.Fatalf("commands: got %d, want %d", len(cmds), len(tt.want))
+			}
+			for i, w := range tt.want {
+				got := cmds[i]
+				if got.Action != w.action || got.TargetName != w.target || got.TargetType != w.targetType || got.DeviceType != w.deviceType {
+					t.Errorf("command %d: got %s %q (%s %s), want %s %q (%s %s)", i,
+						got.Action, got.TargetName, got.TargetType, got.DeviceType,
+						w.action, w.target, w.targetType, w.deviceType)
+				}
+				if !reflect.DeepEqual(got.Parameters, w.params) {
+					t.Errorf("command %d parameters: got %v, want %v", i, got.Parameters, w.params)
+				}
+				if got.RawText != tt.text {
+					t.Errorf("raw text: got %q", got.RawText)
+				}
+			}
+		})
+	}
+}
+
+// "la de la cocina" is the kitchen's light, not everything in the kitchen.
+func TestParser_ElidedNoun(t *testing.T) {
+	kitchen := &mockRegistry{devices: []domain.Device{
+		{ID: "1", Name: "Luz Living", Type: domain.DeviceTypeLight, Area: "Living"},
+		{ID: "2", Name: "Luz Cocina", Type: domain.DeviceTypeLight, Area: "Cocina"},
+		{ID: "3", Name: "Heladera", Type: domain.DeviceTypePlug, Area: "Cocina"},
+	}}
+
+	fallback := &stubParser{cmds: []*domain.Command{{Action: domain.ActionUnknown}}}
+	cmds, err := newParser(fallback).Parse(context.Background(), "prendé la luz del living y apagá la de la cocina", kitchen, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if fallback.calls != 0 || len(cmds) != 2 {
+		t.Fatalf("expected two local commands, got %d (fallback calls %d)", len(cmds), fallback.calls)
+	}
+	if cmds[1].Action != domain.ActionTurnOff || cmds[1].TargetName != "Luz Cocina" || cmds[1].TargetType != domain.TargetTypeDevice {
+		t.Errorf("second command: got %s %q (%s)", cmds[1].Action, cmds[1].TargetName, cmds[1].TargetType)
+	}
+
+	// Without a kind to carry over, the area is only a guess
+	fallback = &stubParser{cmds: []*domain.Command{{Action: domain.ActionUnknown}}}
+	if _, err := newParser(fallback).Parse(context.Background(), "apagá la de la cocina", kitchen, nil); err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if fallback.calls != 1 {
+		t.Error("expected the fallback to be asked")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := map[string]bool{
+		"apagá la luz del living":                        true,
+		"apagá la luz del living y prendé el ventilador": true,
+		"apagá la luz":                                   false,
+		"prendé la lámpara":                              false,
+		"apagá la luz del":                               false,
+		"contame un chiste":                              false,
+	}
+	for text, want := range tests {
+		if got := rules.Complete(text, house); got != want {
+			t.Errorf("Complete(%q) = %t, want %t", text, got, want)
+		}
+	}
+}
+
+func TestParser_EnglishTurnOff(t *testing.T) {
+	registry := &mockRegistry{devices: []domain.Device{{ID: "1", Name: "Kitchen Light", Type: domain.DeviceTypeLight}}}
+
+	cmds, err := newParser(nil).Parse(context.Background(), "turn the kitchen light off", registry, nil)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmds[0].Action != domain.ActionTurnOff || cmds[0].TargetName != "Kitchen Light" {
+		t.Errorf("got %s %q", cmds[0].Action, cmds[0].TargetName)
+	}
+}
+
+func TestParser_FollowUp(t *testing.T) {
+	conv := &application.Conversation{LastTargets: []string{"Luz Living"}}
+
+	cmds, err := newParser(nil).Parse(context.Background(), "apagala", house, conv)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmds[0].Action != domain.ActionTurnOff || cmds[0].TargetName != "Luz Living" {
+		t.Errorf("got %s %q", cmds[0].Action, cmds[0].TargetName)
+	}
+}
+
+func TestParser_RelativeFollowUp(t *testing.T) {
+	conv := &application.Conversation{LastTargets: []string{"Luz Living"}}
+
+	cmds, err := newParser(nil).Parse(context.Background(), "make it a bit brighter", house, conv)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if cmds[0].Action != domain.ActionSetLevel || cmds[0].TargetName != "Luz Living" || cmds[0].Parameters["delta"] != 10.0 {
+		t.Errorf("got %s %q %v", cmds[0].Action, cmds[0].TargetName, cmds[0].Parameters)
+	}
+}
+
+func TestParser_Timing(t *testing.T) {
+	tests := []struct {
+		text     string
+		action   domain.Action
+		target   string
+		delay    time.Duration
+		duration time.Duration
+		hour     int
+	}{
+		{"apagá el ventilador en 30 minutos", domain.ActionTurnOff, "Ventilador", 30 * time.Minute, 0, -1},
+		{"prendé el ventilador por diez minutos", domain.ActionTurnOn, "Ventilador", 0, 10 * time.Minute, -1},
+		{"dentro de media hora apagá la luz del living", domain.ActionTurnOff, "Luz Living", 30 * time.Minute, 0, -1},
+		{"turn the ventilador off in an hour", domain.ActionTurnOff, "Ventilador", time.Hour, 0, -1},
+		{"prendé la luz del living a las 7 de la tarde", domain.ActionTurnOn, "Luz Living", 0, 0, 19},
+		{"close the persiana at 7:30 am", domain.ActionClose, "Persiana Living", 0, 0, 7},
+		{"poné el aire a 22 grados", domain.ActionSetTemperature, "Aire Acondicionado", 0, 0, -1},
+		{"cancelá el timer del ventilador", domain.ActionCancelTimer, "Ventilador", 0, 0, -1},
+		{"cancelá todos los timers", domain.ActionCancelTimer, "", 0, 0, -1},
+		{"qué timers hay", domain.ActionListTimers, "", 0, 0, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			cmds, err := newParser(nil).Parse(context.Background(), tt.text, house, nil)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			if len(cmds) != 1 {
+				t.Fatalf("expected 1 command, got %d", len(cmds))
+			}
+			cmd := cmds[0]
+			if cmd.Action != tt.action || cmd.TargetName != tt.target {
+				t.Errorf("got %s %q, want %s %q", cmd.Action, cmd.TargetName, tt.action, tt.target)
+			}
+			if cmd.Delay != tt.delay || cmd.Duration != tt.duration {
+				t.Errorf("delay %v duration %v, want %v and %v", cmd.Delay, cmd.Duration, tt.delay, tt.duration)
+			}
+			switch {
+			case tt.hour < 0 && !cmd.At.IsZero():
+				t.Errorf("unexpected time %v", cmd.At)
+			case tt.hour >= 0 && (cmd.At.Hour() != tt.hour || !cmd.At.After(time.Now())):
+				t.Errorf("at %v, want the next %d o'clock", cmd.At, tt.hour)
+			}
+		})
+	}
+}
+
+func TestParser_Fallback(t *testing.T) {
+	llm := []*domain.Command{{Action: domain.ActionTurnOn, TargetName: "Luz Living", TargetType: domain.TargetTypeDevice}}
+
+	t.Run("unparseable goes to the fallback", func(t *testing.T) {
+		fallback := &stubParser{cmds: llm}
+		cmds, err := newParser(fallback).Parse(context.Background(), "hace que el living se vea acogedor", house, nil)
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		if fallback.calls != 1 || cmds[0] != llm[0] {
+			t.Errorf("expected the fallback result, got %+v", cmds[0])
+		}
+	})
+
+	t.Run("local guess survives a failing fallback", func(t *testing.T) {
+		fallback := &stubParser{err: errors.New("network down")}
+		cmds, err := newParser(fallback).Parse(context.Background(), "apagá el ventilador grande", house, nil)
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		if cmds[0].Action != domain.ActionTurnOff || cmds[0].TargetName != "Ventilador" {
+			t.Errorf("got %s %q", cmds[0].Action, cmds[0].TargetName)
+		}
+	})
+
+	t.Run("nothing to fall back on", func(t *testing.T) {
+		fallback := &stubParser{err: errors.New("network down")}
+		if _, err := newParser(fallback).Parse(context.Background(), "contame un chiste", house, nil); err == nil {
+			t.Error("expected the fallback error")
+		}
+	})
+
+	t.Run("alone", func(t *testing.T) {
+		cmds, err := newParser(nil).Parse(context.Background(), "contame un chiste", house, nil)
+		if err != nil {
+			t.Fatalf("Parse error: %v", err)
+		}
+		if len(cmds) != 1 || cmds[0].Action != domain.ActionUnknown {
+			t.Errorf("expected unknown, got %+v", cmds)
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package rules
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// timing is when a clause should run and for how long: "en 30 minutos",
+// "a las 7", "por una hora".
+type timing struct {
+	delay    time.Duration
+	duration time.Duration
+	at       time.Time
+}
+
+var (
+	delayWords    = set("en", "dentro", "in", "within")
+	durationWords = set("por", "durante", "for")
+	minuteWords   = set("minuto", "minutos", "min", "minute", "minutes")
+	hourWords     = set("hora", "horas", "hour", "hours")
+	oneWords      = set("un", "una", "uno", "an", "a", "one")
+	halfWords     = set("media", "half")
+	eveningWords  = set("tarde", "noche", "pm")
+)
+
+// timerWords make a clause about the timers themselves: "cancelá el timer
+// de la estufa", "¿qué timers hay?".
+var timerWords = set("timer", "timers", "temporizador", "temporizadores")
+
+var cancelWords = set(
+	"cancela", "cancelar", "cancele", "borra", "borrar", "elimina", "eliminar", "saca", "sacar",
+	"cancel", "delete", "remove", "clear",
+)
+
+// timerFillers say nothing about which timers: "los timers pendientes".
+var timerFillers = set("pendiente", "pendientes", "programado", "programados", "pending", "scheduled", "hay", "tengo", "what", "which")
+
+// extractTiming takes the words saying when out of a clause.
+func extractTiming(tokens []token, now time.Time) ([]token, timing) {
+	var t timing
+	rest := make([]token, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		w := tokens[i].norm
+		switch {
+		case delayWords[w] || durationWords[w]:
+			j := i + 1
+			if w == "dentro" && j < len(tokens) && tokens[j].norm == "de" {
+				j++
+			}
+			if d, n, ok := amount(tokens[j:]); ok {
+				if delayWords[w] {
+					t.delay = d
+				} else {
+					t.duration = d
+				}
+				i = j + n - 1
+				continue
+			}
+		case w == "a" || w == "at":
+			if at, n, ok := clockTime(tokens[i:], now); ok {
+				t.at = at
+				i += n - 1
+				continue
+			}
+		}
+		rest = append(rest, tokens[i])
+	}
+	return rest, t
+}
+
+// amount reads a length of time from the start of the tokens: "30
+// minutos", "una hora", "half an hour". n is how many tokens it took.
+func amount(tokens []token) (d time.Duration, n int, ok bool) {
+	word := func(i int) string {
+		if i < len(tokens) {
+			return tokens[i].norm
+		}
+		return ""
+	}
+
+	if halfWords[word(0)] {
+		// "media hora", "half an hour"
+		n = 1
+		if oneWords[word(n)] {
+			n++
+		}
+		if hourWords[word(n)] {
+			return 30 * time.Minute, n + 1, true
+		}
+		return 0, 0, false
+	}
+
+	v, ok := number(word(0))
+	if !ok && oneWords[word(0)] {
+		v, ok = 1, true
+	}
+	if !ok || v <= 0 {
+		return 0, 0, false
+	}
+	switch {
+	case minuteWords[word(1)]:
+		return time.Duration(v * float64(time.Minute)), 2, true
+	case hourWords[word(1)]:
+		return time.Duration(v * float64(time.Hour)), 2, true
+	}
+	return 0, 0, false
+}
+
+// clockTime reads a time of the day: "a las 7", "a las 19 30", "a las 8
+// de la noche", "at 7:30", "at 7 pm". A bare English number is a level
+// ("set the fan at 40"), so "at" needs the minutes or am/pm.
+func clockTime(tokens []token, now time.Time) (at time.Time, n int, ok bool) {
+	word := func(i int) string {
+		if i < len(tokens) {
+			return tokens[i].norm
+		}
+		return ""
+	}
+
+	spanish := word(0) == "a" && (word(1) == "las" || word(1) == "la")
+	n = 1
+	if spanish {
+		n = 2
+	}
+	hour, ok := number(word(n))
+	if !ok || hour != float64(int(hour)) || hour < 0 || hour > 23 {
+		return time.Time{}, 0, false
+	}
+	n++
+
+	minute, explicit := 0.0, false
+	if m, ok := number(word(n)); ok && m == float64(int(m)) && m >= 0 && m < 60 && len(tokens[n].orig) == 2 {
+		minute, explicit = m, true
+		n++
+	}
+
+	switch {
+	case eveningWords[word(n)]:
+		explicit = true
+		if hour < 12 {
+			hour += 12
+		}
+		n++
+	case word(n) == "de" && word(n+1) == "la" && eveningWords[word(n+2)]:
+		if hour < 12 {
+			hour += 12
+		}
+		n += 3
+	case word(n) == "am":
+		explicit = true
+		n++
+	case word(n) == "de" && word(n+1) == "la" && word(n+2) == "manana":
+		n += 3
+	}
+	if !spanish && !explicit {
+		return time.Time{}, 0, false
+	}
+
+	return application.NextAt(now, int(hour), int(minute)), n, true
+}
+
+// apply times the commands of a clause.
+func (t timing) apply(cmds []*domain.Command) {
+	for _, cmd := range cmds {
+		if cmd.Action == domain.ActionUnknown {
+			continue
+		}
+		cmd.Delay, cmd.Duration, cmd.At = t.delay, t.duration, t.at
+	}
+}
+
+// timerClause understands listing and cancelling timers. ok is false when
+// the clause isn't about timers.
+func timerClause(tokens []token, cands []candidate) (clause, bool) {
+	if !slices.ContainsFunc(tokens, func(t token) bool { return timerWords[t.norm] }) {
+		return clause{}, false
+	}
+
+	verb := tokens[0].norm
+	for _, c := range clitics {
+		if base, ok := strings.CutSuffix(verb, c); ok && cancelWords[base] {
+			verb = base
+		}
+	}
+	if !cancelWords[verb] {
+		return clause{cmds: []*domain.Command{{Action: domain.ActionListTimers, Confidence: 1}}}, true
+	}
+
+	var words []token
+	for _, t := range tokens[1:] {
+		if !timerWords[t.norm] && !timerFillers[t.norm] && !everything[t.norm] && !stopwords[t.norm] {
+			words = append(words, t)
+		}
+	}
+	if len(words) == 0 {
+		return clause{cmds: []*domain.Command{{Action: domain.ActionCancelTimer, Confidence: 1}}}, true
+	}
+
+	t, ok := resolve(words, cands, domain.ActionCancelTimer, false, false)
+	if !ok {
+		return clause{cmds: []*domain.Command{{Action: domain.ActionUnknown}}}, true
+	}
+	return clause{cmds: []*domain.Command{{
+		Action:     domain.ActionCancelTimer,
+		TargetName: t.name,
+		TargetType: t.kind,
+		Confidence: t.score,
+	}}}, true
+}
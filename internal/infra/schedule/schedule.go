@@ -0,0 +1,49 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"smart-home/internal/application"
+)
+
+// Location is where and in which time zone the house is.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Time      *time.Location
+}
+
+// Parse reads the schedule of a routine: "sunrise" or "sunset", with an
+// optional offset ("sunset-30m", "sunrise+1h"), or a cron expression ("30
+// 7 * * mon-fri").
+func Parse(spec string, loc Location) (application.Schedule, error) {
+	if loc.Time == nil {
+		loc.Time = time.Local
+	}
+	spec = strings.TrimSpace(spec)
+
+	for _, event := range []string{Sunrise, Sunset} {
+		rest, ok := strings.CutPrefix(strings.ToLower(spec), event)
+		if !ok {
+			continue
+		}
+		if loc.Latitude == 0 && loc.Longitude == 0 {
+			return nil, errors.New("sunrise and sunset need the latitude and longitude of the house")
+		}
+
+		var offset time.Duration
+		if rest != "" {
+			d, err := time.ParseDuration(strings.TrimPrefix(rest, "+"))
+			if err != nil || (rest[0] != '+' && rest[0] != '-') {
+				return nil, fmt.Errorf("invalid offset in %q: use e.g. %s-30m or %s+1h", spec, event, event)
+			}
+			offset = d
+		}
+		return NewSun(event, offset, loc.Latitude, loc.Longitude, loc.Time), nil
+	}
+
+	return ParseCron(spec, loc.Time)
+}
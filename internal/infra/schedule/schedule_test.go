@@ -0,0 +1,162 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"smart-home/internal/infra/schedule"
+)
+
+func TestCron_Next(t *testing.T) {
+	loc := time.UTC
+	// Saturday
+	from := time.Date(2026, 10, 17, 10, 15, 30, 0, loc)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 17, 10, 16, 0, 0, loc)},
+		{"30 7 * * *", time.Date(2026, 10, 18, 7, 30, 0, 0, loc)},
+		{"*/20 * * * *", time.Date(2026, 10, 17, 10, 20, 0, 0, loc)},
+		{"0 8-18/5 * * *", time.Date(2026, 10, 17, 13, 0, 0, 0, loc)},
+		{"30 7 * * mon-fri", time.Date(2026, 10, 19, 7, 30, 0, 0, loc)},
+		{"0 9 * * 7", time.Date(2026, 10, 18, 9, 0, 0, 0, loc)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, loc)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, loc)},
+		// Both day fields restricted: either of them
+		{"0 12 1 * mon", time.Date(2026, 10, 19, 12, 0, 0, 0, loc)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			c, err := schedule.ParseCron(tt.spec, loc)
+			if err != nil {
+				t.Fatalf("ParseCron error: %v", err)
+			}
+			if got := c.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCron_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := schedule.ParseCron(spec, time.UTC); err == nil {
+			t.Errorf("ParseCron(%q) should fail", spec)
+		}
+	}
+}
+
+func TestSun_Next(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	buenosAires := time.FixedZone("ART", -3*3600)
+
+	tests := []struct {
+		name     string
+		spec     string
+		loc      schedule.Location
+		from     time.Time
+		want     time.Time
+		tolerate time.Duration
+	}{
+		{
+			name: "london sunrise",
+			spec: "sunrise",
+			loc:  schedule.Location{Latitude: 51.5074, Longitude: -0.1278, Time: london},
+			from: time.Date(2024, 6, 21, 0, 0, 0, 0, london),
+			want: time.Date(2024, 6, 21, 4, 43, 0, 0, london),
+		},
+		{
+			name: "london sunset",
+			spec: "sunset",
+			loc:  schedule.Location{Latitude: 51.5074, Longitude: -0.1278, Time: london},
+			from: time.Date(2024, 6, 21, 0, 0, 0, 0, london),
+			want: time.Date(2024, 6, 21, 21, 21, 0, 0, london),
+		},
+		{
+			name: "buenos aires sunset with offset",
+			spec: "sunset-30m",
+			loc:  schedule.Location{Latitude: -34.6037, Longitude: -58.3816, Time: buenosAires},
+			from: time.Date(2024, 12, 21, 12, 0, 0, 0, buenosAires),
+			want: time.Date(2024, 12, 21, 19, 35, 0, 0, buenosAires),
+		},
+		{
+			name: "after today's sunrise, tomorrow's",
+			spec: "sunrise+1h",
+			loc:  schedule.Location{Latitude: 51.5074, Longitude: -0.1278, Time: london},
+			from: time.Date(2024, 6, 21, 12, 0, 0, 0, london),
+			want: time.Date(2024, 6, 22, 5, 43, 0, 0, london),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := schedule.Parse(tt.spec, tt.loc)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			got := s.Next(tt.from)
+			if diff := got.Sub(tt.want).Abs(); diff > 5*time.Minute {
+				t.Errorf("Next = %v, want about %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSun_PolarNight(t *testing.T) {
+	// Tromsø has no sunrise from late November to mid January
+	loc := schedule.Location{Latitude: 69.6492, Longitude: 18.9553, Time: time.UTC}
+	s, err := schedule.Parse("sunrise", loc)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	got := s.Next(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC))
+	if got.Before(time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)) || got.After(time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("first sunrise after the polar night = %v, want mid January", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	house := schedule.Location{Latitude: -34.6, Longitude: -58.4}
+
+	tests := []struct {
+		spec    string
+		loc     schedule.Location
+		want    string
+		wantErr bool
+	}{
+		{spec: "30 7 * * *", want: "30 7 * * *"},
+		{spec: "sunset", loc: house, want: "sunset"},
+		{spec: "Sunrise+1h30m", loc: house, want: "sunrise+1h30m0s"},
+		{spec: "sunset-15m", loc: house, want: "sunset-15m0s"},
+		{spec: "sunset", wantErr: true},
+		{spec: "sunset 30m", loc: house, wantErr: true},
+		{spec: "sunrise+soon", loc: house, wantErr: true},
+		{spec: "every morning", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := schedule.Parse(tt.spec, tt.loc)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			if s.String() != tt.want {
+				t.Errorf("String = %q, want %q", s.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package schedule
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Sun events a routine can be relative to.
+const (
+	Sunrise = "sunrise"
+	Sunset  = "sunset"
+)
+
+// Sun is a time relative to sunrise or sunset, every day, at a given
+// place: "sunset-30m".
+type Sun struct {
+	event    string
+	offset   time.Duration
+	lat, lon float64
+	loc      *time.Location
+}
+
+func NewSun(event string, offset time.Duration, lat, lon float64, loc *time.Location) *Sun {
+	return &Sun{event: event, offset: offset, lat: lat, lon: lon, loc: loc}
+}
+
+// Next returns the first event after the given time. Near the poles there
+// may be none for months; after a year without one it gives up and returns
+// the zero time.
+func (s *Sun) Next(after time.Time) time.Time {
+	day := after.In(s.loc)
+	// Start a day early: with a negative offset yesterday's event may still
+	// be ahead (rarely, but cheaply checked)
+	for i := -1; i <= 366; i++ {
+		date := time.Date(day.Year(), day.Month(), day.Day()+i, 12, 0, 0, 0, s.loc)
+		rise, set, ok := sunTimes(date, s.lat, s.lon)
+		if !ok {
+			continue
+		}
+		event := rise
+		if s.event == Sunset {
+			event = set
+		}
+		if t := event.Add(s.offset).In(s.loc); t.After(after) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Sun) String() string {
+	switch {
+	case s.offset > 0:
+		return fmt.Sprintf("%s+%s", s.event, s.offset)
+	case s.offset < 0:
+		return fmt.Sprintf("%s-%s", s.event, -s.offset)
+	default:
+		return s.event
+	}
+}
+
+// sunTimes works out sunrise and sunset on the date with the sunrise
+// equation (https://en.wikipedia.org/wiki/Sunrise_equation), which is good
+// to a minute or two away from the poles. ok is false when the sun doesn't
+// rise or set that day.
+func sunTimes(date time.Time, lat, lon float64) (rise, set time.Time, ok bool) {
+	const (
+		j2000      = 2451545.0
+		unixEpoch  = 2440587.5
+		obliquity  = 23.4397
+		refraction = -0.833
+	)
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	deg := func(rad float64) float64 { return rad * 180 / math.Pi }
+
+	y, m, d := date.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, time.UTC)
+	julian := float64(noon.Unix())/86400 + unixEpoch
+
+	n := math.Ceil(julian - j2000 + 0.0008)
+	meanNoon := n - lon/360
+	anomaly := math.Mod(357.5291+0.98560028*meanNoon, 360)
+	center := 1.9148*math.Sin(rad(anomaly)) + 0.02*math.Sin(rad(2*anomaly)) + 0.0003*math.Sin(rad(3*anomaly))
+	longitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := j2000 + meanNoon + 0.0053*math.Sin(rad(anomaly)) - 0.0069*math.Sin(rad(2*longitude))
+
+	declination := math.Asin(math.Sin(rad(longitude)) * math.Sin(rad(obliquity)))
+	cosHourAngle := (math.Sin(rad(refraction)) - math.Sin(rad(lat))*math.Sin(declination)) / (math.Cos(rad(lat)) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := deg(math.Acos(cosHourAngle))
+
+	toTime := func(j float64) time.Time {
+		return time.Unix(0, int64((j-unixEpoch)*86400*float64(time.Second))).UTC()
+	}
+	return toTime(transit - hourAngle/360), toTime(transit + hourAngle/360), true
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"smart-home/internal/application"
+)
+
+// RoutineStore saves whether each routine is enabled and how its last run
+// went to a JSON file.
+type RoutineStore struct {
+	path string
+}
+
+func NewRoutineStore(path string) *RoutineStore {
+	return &RoutineStore{path: path}
+}
+
+// routineRecord is the state of a routine as written to the file.
+type routineRecord struct {
+	Enabled    bool      `json:"enabled"`
+	LastRun    time.Time `json:"last_run"`
+	LastResult string    `json:"last_result,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Load reads the state of the routines by name. A missing file means none
+// was saved.
+func (s *RoutineStore) Load() (map[string]application.RoutineState, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+
+	var records map[string]routineRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	states := make(map[string]application.RoutineState, len(records))
+	for name, r := range records {
+		states[name] = application.RoutineState(r)
+	}
+	return states, nil
+}
+
+// Save replaces the file with the given state.
+func (s *RoutineStore) Save(states map[string]application.RoutineState) error {
+	records := make(map[string]routineRecord, len(states))
+	for name, state := range states {
+		records[name] = routineRecord(state)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data)
+}
@@ -0,0 +1,40 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/infra/storage"
+)
+
+func TestRoutineStore(t *testing.T) {
+	store := storage.NewRoutineStore(filepath.Join(t.TempDir(), "data", "routines.json"))
+
+	states, err := store.Load()
+	if err != nil || len(states) != 0 {
+		t.Fatalf("a missing file should hold no state, got %v, %v", states, err)
+	}
+
+	want := map[string]application.RoutineState{
+		"buenos días": {
+			Enabled:    true,
+			LastRun:    time.Date(2026, 10, 17, 7, 30, 0, 0, time.UTC),
+			LastResult: "Encendido: Luz Cocina",
+		},
+		"atardecer": {Enabled: false, LastError: "device not found"},
+	}
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+// Package storage keeps the assistant's state in files, so that it
+// survives restarts.
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+)
+
+// TimerStore saves the pending timers to a JSON file.
+type TimerStore struct {
+	path string
+}
+
+func NewTimerStore(path string) *TimerStore {
+	return &TimerStore{path: path}
+}
+
+// timerRecord is a timer as written to the file.
+type timerRecord struct {
+	ID         string         `json:"id"`
+	At         time.Time      `json:"at"`
+	Action     string         `json:"action"`
+	TargetName string         `json:"target_name"`
+	TargetType string         `json:"target_type"`
+	DeviceType string         `json:"device_type,omitempty"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+	Text       string         `json:"text,omitempty"`
+}
+
+// Load reads the timers from the file. A missing file means no timers.
+func (s *TimerStore) Load() ([]application.Timer, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+
+	var records []timerRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", s.path, err)
+	}
+
+	timers := make([]application.Timer, 0, len(records))
+	for _, r := range records {
+		timers = append(timers, application.Timer{
+			ID: r.ID,
+			At: r.At,
+			Command: domain.Command{
+				Action:     domain.Action(r.Action),
+				TargetName: r.TargetName,
+				TargetType: domain.TargetType(r.TargetType),
+				DeviceType: domain.DeviceType(r.DeviceType),
+				Parameters: r.Parameters,
+				RawText:    r.Text,
+			},
+		})
+	}
+	return timers, nil
+}
+
+// Save replaces the file with the given timers.
+func (s *TimerStore) Save(timers []application.Timer) error {
+	records := make([]timerRecord, 0, len(timers))
+	for _, t := range timers {
+		records = append(records, timerRecord{
+			ID:         t.ID,
+			At:         t.At,
+			Action:     string(t.Command.Action),
+			TargetName: t.Command.TargetName,
+			TargetType: string(t.Command.TargetType),
+			DeviceType: string(t.Command.DeviceType),
+			Parameters: t.Command.Parameters,
+			Text:       t.Command.RawText,
+		})
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, data)
+}
+
+// writeFile replaces a file through a temporary one, so that a crash
+// halfway leaves the previous version rather than a truncated file.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
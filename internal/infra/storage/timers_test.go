@@ -0,0 +1,56 @@
+package storage_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/domain"
+	"smart-home/internal/infra/storage"
+)
+
+func TestTimerStore(t *testing.T) {
+	store := storage.NewTimerStore(filepath.Join(t.TempDir(), "data", "timers.json"))
+
+	timers, err := store.Load()
+	if err != nil || len(timers) != 0 {
+		t.Fatalf("a missing file should hold no timers, got %v, %v", timers, err)
+	}
+
+	want := []application.Timer{{
+		ID: "3",
+		At: time.Date(2026, 10, 17, 19, 30, 0, 0, time.UTC),
+		Command: domain.Command{
+			Action:     domain.ActionSetLevel,
+			TargetName: "Luz Living",
+			TargetType: domain.TargetTypeDevice,
+			Parameters: map[string]any{"level": 30.0},
+			RawText:    "bajá la luz al 30 a las 19:30",
+		},
+	}}
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestTimerStore_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timers.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.NewTimerStore(path).Load(); err == nil {
+		t.Error("expected an error for a corrupt file")
+	}
+}
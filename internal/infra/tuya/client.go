@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
@@ -22,14 +23,25 @@ type Client struct {
 	secret     string
 	baseURL    string
 	httpClient *http.Client
+	logger     *slog.Logger
 
 	mu       sync.RWMutex
 	token    string
 	expireAt time.Time
 	uid      string
+
+	specMu sync.RWMutex
+	specs  map[string]deviceSpec
+}
+
+// deviceSpec is what the client learned about a device on sync: its
+// category and the function specification its commands are built from.
+type deviceSpec struct {
+	category  string
+	functions []domain.DeviceFunction
 }
 
-func NewClient(clientID, secret, region string) *Client {
+func NewClient(clientID, secret, region string, logger *slog.Logger) *Client {
 	baseURL := "https://openapi.tuyaus.com"
 	switch strings.ToLower(region) {
 	case "eu":
@@ -40,20 +52,25 @@ func NewClient(clientID, secret, region string) *Client {
 		baseURL = "https://openapi.tuyain.com"
 	}
 
-	return NewClientWithURL(clientID, secret, baseURL)
+	return NewClientWithURL(clientID, secret, baseURL, logger)
 }
 
-func NewClientWithURL(clientID, secret, baseURL string) *Client {
+func NewClientWithURL(clientID, secret, baseURL string, logger *slog.Logger) *Client {
 	return &Client{
 		clientID:   clientID,
 		secret:     secret,
 		baseURL:    baseURL,
 		httpClient: &http.Client{Timeout: 15 * time.Second},
+		logger:     logger,
+		specs:      make(map[string]deviceSpec),
 	}
 }
 
 func (c *Client) ExecuteCommand(ctx context.Context, cmd *domain.Command) error {
-	commands := c.buildCommands(cmd)
+	commands, err := buildCommands(c.functions(ctx, cmd.TargetID), cmd)
+	if err != nil {
+		return err
+	}
 	body, _ := json.Marshal(map[string]any{"commands": commands})
 
 	path := fmt.Sprintf("/v1.0/iot-03/devices/%s/commands", cmd.TargetID)
@@ -99,27 +116,66 @@ func (c *Client) TriggerScene(ctx context.Context, sceneID string) error {
 	return nil
 }
 
-func (c *Client) buildCommands(cmd *domain.Command) []map[string]any {
-	switch cmd.Action {
-	case domain.ActionTurnOn:
-		return []map[string]any{{"code": "switch_led", "value": true}}
-	case domain.ActionTurnOff:
-		return []map[string]any{{"code": "switch_led", "value": false}}
-	case domain.ActionSetLevel:
-		level, ok := cmd.Parameters["level"].(float64)
-		if !ok {
-			level = 100
-		}
-		tuyaLevel := int(level * 10)
-		return []map[string]any{
-			{"code": "switch_led", "value": true},
-			{"code": "bright_value_v2", "value": tuyaLevel},
+func (c *Client) GetState(ctx context.Context, deviceID string) (*domain.DeviceState, error) {
+	path := fmt.Sprintf("/v1.0/devices/%s/status", deviceID)
+	resp, err := c.doRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching status: %w", err)
+	}
+
+	var result struct {
+		Success bool   `json:"success"`
+		Msg     string `json:"msg"`
+		Result  []struct {
+			Code  string `json:"code"`
+			Value any    `json:"value"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("parsing status: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("tuya error: %s", result.Msg)
+	}
+
+	status := make(map[string]any, len(result.Result))
+	for _, dp := range result.Result {
+		status[dp.Code] = dp.Value
+	}
+
+	return statusToState(status, c.functions(ctx, deviceID)), nil
+}
+
+// functions returns the function specification of a device, fetching it
+// the first time it's needed and falling back to the defaults of the
+// device's category when the cloud doesn't have one.
+func (c *Client) functions(ctx context.Context, deviceID string) []domain.DeviceFunction {
+	c.specMu.RLock()
+	_, known := c.specs[deviceID]
+	c.specMu.RUnlock()
+
+	if !known {
+		if functions, err := c.GetFunctions(ctx, deviceID); err == nil && len(functions) > 0 {
+			c.specMu.Lock()
+			c.specs[deviceID] = deviceSpec{functions: functions}
+			c.specMu.Unlock()
 		}
-	case domain.ActionSetColor:
-		return []map[string]any{{"code": "switch_led", "value": true}}
-	default:
-		return []map[string]any{}
 	}
+
+	return c.knownFunctions(deviceID)
+}
+
+// knownFunctions is like functions but never goes to the network.
+func (c *Client) knownFunctions(deviceID string) []domain.DeviceFunction {
+	c.specMu.RLock()
+	spec := c.specs[deviceID]
+	c.specMu.RUnlock()
+
+	if len(spec.functions) > 0 {
+		return spec.functions
+	}
+	return categoryDefaults(spec.category)
 }
 
 func (c *Client) GetDevices(ctx context.Context) ([]domain.Device, error) {
@@ -151,18 +207,112 @@ func (c *Client) GetDevices(ctx context.Context) ([]domain.Device, error) {
 
 	devices := make([]domain.Device, 0, len(result.Result.Devices))
 	for _, d := range result.Result.Devices {
-		devices = append(devices, domain.Device{
+		device := domain.Device{
 			ID:       d.ID,
 			Name:     d.Name,
 			Category: d.Category,
 			Type:     categoryToType(d.Category),
 			Online:   d.Online,
-		})
+		}
+
+		// Specs don't change: they are only fetched for devices not seen
+		// before, or whose fetch failed last time
+		c.specMu.RLock()
+		spec := c.specs[d.ID]
+		c.specMu.RUnlock()
+		spec.category = d.Category
+		if spec.functions == nil {
+			functions, err := c.GetFunctions(ctx, d.ID)
+			if err != nil {
+				c.logger.Warn("fetching device functions", "device", d.Name, "error", err)
+			}
+			spec.functions = functions
+		}
+
+		c.specMu.Lock()
+		c.specs[d.ID] = spec
+		c.specMu.Unlock()
+
+		// Without the function specs the device's capabilities stay unknown
+		// and every command is attempted
+		if spec.functions != nil {
+			device.Functions = spec.functions
+			device.Capabilities = functionsToCapabilities(spec.functions)
+		}
+
+		devices = append(devices, device)
 	}
 
 	return devices, nil
 }
 
+// GetLocalKey returns the AES key the device uses on the LAN protocol.
+func (c *Client) GetLocalKey(ctx context.Context, deviceID string) (string, error) {
+	resp, err := c.doRequest(ctx, http.MethodGet, "/v1.0/devices/"+deviceID, nil)
+	if err != nil {
+		return "", fmt.Errorf("fetching device: %w", err)
+	}
+
+	var result struct {
+		Success bool   `json:"success"`
+		Msg     string `json:"msg"`
+		Result  struct {
+			LocalKey string `json:"local_key"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return "", fmt.Errorf("parsing device: %w", err)
+	}
+
+	if !result.Success {
+		return "", fmt.Errorf("tuya error: %s", result.Msg)
+	}
+	if result.Result.LocalKey == "" {
+		return "", fmt.Errorf("no local key for device %s", deviceID)
+	}
+
+	return result.Result.LocalKey, nil
+}
+
+// GetDataPoints returns the LAN data point id of each instruction code the
+// device supports.
+func (c *Client) GetDataPoints(ctx context.Context, deviceID string) (map[string]int, error) {
+	path := fmt.Sprintf("/v1.1/devices/%s/specifications", deviceID)
+	resp, err := c.doRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching specifications: %w", err)
+	}
+
+	type spec struct {
+		Code string `json:"code"`
+		DPID int    `json:"dp_id"`
+	}
+	var result struct {
+		Success bool   `json:"success"`
+		Msg     string `json:"msg"`
+		Result  struct {
+			Functions []spec `json:"functions"`
+			Status    []spec `json:"status"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("parsing specifications: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("tuya error: %s", result.Msg)
+	}
+
+	dps := make(map[string]int)
+	for _, s := range append(result.Result.Functions, result.Result.Status...) {
+		if s.DPID > 0 {
+			dps[s.Code] = s.DPID
+		}
+	}
+
+	return dps, nil
+}
+
 func (c *Client) GetHomes(ctx context.Context) ([]string, error) {
 	if err := c.ensureToken(ctx); err != nil {
 		return nil, err
@@ -367,8 +517,13 @@ func categoryToType(category string) domain.DeviceType {
 		return domain.DeviceTypePlug
 	case "kg", "tdq":
 		return domain.DeviceTypeSwitch
-	case "wk", "wkf":
+	case "wk", "wkf", "kt":
 		return domain.DeviceTypeThermostat
+	case "cl", "clkg":
+		return domain.DeviceTypeCover
+	case "fs", "fsd":
+		return domain.DeviceTypeFan
+
 	case "pir", "mcs", "ywbj", "rqbj", "jwbj":
 		return domain.DeviceTypeSensor
 	default:
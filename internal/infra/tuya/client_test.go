@@ -3,15 +3,21 @@ package tuya_test
 import (
 	"context"
 	"encoding/json"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"smart-home/internal/domain"
 	"smart-home/internal/infra/tuya"
 )
 
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
 func TestClient_GetDevices(t *testing.T) {
+	var specFetches atomic.Int32
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case "/v1.0/token":
@@ -33,13 +39,24 @@ func TestClient_GetDevices(t *testing.T) {
 					},
 				},
 			})
+		case "/v1.0/devices/dev2/functions":
+			specFetches.Add(1)
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result": map[string]any{
+					"functions": []map[string]any{
+						{"code": "switch_1", "type": "Boolean", "values": "{}"},
+						{"code": "countdown_1", "type": "Integer", "values": `{"min":0,"max":86400,"scale":0,"step":1}`},
+					},
+				},
+			})
 		default:
 			http.Error(w, "not found", http.StatusNotFound)
 		}
 	}))
 	defer server.Close()
 
-	client := tuya.NewClientWithURL("client-id", "secret", server.URL)
+	client := tuya.NewClientWithURL("client-id", "secret", server.URL, testLogger)
 
 	devices, err := client.GetDevices(context.Background())
 	if err != nil {
@@ -57,6 +74,30 @@ func TestClient_GetDevices(t *testing.T) {
 	if devices[0].Type != domain.DeviceTypeLight {
 		t.Errorf("device type: got %s, want light", devices[0].Type)
 	}
+
+	if devices[0].Capabilities != nil {
+		t.Errorf("capabilities without function specs: got %v, want unknown", devices[0].Capabilities)
+	}
+
+	plug := devices[1]
+	if len(plug.Functions) != 2 || plug.Functions[1].Values["max"] != 86400.0 {
+		t.Errorf("functions: got %+v", plug.Functions)
+	}
+	if plug.Supports(domain.ActionSetColor) || !plug.Supports(domain.ActionTurnOff) {
+		t.Errorf("plug capabilities: got %s", plug.Capabilities)
+	}
+
+	// The next sync keeps the specs it already has
+	devices, err = client.GetDevices(context.Background())
+	if err != nil {
+		t.Fatalf("second GetDevices error: %v", err)
+	}
+	if len(devices[1].Functions) != 2 {
+		t.Errorf("functions after the second sync: got %+v", devices[1].Functions)
+	}
+	if n := specFetches.Load(); n != 1 {
+		t.Errorf("function specs fetched %d times, want once", n)
+	}
 }
 
 func TestClient_ExecuteCommand(t *testing.T) {
@@ -84,7 +125,7 @@ func TestClient_ExecuteCommand(t *testing.T) {
 	}))
 	defer server.Close()
 
-	client := tuya.NewClientWithURL("client-id", "secret", server.URL)
+	client := tuya.NewClientWithURL("client-id", "secret", server.URL, testLogger)
 
 	cmd := &domain.Command{
 		Action:   domain.ActionTurnOn,
@@ -126,7 +167,7 @@ func TestClient_TriggerScene(t *testing.T) {
 	}))
 	defer server.Close()
 
-	client := tuya.NewClientWithURL("client-id", "secret", server.URL)
+	client := tuya.NewClientWithURL("client-id", "secret", server.URL, testLogger)
 
 	err := client.TriggerScene(context.Background(), "scene1")
 	if err != nil {
@@ -138,3 +179,41 @@ func TestClient_TriggerScene(t *testing.T) {
 	}
 }
 
+
+func TestClient_GetState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1.0/token":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result": map[string]any{
+					"access_token": "test-token",
+					"expire_time":  7200,
+					"uid":          "test-uid",
+				},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/v1.0/devices/dev1/status":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result": []map[string]any{
+					{"code": "switch_led", "value": true},
+					{"code": "bright_value_v2", "value": 400},
+				},
+			})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := tuya.NewClientWithURL("client-id", "secret", server.URL, testLogger)
+
+	state, err := client.GetState(context.Background(), "dev1")
+	if err != nil {
+		t.Fatalf("GetState error: %v", err)
+	}
+
+	if got := state.Describe("Luz Living"); got != "Luz Living is on at 40%" {
+		t.Errorf("state: got %q, want %q", got, "Luz Living is on at 40%")
+	}
+}
@@ -0,0 +1,119 @@
+package tuya
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"smart-home/internal/domain"
+)
+
+// functionValues is the decoded "values" spec of a Tuya function: the
+// bounds of an Integer, or the options of an Enum.
+type functionValues struct {
+	Min   float64  `json:"min"`
+	Max   float64  `json:"max"`
+	Scale int      `json:"scale"`
+	Step  float64  `json:"step"`
+	Unit  string   `json:"unit"`
+	Range []string `json:"range"`
+}
+
+// GetFunctions returns the instruction codes a device accepts, with their
+// type ("Boolean", "Integer", "Enum", "Json"...) and value spec.
+func (c *Client) GetFunctions(ctx context.Context, deviceID string) ([]domain.DeviceFunction, error) {
+	path := fmt.Sprintf("/v1.0/devices/%s/functions", deviceID)
+	resp, err := c.doRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching functions: %w", err)
+	}
+
+	var result struct {
+		Success bool   `json:"success"`
+		Msg     string `json:"msg"`
+		Result  struct {
+			Functions []struct {
+				Code   string `json:"code"`
+				Type   string `json:"type"`
+				Values string `json:"values"`
+			} `json:"functions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, fmt.Errorf("parsing functions: %w", err)
+	}
+
+	if !result.Success {
+		return nil, fmt.Errorf("tuya error: %s", result.Msg)
+	}
+
+	functions := make([]domain.DeviceFunction, 0, len(result.Result.Functions))
+	for _, f := range result.Result.Functions {
+		var values map[string]any
+		// Values is JSON encoded inside a string; an unparseable spec just
+		// leaves the function without bounds
+		_ = json.Unmarshal([]byte(f.Values), &values)
+		functions = append(functions, domain.DeviceFunction{
+			Code:   f.Code,
+			Type:   f.Type,
+			Values: values,
+		})
+	}
+
+	return functions, nil
+}
+
+// decodeValues converts a function's generic Values map into its spec.
+func decodeValues(f domain.DeviceFunction) functionValues {
+	var v functionValues
+	data, err := json.Marshal(f.Values)
+	if err != nil {
+		return v
+	}
+	_ = json.Unmarshal(data, &v)
+	return v
+}
+
+// scaled applies the spec's scale, e.g. 215 with scale 1 is 21.5.
+func (v functionValues) scaled(raw float64) float64 {
+	return raw / math.Pow10(v.Scale)
+}
+
+// functionsToCapabilities maps the standard instruction set onto actions,
+// mirroring what buildCommands knows how to send.
+func functionsToCapabilities(functions []domain.DeviceFunction) *domain.Capabilities {
+	caps := &domain.Capabilities{}
+
+	if len(switchFunctions(functions)) > 0 {
+		caps.Add(domain.ActionTurnOn, domain.ActionTurnOff)
+	}
+
+	for _, f := range functions {
+		switch f.Code {
+		case "bright_value", "bright_value_v2", "fan_speed_percent":
+			caps.Add(domain.ActionSetLevel)
+		case "colour_data", "colour_data_v2", "temp_value", "temp_value_v2":
+			caps.Add(domain.ActionSetColor)
+		case "temp_set":
+			caps.Add(domain.ActionSetTemperature)
+			v := decodeValues(f)
+			if v.Max > v.Min {
+				caps.SetRange("temperature", v.scaled(v.Min), v.scaled(v.Max))
+			}
+		case "mode":
+			if options := decodeValues(f).Range; len(options) > 0 {
+				caps.Add(domain.ActionSetHVACMode)
+				caps.SetOptions("hvac_mode", options)
+			}
+		case "control":
+			// Curtains take "open", "stop" and "close"
+			caps.Add(domain.ActionOpen, domain.ActionClose, domain.ActionTurnOn, domain.ActionTurnOff)
+		case "percent_control":
+			caps.Add(domain.ActionSetPosition, domain.ActionSetLevel)
+		}
+	}
+
+	return caps
+}
@@ -0,0 +1,382 @@
+package tuya
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"smart-home/internal/domain"
+)
+
+// LocalDevice is a Tuya device reachable on the LAN.
+type LocalDevice struct {
+	ID      string
+	IP      string
+	Version string // "3.3" or "3.4"
+	// LocalKey overrides the key fetched from the cloud
+	LocalKey string
+}
+
+// localDeviceInfo is what the LAN protocol needs from the cloud: the AES key,
+// the data point id behind each instruction code and the function
+// specification commands are built from.
+type localDeviceInfo struct {
+	LocalKey  string                  `json:"local_key"`
+	DPs       map[string]int          `json:"dps"`
+	Functions []domain.DeviceFunction `json:"functions,omitempty"`
+}
+
+// defaultDPs are the data point ids of the standard instruction set, used
+// when the cloud doesn't report a mapping for the device. Different kinds of
+// devices share ids (1 is "switch" on a plug and "switch_1" on a power
+// strip): reading a status, only the codes in the device's function
+// specification are considered, and without one the first listed wins.
+var defaultDPs = []struct {
+	code string
+	id   int
+}{
+	{"switch_1", 1},
+	{"switch_2", 2},
+	{"switch_3", 3},
+	{"switch_4", 4},
+	{"switch", 1},
+	{"bright_value", 2},
+	{"switch_led", 20},
+	{"work_mode", 21},
+	{"bright_value_v2", 22},
+	{"temp_value_v2", 23},
+	{"colour_data_v2", 24},
+}
+
+// LocalClient controls Tuya devices directly over the LAN protocol (TCP port
+// 6668), so they keep working when the internet or Tuya's cloud is down.
+// Local keys are fetched once from the cloud and cached in keyFile. Devices
+// not listed, scenes and failed local calls go through the cloud client.
+type LocalClient struct {
+	cloud   *Client
+	devices map[string]LocalDevice
+	keyFile string
+	port    int
+	timeout time.Duration
+	logger  *slog.Logger
+
+	mu   sync.Mutex
+	info map[string]localDeviceInfo
+}
+
+func NewLocalClient(cloud *Client, devices []LocalDevice, keyFile string, logger *slog.Logger) *LocalClient {
+	c := &LocalClient{
+		cloud:   cloud,
+		devices: make(map[string]LocalDevice, len(devices)),
+		keyFile: keyFile,
+		port:    localPort,
+		timeout: 5 * time.Second,
+		logger:  logger,
+		info:    make(map[string]localDeviceInfo),
+	}
+
+	for _, d := range devices {
+		if d.Version == "" {
+			d.Version = "3.3"
+		}
+		c.devices[d.ID] = d
+	}
+
+	c.loadKeys()
+	return c
+}
+
+func (c *LocalClient) ExecuteCommand(ctx context.Context, cmd *domain.Command) error {
+	dev, ok := c.devices[cmd.TargetID]
+	if !ok {
+		return c.cloud.ExecuteCommand(ctx, cmd)
+	}
+
+	err := c.control(ctx, dev, cmd)
+	if err == nil {
+		return nil
+	}
+
+	c.logger.Warn("local control failed, falling back to cloud", "device", dev.ID, "error", err)
+	if cloudErr := c.cloud.ExecuteCommand(ctx, cmd); cloudErr != nil {
+		return fmt.Errorf("local: %v; cloud: %w", err, cloudErr)
+	}
+	return nil
+}
+
+func (c *LocalClient) TriggerScene(ctx context.Context, sceneID string) error {
+	return c.cloud.TriggerScene(ctx, sceneID)
+}
+
+func (c *LocalClient) GetState(ctx context.Context, deviceID string) (*domain.DeviceState, error) {
+	dev, ok := c.devices[deviceID]
+	if !ok {
+		return c.cloud.GetState(ctx, deviceID)
+	}
+
+	status, functions, err := c.query(ctx, dev)
+	if err == nil {
+		return statusToState(status, functions), nil
+	}
+
+	c.logger.Warn("local status query failed, falling back to cloud", "device", dev.ID, "error", err)
+	return c.cloud.GetState(ctx, deviceID)
+}
+
+func (c *LocalClient) control(ctx context.Context, dev LocalDevice, cmd *domain.Command) error {
+	info, err := c.deviceInfo(ctx, dev)
+	if err != nil {
+		return err
+	}
+
+	commands, err := buildCommands(c.functions(dev, info), cmd)
+	if err != nil {
+		return err
+	}
+
+	dps := make(map[string]any, len(commands))
+	for _, command := range commands {
+		code, _ := command["code"].(string)
+		id, ok := dpID(info, code)
+		if !ok {
+			return fmt.Errorf("no data point for %q", code)
+		}
+		value := command["value"]
+		if colour, ok := value.(hsv); ok {
+			value = colour.localString(code)
+		}
+		dps[strconv.Itoa(id)] = value
+	}
+
+	conn, err := c.dial(ctx, dev, info)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	now := time.Now().Unix()
+	if dev.Version == "3.4" {
+		body, _ := json.Marshal(map[string]any{
+			"protocol": 5,
+			"t":        now,
+			"data":     map[string]any{"dps": dps},
+		})
+		_, err = conn.request(cmdControlNew, body)
+	} else {
+		body, _ := json.Marshal(map[string]any{
+			"devId": dev.ID,
+			"uid":   dev.ID,
+			"t":     strconv.FormatInt(now, 10),
+			"dps":   dps,
+		})
+		_, err = conn.request(cmdControl, body)
+	}
+	if err != nil {
+		return fmt.Errorf("sending control: %w", err)
+	}
+
+	return nil
+}
+
+// query reads the device's data points and returns them keyed by
+// instruction code, along with the device's function specification.
+func (c *LocalClient) query(ctx context.Context, dev LocalDevice) (map[string]any, []domain.DeviceFunction, error) {
+	info, err := c.deviceInfo(ctx, dev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := c.dial(ctx, dev, info)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	var resp []byte
+	if dev.Version == "3.4" {
+		resp, err = conn.request(cmdDPQueryNew, []byte("{}"))
+	} else {
+		body, _ := json.Marshal(map[string]any{
+			"gwId":  dev.ID,
+			"devId": dev.ID,
+			"uid":   dev.ID,
+			"t":     strconv.FormatInt(time.Now().Unix(), 10),
+		})
+		resp, err = conn.request(cmdDPQuery, body)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying status: %w", err)
+	}
+
+	var result struct {
+		DPs  map[string]any `json:"dps"`
+		Data struct {
+			DPs map[string]any `json:"dps"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, nil, fmt.Errorf("parsing status: %w", err)
+	}
+
+	dps := result.DPs
+	if dps == nil {
+		dps = result.Data.DPs
+	}
+
+	functions := c.functions(dev, info)
+	codes := statusCodes(info, functions)
+
+	status := make(map[string]any, len(dps))
+	for key, value := range dps {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		if code, ok := codes[id]; ok {
+			status[code] = value
+		}
+	}
+
+	return status, functions, nil
+}
+
+// statusCodes maps the data point ids of a device back to instruction
+// codes: the cloud's mapping first, then the defaults for the codes the
+// device has.
+func statusCodes(info localDeviceInfo, functions []domain.DeviceFunction) map[int]string {
+	codes := make(map[int]string, len(info.DPs))
+	for code, id := range info.DPs {
+		codes[id] = code
+	}
+
+	has := make(map[string]bool, len(functions))
+	for _, f := range functions {
+		has[f.Code] = true
+	}
+	for _, dp := range defaultDPs {
+		if _, ok := codes[dp.id]; ok {
+			continue
+		}
+		if _, ok := info.DPs[dp.code]; ok {
+			continue
+		}
+		if len(functions) > 0 && !has[dp.code] {
+			continue
+		}
+		codes[dp.id] = dp.code
+	}
+	return codes
+}
+
+// functions prefers the specification cached with the local key, then
+// whatever the cloud client learned on sync, without going online.
+func (c *LocalClient) functions(dev LocalDevice, info localDeviceInfo) []domain.DeviceFunction {
+	if len(info.Functions) > 0 {
+		return info.Functions
+	}
+	return c.cloud.knownFunctions(dev.ID)
+}
+
+func (c *LocalClient) dial(ctx context.Context, dev LocalDevice, info localDeviceInfo) (*localConn, error) {
+	addr := net.JoinHostPort(dev.IP, strconv.Itoa(c.port))
+	return dialLocal(ctx, addr, dev.Version, []byte(info.LocalKey), c.timeout)
+}
+
+// deviceInfo returns the local key and data point mapping of a device,
+// asking the cloud the first time and caching the answer on disk. A key set
+// in the configuration saves fetching it, but the data points are still
+// needed.
+func (c *LocalClient) deviceInfo(ctx context.Context, dev LocalDevice) (localDeviceInfo, error) {
+	c.mu.Lock()
+	info, ok := c.info[dev.ID]
+	c.mu.Unlock()
+
+	if dev.LocalKey != "" {
+		info.LocalKey = dev.LocalKey
+	}
+	if ok && info.LocalKey != "" {
+		return info, nil
+	}
+
+	if info.LocalKey == "" {
+		key, err := c.cloud.GetLocalKey(ctx, dev.ID)
+		if err != nil {
+			return localDeviceInfo{}, fmt.Errorf("fetching local key: %w", err)
+		}
+		info.LocalKey = key
+	}
+
+	if !ok {
+		dps, err := c.cloud.GetDataPoints(ctx, dev.ID)
+		if err != nil {
+			c.logger.Warn("fetching data points, using defaults", "device", dev.ID, "error", err)
+		}
+		info.DPs = dps
+
+		functions, err := c.cloud.GetFunctions(ctx, dev.ID)
+		if err != nil {
+			c.logger.Warn("fetching function specification, using defaults", "device", dev.ID, "error", err)
+		}
+		info.Functions = functions
+	}
+
+	c.mu.Lock()
+	c.info[dev.ID] = info
+	c.mu.Unlock()
+
+	c.saveKeys()
+	return info, nil
+}
+
+func dpID(info localDeviceInfo, code string) (int, bool) {
+	if id, ok := info.DPs[code]; ok {
+		return id, true
+	}
+	for _, dp := range defaultDPs {
+		if dp.code == code {
+			return dp.id, true
+		}
+	}
+	return 0, false
+}
+
+func (c *LocalClient) loadKeys() {
+	if c.keyFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Warn("reading tuya key cache", "path", c.keyFile, "error", err)
+		}
+		return
+	}
+
+	if err := json.Unmarshal(data, &c.info); err != nil {
+		c.logger.Warn("parsing tuya key cache", "path", c.keyFile, "error", err)
+	}
+}
+
+func (c *LocalClient) saveKeys() {
+	if c.keyFile == "" {
+		return
+	}
+
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.info, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err := os.WriteFile(c.keyFile, data, 0600); err != nil {
+		c.logger.Warn("writing tuya key cache", "path", c.keyFile, "error", err)
+	}
+}
@@ -0,0 +1,344 @@
+package tuya
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"smart-home/internal/domain"
+)
+
+const testLocalKey = "0123456789abcdef"
+
+// fakeDevice speaks the device side of the LAN protocol and keeps its data
+// points in memory.
+type fakeDevice struct {
+	t        *testing.T
+	version  string
+	listener net.Listener
+
+	mu  sync.Mutex
+	dps map[string]any
+}
+
+func newFakeDevice(t *testing.T, version string, dps map[string]any) *fakeDevice {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+
+	d := &fakeDevice{t: t, version: version, listener: listener, dps: dps}
+	go d.serve()
+	t.Cleanup(func() { listener.Close() })
+	return d
+}
+
+func (d *fakeDevice) port() int {
+	return d.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (d *fakeDevice) dp(id string) any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dps[id]
+}
+
+func (d *fakeDevice) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDevice) handle(conn net.Conn) {
+	defer conn.Close()
+
+	key := []byte(testLocalKey)
+	var hmacKey []byte
+	if d.version == "3.4" {
+		hmacKey = key
+		sessionKey, ok := d.negotiate(conn, key)
+		if !ok {
+			return
+		}
+		key, hmacKey = sessionKey, sessionKey
+	}
+
+	for {
+		req, err := readFrame(conn, hmacKey)
+		if err != nil {
+			return
+		}
+
+		payload := stripVersionHeader(req.payload, d.version)
+		plain, err := aesECBDecrypt(key, payload)
+		if err != nil {
+			d.t.Errorf("device: decrypting request: %v", err)
+			return
+		}
+		plain = stripVersionHeader(plain, d.version)
+
+		var body struct {
+			DPs  map[string]any `json:"dps"`
+			Data struct {
+				DPs map[string]any `json:"dps"`
+			} `json:"data"`
+		}
+		json.Unmarshal(plain, &body)
+
+		var answer []byte
+		switch req.cmd {
+		case cmdControl, cmdControlNew:
+			d.mu.Lock()
+			for k, v := range body.DPs {
+				d.dps[k] = v
+			}
+			for k, v := range body.Data.DPs {
+				d.dps[k] = v
+			}
+			d.mu.Unlock()
+		case cmdDPQuery, cmdDPQueryNew:
+			d.mu.Lock()
+			data, _ := json.Marshal(map[string]any{"devId": "dev1", "dps": d.dps})
+			d.mu.Unlock()
+			answer, _ = aesECBEncrypt(key, data, true)
+		}
+
+		reply := append([]byte{0, 0, 0, 0}, answer...)
+		conn.Write(encodeFrame(frame{seq: req.seq, cmd: req.cmd, payload: reply}, hmacKey))
+	}
+}
+
+func (d *fakeDevice) negotiate(conn net.Conn, localKey []byte) ([]byte, bool) {
+	start, err := readFrame(conn, localKey)
+	if err != nil || start.cmd != cmdSessKeyNegStart {
+		d.t.Errorf("device: expected session start, got %v %v", start.cmd, err)
+		return nil, false
+	}
+	clientNonce, err := aesECBDecrypt(localKey, start.payload)
+	if err != nil {
+		d.t.Errorf("device: decrypting nonce: %v", err)
+		return nil, false
+	}
+
+	deviceNonce := []byte("fedcba9876543210")
+	answer, _ := aesECBEncrypt(localKey, append(append([]byte{}, deviceNonce...), hmacSHA256(localKey, clientNonce)...), true)
+	conn.Write(encodeFrame(frame{seq: start.seq, cmd: cmdSessKeyNegResp, payload: append([]byte{0, 0, 0, 0}, answer...)}, localKey))
+
+	finish, err := readFrame(conn, localKey)
+	if err != nil || finish.cmd != cmdSessKeyNegFinish {
+		d.t.Errorf("device: expected session finish, got %v %v", finish.cmd, err)
+		return nil, false
+	}
+	proof, err := aesECBDecrypt(localKey, finish.payload)
+	if err != nil || string(proof) != string(hmacSHA256(localKey, deviceNonce)) {
+		d.t.Errorf("device: client failed to prove the local key")
+		return nil, false
+	}
+
+	mixed := make([]byte, 16)
+	for i := range mixed {
+		mixed[i] = clientNonce[i] ^ deviceNonce[i]
+	}
+	sessionKey, _ := aesECBEncrypt(localKey, mixed, false)
+	return sessionKey, true
+}
+
+func newTestLocalClient(cloudURL string, port int, version string) *LocalClient {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := NewLocalClient(
+		NewClientWithURL("client-id", "secret", cloudURL, logger),
+		[]LocalDevice{{ID: "dev1", IP: "127.0.0.1", Version: version, LocalKey: testLocalKey}},
+		"",
+		logger,
+	)
+	client.port = port
+	return client
+}
+
+func TestLocalClient_ControlAndQuery(t *testing.T) {
+	for _, version := range []string{"3.3", "3.4"} {
+		t.Run(version, func(t *testing.T) {
+			device := newFakeDevice(t, version, map[string]any{"20": false, "22": 10.0})
+			client := newTestLocalClient("http://127.0.0.1:0", device.port(), version)
+			ctx := context.Background()
+
+			err := client.ExecuteCommand(ctx, &domain.Command{
+				Action:     domain.ActionSetLevel,
+				TargetID:   "dev1",
+				Parameters: map[string]any{"level": 40.0},
+			})
+			if err != nil {
+				t.Fatalf("ExecuteCommand error: %v", err)
+			}
+
+			if on, _ := device.dp("20").(bool); !on {
+				t.Errorf("switch_led: got %v, want true", device.dp("20"))
+			}
+			if level, _ := device.dp("22").(float64); level != 400 {
+				t.Errorf("bright_value_v2: got %v, want 400", device.dp("22"))
+			}
+
+			state, err := client.GetState(ctx, "dev1")
+			if err != nil {
+				t.Fatalf("GetState error: %v", err)
+			}
+			if got := state.Describe("Luz Living"); got != "Luz Living is on at 40%" {
+				t.Errorf("state: got %q", got)
+			}
+		})
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	spec := func(codes ...string) []domain.DeviceFunction {
+		var functions []domain.DeviceFunction
+		for _, c := range codes {
+			functions = append(functions, domain.DeviceFunction{Code: c})
+		}
+		return functions
+	}
+
+	tests := []struct {
+		name      string
+		info      localDeviceInfo
+		functions []domain.DeviceFunction
+		want      map[int]string
+	}{
+		{"plug", localDeviceInfo{}, spec("switch"), map[int]string{1: "switch"}},
+		{"dimmer", localDeviceInfo{}, spec("switch", "bright_value"), map[int]string{1: "switch", 2: "bright_value"}},
+		{"power strip", localDeviceInfo{}, spec("switch_1", "switch_2"), map[int]string{1: "switch_1", 2: "switch_2"}},
+		{"cloud mapping", localDeviceInfo{DPs: map[string]int{"switch_led": 1}}, spec("switch_led", "switch_1"), map[int]string{1: "switch_led"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusCodes(tt.info, tt.functions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Without a specification the same code always wins
+	for i := 0; i < 20; i++ {
+		codes := statusCodes(localDeviceInfo{}, nil)
+		if codes[1] != "switch_1" || codes[2] != "switch_2" {
+			t.Fatalf("without a specification: got %v", codes)
+		}
+	}
+}
+
+func TestLocalClient_FallsBackToCloud(t *testing.T) {
+	cloudCalled := false
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1.0/token":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result":  map[string]any{"access_token": "test-token", "expire_time": 7200, "uid": "test-uid"},
+			})
+		case "/v1.0/iot-03/devices/dev1/commands":
+			cloudCalled = true
+			json.NewEncoder(w).Encode(map[string]any{"success": true})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer cloud.Close()
+
+	// Grab a free port and close it so the device is unreachable
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	client := newTestLocalClient(cloud.URL, port, "3.3")
+
+	err = client.ExecuteCommand(context.Background(), &domain.Command{Action: domain.ActionTurnOn, TargetID: "dev1"})
+	if err != nil {
+		t.Fatalf("ExecuteCommand error: %v", err)
+	}
+
+	if !cloudCalled {
+		t.Error("command should have been sent through the cloud")
+	}
+}
+
+func TestLocalClient_ConfiguredKeyStillFetchesDataPoints(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	cloud := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/v1.0/token":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result":  map[string]any{"access_token": "test-token", "expire_time": 7200, "uid": "test-uid"},
+			})
+		case "/v1.1/devices/dev1/specifications":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result": map[string]any{
+					"functions": []map[string]any{{"code": "switch", "dp_id": 1}, {"code": "temp_set", "dp_id": 2}},
+				},
+			})
+		case "/v1.0/devices/dev1/functions":
+			json.NewEncoder(w).Encode(map[string]any{
+				"success": true,
+				"result": map[string]any{
+					"functions": []map[string]any{
+						{"code": "switch", "type": "Boolean", "values": "{}"},
+						{"code": "temp_set", "type": "Integer", "values": `{"min":5,"max":35,"scale":0,"step":1}`},
+					},
+				},
+			})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer cloud.Close()
+
+	device := newFakeDevice(t, "3.3", map[string]any{"1": false, "2": 20.0})
+	client := newTestLocalClient(cloud.URL, device.port(), "3.3")
+
+	for _, temperature := range []float64{24, 22} {
+		err := client.ExecuteCommand(context.Background(), &domain.Command{
+			Action:     domain.ActionSetTemperature,
+			TargetID:   "dev1",
+			Parameters: map[string]any{"temperature": temperature},
+		})
+		if err != nil {
+			t.Fatalf("ExecuteCommand error: %v", err)
+		}
+		if got, _ := device.dp("2").(float64); got != temperature {
+			t.Errorf("temp_set: got %v, want %v", device.dp("2"), temperature)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls["/v1.0/iot-03/devices/dev1/commands"] > 0 {
+		t.Error("the thermostat should be controlled locally")
+	}
+	if calls["/v1.0/devices/dev1"] > 0 {
+		t.Error("the configured local key shouldn't be fetched")
+	}
+	if calls["/v1.1/devices/dev1/specifications"] != 1 || calls["/v1.0/devices/dev1/functions"] != 1 {
+		t.Errorf("data points and functions should be fetched once: got %v", calls)
+	}
+}
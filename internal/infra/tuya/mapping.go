@@ -0,0 +1,473 @@
+package tuya
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"smart-home/internal/domain"
+)
+
+// Instruction codes of the standard Tuya instruction set, in order of
+// preference when a device reports more than one of them.
+var (
+	switchCodes     = []string{"switch_led", "switch", "switch_1"}
+	brightnessCodes = []string{"bright_value_v2", "bright_value"}
+	colourCodes     = []string{"colour_data_v2", "colour_data"}
+	colourTempCodes = []string{"temp_value_v2", "temp_value"}
+	fanSpeedCodes   = []string{"fan_speed_percent"}
+	positionCodes   = []string{"percent_control"}
+)
+
+// categoryDefaults is the instruction set assumed for a category when the
+// cloud can't tell us a device's function specification. Unknown
+// categories get the light set, which is what most Tuya devices are.
+func categoryDefaults(category string) []domain.DeviceFunction {
+	switch categoryToType(category) {
+	case domain.DeviceTypePlug, domain.DeviceTypeSwitch:
+		return []domain.DeviceFunction{
+			{Code: "switch_1", Type: "Boolean"},
+		}
+	case domain.DeviceTypeThermostat:
+		return []domain.DeviceFunction{
+			{Code: "switch", Type: "Boolean"},
+			{Code: "temp_set", Type: "Integer", Values: map[string]any{"min": 5.0, "max": 35.0, "scale": 0.0, "step": 1.0}},
+		}
+	case domain.DeviceTypeCover:
+		return []domain.DeviceFunction{
+			{Code: "control", Type: "Enum", Values: map[string]any{"range": []any{"open", "stop", "close"}}},
+			{Code: "percent_control", Type: "Integer", Values: map[string]any{"min": 0.0, "max": 100.0, "scale": 0.0, "step": 1.0}},
+		}
+	case domain.DeviceTypeFan:
+		return []domain.DeviceFunction{
+			{Code: "switch", Type: "Boolean"},
+			{Code: "fan_speed_percent", Type: "Integer", Values: map[string]any{"min": 1.0, "max": 100.0, "scale": 0.0, "step": 1.0}},
+		}
+	default:
+		return []domain.DeviceFunction{
+			{Code: "switch_led", Type: "Boolean"},
+			{Code: "work_mode", Type: "Enum", Values: map[string]any{"range": []any{"white", "colour", "scene", "music"}}},
+			{Code: "bright_value_v2", Type: "Integer", Values: map[string]any{"min": 10.0, "max": 1000.0, "scale": 0.0, "step": 1.0}},
+			{Code: "temp_value_v2", Type: "Integer", Values: map[string]any{"min": 0.0, "max": 1000.0, "scale": 0.0, "step": 1.0}},
+			{Code: "colour_data_v2", Type: "Json"},
+		}
+	}
+}
+
+// findFunction returns the first of the codes the device supports.
+func findFunction(functions []domain.DeviceFunction, codes ...string) (domain.DeviceFunction, bool) {
+	for _, code := range codes {
+		for _, f := range functions {
+			if f.Code == code {
+				return f, true
+			}
+		}
+	}
+	return domain.DeviceFunction{}, false
+}
+
+// switchFunctions returns the on/off codes of a device: its single switch,
+// or every gang of a multi-gang switch (switch_1, switch_2, ...).
+func switchFunctions(functions []domain.DeviceFunction) []string {
+	for _, code := range switchCodes[:2] {
+		if _, ok := findFunction(functions, code); ok {
+			return []string{code}
+		}
+	}
+
+	var gangs []string
+	for _, f := range functions {
+		if n, ok := gangNumber(f.Code); ok && n > 0 {
+			gangs = append(gangs, f.Code)
+		}
+	}
+	slices.SortFunc(gangs, func(a, b string) int {
+		na, _ := gangNumber(a)
+		nb, _ := gangNumber(b)
+		return na - nb
+	})
+	return gangs
+}
+
+func gangNumber(code string) (int, bool) {
+	rest, ok := strings.CutPrefix(code, "switch_")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest)
+	return n, err == nil
+}
+
+// buildCommands translates a command into Tuya instructions using the
+// device's function specification.
+func buildCommands(functions []domain.DeviceFunction, cmd *domain.Command) ([]map[string]any, error) {
+	switch cmd.Action {
+	case domain.ActionTurnOn, domain.ActionTurnOff:
+		on := cmd.Action == domain.ActionTurnOn
+		if control, ok := findFunction(functions, "control"); ok {
+			// Curtains open and close instead of switching
+			value := "close"
+			if on {
+				value = "open"
+			}
+			return []map[string]any{{"code": control.Code, "value": value}}, nil
+		}
+		return switchCommands(functions, cmd, on)
+
+	case domain.ActionSetLevel:
+		level, ok := cmd.Parameters["level"].(float64)
+		if !ok {
+			if _, relative := cmd.Delta(); relative {
+				// The assistant resolves deltas from the current status;
+				// without it there's nothing to add to
+				return nil, fmt.Errorf("can't adjust the level: current level unknown")
+			}
+			level = 100
+		}
+		f, ok := findFunction(functions, slices.Concat(brightnessCodes, fanSpeedCodes, positionCodes)...)
+		if !ok {
+			return nil, fmt.Errorf("device has no brightness or speed control")
+		}
+		commands := []map[string]any{{"code": f.Code, "value": percentValue(f, level)}}
+		if slices.Contains(positionCodes, f.Code) {
+			return commands, nil
+		}
+		return withPower(functions, commands), nil
+
+	case domain.ActionSetColor:
+		return colourCommands(functions, cmd)
+
+	case domain.ActionOpen, domain.ActionClose:
+		f, ok := findFunction(functions, "control")
+		if !ok {
+			return nil, fmt.Errorf("device can't open or close")
+		}
+		return []map[string]any{{"code": f.Code, "value": string(cmd.Action)}}, nil
+
+	case domain.ActionSetPosition:
+		position, ok := cmd.Parameters["position"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("set_position needs a position")
+		}
+		f, ok := findFunction(functions, positionCodes...)
+		if !ok {
+			return nil, fmt.Errorf("device has no position control")
+		}
+		return []map[string]any{{"code": f.Code, "value": percentValue(f, position)}}, nil
+
+	case domain.ActionSetTemperature:
+		temperature, ok := cmd.Parameters["temperature"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("set_temperature needs a temperature")
+		}
+		f, ok := findFunction(functions, "temp_set")
+		if !ok {
+			return nil, fmt.Errorf("device has no temperature setpoint")
+		}
+		return withPower(functions, []map[string]any{{"code": f.Code, "value": scaledValue(f, temperature)}}), nil
+
+	case domain.ActionSetHVACMode:
+		mode, _ := cmd.Parameters["hvac_mode"].(string)
+		f, ok := findFunction(functions, "mode")
+		if !ok || !slices.Contains(decodeValues(f).Range, mode) {
+			return nil, fmt.Errorf("device has no mode %q", mode)
+		}
+		return withPower(functions, []map[string]any{{"code": f.Code, "value": mode}}), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported action: %s", cmd.Action)
+	}
+}
+
+// switchCommands switches every gang of the device, or only the one given
+// by the "switch" parameter (1-based) on multi-gang switches.
+func switchCommands(functions []domain.DeviceFunction, cmd *domain.Command, on bool) ([]map[string]any, error) {
+	codes := switchFunctions(functions)
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("device has no on/off switch")
+	}
+
+	if gang, ok := cmd.Parameters["switch"].(float64); ok {
+		code := fmt.Sprintf("switch_%d", int(gang))
+		if !slices.Contains(codes, code) {
+			return nil, fmt.Errorf("device has no switch %d", int(gang))
+		}
+		codes = []string{code}
+	}
+
+	commands := make([]map[string]any, 0, len(codes))
+	for _, code := range codes {
+		commands = append(commands, map[string]any{"code": code, "value": on})
+	}
+	return commands, nil
+}
+
+// withPower prepends turning the device on, since setting a level or a
+// setpoint on a device that is off has no visible effect.
+func withPower(functions []domain.DeviceFunction, commands []map[string]any) []map[string]any {
+	codes := switchFunctions(functions)
+	if len(codes) != 1 {
+		return commands
+	}
+	return append([]map[string]any{{"code": codes[0], "value": true}}, commands...)
+}
+
+// percentValue maps a 0-100 percentage onto an Integer function's range,
+// e.g. 40% of bright_value_v2 (10-1000) is 400.
+func percentValue(f domain.DeviceFunction, percent float64) int {
+	v := decodeValues(f)
+	if v.Max <= 0 {
+		v.Max = 100
+	}
+	return v.fit(percent / 100 * v.Max)
+}
+
+// scaledValue converts a real-world value into the raw integer of a
+// function, e.g. 21.5° with scale 1 is 215.
+func scaledValue(f domain.DeviceFunction, value float64) int {
+	v := decodeValues(f)
+	return v.fit(value * math.Pow10(v.Scale))
+}
+
+// fit rounds a raw value to the spec's step and clamps it to its range.
+func (v functionValues) fit(raw float64) int {
+	if v.Step > 0 {
+		raw = math.Round(raw/v.Step) * v.Step
+	}
+	if v.Max > v.Min {
+		raw = min(max(raw, v.Min), v.Max)
+	}
+	return int(math.Round(raw))
+}
+
+// percentOf is the inverse of percentValue, used to report levels.
+func percentOf(f domain.DeviceFunction, raw float64) int {
+	v := decodeValues(f)
+	if v.Max <= 0 {
+		v.Max = 100
+	}
+	return int(math.Round(raw / v.Max * 100))
+}
+
+// hsv is a colour_data value. The cloud API takes it as a JSON object;
+// the LAN protocol as a hex string (see localString).
+type hsv struct {
+	H int `json:"h"`
+	S int `json:"s"`
+	V int `json:"v"`
+}
+
+// namedHues covers the colours people ask for, in English and Spanish.
+var namedHues = map[string]int{
+	"red": 0, "rojo": 0,
+	"orange": 30, "naranja": 30,
+	"yellow": 60, "amarillo": 60,
+	"green": 120, "verde": 120,
+	"cyan": 180, "celeste": 190, "cian": 180,
+	"blue": 240, "azul": 240,
+	"purple": 275, "violeta": 275, "morado": 275, "lila": 275,
+	"magenta": 300, "fucsia": 315,
+	"pink": 330, "rosa": 330,
+}
+
+// whiteTemperatures maps white shades to a colour temperature percentage,
+// 0 being the warmest.
+var whiteTemperatures = map[string]float64{
+	"warm": 0, "cálido": 0, "calido": 0, "warm white": 0, "blanco cálido": 0,
+	"white": 50, "blanco": 50, "neutral": 50,
+	"cool": 100, "cold": 100, "frío": 100, "frio": 100, "cool white": 100, "blanco frío": 100,
+}
+
+// colourCommands sets a colour by name or #rrggbb, a white shade by name,
+// or a colour temperature via the "color_temp" parameter (percentage from
+// warm to cool, or Kelvin).
+func colourCommands(functions []domain.DeviceFunction, cmd *domain.Command) ([]map[string]any, error) {
+	name, _ := cmd.Parameters["color"].(string)
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if temp, ok := cmd.Parameters["color_temp"].(float64); ok {
+		return whiteCommands(functions, kelvinToPercent(temp))
+	}
+	if temp, ok := whiteTemperatures[name]; ok {
+		return whiteCommands(functions, temp)
+	}
+
+	f, ok := findFunction(functions, colourCodes...)
+	if !ok {
+		return nil, fmt.Errorf("device has no colour control")
+	}
+
+	h, s, ok := parseColour(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown colour %q", name)
+	}
+
+	// colour_data_v2 uses 0-1000 for saturation and value, colour_data 0-255
+	scale := 1000.0
+	if f.Code == "colour_data" {
+		scale = 255
+	}
+	colour := hsv{H: h, S: int(math.Round(s * scale)), V: int(scale)}
+
+	commands := []map[string]any{{"code": f.Code, "value": colour}}
+	if mode, ok := findFunction(functions, "work_mode"); ok {
+		commands = append([]map[string]any{{"code": mode.Code, "value": "colour"}}, commands...)
+	}
+	return withPower(functions, commands), nil
+}
+
+func whiteCommands(functions []domain.DeviceFunction, temperature float64) ([]map[string]any, error) {
+	var commands []map[string]any
+	if mode, ok := findFunction(functions, "work_mode"); ok {
+		commands = append(commands, map[string]any{"code": mode.Code, "value": "white"})
+	}
+	if f, ok := findFunction(functions, colourTempCodes...); ok {
+		commands = append(commands, map[string]any{"code": f.Code, "value": percentValue(f, temperature)})
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("device has no white or colour temperature control")
+	}
+	return withPower(functions, commands), nil
+}
+
+// kelvinToPercent treats values above 100 as Kelvin and maps the usual
+// 2700-6500K span of bulbs onto 0-100.
+func kelvinToPercent(v float64) float64 {
+	if v <= 100 {
+		return max(v, 0)
+	}
+	return min(max((v-2700)/(6500-2700)*100, 0), 100)
+}
+
+// parseColour returns the hue (0-360) and saturation (0-1) of a colour name
+// or #rrggbb.
+func parseColour(name string) (int, float64, bool) {
+	if hue, ok := namedHues[name]; ok {
+		return hue, 1, true
+	}
+
+	hex := strings.TrimPrefix(name, "#")
+	if len(hex) != 6 {
+		return 0, 0, false
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	r := float64(rgb>>16&0xFF) / 255
+	g := float64(rgb>>8&0xFF) / 255
+	b := float64(rgb&0xFF) / 255
+	hi, lo := max(r, g, b), min(r, g, b)
+	delta := hi - lo
+
+	var hue float64
+	switch {
+	case delta == 0:
+		hue = 0
+	case hi == r:
+		hue = 60 * math.Mod((g-b)/delta, 6)
+	case hi == g:
+		hue = 60 * ((b-r)/delta + 2)
+	default:
+		hue = 60 * ((r-g)/delta + 4)
+	}
+	if hue < 0 {
+		hue += 360
+	}
+
+	saturation := 0.0
+	if hi > 0 {
+		saturation = delta / hi
+	}
+	return int(math.Round(hue)), saturation, true
+}
+
+// localString encodes the colour the way the LAN protocol expects it:
+// HHHHSSSSVVVV for colour_data_v2, RRGGBB0HHHSSVV for the legacy code.
+func (c hsv) localString(code string) string {
+	if code != "colour_data" {
+		return fmt.Sprintf("%04x%04x%04x", c.H, c.S, c.V)
+	}
+
+	s, v := float64(c.S)/255, float64(c.V)/255
+	chroma := v * s
+	x := chroma * (1 - math.Abs(math.Mod(float64(c.H)/60, 2)-1))
+	m := v - chroma
+
+	var r, g, b float64
+	switch {
+	case c.H < 60:
+		r, g = chroma, x
+	case c.H < 120:
+		r, g = x, chroma
+	case c.H < 180:
+		g, b = chroma, x
+	case c.H < 240:
+		g, b = x, chroma
+	case c.H < 300:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+
+	to8 := func(f float64) int { return int(math.Round((f + m) * 255)) }
+	return fmt.Sprintf("%02x%02x%02x%04x%02x%02x", to8(r), to8(g), to8(b), c.H, c.S, c.V)
+}
+
+// statusToState maps the standard instruction set codes onto a DeviceState,
+// scaling values with the device's function specification.
+func statusToState(status map[string]any, functions []domain.DeviceFunction) *domain.DeviceState {
+	state := &domain.DeviceState{Status: "unknown"}
+
+	for _, code := range switchFunctions(functions) {
+		if on, ok := status[code].(bool); ok {
+			if on {
+				state.Status = "on"
+				break
+			}
+			state.Status = "off"
+		}
+	}
+
+	for _, code := range slices.Concat(brightnessCodes, fanSpeedCodes, positionCodes) {
+		raw, ok := status[code].(float64)
+		if !ok {
+			continue
+		}
+		f, ok := findFunction(functions, code)
+		if !ok {
+			f = domain.DeviceFunction{Code: code, Values: map[string]any{"max": defaultMax(code)}}
+		}
+		level := percentOf(f, raw)
+		state.Level = &level
+		break
+	}
+
+	// Tuya reports temperatures with the scale of the setpoint
+	setpoint, _ := findFunction(functions, "temp_set")
+	scale := math.Pow10(decodeValues(setpoint).Scale)
+	if v, ok := status["temp_current"].(float64); ok {
+		current := v / scale
+		state.Temperature = &current
+	}
+	if v, ok := status["temp_set"].(float64); ok {
+		target := v / scale
+		state.TargetTemperature = &target
+	}
+
+	return state
+}
+
+func defaultMax(code string) float64 {
+	switch code {
+	case "bright_value_v2", "temp_value_v2":
+		return 1000
+	case "bright_value", "temp_value":
+		return 255
+	default:
+		return 100
+	}
+}
@@ -0,0 +1,169 @@
+package tuya
+
+import (
+	"reflect"
+	"testing"
+
+	"smart-home/internal/domain"
+)
+
+func integer(code string, min, max, scale, step float64) domain.DeviceFunction {
+	return domain.DeviceFunction{Code: code, Type: "Integer", Values: map[string]any{"min": min, "max": max, "scale": scale, "step": step}}
+}
+
+func boolean(code string) domain.DeviceFunction {
+	return domain.DeviceFunction{Code: code, Type: "Boolean"}
+}
+
+func TestBuildCommands(t *testing.T) {
+	plug := []domain.DeviceFunction{boolean("switch_1"), integer("countdown_1", 0, 86400, 0, 1)}
+	gangs := []domain.DeviceFunction{boolean("switch_2"), boolean("switch_1"), boolean("switch_3")}
+	oldBulb := []domain.DeviceFunction{boolean("switch_led"), integer("bright_value", 25, 255, 0, 1)}
+	colourBulb := categoryDefaults("dj")
+	thermostat := []domain.DeviceFunction{boolean("switch"), integer("temp_set", 50, 300, 1, 5)}
+
+	tests := []struct {
+		name      string
+		functions []domain.DeviceFunction
+		cmd       domain.Command
+		want      []map[string]any
+	}{
+		{
+			name:      "plug",
+			functions: plug,
+			cmd:       domain.Command{Action: domain.ActionTurnOn},
+			want:      []map[string]any{{"code": "switch_1", "value": true}},
+		},
+		{
+			name:      "every gang",
+			functions: gangs,
+			cmd:       domain.Command{Action: domain.ActionTurnOff},
+			want: []map[string]any{
+				{"code": "switch_1", "value": false},
+				{"code": "switch_2", "value": false},
+				{"code": "switch_3", "value": false},
+			},
+		},
+		{
+			name:      "one gang",
+			functions: gangs,
+			cmd:       domain.Command{Action: domain.ActionTurnOn, Parameters: map[string]any{"switch": 2.0}},
+			want:      []map[string]any{{"code": "switch_2", "value": true}},
+		},
+		{
+			name:      "legacy brightness range",
+			functions: oldBulb,
+			cmd:       domain.Command{Action: domain.ActionSetLevel, Parameters: map[string]any{"level": 40.0}},
+			want: []map[string]any{
+				{"code": "switch_led", "value": true},
+				{"code": "bright_value", "value": 102},
+			},
+		},
+		{
+			name:      "brightness never below the minimum",
+			functions: oldBulb,
+			cmd:       domain.Command{Action: domain.ActionSetLevel, Parameters: map[string]any{"level": 1.0}},
+			want: []map[string]any{
+				{"code": "switch_led", "value": true},
+				{"code": "bright_value", "value": 25},
+			},
+		},
+		{
+			name:      "named colour",
+			functions: colourBulb,
+			cmd:       domain.Command{Action: domain.ActionSetColor, Parameters: map[string]any{"color": "Rojo"}},
+			want: []map[string]any{
+				{"code": "switch_led", "value": true},
+				{"code": "work_mode", "value": "colour"},
+				{"code": "colour_data_v2", "value": hsv{H: 0, S: 1000, V: 1000}},
+			},
+		},
+		{
+			name:      "hex colour",
+			functions: colourBulb,
+			cmd:       domain.Command{Action: domain.ActionSetColor, Parameters: map[string]any{"color": "#0000ff"}},
+			want: []map[string]any{
+				{"code": "switch_led", "value": true},
+				{"code": "work_mode", "value": "colour"},
+				{"code": "colour_data_v2", "value": hsv{H: 240, S: 1000, V: 1000}},
+			},
+		},
+		{
+			name:      "colour temperature in Kelvin",
+			functions: colourBulb,
+			cmd:       domain.Command{Action: domain.ActionSetColor, Parameters: map[string]any{"color_temp": 6500.0}},
+			want: []map[string]any{
+				{"code": "switch_led", "value": true},
+				{"code": "work_mode", "value": "white"},
+				{"code": "temp_value_v2", "value": 1000},
+			},
+		},
+		{
+			name:      "thermostat setpoint",
+			functions: thermostat,
+			cmd:       domain.Command{Action: domain.ActionSetTemperature, Parameters: map[string]any{"temperature": 21.4}},
+			want: []map[string]any{
+				{"code": "switch", "value": true},
+				{"code": "temp_set", "value": 215},
+			},
+		},
+		{
+			name:      "cover",
+			functions: categoryDefaults("cl"),
+			cmd:       domain.Command{Action: domain.ActionTurnOff},
+			want:      []map[string]any{{"code": "control", "value": "close"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildCommands(tt.functions, &tt.cmd)
+			if err != nil {
+				t.Fatalf("buildCommands error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCommands_Unsupported(t *testing.T) {
+	plug := []domain.DeviceFunction{boolean("switch_1")}
+
+	if _, err := buildCommands(plug, &domain.Command{Action: domain.ActionSetColor, Parameters: map[string]any{"color": "red"}}); err == nil {
+		t.Error("set_color on a plug should fail")
+	}
+	if _, err := buildCommands(plug, &domain.Command{Action: domain.ActionTurnOn, Parameters: map[string]any{"switch": 2.0}}); err == nil {
+		t.Error("a missing gang should fail")
+	}
+}
+
+func TestStatusToState(t *testing.T) {
+	oldBulb := []domain.DeviceFunction{boolean("switch_led"), integer("bright_value", 25, 255, 0, 1)}
+	state := statusToState(map[string]any{"switch_led": true, "bright_value": 102.0}, oldBulb)
+	if got := state.Describe("Velador"); got != "Velador is on at 40%" {
+		t.Errorf("bulb: got %q", got)
+	}
+
+	thermostat := []domain.DeviceFunction{boolean("switch"), integer("temp_set", 50, 300, 1, 5)}
+	state = statusToState(map[string]any{"switch": true, "temp_set": 215.0, "temp_current": 198.0}, thermostat)
+	if got := state.Describe("Estufa"); got != "Estufa is on, set to 21.5°, currently 19.8°" {
+		t.Errorf("thermostat: got %q", got)
+	}
+
+	gangs := []domain.DeviceFunction{boolean("switch_1"), boolean("switch_2")}
+	state = statusToState(map[string]any{"switch_1": false, "switch_2": true}, gangs)
+	if state.Status != "on" {
+		t.Errorf("multi-gang with one gang on: got %q, want on", state.Status)
+	}
+}
+
+func TestHSVLocalString(t *testing.T) {
+	if got := (hsv{H: 0, S: 1000, V: 1000}).localString("colour_data_v2"); got != "000003e803e8" {
+		t.Errorf("v2: got %s", got)
+	}
+	if got := (hsv{H: 120, S: 255, V: 255}).localString("colour_data"); got != "00ff000078ffff" {
+		t.Errorf("v1: got %s", got)
+	}
+}
@@ -0,0 +1,363 @@
+package tuya
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"time"
+)
+
+// Tuya LAN protocol command codes.
+const (
+	cmdSessKeyNegStart  uint32 = 3
+	cmdSessKeyNegResp   uint32 = 4
+	cmdSessKeyNegFinish uint32 = 5
+	cmdControl          uint32 = 7
+	cmdStatus           uint32 = 8
+	cmdDPQuery          uint32 = 10
+	cmdControlNew       uint32 = 13
+	cmdDPQueryNew       uint32 = 16
+)
+
+const (
+	framePrefix uint32 = 0x000055AA
+	frameSuffix uint32 = 0x0000AA55
+	headerSize         = 16
+	localPort          = 6668
+)
+
+// frame is a single message of the Tuya LAN protocol:
+//
+//	prefix | seq | cmd | length | payload | crc32 or hmac-sha256 | suffix
+//
+// Version 3.3 protects frames with a CRC32, version 3.4 with an HMAC keyed
+// with the session key.
+type frame struct {
+	seq     uint32
+	cmd     uint32
+	payload []byte
+}
+
+func encodeFrame(f frame, hmacKey []byte) []byte {
+	checksumSize := 4
+	if hmacKey != nil {
+		checksumSize = sha256.Size
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, framePrefix)
+	binary.Write(&buf, binary.BigEndian, f.seq)
+	binary.Write(&buf, binary.BigEndian, f.cmd)
+	binary.Write(&buf, binary.BigEndian, uint32(len(f.payload)+checksumSize+4))
+	buf.Write(f.payload)
+
+	if hmacKey != nil {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(buf.Bytes())
+		buf.Write(mac.Sum(nil))
+	} else {
+		binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+	}
+
+	binary.Write(&buf, binary.BigEndian, frameSuffix)
+	return buf.Bytes()
+}
+
+func readFrame(r io.Reader, hmacKey []byte) (frame, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, fmt.Errorf("reading header: %w", err)
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) != framePrefix {
+		return frame{}, errors.New("invalid frame prefix")
+	}
+
+	length := binary.BigEndian.Uint32(header[12:16])
+	if length > 64*1024 {
+		return frame{}, fmt.Errorf("frame too large: %d bytes", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return frame{}, fmt.Errorf("reading body: %w", err)
+	}
+
+	checksumSize := 4
+	if hmacKey != nil {
+		checksumSize = sha256.Size
+	}
+	if int(length) < checksumSize+4 {
+		return frame{}, errors.New("frame too short")
+	}
+
+	payload := body[:int(length)-checksumSize-4]
+	checksum := body[len(payload) : len(payload)+checksumSize]
+	signed := append(append([]byte{}, header...), payload...)
+
+	if hmacKey != nil {
+		mac := hmac.New(sha256.New, hmacKey)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), checksum) {
+			return frame{}, errors.New("frame HMAC mismatch")
+		}
+	} else if crc32.ChecksumIEEE(signed) != binary.BigEndian.Uint32(checksum) {
+		return frame{}, errors.New("frame CRC mismatch")
+	}
+
+	return frame{
+		seq:     binary.BigEndian.Uint32(header[4:8]),
+		cmd:     binary.BigEndian.Uint32(header[8:12]),
+		payload: payload,
+	}, nil
+}
+
+// versionHeader is the "3.x" marker plus 12 reserved bytes that prefixes
+// control messages.
+func versionHeader(version string) []byte {
+	return append([]byte(version), make([]byte, 12)...)
+}
+
+func stripVersionHeader(data []byte, version string) []byte {
+	if len(data) >= 15 && string(data[:3]) == version {
+		return data[15:]
+	}
+	return data
+}
+
+func aesECBEncrypt(key, plaintext []byte, pad bool) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	if pad {
+		n := aes.BlockSize - len(plaintext)%aes.BlockSize
+		plaintext = append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(n)}, n)...)
+	}
+	if len(plaintext)%aes.BlockSize != 0 {
+		return nil, errors.New("plaintext is not a multiple of the block size")
+	}
+
+	out := make([]byte, len(plaintext))
+	for i := 0; i < len(plaintext); i += aes.BlockSize {
+		block.Encrypt(out[i:i+aes.BlockSize], plaintext[i:i+aes.BlockSize])
+	}
+	return out, nil
+}
+
+func aesECBDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a multiple of the block size")
+	}
+
+	out := make([]byte, len(ciphertext))
+	for i := 0; i < len(ciphertext); i += aes.BlockSize {
+		block.Decrypt(out[i:i+aes.BlockSize], ciphertext[i:i+aes.BlockSize])
+	}
+
+	n := int(out[len(out)-1])
+	if n == 0 || n > aes.BlockSize || n > len(out) {
+		return nil, errors.New("invalid padding")
+	}
+	return out[:len(out)-n], nil
+}
+
+// localConn is an open connection to a device, with the session key
+// already negotiated for version 3.4.
+type localConn struct {
+	conn    net.Conn
+	version string
+	key     []byte
+	hmacKey []byte
+	seq     uint32
+}
+
+func dialLocal(ctx context.Context, addr, version string, localKey []byte, timeout time.Duration) (*localConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c := &localConn{conn: conn, version: version, key: localKey}
+
+	switch version {
+	case "3.3":
+	case "3.4":
+		c.hmacKey = localKey
+		if err := c.negotiateSessionKey(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("negotiating session key: %w", err)
+		}
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unsupported protocol version %q", version)
+	}
+
+	return c, nil
+}
+
+func (c *localConn) Close() error {
+	return c.conn.Close()
+}
+
+// negotiateSessionKey runs the 3.4 handshake: both sides exchange nonces
+// encrypted with the local key and derive a per-connection session key.
+func (c *localConn) negotiateSessionKey() error {
+	localNonce := make([]byte, 16)
+	if _, err := rand.Read(localNonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+
+	payload, err := aesECBEncrypt(c.key, localNonce, true)
+	if err != nil {
+		return err
+	}
+	if err := c.write(cmdSessKeyNegStart, payload); err != nil {
+		return err
+	}
+
+	resp, err := c.read()
+	if err != nil {
+		return err
+	}
+	if resp.cmd != cmdSessKeyNegResp {
+		return fmt.Errorf("unexpected command %d", resp.cmd)
+	}
+
+	data, err := aesECBDecrypt(c.key, trimReturnCode(resp.payload))
+	if err != nil {
+		return fmt.Errorf("decrypting response: %w", err)
+	}
+	if len(data) < 16+sha256.Size {
+		return errors.New("session response too short")
+	}
+
+	remoteNonce := data[:16]
+	if !hmac.Equal(data[16:16+sha256.Size], hmacSHA256(c.key, localNonce)) {
+		return errors.New("device failed to prove the local key")
+	}
+
+	payload, err = aesECBEncrypt(c.key, hmacSHA256(c.key, remoteNonce), true)
+	if err != nil {
+		return err
+	}
+	if err := c.write(cmdSessKeyNegFinish, payload); err != nil {
+		return err
+	}
+
+	mixed := make([]byte, 16)
+	for i := range mixed {
+		mixed[i] = localNonce[i] ^ remoteNonce[i]
+	}
+	sessionKey, err := aesECBEncrypt(c.key, mixed, false)
+	if err != nil {
+		return err
+	}
+
+	c.key = sessionKey
+	c.hmacKey = sessionKey
+	return nil
+}
+
+// request encrypts a JSON payload, sends it and returns the decrypted JSON
+// of the device's answer to that command.
+func (c *localConn) request(cmd uint32, body []byte) ([]byte, error) {
+	var payload []byte
+	var err error
+
+	switch {
+	case c.version == "3.3" && cmd == cmdControl:
+		payload, err = aesECBEncrypt(c.key, body, true)
+		payload = append(versionHeader(c.version), payload...)
+	case c.version == "3.4" && cmd == cmdControlNew:
+		payload, err = aesECBEncrypt(c.key, append(versionHeader(c.version), body...), true)
+	default:
+		payload, err = aesECBEncrypt(c.key, body, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.write(cmd, payload); err != nil {
+		return nil, err
+	}
+
+	for {
+		resp, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		// Devices may push a status update before answering
+		if resp.cmd != cmd {
+			continue
+		}
+		return c.decrypt(resp.payload)
+	}
+}
+
+func (c *localConn) decrypt(payload []byte) ([]byte, error) {
+	if len(payload) >= 4 && payload[0] == 0 && payload[1] == 0 && payload[2] == 0 && payload[3] != 0 {
+		return nil, fmt.Errorf("device returned code %d", payload[3])
+	}
+
+	payload = trimReturnCode(payload)
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	payload = stripVersionHeader(payload, c.version)
+	data, err := aesECBDecrypt(c.key, payload)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting payload: %w", err)
+	}
+	return stripVersionHeader(data, c.version), nil
+}
+
+func (c *localConn) write(cmd uint32, payload []byte) error {
+	c.seq++
+	_, err := c.conn.Write(encodeFrame(frame{seq: c.seq, cmd: cmd, payload: payload}, c.hmacKey))
+	if err != nil {
+		return fmt.Errorf("writing frame: %w", err)
+	}
+	return nil
+}
+
+func (c *localConn) read() (frame, error) {
+	return readFrame(c.conn, c.hmacKey)
+}
+
+// trimReturnCode drops the 4-byte return code devices put in front of the
+// payload of every answer.
+func trimReturnCode(payload []byte) []byte {
+	if len(payload) >= 4 && payload[0] == 0 && payload[1] == 0 && payload[2] == 0 {
+		return payload[4:]
+	}
+	return payload
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -135,29 +135,6 @@ func (r *Registry) FindSceneByName(name string) (*domain.Scene, bool) {
 	return nil, false
 }
 
-func (r *Registry) Summary() string {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	var sb strings.Builder
-
-	sb.WriteString("## Dispositivos disponibles:\n")
-	for _, d := range r.devices {
-		status := "offline"
-		if d.Online {
-			status = "online"
-		}
-		sb.WriteString(fmt.Sprintf("- %s (tipo: %s, estado: %s)\n", d.Name, d.Type, status))
-	}
-
-	sb.WriteString("\n## Escenas disponibles:\n")
-	for _, s := range r.scenes {
-		sb.WriteString(fmt.Sprintf("- %s\n", s.Name))
-	}
-
-	return sb.String()
-}
-
 func (r *Registry) StartPeriodicSync(ctx context.Context, interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
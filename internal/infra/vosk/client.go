@@ -0,0 +1,206 @@
+// Package vosk transcribes speech with a Vosk server
+// (https://github.com/alphacep/vosk-server) over its WebSocket protocol, so
+// recognition runs at home and follows the audio as it is recorded.
+package vosk
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"smart-home/internal/application"
+	"smart-home/internal/infra/audiofile"
+	"smart-home/internal/infra/websocket"
+)
+
+// chunkSize is how much audio Transcribe sends per message: 0.25 s at
+// 16 kHz, in the range the Vosk examples use.
+const chunkSize = 8000
+
+// Client opens a WebSocket to the Vosk server for every utterance.
+type Client struct {
+	url    string
+	logger *slog.Logger
+}
+
+// NewClient creates a client for the server at url, e.g.
+// "ws://localhost:2700".
+func NewClient(url string, logger *slog.Logger) *Client {
+	return &Client{url: url, logger: logger}
+}
+
+type configMessage struct {
+	Config struct {
+		SampleRate int `json:"sample_rate"`
+	} `json:"config"`
+}
+
+// resultMessage is what the server answers to each chunk: {"partial": ...}
+// while the sentence goes on, {"text": ...} once it ends.
+type resultMessage struct {
+	Partial *string `json:"partial"`
+	Text    *string `json:"text"`
+}
+
+func (c *Client) StartStream(ctx context.Context, sampleRate int) (application.SpeechStream, error) {
+	conn, err := websocket.Dial(ctx, c.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to vosk: %w", err)
+	}
+
+	var cfg configMessage
+	cfg.Config.SampleRate = sampleRate
+	if err := conn.WriteJSON(cfg); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("configuring vosk: %w", err)
+	}
+
+	s := &stream{
+		conn:    conn,
+		results: make(chan application.Transcript, 16),
+		done:    make(chan struct{}),
+	}
+	go s.read(c.logger)
+	return s, nil
+}
+
+// Transcribe sends a whole recording through a stream. The audio must be a
+// WAV file; Vosk can't read compressed formats.
+func (c *Client) Transcribe(ctx context.Context, audio []byte) (string, error) {
+	if format := audiofile.Detect(audio); format != audiofile.WAV {
+		return "", fmt.Errorf("vosk needs WAV audio, got %s", format)
+	}
+	clip, err := audiofile.DecodeWAV(audio)
+	if err != nil {
+		return "", err
+	}
+	pcm := make([]byte, 0, len(clip.Samples)*2)
+	for _, s := range clip.Samples {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(s))
+	}
+
+	s, err := c.StartStream(ctx, clip.SampleRate)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+
+	for len(pcm) > 0 {
+		n := min(len(pcm), chunkSize)
+		if err := s.Write(pcm[:n]); err != nil {
+			return "", err
+		}
+		pcm = pcm[n:]
+	}
+	return s.Finish(ctx)
+}
+
+type stream struct {
+	conn    *websocket.Conn
+	results chan application.Transcript
+	done    chan struct{}
+
+	mu     sync.Mutex
+	finals []string
+	err    error
+}
+
+func (s *stream) Write(pcm []byte) error {
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, pcm); err != nil {
+		return fmt.Errorf("sending audio to vosk: %w", err)
+	}
+	return nil
+}
+
+func (s *stream) Results() <-chan application.Transcript {
+	return s.results
+}
+
+func (s *stream) Finish(ctx context.Context) (string, error) {
+	if err := s.conn.WriteMessage(websocket.TextMessage, []byte(`{"eof" : 1}`)); err != nil {
+		return "", fmt.Errorf("ending vosk stream: %w", err)
+	}
+
+	// The server answers eof with the last result and closes the connection
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		s.conn.Close()
+		return "", ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return "", s.err
+	}
+	return strings.Join(s.finals, " "), nil
+}
+
+func (s *stream) Close() error {
+	return s.conn.Close()
+}
+
+// read collects the server's results until the connection ends.
+func (s *stream) read(logger *slog.Logger) {
+	defer close(s.done)
+	defer close(s.results)
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, websocket.ErrClosed) && !errors.Is(err, io.EOF) {
+				s.mu.Lock()
+				s.err = fmt.Errorf("reading from vosk: %w", err)
+				s.mu.Unlock()
+			}
+			return
+		}
+
+		var msg resultMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			logger.Warn("ignoring unexpected vosk message", "message", string(data))
+			continue
+		}
+
+		switch {
+		case msg.Text != nil:
+			text := strings.TrimSpace(*msg.Text)
+			if text != "" {
+				s.mu.Lock()
+				s.finals = append(s.finals, text)
+				s.mu.Unlock()
+			}
+			s.deliver(application.Transcript{Text: text, Final: true})
+		case msg.Partial != nil:
+			s.deliver(application.Transcript{Text: *msg.Partial})
+		}
+	}
+}
+
+// deliver queues a result without blocking: the connection must be read
+// even when nobody reads the results (Transcribe doesn't), or the server
+// would stall. Partial results are dropped when the queue is full; a final
+// one makes room by dropping the oldest.
+func (s *stream) deliver(t application.Transcript) {
+	for {
+		select {
+		case s.results <- t:
+			return
+		default:
+		}
+		if !t.Final {
+			return
+		}
+		select {
+		case <-s.results:
+		default:
+		}
+	}
+}
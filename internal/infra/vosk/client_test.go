@@ -0,0 +1,163 @@
+package vosk_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"smart-home/internal/application"
+	"smart-home/internal/infra/vosk"
+	"smart-home/internal/infra/websocket"
+)
+
+// fakeVosk speaks the Vosk server protocol: a partial result after every
+// chunk of audio, a final one for the first sentence once 10000 bytes have
+// arrived, and the rest of the text at eof. It reports the configured sample
+// rate and the amount of audio received on the channels.
+func fakeVosk(t *testing.T) (url string, sampleRate, received chan int) {
+	t.Helper()
+	sampleRate, received = make(chan int, 1), make(chan int, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var cfg struct {
+			Config struct {
+				SampleRate int `json:"sample_rate"`
+			} `json:"config"`
+		}
+		if err := conn.ReadJSON(&cfg); err != nil {
+			t.Errorf("reading config: %v", err)
+			return
+		}
+		sampleRate <- cfg.Config.SampleRate
+
+		total, sentenceDone := 0, false
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if op == websocket.TextMessage && strings.Contains(string(data), "eof") {
+				received <- total
+				conn.WriteJSON(map[string]any{"text": "del living"})
+				return
+			}
+
+			total += len(data)
+			if total >= 10000 && !sentenceDone {
+				sentenceDone = true
+				conn.WriteJSON(map[string]any{"result": []any{}, "text": "prendé la luz"})
+				continue
+			}
+			conn.WriteJSON(map[string]any{"partial": "prendé"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), sampleRate, received
+}
+
+func TestClient_Stream(t *testing.T) {
+	url, sampleRate, received := fakeVosk(t)
+	client := vosk.NewClient(url, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.StartStream(ctx, 16000)
+	if err != nil {
+		t.Fatalf("StartStream error: %v", err)
+	}
+	defer stream.Close()
+	if rate := <-sampleRate; rate != 16000 {
+		t.Errorf("sample rate: got %d", rate)
+	}
+
+	var results []application.Transcript
+	for range 3 {
+		if err := stream.Write(make([]byte, 4000)); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		select {
+		case r := <-stream.Results():
+			results = append(results, r)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for a result")
+		}
+	}
+	want := []application.Transcript{{Text: "prendé"}, {Text: "prendé"}, {Text: "prendé la luz", Final: true}}
+	if len(results) != len(want) || results[0] != want[0] || results[2] != want[2] {
+		t.Errorf("results: got %+v, want %+v", results, want)
+	}
+
+	text, err := stream.Finish(ctx)
+	if err != nil {
+		t.Fatalf("Finish error: %v", err)
+	}
+	if text != "prendé la luz del living" {
+		t.Errorf("got %q", text)
+	}
+	if n := <-received; n != 12000 {
+		t.Errorf("server received %d bytes, want 12000", n)
+	}
+}
+
+func TestClient_Transcribe(t *testing.T) {
+	url, sampleRate, received := fakeVosk(t)
+	client := vosk.NewClient(url, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Nobody reads the results here: the stream must not stall on them
+	pcm := make([]byte, 40*8000)
+	text, err := client.Transcribe(context.Background(), wav(pcm, 8000))
+	if err != nil {
+		t.Fatalf("Transcribe error: %v", err)
+	}
+	if text != "prendé la luz del living" {
+		t.Errorf("got %q", text)
+	}
+	if rate := <-sampleRate; rate != 8000 {
+		t.Errorf("sample rate: got %d", rate)
+	}
+	if n := <-received; n != len(pcm) {
+		t.Errorf("server received %d bytes, want %d", n, len(pcm))
+	}
+}
+
+func TestClient_TranscribeRejectsOtherFormats(t *testing.T) {
+	client := vosk.NewClient("ws://127.0.0.1:1", slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	adpcm := wav(make([]byte, 100), 16000)
+	binary.LittleEndian.PutUint16(adpcm[20:22], 2)
+
+	for name, audio := range map[string][]byte{"mp3": []byte("ID3\x04 not a wav"), "adpcm": adpcm} {
+		if _, err := client.Transcribe(context.Background(), audio); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// wav wraps 16-bit mono PCM in a WAV header.
+func wav(pcm []byte, sampleRate int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(sampleRate), uint32(sampleRate * 2), uint16(2), uint16(16)} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
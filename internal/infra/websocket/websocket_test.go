@@ -0,0 +1,296 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"smart-home/internal/infra/websocket"
+)
+
+func TestConn_EchoRoundTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Ping first so the client has to answer while reading
+		conn.WriteMessage(websocket.PingMessage, []byte("ping"))
+
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(opcode, data)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	messages := []struct {
+		opcode int
+		data   []byte
+	}{
+		{websocket.TextMessage, []byte(`{"type":"auth"}`)},
+		{websocket.BinaryMessage, bytes.Repeat([]byte{0x01, 0x02}, 300)},
+		{websocket.BinaryMessage, bytes.Repeat([]byte{0xAB}, 70000)},
+	}
+
+	for _, m := range messages {
+		if err := conn.WriteMessage(m.opcode, m.data); err != nil {
+			t.Fatalf("WriteMessage error: %v", err)
+		}
+
+		opcode, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage error: %v", err)
+		}
+		if opcode != m.opcode || !bytes.Equal(data, m.data) {
+			t.Errorf("echo mismatch: got opcode %d and %d bytes, want opcode %d and %d bytes",
+				opcode, len(data), m.opcode, len(m.data))
+		}
+	}
+}
+
+func TestDial_RejectsNonWebSocketServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	_, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err == nil {
+		t.Fatal("expected handshake error")
+	}
+}
+
+// rawServer upgrades the connection by hand and hands it to handle, which
+// speaks the protocol frame by frame. The test waits for handle to return.
+func rawServer(t *testing.T, handle func(conn net.Conn, br *bufio.Reader)) *websocket.Conn {
+	t.Helper()
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		netConn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijacking: %v", err)
+			return
+		}
+		defer netConn.Close()
+
+		sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			base64.StdEncoding.EncodeToString(sum[:]))
+		rw.Flush()
+
+		netConn.SetDeadline(time.Now().Add(5 * time.Second))
+		handle(netConn, rw.Reader)
+	}))
+	t.Cleanup(func() {
+		<-done
+		server.Close()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// frame encodes an unmasked frame, as servers send them.
+func frame(fin bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	header := []byte{first, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	return append(header, payload...)
+}
+
+type clientFrame struct {
+	opcode  byte
+	length  byte // the 7-bit length field: the size, 126 or 127
+	payload []byte
+}
+
+// readClientFrame reads a frame sent by the client, which must be masked.
+func readClientFrame(t *testing.T, br *bufio.Reader) clientFrame {
+	t.Helper()
+
+	var header [2]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		t.Errorf("reading frame header: %v", err)
+		return clientFrame{}
+	}
+	if header[1]&0x80 == 0 {
+		t.Error("client frames must be masked")
+	}
+	f := clientFrame{opcode: header[0] & 0x0F, length: header[1] & 0x7F}
+
+	n := uint64(f.length)
+	switch f.length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	io.ReadFull(br, mask[:])
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(br, f.payload); err != nil {
+		t.Errorf("reading payload: %v", err)
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f
+}
+
+func TestConn_ReadsFragmentedMessage(t *testing.T) {
+	conn := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		// A ping may arrive between the fragments of a message
+		c.Write(frame(false, websocket.TextMessage, []byte("Hola, ")))
+		c.Write(frame(true, websocket.PingMessage, []byte("¿estás?")))
+		c.Write(frame(false, 0, []byte("prendé ")))
+		c.Write(frame(true, 0, []byte("la luz")))
+
+		if f := readClientFrame(t, br); f.opcode != websocket.PongMessage || string(f.payload) != "¿estás?" {
+			t.Errorf("expected a pong echoing the ping, got opcode %d %q", f.opcode, f.payload)
+		}
+
+		// A continuation without a message to continue
+		c.Write(frame(true, 0, []byte("suelto")))
+	})
+	defer conn.Close()
+
+	opcode, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage error: %v", err)
+	}
+	if opcode != websocket.TextMessage || string(data) != "Hola, prendé la luz" {
+		t.Errorf("got opcode %d %q, want the reassembled text", opcode, data)
+	}
+
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("expected an error for a stray continuation frame")
+	}
+}
+
+func TestConn_PayloadLengths(t *testing.T) {
+	// Each size is read from the server and written back by the client
+	sizes := []int{125, 126, 0xFFFF, 0x10000, 70000}
+	wantLength := func(n int) byte {
+		switch {
+		case n < 126:
+			return byte(n)
+		case n <= 0xFFFF:
+			return 126
+		default:
+			return 127
+		}
+	}
+
+	conn := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for i, n := range sizes {
+			payload := bytes.Repeat([]byte{byte(i + 1)}, n)
+			c.Write(frame(true, websocket.BinaryMessage, payload))
+
+			f := readClientFrame(t, br)
+			if f.length != wantLength(n) || !bytes.Equal(f.payload, payload) {
+				t.Errorf("%d bytes: client sent length field %d and %d bytes, want %d", n, f.length, len(f.payload), wantLength(n))
+			}
+		}
+	})
+	defer conn.Close()
+
+	for i, n := range sizes {
+		opcode, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%d bytes: ReadMessage error: %v", n, err)
+		}
+		if opcode != websocket.BinaryMessage || len(data) != n || data[0] != byte(i+1) {
+			t.Errorf("%d bytes: got opcode %d and %d bytes", n, opcode, len(data))
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatalf("%d bytes: WriteMessage error: %v", n, err)
+		}
+	}
+}
+
+func TestConn_AnswersPingDuringRead(t *testing.T) {
+	conn := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		c.Write(frame(true, websocket.PingMessage, []byte("hb")))
+		// The message only comes once the pong is in, so the client must
+		// answer from inside ReadMessage
+		if f := readClientFrame(t, br); f.opcode != websocket.PongMessage || string(f.payload) != "hb" {
+			t.Errorf("expected a pong, got opcode %d %q", f.opcode, f.payload)
+		}
+		c.Write(frame(true, websocket.TextMessage, []byte(`{"type":"event"}`)))
+	})
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage error: %v", err)
+	}
+	if string(data) != `{"type":"event"}` {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestConn_ServerClose(t *testing.T) {
+	conn := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		c.Write(frame(true, websocket.CloseMessage, []byte{0x03, 0xE9})) // 1001: going away
+		if f := readClientFrame(t, br); f.opcode != websocket.CloseMessage {
+			t.Errorf("expected the client to answer the close, got opcode %d", f.opcode)
+		}
+	})
+	defer conn.Close()
+
+	if _, _, err := conn.ReadMessage(); !errors.Is(err, websocket.ErrClosed) {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Package whispercpp transcribes audio with the HTTP server that ships with
+// whisper.cpp (https://github.com/ggerganov/whisper.cpp), running at home.
+package whispercpp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"smart-home/internal/infra"
+	"smart-home/internal/infra/audiofile"
+)
+
+// Client posts audio to the /inference endpoint of a whisper.cpp server.
+// The model is the one the server was started with.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	language   string
+}
+
+// NewClient creates a client for the server at baseURL, e.g.
+// "http://localhost:8080". An empty language lets whisper detect it.
+func NewClient(baseURL, language string) *Client {
+	return &Client{
+		// Transcribing on a CPU takes a while with the larger models
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		language:   language,
+	}
+}
+
+// SetTimeout changes how long a transcription may take.
+func (c *Client) SetTimeout(d time.Duration) {
+	c.httpClient.Timeout = d
+}
+
+type inferenceResponse struct {
+	Text  string `json:"text"`
+	Error string `json:"error"`
+}
+
+func (c *Client) Transcribe(ctx context.Context, audio []byte) (string, error) {
+	var result inferenceResponse
+
+	retryErr := infra.WithRetry(ctx, infra.DefaultRetryConfig(), func() error {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+
+		// Name the upload after what it is: the server may go by the extension
+		part, err := writer.CreatePart(audiofile.Detect(audio).PartHeader("file"))
+		if err != nil {
+			return fmt.Errorf("creating form file: %w", err)
+		}
+		if _, err = part.Write(audio); err != nil {
+			return fmt.Errorf("writing audio: %w", err)
+		}
+
+		fields := map[string]string{"response_format": "json", "temperature": "0"}
+		if c.language != "" {
+			fields["language"] = c.language
+		}
+		for name, value := range fields {
+			if err = writer.WriteField(name, value); err != nil {
+				return fmt.Errorf("writing %s field: %w", name, err)
+			}
+		}
+
+		if err = writer.Close(); err != nil {
+			return fmt.Errorf("closing writer: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/inference", body)
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("sending request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			respBody, _ := io.ReadAll(resp.Body)
+			if infra.IsRetryableHTTPStatus(resp.StatusCode) {
+				return fmt.Errorf("whisper.cpp error %d: %s (retryable)", resp.StatusCode, string(respBody))
+			}
+			return fmt.Errorf("whisper.cpp error %d: %s", resp.StatusCode, string(respBody))
+		}
+
+		if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+		// The server answers 200 with an error field for audio it can't read
+		if result.Error != "" {
+			return fmt.Errorf("whisper.cpp error: %s", result.Error)
+		}
+
+		return nil
+	})
+
+	if retryErr != nil {
+		return "", retryErr
+	}
+
+	// whisper.cpp keeps the leading space and newline of its segments
+	return strings.TrimSpace(result.Text), nil
+}
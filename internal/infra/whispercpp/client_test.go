@@ -0,0 +1,72 @@
+package whispercpp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"smart-home/internal/infra/whispercpp"
+)
+
+// whisperServer mimics the whisper.cpp server, answering with reply.
+func whisperServer(t *testing.T, reply map[string]string, check func(r *http.Request)) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/inference" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if _, _, err := r.FormFile("file"); err != nil {
+			t.Errorf("reading file: %v", err)
+		}
+		if check != nil {
+			check(r)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reply)
+	}))
+}
+
+func TestClient_Transcribe(t *testing.T) {
+	server := whisperServer(t, map[string]string{"text": " apagá el ventilador\n"}, func(r *http.Request) {
+		if got := r.FormValue("language"); got != "es" {
+			t.Errorf("language: got %q", got)
+		}
+		if got := r.FormValue("response_format"); got != "json" {
+			t.Errorf("response_format: got %q", got)
+		}
+	})
+	defer server.Close()
+
+	text, err := whispercpp.NewClient(server.URL+"/", "es").Transcribe(context.Background(), []byte("RIFF fake wav"))
+	if err != nil {
+		t.Fatalf("Transcribe error: %v", err)
+	}
+	if text != "apagá el ventilador" {
+		t.Errorf("got %q", text)
+	}
+}
+
+func TestClient_AutoLanguage(t *testing.T) {
+	server := whisperServer(t, map[string]string{"text": "turn on the fan"}, func(r *http.Request) {
+		if _, ok := r.MultipartForm.Value["language"]; ok {
+			t.Error("no language should be sent so that whisper detects it")
+		}
+	})
+	defer server.Close()
+
+	if _, err := whispercpp.NewClient(server.URL, "").Transcribe(context.Background(), []byte("audio")); err != nil {
+		t.Fatalf("Transcribe error: %v", err)
+	}
+}
+
+func TestClient_ServerError(t *testing.T) {
+	server := whisperServer(t, map[string]string{"error": "failed to read WAV file"}, nil)
+	defer server.Close()
+
+	if _, err := whispercpp.NewClient(server.URL, "es").Transcribe(context.Background(), []byte("not audio")); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -42,13 +42,13 @@ type recordingIntent struct {
 	results  map[string]*domain.Command
 }
 
-func (r *recordingIntent) Parse(_ context.Context, text string, _ application.DeviceRegistry) (*domain.Command, error) {
+func (r *recordingIntent) Parse(_ context.Context, text string, _ application.DeviceRegistry, _ *application.Conversation) ([]*domain.Command, error) {
 	cmd := &domain.Command{Action: domain.ActionUnknown}
 	if c, ok := r.results[text]; ok {
 		cmd = c
 	}
 	r.recorder.commands = append(r.recorder.commands, cmd)
-	return cmd, nil
+	return []*domain.Command{cmd}, nil
 }
 
 type recordingController struct {
@@ -65,15 +65,18 @@ func (r *recordingController) TriggerScene(_ context.Context, sceneID string) er
 	return nil
 }
 
+func (r *recordingController) GetState(_ context.Context, _ string) (*domain.DeviceState, error) {
+	return &domain.DeviceState{Status: "off"}, nil
+}
+
 type staticRegistry struct {
 	devices []domain.Device
 	scenes  []domain.Scene
 }
 
-func (s *staticRegistry) Sync(_ context.Context) error          { return nil }
-func (s *staticRegistry) GetDevices() []domain.Device           { return s.devices }
-func (s *staticRegistry) GetScenes() []domain.Scene             { return s.scenes }
-func (s *staticRegistry) Summary() string                       { return "test devices" }
+func (s *staticRegistry) Sync(_ context.Context) error { return nil }
+func (s *staticRegistry) GetDevices() []domain.Device  { return s.devices }
+func (s *staticRegistry) GetScenes() []domain.Scene    { return s.scenes }
 func (s *staticRegistry) FindDeviceByName(name string) (*domain.Device, bool) {
 	for i, d := range s.devices {
 		if d.Name == name {